@@ -0,0 +1,331 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Alert is a single call to Info, Error or Resolve, assembled together with
+// the name and values of the Alerter it was made through.  It is primarily
+// used by libraries implementing Sink on top of NewSink, rather than end
+// users.
+type Alert struct {
+	// Time is when the alert was raised.
+	Time time.Time
+
+	// Name is the name of the Alerter, with the elements passed to
+	// WithName joined by "/".
+	Name string
+
+	// Level is the V-level of an Info alert.  It is 0 for Error and
+	// Resolve, which are not subject to verbosity.
+	Level int
+
+	// Message is the msg argument of the call.
+	Message string
+
+	// Err is the err argument of Error, which may be nil.
+	Err error
+
+	// Severity is the severity of the alert: the one attached with
+	// SeverityKey if any, otherwise SeverityError for Error and
+	// SeverityInfo for Info and Resolve.
+	Severity Severity
+
+	// Resolved is true for alerts raised with Resolve.
+	Resolved bool
+
+	// Values holds the key/value pairs added with WithValues.
+	Values []interface{}
+
+	// KeysAndValues holds the key/value pairs passed to the call itself.
+	KeysAndValues []interface{}
+}
+
+// Field is a single key/value pair of an Alert.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Fields returns the key/value pairs of the alert, Values first.  Values
+// implementing Marshaler are replaced by the result of MarshalAlert, keys
+// which are not strings are formatted with fmt, and a trailing key without
+// a value is given the value "<no-value>".
+func (a *Alert) Fields() []Field {
+	fields := make([]Field, 0, (len(a.Values)+len(a.KeysAndValues)+1)/2)
+	fields = appendFields(fields, a.Values)
+	return appendFields(fields, a.KeysAndValues)
+}
+
+// Fingerprint identifies the condition an alert is about, the same way
+// Resolve matches alerts: by name, message and values, ignoring the
+// key/value pairs of the call.  An alert and its resolve share a
+// fingerprint, as do repeated alerts for the same condition.
+func (a *Alert) Fingerprint() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s", a.Name, a.Message)
+	for _, f := range appendFields(nil, a.Values) {
+		fmt.Fprintf(h, "\x00%s=%v", f.Key, f.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func appendFields(fields []Field, kvs []interface{}) []Field {
+	for i := 0; i < len(kvs); i += 2 {
+		var key string
+		switch k := kvs[i].(type) {
+		case string:
+			key = k
+		default:
+			key = fmt.Sprintf("<non-string-key: %v>", k)
+		}
+		var value interface{} = "<no-value>"
+		if i+1 < len(kvs) {
+			value = kvs[i+1]
+		}
+		if m, ok := value.(Marshaler); ok {
+			value = m.MarshalAlert()
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
+	return fields
+}
+
+// SendFunc delivers a single assembled Alert, reporting whether it could be
+// delivered.
+type SendFunc func(a *Alert) error
+
+// SinkOptions carries parameters which influence the behavior of a Sink
+// returned by NewSink.
+type SinkOptions struct {
+	// Enabled reports whether Info alerts at the given V-level are sent.
+	// When nil, all levels are enabled.
+	Enabled func(level int) bool
+
+	// OnError is called with every error returned by the SendFunc.  When
+	// nil, such errors are discarded.
+	OnError func(a *Alert, err error)
+
+	// Clock stamps alerts with their time.  Defaults to SystemClock.
+	Clock Clock
+
+	// SendBatch, if set, delivers several alerts at once, e.g. in a
+	// single request, when the Sink is used as a BatchSink.  Otherwise
+	// batches are delivered one alert at a time through the SendFunc.
+	SendBatch func(alerts []*Alert) error
+}
+
+// NewSink returns a Sink which takes care of the bookkeeping for WithName and
+// WithValues and assembles every Info, Error and Resolve call into an Alert
+// which it hands to send.  It is primarily used by libraries implementing
+// Sink, which then only have to deliver alerts.
+func NewSink(send SendFunc, opts SinkOptions) Sink {
+	opts.Clock = clockOr(opts.Clock)
+	return &funcSink{send: send, opts: opts}
+}
+
+// AlertSink is an optional interface that a Sink may implement to accept
+// Alerts which were already assembled, e.g. by a middleware wrapping it.
+// Sinks returned by NewSink implement it.
+type AlertSink interface {
+	// Send delivers a, prefixing its name and values with those of the
+	// Sink.  It reports whether the alert could be delivered.
+	Send(a *Alert) error
+}
+
+// Send delivers an assembled Alert to sink.  Sinks implementing AlertSink
+// receive it as is; to other sinks it is replayed through WithName,
+// WithValues and Info, Error or Resolve, in which case only errors from
+// Send itself can be reported.  Alerts without Err are replayed as Error
+// when their severity is SeverityError or above.
+func Send(sink Sink, a *Alert) error {
+	if s, ok := sink.(AlertSink); ok {
+		return s.Send(a)
+	}
+	if a.Name != "" {
+		for _, name := range strings.Split(a.Name, "/") {
+			sink = sink.WithName(name)
+		}
+	}
+	if len(a.Values) > 0 {
+		sink = sink.WithValues(a.Values...)
+	}
+	kvs := a.KeysAndValues
+	switch {
+	case a.Resolved:
+		if r, ok := sink.(Resolver); ok {
+			r.Resolve(a.Message, withSeverity(kvs, a.Severity, SeverityInfo)...)
+		}
+	case a.Err != nil || a.Severity >= SeverityError:
+		sink.Error(a.Err, a.Message, withSeverity(kvs, a.Severity, SeverityError)...)
+	case sink.Enabled(a.Level):
+		sink.Info(a.Level, a.Message, withSeverity(kvs, a.Severity, SeverityInfo)...)
+	}
+	return nil
+}
+
+// BatchSink is an optional interface that a Sink may implement to deliver
+// several assembled Alerts in one payload, such as a single email or
+// webhook request.  Sinks returned by NewSink implement it.
+type BatchSink interface {
+	// SendBatch delivers alerts, prefixing their names and values with
+	// those of the Sink.  It reports whether they could be delivered.
+	SendBatch(alerts []*Alert) error
+}
+
+// SendBatch delivers assembled Alerts to sink, in one payload if it
+// implements BatchSink and one at a time through Send otherwise.
+func SendBatch(sink Sink, alerts []*Alert) error {
+	if s, ok := sink.(BatchSink); ok {
+		return s.SendBatch(alerts)
+	}
+	var errs []error
+	for _, a := range alerts {
+		if err := Send(sink, a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// withSeverity returns kvs with sev attached unless it is the default the
+// receiving sink assumes anyway.
+func withSeverity(kvs []interface{}, sev, def Severity) []interface{} {
+	if sev == def {
+		return kvs
+	}
+	return append(kvs[:len(kvs):len(kvs)], SeverityKey, sev)
+}
+
+type funcSink struct {
+	send   SendFunc
+	opts   SinkOptions
+	name   string
+	values []interface{}
+}
+
+var _ Sink = &funcSink{}
+var _ Resolver = &funcSink{}
+var _ AlertSink = &funcSink{}
+var _ BatchSink = &funcSink{}
+
+func (s *funcSink) Enabled(level int) bool {
+	return s.opts.Enabled == nil || s.opts.Enabled(level)
+}
+
+func (s *funcSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.emit(&Alert{Level: level, Message: msg, Severity: SeverityInfo, KeysAndValues: keysAndValues})
+}
+
+func (s *funcSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.emit(&Alert{Message: msg, Err: err, Severity: SeverityError, KeysAndValues: keysAndValues})
+}
+
+func (s *funcSink) Resolve(msg string, keysAndValues ...interface{}) {
+	s.emit(&Alert{Message: msg, Severity: SeverityInfo, Resolved: true, KeysAndValues: keysAndValues})
+}
+
+func (s *funcSink) Send(a *Alert) error {
+	if !s.accepts(a) {
+		return nil
+	}
+	return s.send(s.prefixed(a))
+}
+
+func (s *funcSink) SendBatch(alerts []*Alert) error {
+	if s.opts.SendBatch == nil {
+		var errs []error
+		for _, a := range alerts {
+			if err := s.Send(a); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	batch := make([]*Alert, 0, len(alerts))
+	for _, a := range alerts {
+		if s.accepts(a) {
+			batch = append(batch, s.prefixed(a))
+		}
+	}
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.opts.SendBatch(batch)
+}
+
+// accepts reports whether an assembled alert passes the verbosity of the
+// sink.
+func (s *funcSink) accepts(a *Alert) bool {
+	return a.Resolved || a.Err != nil || a.Severity >= SeverityError || s.Enabled(a.Level)
+}
+
+// prefixed returns a with the name and values of the sink in front of its
+// own.
+func (s *funcSink) prefixed(a *Alert) *Alert {
+	if s.name == "" && len(s.values) == 0 {
+		return a
+	}
+	c := *a
+	if s.name != "" {
+		if c.Name != "" {
+			c.Name = s.name + "/" + c.Name
+		} else {
+			c.Name = s.name
+		}
+	}
+	if len(s.values) > 0 {
+		c.Values = append(s.values[:len(s.values):len(s.values)], c.Values...)
+	}
+	return &c
+}
+
+func (s funcSink) WithValues(keysAndValues ...interface{}) Sink {
+	// Three slice args forces a copy, so WithValues on two copies of the
+	// same sink do not share their backing array.
+	s.values = append(s.values[:len(s.values):len(s.values)], keysAndValues...)
+	return &s
+}
+
+func (s funcSink) WithName(name string) Sink {
+	if s.name != "" {
+		s.name += "/"
+	}
+	s.name += name
+	return &s
+}
+
+func (s *funcSink) emit(a *Alert) {
+	a.Time = s.opts.Clock.Now()
+	a.Name = s.name
+	a.Values = s.values
+	if sev, ok := severityOf(a.KeysAndValues); ok {
+		a.Severity = sev
+	} else if sev, ok := severityOf(a.Values); ok {
+		a.Severity = sev
+	}
+	if err := s.send(a); err != nil && s.opts.OnError != nil {
+		s.opts.OnError(a, err)
+	}
+}
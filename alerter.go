@@ -16,6 +16,13 @@ limitations under the License.
 
 package alerter
 
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
 // New returns a new Alerter instance.  This is primarily used by libraries
 // implementing Sink, rather than end users.
 func New(sink Sink) Alerter {
@@ -54,6 +61,23 @@ func (a Alerter) WithSink(sink Sink) Alerter {
 type Alerter struct {
 	sink  Sink
 	level int
+
+	// caller enables attaching the call site to every alert, callDepth
+	// is the number of extra frames to skip when doing so.
+	caller    bool
+	callDepth int
+
+	// stack, if set, enables attaching stack traces to errors.
+	stack *StackOptions
+
+	// errorChain enables expanding the chain of wrapped errors.
+	errorChain bool
+
+	// emergency, if set, receives Emergency alerts instead of sink.
+	emergency *emergency
+
+	// conventions, if set, replaces DefaultConventions.
+	conventions *Conventions
 }
 
 // Enabled tests whether this Logger is enabled.  For example, commandline
@@ -70,6 +94,9 @@ func (a Alerter) Enabled() bool {
 // values.
 func (a Alerter) Info(msg string, keysAndValues ...interface{}) {
 	if a.sink != nil && a.Enabled() {
+		if a.caller {
+			keysAndValues = a.withCaller(keysAndValues)
+		}
 		a.sink.Info(a.level, msg, keysAndValues...)
 	}
 }
@@ -84,10 +111,34 @@ func (a Alerter) Info(msg string, keysAndValues ...interface{}) {
 // triggered this alert line, if present.
 func (a Alerter) Error(err error, msg string, keysAndValues ...interface{}) {
 	if a.sink != nil {
+		if a.caller {
+			keysAndValues = a.withCaller(keysAndValues)
+		}
+		if a.stack != nil {
+			keysAndValues = a.withStack(keysAndValues)
+		}
+		if a.errorChain && err != nil {
+			keysAndValues = withErrorChain(err, keysAndValues)
+		}
 		a.sink.Error(err, msg, keysAndValues...)
 	}
 }
 
+// Resolve reports that the condition behind an earlier alert has cleared.  The
+// alert is identified by the Alerter's name and values together with msg, so
+// callers should resolve through the same Alerter they alerted with.  The
+// key/value pairs may carry additional detail about the recovery.
+//
+// Sinks which do not implement Resolver silently ignore the call.
+func (a Alerter) Resolve(msg string, keysAndValues ...interface{}) {
+	if r, ok := a.sink.(Resolver); ok {
+		if a.caller {
+			keysAndValues = a.withCaller(keysAndValues)
+		}
+		r.Resolve(msg, keysAndValues...)
+	}
+}
+
 // V returns a new Alerter instance for a specific verbosity level, relative to
 // this Alerter.  In other words, V-levels are additive.  A higher verbosity
 // level means a log message is less important.  Negative V-levels are treated
@@ -108,6 +159,11 @@ func (a Alerter) WithValues(keysAndValues ...interface{}) Alerter {
 	if a.sink != nil {
 		a.setSink(a.sink.WithValues(keysAndValues...))
 	}
+	if a.emergency != nil {
+		e := *a.emergency
+		e.sink = e.sink.WithValues(keysAndValues...)
+		a.emergency = &e
+	}
 	return a
 }
 
@@ -120,9 +176,52 @@ func (a Alerter) WithName(name string) Alerter {
 	if a.sink != nil {
 		a.setSink(a.sink.WithName(name))
 	}
+	if a.emergency != nil {
+		e := *a.emergency
+		e.sink = e.sink.WithName(name)
+		a.emergency = &e
+	}
 	return a
 }
 
+// WithCallDepth returns a new Alerter instance that offsets the call site
+// attached by WithCaller, and the one reported by sinks implementing
+// CallDepthSink, by depth frames.  This is useful for helper functions which
+// alert on behalf of their caller: a depth of 1 attributes the alert to the
+// caller of the helper instead of the helper itself.
+func (a Alerter) WithCallDepth(depth int) Alerter {
+	if a.sink == nil {
+		return a
+	}
+	if s, ok := a.sink.(CallDepthSink); ok {
+		a.setSink(s.WithCallDepth(depth))
+	}
+	a.callDepth += depth
+	return a
+}
+
+// WithCaller returns a new Alerter instance which attaches the call site of
+// every alert, as a Caller value with the key CallerKey, to its key/value
+// pairs.  Sinks therefore receive the file, line and function of the code
+// which raised an alert like any other structured field.
+func (a Alerter) WithCaller(enabled bool) Alerter {
+	a.caller = enabled
+	return a
+}
+
+// withCaller returns keysAndValues with the call site of the Alerter method
+// calling it appended.  The original slice is never modified.
+func (a Alerter) withCaller(keysAndValues []interface{}) []interface{} {
+	var pcs [1]uintptr
+	// Skip runtime.Callers, withCaller and the Alerter method.
+	if runtime.Callers(3+a.callDepth, pcs[:]) == 0 {
+		return keysAndValues
+	}
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	c := Caller{File: frame.File, Line: frame.Line, Function: frame.Function}
+	return append(keysAndValues[:len(keysAndValues):len(keysAndValues)], CallerKey, c)
+}
+
 type Sink interface {
 	// Enabled tests whether this Sink is enabled at the specified V-levea.
 	// For example, commandline flags might be used to set the alerting
@@ -148,6 +247,26 @@ type Sink interface {
 	WithName(name string) Sink
 }
 
+// CallDepthSink is an optional interface that a Sink may implement if it
+// determines the call site of alerts itself, e.g. by wrapping a logging
+// library.  Sinks relying on Alerter.WithCaller do not need it.
+type CallDepthSink interface {
+	// WithCallDepth returns a Sink that will offset the call stack by the
+	// specified number of frames when determining call site information.
+	// See Alerter.WithCallDepth for more details.
+	WithCallDepth(depth int) Sink
+}
+
+// Resolver is an optional interface that a Sink may implement to be told when
+// the condition behind an earlier alert has cleared, for example to close an
+// incident that was opened for it.
+type Resolver interface {
+	// Resolve marks the alert with the given message, raised with the
+	// Sink's current name and values, as resolved.  See Alerter.Resolve
+	// for more details.
+	Resolve(msg string, keysAndValues ...interface{})
+}
+
 // Marshaler is an optional interface that alerted values may choose to
 // implement. Alerters with structured output, such as JSON, should
 // alert the object return by the MarshalAlert method instead of the
@@ -165,3 +284,32 @@ type Marshaler interface {
 	// It may return any value of any type.
 	MarshalAlert() interface{}
 }
+
+// CallerKey is the key under which Alerter.WithCaller attaches the call site
+// of an alert.
+const CallerKey = "caller"
+
+// Caller is the call site of an alert.
+type Caller struct {
+	// File is the full path of the source file.
+	File string
+	// Line is the line number within File.
+	Line int
+	// Function is the fully qualified function name.
+	Function string
+}
+
+// String returns the call site in the short form "dir/file.go:42".
+func (c Caller) String() string {
+	return fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(c.File)), filepath.Base(c.File), c.Line)
+}
+
+// MarshalJSON implements json.Marshaler, so structured sinks write the call
+// site as an object rather than its short form.
+func (c Caller) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File     string `json:"file"`
+		Line     int    `json:"line"`
+		Function string `json:"function"`
+	}{c.File, c.Line, c.Function})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package checks runs periodic health checks and turns the problems they
+// find into alerts.
+//
+// A Check reports the problems it currently sees.  The Runner remembers what
+// each check reported the previous time: new problems are alerted through an
+// Alerter named after the check, and problems which are no longer reported
+// are resolved.  A check therefore never needs to keep state of its own.
+package checks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Problem is a single unhealthy condition found by a Check.
+type Problem struct {
+	// Object identifies what the problem is about, e.g. a device or a
+	// mount point.  It is attached to the alert as the "object" value.
+	Object string
+
+	// Message is the constant description of the problem.  Together with
+	// Object it identifies the problem between two runs.
+	Message string
+
+	// Err is the underlying error, if any.
+	Err error
+
+	// KeysAndValues carry additional detail.  They may change between
+	// runs without raising a new alert.
+	KeysAndValues []interface{}
+}
+
+// Check inspects one aspect of the system.
+type Check interface {
+	// Name identifies the check.  It is used as the name of the Alerter
+	// the check's problems are reported through.
+	Name() string
+
+	// Run returns the problems currently present, or an error if the
+	// check itself could not be carried out.
+	Run(ctx context.Context) ([]Problem, error)
+}
+
+// Func returns a Check with the given name which calls fn.
+func Func(name string, fn func(ctx context.Context) ([]Problem, error)) Check {
+	return funcCheck{name: name, fn: fn}
+}
+
+type funcCheck struct {
+	name string
+	fn   func(ctx context.Context) ([]Problem, error)
+}
+
+func (c funcCheck) Name() string { return c.name }
+
+func (c funcCheck) Run(ctx context.Context) ([]Problem, error) { return c.fn(ctx) }
+
+const msgCheckFailed = "check failed"
+
+// Runner runs a set of checks and alerts on the problems they report.
+type Runner struct {
+	alerter  alerter.Alerter
+	interval time.Duration
+	checks   []Check
+	state    map[string]*checkState
+}
+
+type checkState struct {
+	failing  bool
+	problems map[problemKey]bool
+}
+
+type problemKey struct {
+	object  string
+	message string
+}
+
+// NewRunner returns a Runner which runs checks every interval and reports
+// through a.  Check names must be unique within a Runner.
+func NewRunner(a alerter.Alerter, interval time.Duration, checks ...Check) *Runner {
+	r := &Runner{
+		alerter:  a,
+		interval: interval,
+		checks:   checks,
+		state:    make(map[string]*checkState, len(checks)),
+	}
+	for _, c := range checks {
+		r.state[c.Name()] = &checkState{problems: map[problemKey]bool{}}
+	}
+	return r
+}
+
+// Run runs all checks every interval until ctx is cancelled.
+func (r *Runner) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		r.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce runs every check once, alerting on new problems and resolving those
+// which are gone.  A check which fails to run is alerted as well; the
+// problems it reported before are kept until it runs successfully again.
+func (r *Runner) RunOnce(ctx context.Context) {
+	for _, c := range r.checks {
+		r.runCheck(ctx, c)
+	}
+}
+
+func (r *Runner) runCheck(ctx context.Context, c Check) {
+	st := r.state[c.Name()]
+	ca := r.alerter.WithName(c.Name())
+
+	problems, err := c.Run(ctx)
+	if err != nil {
+		if ctx.Err() == nil && !st.failing {
+			st.failing = true
+			ca.Error(err, msgCheckFailed)
+		}
+		return
+	}
+	if st.failing {
+		st.failing = false
+		ca.Resolve(msgCheckFailed)
+	}
+
+	current := make(map[problemKey]bool, len(problems))
+	for _, p := range problems {
+		key := problemKey{object: p.Object, message: p.Message}
+		current[key] = true
+		if st.problems[key] {
+			continue
+		}
+		err := p.Err
+		if err == nil {
+			err = fmt.Errorf("%s: %s", p.Object, p.Message)
+		}
+		ca.WithValues("object", p.Object).Error(err, p.Message, p.KeysAndValues...)
+	}
+	for key := range st.problems {
+		if !current[key] {
+			ca.WithValues("object", key.object).Resolve(key.message)
+		}
+	}
+	st.problems = current
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sumengzs/alerter/checks"
+)
+
+// Inodes checks that file systems do not run out of inodes, which makes
+// file creation fail even though there is free space left.
+type Inodes struct {
+	// Paths lists mount points, or any path within the file systems to
+	// check.  Defaults to "/".
+	Paths []string
+
+	// Threshold is the fraction of used inodes above which a file system
+	// is reported, e.g. 0.9.  Defaults to 0.9.
+	Threshold float64
+}
+
+var _ checks.Check = Inodes{}
+
+// Name implements checks.Check.
+func (Inodes) Name() string { return "inodes" }
+
+// Run implements checks.Check.
+func (c Inodes) Run(ctx context.Context) ([]checks.Problem, error) {
+	paths := c.Paths
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	threshold := c.Threshold
+	if threshold <= 0 {
+		threshold = 0.9
+	}
+
+	var problems []checks.Problem
+	for _, path := range paths {
+		total, free, err := inodes(path)
+		if err != nil {
+			return nil, err
+		}
+		if total == 0 {
+			// File systems such as btrfs allocate inodes dynamically.
+			continue
+		}
+		used := float64(total-free) / float64(total)
+		if used >= threshold {
+			problems = append(problems, checks.Problem{
+				Object:        path,
+				Message:       "inodes nearly exhausted",
+				Err:           fmt.Errorf("%s: %.1f%% of inodes in use", path, used*100),
+				KeysAndValues: []interface{}{"total", total, "free", free},
+			})
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package host
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/sumengzs/alerter/checks"
+)
+
+// RAID checks the state of Linux software RAID (md) arrays as reported by
+// /proc/mdstat.  Machines without md arrays are always healthy.
+type RAID struct {
+	// Path is the file to read.  Defaults to "/proc/mdstat".
+	Path string
+}
+
+var _ checks.Check = RAID{}
+
+// Name implements checks.Check.
+func (RAID) Name() string { return "raid" }
+
+// Run implements checks.Check.
+func (c RAID) Run(ctx context.Context) ([]checks.Problem, error) {
+	path := c.Path
+	if path == "" {
+		path = "/proc/mdstat"
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []checks.Problem
+	for _, md := range parseMdstat(string(data)) {
+		switch {
+		case md.state != "active":
+			problems = append(problems, checks.Problem{
+				Object:        md.name,
+				Message:       "RAID array inactive",
+				Err:           fmt.Errorf("%s is %s", md.name, md.state),
+				KeysAndValues: []interface{}{"level", md.level},
+			})
+		case len(md.failed) > 0 || strings.Contains(md.status, "_"):
+			problems = append(problems, checks.Problem{
+				Object:        md.name,
+				Message:       "RAID array degraded",
+				Err:           fmt.Errorf("%s is degraded: %s", md.name, md.status),
+				KeysAndValues: []interface{}{"level", md.level, "status", md.status, "failed", md.failed},
+			})
+		}
+	}
+	return problems, nil
+}
+
+type mdArray struct {
+	name   string
+	state  string
+	level  string
+	failed []string
+	status string
+}
+
+var (
+	// md0 : active raid1 sdb1[1] sda1[0](F)
+	mdLine = regexp.MustCompile(`^(md\S*)\s*:\s*(\S+)\s*(\(\S+\)\s*)?(raid\d+|linear|multipath)?\s*(.*)$`)
+	// 1953382400 blocks super 1.2 [2/1] [U_]
+	mdStatus = regexp.MustCompile(`\[(\d+)/(\d+)\]\s*\[([U_]+)\]`)
+)
+
+func parseMdstat(data string) []mdArray {
+	var arrays []mdArray
+	for _, line := range strings.Split(data, "\n") {
+		if m := mdLine.FindStringSubmatch(line); m != nil {
+			md := mdArray{name: m[1], state: m[2], level: m[4]}
+			for _, member := range strings.Fields(m[5]) {
+				if strings.HasSuffix(member, "(F)") {
+					disk, _, _ := strings.Cut(member, "[")
+					md.failed = append(md.failed, disk)
+				}
+			}
+			arrays = append(arrays, md)
+			continue
+		}
+		if m := mdStatus.FindStringSubmatch(line); m != nil && len(arrays) > 0 && arrays[len(arrays)-1].status == "" {
+			arrays[len(arrays)-1].status = m[3]
+		}
+	}
+	return arrays
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package host provides optional checks of the health of the local machine,
+// for use with checks.Runner when this module serves as a lightweight node
+// agent.
+package host
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/sumengzs/alerter/checks"
+)
+
+// SMART checks the SMART overall-health status of disks with smartctl from
+// smartmontools, which usually requires root privileges.
+type SMART struct {
+	// Devices lists the devices to check, e.g. "/dev/sda".  When empty,
+	// the devices found by "smartctl --scan" are checked.
+	Devices []string
+
+	// Smartctl is the path of the smartctl binary.  Defaults to
+	// "smartctl", looked up in PATH.
+	Smartctl string
+}
+
+var _ checks.Check = SMART{}
+
+// Name implements checks.Check.
+func (SMART) Name() string { return "smart" }
+
+// Run implements checks.Check.
+func (c SMART) Run(ctx context.Context) ([]checks.Problem, error) {
+	devices := c.Devices
+	if len(devices) == 0 {
+		out, err := c.smartctl(ctx, "--scan")
+		if err != nil {
+			return nil, err
+		}
+		devices = parseScan(out)
+	}
+
+	var problems []checks.Problem
+	for _, dev := range devices {
+		out, err := c.smartctl(ctx, "--health", "--json", dev)
+		// smartctl signals a failing disk through its exit status, so the
+		// report is inspected before the error.
+		var report smartReport
+		if jerr := json.Unmarshal(out, &report); jerr != nil || report.SmartStatus == nil {
+			if err == nil {
+				err = errors.New("no SMART status in smartctl output")
+			}
+			problems = append(problems, checks.Problem{
+				Object:  dev,
+				Message: "SMART status unavailable",
+				Err:     err,
+			})
+			continue
+		}
+		if !report.SmartStatus.Passed {
+			problems = append(problems, checks.Problem{
+				Object:        dev,
+				Message:       "SMART health check failed",
+				Err:           fmt.Errorf("%s: SMART overall-health self-assessment failed", dev),
+				KeysAndValues: []interface{}{"model", report.ModelName, "serial", report.SerialNumber},
+			})
+		}
+	}
+	return problems, nil
+}
+
+type smartReport struct {
+	ModelName    string `json:"model_name"`
+	SerialNumber string `json:"serial_number"`
+	SmartStatus  *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+}
+
+func (c SMART) smartctl(ctx context.Context, args ...string) ([]byte, error) {
+	bin := c.Smartctl
+	if bin == "" {
+		bin = "smartctl"
+	}
+	out, err := exec.CommandContext(ctx, bin, args...).Output()
+	if err != nil {
+		return out, fmt.Errorf("smartctl %s: %w", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// parseScan returns the devices listed by "smartctl --scan", which prints
+// lines such as "/dev/sda -d scsi # /dev/sda, SCSI device".
+func parseScan(out []byte) []string {
+	var devices []string
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if fields := strings.Fields(string(line)); len(fields) > 0 && !strings.HasPrefix(fields[0], "#") {
+			devices = append(devices, fields[0])
+		}
+	}
+	return devices
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time.  Built-in sinks and watchers read the time through
+// a Clock, for alert timestamps as well as for windows and expiry, so that
+// tests can control it and deployments can standardize on a time zone.
+// Their timers are started with AfterFunc, following the Clock if it
+// implements TimerClock.
+type Clock interface {
+	Now() time.Time
+}
+
+// TimerClock is a Clock which also runs timers, such as ManualClock.
+type TimerClock interface {
+	Clock
+
+	// AfterFunc waits until the clock moved on by d and then calls f, as
+	// time.AfterFunc does.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer started by AfterFunc.  *time.Timer implements it.
+type Timer interface {
+	// Stop prevents the timer from firing.  It reports whether it
+	// stopped the timer, i.e. false if the timer already fired or was
+	// stopped.
+	Stop() bool
+
+	// Reset changes the timer to fire after d and reports whether it
+	// was active.
+	Reset(d time.Duration) bool
+}
+
+// AfterFunc calls f in its own goroutine after d has passed on c: through
+// c's AfterFunc if c is a TimerClock, and with time.AfterFunc otherwise.
+func AfterFunc(c Clock, d time.Duration, f func()) Timer {
+	if tc, ok := c.(TimerClock); ok {
+		return tc.AfterFunc(d, f)
+	}
+	return time.AfterFunc(d, f)
+}
+
+// Sleep pauses the calling goroutine until d has passed on c, with a timer
+// started by AfterFunc, so that waits follow a ManualClock in tests.
+func Sleep(c Clock, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	done := make(chan struct{})
+	AfterFunc(c, d, func() { close(done) })
+	<-done
+}
+
+// ClockFunc adapts a function to the Clock interface.
+type ClockFunc func() time.Time
+
+// Now implements Clock.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock is the Clock of the local system.  It is used wherever a Clock
+// is optional and not set.
+var SystemClock Clock = ClockFunc(time.Now)
+
+// UTC returns a Clock which reports the time of c in UTC.  Its timers are
+// those of c.
+func UTC(c Clock) Clock {
+	return utcClock{c}
+}
+
+type utcClock struct {
+	c Clock
+}
+
+func (u utcClock) Now() time.Time {
+	return u.c.Now().UTC()
+}
+
+func (u utcClock) AfterFunc(d time.Duration, f func()) Timer {
+	return AfterFunc(u.c, d, f)
+}
+
+// clockOr returns c, or SystemClock if c is nil.
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
+
+// ManualClock is a Clock which only moves when told to, for deterministic
+// tests.  It is safe for concurrent use.
+//
+// Its timers fire as Set and Advance move it past them, in the order of
+// their deadlines, with the clock set to the deadline of each, and before
+// Set and Advance return.  Unlike those of time.AfterFunc, their functions
+// run in the goroutine moving the clock; timers started with a deadline
+// which already passed fire right away in their own goroutine.
+type ManualClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+// manualTimer is a timer of a ManualClock.  It is active while it is in the
+// timers of its clock.
+type manualTimer struct {
+	c  *ManualClock
+	at time.Time
+	f  func()
+}
+
+// NewManualClock returns a ManualClock set to now.
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+// Now implements Clock.
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set sets the clock to now, firing the timers it passes.
+func (c *ManualClock) Set(now time.Time) {
+	c.moveTo(func(time.Time) time.Time { return now })
+}
+
+// Advance moves the clock forward by d, firing the timers it passes.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.moveTo(func(now time.Time) time.Time { return now.Add(d) })
+}
+
+// AfterFunc implements TimerClock.
+func (c *ManualClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &manualTimer{c: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Len returns the number of timers which have not fired or been stopped.
+func (c *ManualClock) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// moveTo sets the clock to the time end returns for the current time, and
+// fires the timers due until then, including the ones they start.
+func (c *ManualClock) moveTo(end func(now time.Time) time.Time) {
+	c.mu.Lock()
+	target := end(c.now)
+	for len(c.timers) > 0 && !c.timers[0].at.After(target) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = target
+	c.mu.Unlock()
+}
+
+// remove deactivates t and reports whether it was active.  It must be
+// called with c.mu held.
+func (c *ManualClock) remove(t *manualTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *manualTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	return t.c.remove(t)
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	c := t.c
+	c.mu.Lock()
+	active := c.remove(t)
+	if d <= 0 {
+		c.mu.Unlock()
+		go t.f()
+		return active
+	}
+	t.at = c.now.Add(d)
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].at.After(t.at) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	c.mu.Unlock()
+	return active
+}
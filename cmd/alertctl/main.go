@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command alertctl is a command line tool for working with alerter configs.
+//
+// Usage:
+//
+//	alertctl schema [component]
+//	alertctl queue ls path
+//	alertctl queue peek path
+//	alertctl queue drop path seq...
+//	alertctl queue requeue path seq...
+//
+// The schema command prints the JSON Schema of the config of a built-in
+// component, or of all of them combined, for use by editors and validation
+// in GitOps pipelines.
+//
+// The queue commands work on the log of a middleware.DiskQueue which is not
+// open, for triaging alerts which hold it up.  ls prints the alerts which
+// were not delivered yet, one line of JSON each, with their sequence number
+// as "seq", and peek the first of them, which is delivered next.  drop
+// removes the alerts with the given sequence numbers without delivering
+// them, and requeue moves them to the end of the queue.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/config"
+	"github.com/sumengzs/alerter/middleware"
+	"github.com/sumengzs/alerter/sinks/file"
+	"github.com/sumengzs/alerter/watch/process"
+)
+
+// components maps the names of built-in components to their config types.
+var components = map[string]interface{}{
+	"process": process.Config{},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "schema":
+		err = schema(os.Args[2:])
+	case "queue":
+		err = queue(os.Args[2:])
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "alertctl: unknown command %q\n", os.Args[1])
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "alertctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: alertctl schema [%s]\n       alertctl queue ls|peek path\n       alertctl queue drop|requeue path seq...\n", names())
+	os.Exit(2)
+}
+
+func names() string {
+	var names []string
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+func schema(args []string) error {
+	var s map[string]interface{}
+	switch len(args) {
+	case 0:
+		// Config files are composed of the component configs, so the
+		// combined schema merges their fields and leaves room for those
+		// of the application.
+		props := map[string]interface{}{}
+		for _, c := range components {
+			for k, v := range config.Schema(c)["properties"].(map[string]interface{}) {
+				props[k] = v
+			}
+		}
+		s = map[string]interface{}{
+			"$schema":    config.SchemaDraft,
+			"type":       "object",
+			"properties": props,
+		}
+	case 1:
+		c, ok := components[args[0]]
+		if !ok {
+			return fmt.Errorf("unknown component %q, want one of %s", args[0], names())
+		}
+		s = config.Schema(c)
+	default:
+		usage()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
+
+func queue(args []string) error {
+	if len(args) < 2 {
+		usage()
+	}
+	cmd, path, args := args[0], args[1], args[2:]
+	switch cmd {
+	case "ls", "peek":
+		if len(args) != 0 {
+			usage()
+		}
+		return list(path, cmd == "peek")
+	case "drop", "requeue":
+		if len(args) == 0 {
+			usage()
+		}
+		seqs := make([]uint64, len(args))
+		for i, arg := range args {
+			seq, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid sequence number %q", arg)
+			}
+			seqs[i] = seq
+		}
+		if cmd == "drop" {
+			return middleware.DropFromDiskQueue(path, seqs...)
+		}
+		return middleware.RequeueInDiskQueue(path, seqs...)
+	default:
+		fmt.Fprintf(os.Stderr, "alertctl: unknown queue command %q\n", cmd)
+		usage()
+	}
+	return nil
+}
+
+// list prints the alerts of the queue at path, or only the first one.
+func list(path string, first bool) error {
+	// A corrupt log is reported after the alerts which could be read.
+	entries, err := middleware.ListDiskQueue(path)
+	if first && len(entries) > 1 {
+		entries = entries[:1]
+	}
+	out := file.New(os.Stdout, math.MaxInt).GetSink()
+	for _, e := range entries {
+		a := *e.Alert
+		a.KeysAndValues = append([]interface{}{"seq", e.Seq}, a.KeysAndValues...)
+		if err := alerter.Send(out, &a); err != nil {
+			return err
+		}
+	}
+	return err
+}
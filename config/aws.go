@@ -0,0 +1,198 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSSecretsManager is a SecretProvider which reads from AWS Secrets
+// Manager.  Secret names are secret IDs or ARNs, optionally followed by
+// "#key" to pick a key of a secret stored as a JSON object, e.g.
+// ${secret:prod/alerting#pagerduty}.
+type AWSSecretsManager struct {
+	// Region of the secrets.  Defaults to $AWS_REGION.
+	Region string
+
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials
+	// requests are signed with.  They default to the standard
+	// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN
+	// environment variables.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Endpoint overrides the service URL, e.g. for VPC endpoints.
+	Endpoint string
+
+	// HTTPClient sends the requests.  Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+var _ SecretProvider = AWSSecretsManager{}
+
+// Secret implements SecretProvider.
+func (m AWSSecretsManager) Secret(ctx context.Context, name string) (string, error) {
+	id, key, hasKey := strings.Cut(name, "#")
+	region := orEnv(m.Region, "AWS_REGION")
+	endpoint := m.Endpoint
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com/"
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signV4(req, body, time.Now().UTC(), region, "secretsmanager",
+		orEnv(m.AccessKeyID, "AWS_ACCESS_KEY_ID"),
+		orEnv(m.SecretAccessKey, "AWS_SECRET_ACCESS_KEY"),
+		orEnv(m.SessionToken, "AWS_SESSION_TOKEN"))
+
+	client := m.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &e)
+		err := fmt.Errorf("secrets manager: %s: %s %s", resp.Status, e.Type, e.Message)
+		if strings.HasSuffix(e.Type, "ResourceNotFoundException") {
+			err = fmt.Errorf("%w: %v", ErrSecretNotFound, err)
+		}
+		return "", err
+	}
+	var out struct {
+		SecretString *string
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return "", errors.New("secrets manager: binary secrets are not supported")
+	}
+	if !hasKey {
+		return *out.SecretString, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(*out.SecretString), &fields); err != nil {
+		return "", fmt.Errorf("secrets manager: %s is not a JSON object: %w", id, err)
+	}
+	v, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secrets manager: %s has no key %q: %w", id, key, ErrSecretNotFound)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
+
+func orEnv(v, env string) string {
+	if v != "" {
+		return v
+	}
+	return os.Getenv(env)
+}
+
+// signV4 signs req with AWS Signature Version 4.
+func signV4(req *http.Request, body []byte, now time.Time, region, service, keyID, secret, token string) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+keyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
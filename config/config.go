@@ -0,0 +1,114 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config loads the JSON config file of an alerting pipeline.
+//
+// The file has no fixed layout: applications compose it from the config
+// types of the components they use, such as process.Config, and decode it
+// with Load.  Unknown fields are rejected so that typos do not silently
+// disable a setting.
+//
+// String values may reference secrets as ${secret:NAME}, which a Loader
+// resolves through its SecretProviders, so that tokens and passwords never
+// have to appear in the file itself:
+//
+//	{"webhook": "https://hooks.example.com/${secret:webhook-token}"}
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Loader loads config files, resolving secret references with Secrets.
+type Loader struct {
+	// Secrets are asked in order for every referenced secret; the first
+	// one which knows it wins.  A file referencing secrets fails to load
+	// when there are none.
+	Secrets []SecretProvider
+}
+
+// Load decodes the JSON file at path into v.  It is a shorthand for
+// Loader{}.Load, so files referencing secrets fail to load.
+func Load(path string, v interface{}) error {
+	return Loader{}.Load(context.Background(), path, v)
+}
+
+// Decode decodes JSON data into v, rejecting unknown fields.  It is a
+// shorthand for Loader{}.Decode, so data referencing secrets fails to
+// decode.
+func Decode(data []byte, v interface{}) error {
+	return Loader{}.Decode(context.Background(), data, v)
+}
+
+// Load decodes the JSON file at path into v.
+func (l Loader) Load(ctx context.Context, path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := l.Decode(ctx, data, v); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Decode decodes JSON data into v, rejecting unknown fields and resolving
+// secret references in string values.
+func (l Loader) Decode(ctx context.Context, data []byte, v interface{}) error {
+	if bytes.Contains(data, []byte(secretPrefix)) {
+		var err error
+		if data, err = l.resolveSecrets(ctx, data); err != nil {
+			return err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// Duration is a time.Duration which is written as a string such as "1m30s"
+// in the config file.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  Bare numbers are accepted as
+// nanoseconds.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid duration %s", data)
+		}
+		*d = Duration(n)
+		return nil
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
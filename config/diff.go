@@ -0,0 +1,51 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/sumengzs/alerter"
+)
+
+// Operations of a Change.
+const (
+	Added   = alerter.DiffAdded
+	Removed = alerter.DiffRemoved
+	Changed = alerter.DiffChanged
+)
+
+// Change is a single difference between two configs.
+type Change = alerter.Change
+
+// Diff returns the changes between two configs, such as the one in use and
+// one that was just reloaded, ordered by path.  The configs are compared by
+// their JSON encoding, so old and new may be decoded config types or, as
+// []byte, raw file contents.  Diffing raw contents keeps ${secret:NAME}
+// references in the changes instead of the secrets they resolve to, which
+// makes the result safe to alert or log.
+func Diff(old, new interface{}) ([]Change, error) {
+	changes, err := alerter.DiffValues(old, new)
+	return []Change(changes), err
+}
+
+// Report alerts changes through a as a single "config changed" info alert,
+// so that live changes can be audited through any sink.  Nothing is alerted
+// without changes.
+func Report(a alerter.Alerter, changes []Change) {
+	if len(changes) > 0 {
+		a.Info("config changed", "changes", alerter.Difference(changes))
+	}
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package git implements a config source which reads the config file from a
+// Git repository, so that changes to alerting are reviewed through pull
+// requests before they go live.
+//
+// The Source polls the repository and can additionally be triggered by a
+// push webhook:
+//
+//	src := git.New(git.Options{
+//		URL:              "https://git.example.com/ops/alerting.git",
+//		Path:             "alerting.json",
+//		VerifySignatures: true,
+//	})
+//	http.Handle("/hooks/config", src)
+//	go src.Watch(ctx, func(data []byte, commit string) error {
+//		var cfg Config
+//		if err := loader.Decode(ctx, data, &cfg); err != nil {
+//			return err
+//		}
+//		apply(cfg)
+//		return nil
+//	})
+//
+// Repositories are accessed with the git command, so credentials and
+// signature trust are configured the way they are for git itself.
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options carries parameters which influence the way a Source fetches the
+// config.
+type Options struct {
+	// URL of the repository.
+	URL string
+
+	// Branch to read.  Defaults to "main".
+	Branch string
+
+	// Path of the config file within the repository.
+	Path string
+
+	// Dir is the local directory the repository is fetched into.  It is
+	// created if needed, and only reused if it belongs to the current
+	// user and no one else may write to it.  Defaults to a directory
+	// below os.UserCacheDir, or a new temporary directory if there is no
+	// cache directory.
+	Dir string
+
+	// Interval is the time between two polls.  Defaults to one minute.
+	Interval time.Duration
+
+	// VerifySignatures rejects commits without a valid signature from a
+	// key trusted by git, as checked by "git verify-commit".
+	VerifySignatures bool
+
+	// WebhookSecret verifies the X-Hub-Signature-256 header of webhook
+	// requests.  Without it, any request triggers a fetch.
+	WebhookSecret string
+
+	// OnError is called when fetching or applying the config fails.  The
+	// Source keeps serving the last good config and retries on the next
+	// poll.
+	OnError func(err error)
+
+	// Git is the git executable.  Defaults to "git" from $PATH.
+	Git string
+}
+
+// Source reads a config file from a Git repository.
+type Source struct {
+	opts    Options
+	trigger chan struct{}
+
+	// fetch serializes fetches, which share dir.
+	fetch sync.Mutex
+	dir   string
+
+	mu   sync.Mutex
+	last string
+}
+
+// New returns a Source with the given options.
+func New(opts Options) *Source {
+	if opts.Branch == "" {
+		opts.Branch = "main"
+	}
+	if opts.Dir == "" {
+		if cache, err := os.UserCacheDir(); err == nil {
+			sum := sha256.Sum256([]byte(opts.URL))
+			opts.Dir = filepath.Join(cache, "alerter", "config-"+hex.EncodeToString(sum[:8]))
+		}
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Git == "" {
+		opts.Git = "git"
+	}
+	return &Source{opts: opts, trigger: make(chan struct{}, 1)}
+}
+
+// Fetch fetches the branch and returns the contents of the config file at
+// its head together with the commit ID.
+func (s *Source) Fetch(ctx context.Context) (data []byte, commit string, err error) {
+	s.fetch.Lock()
+	defer s.fetch.Unlock()
+	if err := s.prepare(ctx); err != nil {
+		return nil, "", err
+	}
+	if _, err := s.git(ctx, "fetch", "--quiet", "--depth=1", "--", s.opts.URL, s.opts.Branch); err != nil {
+		return nil, "", err
+	}
+	out, err := s.git(ctx, "rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return nil, "", err
+	}
+	commit = strings.TrimSpace(string(out))
+	if s.opts.VerifySignatures {
+		if _, err := s.git(ctx, "verify-commit", commit); err != nil {
+			return nil, "", fmt.Errorf("commit %s is not trusted: %w", commit, err)
+		}
+	}
+	data, err = s.git(ctx, "show", commit+":"+strings.TrimPrefix(s.opts.Path, "/"))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, commit, nil
+}
+
+// prepare creates the repository in s.dir, or checks that the existing one
+// may be trusted.  It must be called with s.fetch held.
+func (s *Source) prepare(ctx context.Context) error {
+	if s.dir == "" {
+		if s.opts.Dir == "" {
+			dir, err := os.MkdirTemp("", "alerter-config-")
+			if err != nil {
+				return err
+			}
+			s.dir = dir
+		} else {
+			info, err := os.Stat(s.opts.Dir)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				if err := os.MkdirAll(s.opts.Dir, 0o700); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			default:
+				// Another user could have created the directory first,
+				// with a repository feeding us their config or hooks.
+				if err := checkOwner(s.opts.Dir, info); err != nil {
+					return err
+				}
+			}
+			s.dir = s.opts.Dir
+		}
+	}
+	if _, err := os.Stat(filepath.Join(s.dir, "HEAD")); err == nil {
+		return nil
+	}
+	_, err := s.git(ctx, "init", "--quiet", "--bare")
+	return err
+}
+
+// Watch fetches the config every Options.Interval, and whenever the webhook
+// is triggered, until ctx is cancelled.  Every time the branch moved to a
+// new commit, apply is called with the config file of that commit.  A
+// commit is applied only once, unless apply returns an error.
+func (s *Source) Watch(ctx context.Context, apply func(data []byte, commit string) error) error {
+	ticker := time.NewTicker(s.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := s.poll(ctx, apply); err != nil && ctx.Err() == nil && s.opts.OnError != nil {
+			s.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-s.trigger:
+		}
+	}
+}
+
+func (s *Source) poll(ctx context.Context, apply func(data []byte, commit string) error) error {
+	data, commit, err := s.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	seen := commit == s.last
+	s.mu.Unlock()
+	if seen {
+		return nil
+	}
+	if err := apply(data, commit); err != nil {
+		return fmt.Errorf("commit %s: %w", commit, err)
+	}
+	s.mu.Lock()
+	s.last = commit
+	s.mu.Unlock()
+	return nil
+}
+
+// Commit returns the ID of the last applied commit.
+func (s *Source) Commit() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// ServeHTTP implements http.Handler for push webhooks as sent by GitHub,
+// GitLab and Gitea: every valid request makes Watch fetch immediately.
+func (s *Source) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.opts.WebhookSecret != "" && !validSignature(s.opts.WebhookSecret, body, r.Header) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+		// A fetch is already pending.
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validSignature checks the HMAC signature of GitHub and Gitea webhooks, or
+// the shared token of GitLab ones.
+func validSignature(secret string, body []byte, h http.Header) bool {
+	if token := h.Get("X-Gitlab-Token"); token != "" {
+		return hmac.Equal([]byte(token), []byte(secret))
+	}
+	sig := strings.TrimPrefix(h.Get("X-Hub-Signature-256"), "sha256=")
+	if sig == "" {
+		sig = h.Get("X-Gitea-Signature")
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(want, mac.Sum(nil))
+}
+
+func (s *Source) git(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, s.opts.Git, append([]string{"-C", s.dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && stderr.Len() > 0 {
+			return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return out, nil
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const secretPrefix = "${secret:"
+
+var secretRef = regexp.MustCompile(`\$\{secret:([^}]+)\}`)
+
+// ErrSecretNotFound is returned by a SecretProvider which does not know the
+// requested secret, so that the next provider is asked.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretProvider looks up secrets referenced from the config file.
+type SecretProvider interface {
+	// Secret returns the value of the named secret, or an error wrapping
+	// ErrSecretNotFound if the provider does not know it.
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// SecretFunc is a SecretProvider implemented by a function.
+type SecretFunc func(ctx context.Context, name string) (string, error)
+
+// Secret implements SecretProvider.
+func (f SecretFunc) Secret(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+// Env returns a SecretProvider which reads the environment variable prefix
+// followed by the secret name, e.g. ALERTER_SLACK_TOKEN for the name
+// SLACK_TOKEN and the prefix "ALERTER_".
+func Env(prefix string) SecretProvider {
+	return SecretFunc(func(ctx context.Context, name string) (string, error) {
+		if v, ok := os.LookupEnv(prefix + name); ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("environment variable %s%s: %w", prefix, name, ErrSecretNotFound)
+	})
+}
+
+// Files returns a SecretProvider which reads the secret from the file of the
+// same name in dir, as mounted by Docker and Kubernetes.  A single trailing
+// newline is removed.
+func Files(dir string) SecretProvider {
+	return SecretFunc(func(ctx context.Context, name string) (string, error) {
+		if name != filepath.Base(name) {
+			return "", fmt.Errorf("secret name %q must not contain a path", name)
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("%s: %w", filepath.Join(dir, name), ErrSecretNotFound)
+		}
+		if err != nil {
+			return "", err
+		}
+		data = bytes.TrimSuffix(data, []byte("\n"))
+		data = bytes.TrimSuffix(data, []byte("\r"))
+		return string(data), nil
+	})
+}
+
+// resolveSecrets replaces secret references in all string values of the JSON
+// document data.  Substitution happens on decoded strings, so secrets may
+// contain any character without breaking the document.
+func (l Loader) resolveSecrets(ctx context.Context, data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	cache := map[string]string{}
+	var walk func(v interface{}) (interface{}, error)
+	walk = func(v interface{}) (interface{}, error) {
+		var err error
+		switch v := v.(type) {
+		case string:
+			return l.expand(ctx, v, cache)
+		case map[string]interface{}:
+			for k, e := range v {
+				if v[k], err = walk(e); err != nil {
+					return nil, err
+				}
+			}
+		case []interface{}:
+			for i, e := range v {
+				if v[i], err = walk(e); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return v, nil
+	}
+	doc, err := walk(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func (l Loader) expand(ctx context.Context, s string, cache map[string]string) (string, error) {
+	if !strings.Contains(s, secretPrefix) {
+		return s, nil
+	}
+	var firstErr error
+	out := secretRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := secretRef.FindStringSubmatch(ref)[1]
+		if v, ok := cache[name]; ok {
+			return v
+		}
+		v, err := l.secret(ctx, name)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return ref
+		}
+		cache[name] = v
+		return v
+	})
+	return out, firstErr
+}
+
+func (l Loader) secret(ctx context.Context, name string) (string, error) {
+	if len(l.Secrets) == 0 {
+		return "", fmt.Errorf("secret %q referenced, but no secret providers are configured", name)
+	}
+	for _, p := range l.Secrets {
+		v, err := p.Secret(ctx, name)
+		if err == nil {
+			return v, nil
+		}
+		if !errors.Is(err, ErrSecretNotFound) {
+			return "", fmt.Errorf("secret %q: %w", name, err)
+		}
+	}
+	return "", fmt.Errorf("secret %q: %w", name, ErrSecretNotFound)
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sumengzs/alerter/vault"
+)
+
+// Vault returns a SecretProvider which reads from the KV version 2 secrets
+// engine mounted at mount, usually "secret".  Secret names have the form
+// "path#key", e.g. ${secret:alerting/slack#token}; without a key, the key
+// "value" is read.
+func Vault(c *vault.Client, mount string) SecretProvider {
+	return SecretFunc(func(ctx context.Context, name string) (string, error) {
+		path, key, ok := strings.Cut(name, "#")
+		if !ok {
+			key = "value"
+		}
+		s, err := c.Read(ctx, strings.Trim(mount, "/")+"/data/"+path)
+		if errors.Is(err, vault.ErrNotFound) {
+			return "", fmt.Errorf("%w: %v", ErrSecretNotFound, err)
+		}
+		if err != nil {
+			return "", err
+		}
+		// KV version 2 nests the secret below "data" once more, next to
+		// its metadata.
+		data, _ := s.Data["data"].(map[string]interface{})
+		v, ok := data[key]
+		if !ok {
+			return "", fmt.Errorf("vault %s has no key %q: %w", path, key, ErrSecretNotFound)
+		}
+		if str, ok := v.(string); ok {
+			return str, nil
+		}
+		return fmt.Sprint(v), nil
+	})
+}
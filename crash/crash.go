@@ -0,0 +1,230 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crash delivers a final critical alert when the process dies
+// unexpectedly.
+//
+// Recover handles panics of the goroutine it is deferred in:
+//
+//	func main() {
+//		defer crash.Recover(crash.Options{Alerter: a})
+//		...
+//	}
+//
+// Panics of other goroutines and fatal runtime errors, such as concurrent
+// map writes or running out of memory, cannot be recovered from.  Supervise
+// covers those by running the program as a child of itself and alerting
+// with the crash output when the child dies:
+//
+//	func main() {
+//		crash.Supervise(crash.Options{Alerter: a})
+//		...
+//	}
+package crash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Keys of the key/value pairs of crash alerts.
+const (
+	// OutputKey carries the crash output, such as the panic message and
+	// the stack traces of all goroutines, or its tail if it is long.
+	OutputKey = "output"
+
+	// ExitCodeKey carries the exit code of a supervised child.
+	ExitCodeKey = "exit_code"
+)
+
+// supervisedEnv marks the child started by Supervise.
+const supervisedEnv = "ALERTER_CRASH_SUPERVISED"
+
+// Options carries parameters for Recover and Supervise.
+type Options struct {
+	// Alerter receives the crash alert through Alerter.Emergency, so that
+	// it reaches its emergency sink, if one is set, rather than a queue
+	// which is lost when the process exits right after.
+	Alerter alerter.Alerter
+
+	// Timeout bounds the time spent delivering the alert, so that a
+	// crashing process does not hang on an unreachable backend, in
+	// addition to the deadline of Alerter.Emergency.  Defaults to 10
+	// seconds.
+	Timeout time.Duration
+
+	// MaxOutput is the number of bytes of crash output kept, from the
+	// end.  Defaults to 64 KiB.
+	MaxOutput int
+}
+
+func (o *Options) defaults() {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	if o.MaxOutput <= 0 {
+		o.MaxOutput = 64 << 10
+	}
+}
+
+// Recover recovers a panic of the calling goroutine, delivers a critical
+// alert with the panic value and stack trace, and panics again with the
+// same value, so that the program still crashes the way it would have.  It
+// must be called directly by defer.
+func Recover(opts Options) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	opts.defaults()
+	output := fmt.Sprintf("panic: %v\n\n%s", r, debug.Stack())
+	deliver(opts, fmt.Errorf("panic: %v", r), "process panicked", output)
+	panic(r)
+}
+
+// Supervise makes the program supervise itself: the first time it is
+// called, it starts the program again as a child process with the same
+// arguments and environment, waits for the child to exit and then exits
+// with the same code, never returning.  If the child exits with an error
+// other than one caused by a signal forwarded to it, such as a panic or
+// fatal error, a critical alert carrying the tail of its standard error is
+// delivered first.  In the child, Supervise returns right away.
+//
+// Supervise should be called early in main, as everything before it runs
+// twice.  Interrupt and termination signals are forwarded to the child.
+func Supervise(opts Options) {
+	if os.Getenv(supervisedEnv) != "" {
+		return
+	}
+	opts.defaults()
+	code, err := supervise(opts)
+	if err != nil {
+		// The child could not be started: run unsupervised rather than
+		// not at all.
+		fmt.Fprintf(os.Stderr, "crash: %v\n", err)
+		return
+	}
+	os.Exit(code)
+}
+
+func supervise(opts Options) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	tail := &tailBuffer{max: opts.MaxOutput}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), supervisedEnv+"=1")
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, tail)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	var signaled atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				signaled.Store(true)
+				_ = cmd.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err = cmd.Wait()
+	close(done)
+
+	var exit *exec.ExitError
+	if err != nil && !errors.As(err, &exit) {
+		return 1, nil
+	}
+	code := cmd.ProcessState.ExitCode()
+	if code == 0 {
+		return 0, nil
+	}
+	if code < 0 {
+		code = 1
+	}
+	if !signaled.Load() {
+		output := tail.String()
+		deliver(opts, fmt.Errorf("%s exited: %s", filepath.Base(exe), cmd.ProcessState), "process crashed", output,
+			ExitCodeKey, code)
+	}
+	return code, nil
+}
+
+// deliver sends the crash alert, giving up after opts.Timeout.
+func deliver(opts Options, err error, msg, output string, keysAndValues ...interface{}) {
+	if len(output) > opts.MaxOutput {
+		output = output[len(output)-opts.MaxOutput:]
+	}
+	keysAndValues = append(keysAndValues, OutputKey, output)
+	sent := make(chan error, 1)
+	go func() {
+		sent <- opts.Alerter.Emergency(err, msg, keysAndValues...)
+	}()
+	select {
+	case err := <-sent:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "crash: %v\n", err)
+		}
+	case <-time.After(opts.Timeout):
+		fmt.Fprintf(os.Stderr, "crash: delivering alert timed out after %s\n", opts.Timeout)
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	max int
+	buf bytes.Buffer
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf.Write(p)
+	if extra := t.buf.Len() - t.max; extra > 0 {
+		t.buf.Next(extra)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.buf.String()
+}
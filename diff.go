@@ -0,0 +1,203 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operations of a Change.
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// Change is a single difference found by Diff.
+type Change struct {
+	// Path locates the value, e.g. "spec.containers[0].image".
+	Path string `json:"path"`
+
+	// Op is DiffAdded, DiffRemoved or DiffChanged.
+	Op string `json:"op"`
+
+	// Old and New are the values before and after the change, missing
+	// for additions and removals respectively.
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+// Difference is a list of changes, for alerts about unexpected state
+// changes such as configuration drift, where before and after is the whole
+// story:
+//
+//	a.Error(nil, "deployment spec drifted", "diff", alerter.Diff(want, got))
+//
+// Structured sinks write it as an array of changes, text sinks on a single
+// line such as
+//
+//	spec.replicas: 3 -> 5, +metadata.labels.team: "db", -spec.paused
+type Difference []Change
+
+// Diff returns the changes from old to new, ordered by path.  The values
+// are compared by their JSON encoding, so old and new may be of any type
+// encoding/json supports or, as []byte, JSON documents.  Values which
+// cannot be encoded are reported as a single change of the whole value.
+func Diff(old, new interface{}) Difference {
+	d, err := DiffValues(old, new)
+	if err != nil {
+		return Difference{{Op: DiffChanged, Old: old, New: new}}
+	}
+	return d
+}
+
+// DiffValues is like Diff but fails if old or new cannot be encoded.
+func DiffValues(old, new interface{}) (Difference, error) {
+	o, err := generic(old)
+	if err != nil {
+		return nil, err
+	}
+	n, err := generic(new)
+	if err != nil {
+		return nil, err
+	}
+	var changes Difference
+	diff("", o, n, &changes)
+	return changes, nil
+}
+
+// String returns the changes on a single line.
+func (d Difference) String() string {
+	if len(d) == 0 {
+		return "no changes"
+	}
+	parts := make([]string, len(d))
+	for i, c := range d {
+		path := c.Path
+		if path == "" {
+			path = "."
+		}
+		switch c.Op {
+		case DiffAdded:
+			parts[i] = "+" + path + ": " + diffValue(c.New)
+		case DiffRemoved:
+			parts[i] = "-" + path
+		default:
+			parts[i] = path + ": " + diffValue(c.Old) + " -> " + diffValue(c.New)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// MarshalJSON implements json.Marshaler, so structured sinks write the
+// changes as an array rather than their text form.
+func (d Difference) MarshalJSON() ([]byte, error) {
+	if d == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Change(d))
+}
+
+// diffValue formats a value of a change compactly, as JSON.
+func diffValue(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+// generic returns v decoded into maps, slices and scalars.
+func generic(v interface{}) (interface{}, error) {
+	data, ok := v.([]byte)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var out interface{}
+	if err := dec.Decode(&out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func diff(path string, old, new interface{}, changes *Difference) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(o)+len(n))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		for k := range n {
+			if _, ok := o[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			ov, inOld := o[k]
+			nv, inNew := n[k]
+			switch {
+			case !inOld:
+				*changes = append(*changes, Change{Path: p, Op: DiffAdded, New: nv})
+			case !inNew:
+				*changes = append(*changes, Change{Path: p, Op: DiffRemoved, Old: ov})
+			default:
+				diff(p, ov, nv, changes)
+			}
+		}
+		return
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(o) || i < len(n); i++ {
+			p := path + "[" + strconv.Itoa(i) + "]"
+			switch {
+			case i >= len(o):
+				*changes = append(*changes, Change{Path: p, Op: DiffAdded, New: n[i]})
+			case i >= len(n):
+				*changes = append(*changes, Change{Path: p, Op: DiffRemoved, Old: o[i]})
+			default:
+				diff(p, o[i], n[i], changes)
+			}
+		}
+		return
+	}
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, Change{Path: path, Op: DiffChanged, Old: old, New: new})
+	}
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultEmergencyTimeout is the deadline of Emergency alerts for which
+// WithEmergencySink sets none.
+const DefaultEmergencyTimeout = 5 * time.Second
+
+// emergency is the destination of Emergency alerts.
+type emergency struct {
+	sink    Sink
+	timeout time.Duration
+}
+
+// WithEmergencySink returns a new Alerter instance which delivers Emergency
+// alerts to sink, giving up after timeout, or DefaultEmergencyTimeout if it
+// is not positive.  The sink should be the most reliable one available and
+// deliver directly, without the queues, batching, sampling or rate limits
+// the regular sink may go through:
+//
+//	a = a.WithEmergencySink(pagerDuty, 3*time.Second)
+//
+// Names and values added to the Alerter afterwards are added to the
+// emergency sink as well.  A nil sink makes Emergency use the regular sink
+// again.
+func (a Alerter) WithEmergencySink(sink Sink, timeout time.Duration) Alerter {
+	if sink == nil {
+		a.emergency = nil
+		return a
+	}
+	if timeout <= 0 {
+		timeout = DefaultEmergencyTimeout
+	}
+	a.emergency = &emergency{sink: sink, timeout: timeout}
+	return a
+}
+
+// Emergency alerts a critical error synchronously, for the case that the
+// program is about to crash and alerts queued or batched by the regular
+// sink would be lost.  It delivers to the sink set with WithEmergencySink,
+// bypassing the regular one, and returns once the alert is delivered, the
+// sink failed or the deadline has passed, reporting the former two if the
+// sink implements AlertSink.  Without an emergency sink the regular sink is
+// used, which may only queue the alert.
+//
+// Alerts are not subject to the verbosity of the Alerter, and the call site,
+// stack trace and error chain are attached as by Error.  Alerts are stamped,
+// and the deadline timed, by the Clock of the sink if it was made by
+// NewSink, and by SystemClock otherwise.
+func (a Alerter) Emergency(err error, msg string, keysAndValues ...interface{}) error {
+	sink, timeout := a.sink, DefaultEmergencyTimeout
+	if a.emergency != nil {
+		sink, timeout = a.emergency.sink, a.emergency.timeout
+	}
+	if sink == nil {
+		return nil
+	}
+	if a.caller {
+		keysAndValues = a.withCaller(keysAndValues)
+	}
+	if a.stack != nil {
+		keysAndValues = a.withStack(keysAndValues)
+	}
+	if a.errorChain && err != nil {
+		keysAndValues = withErrorChain(err, keysAndValues)
+	}
+	clock := SystemClock
+	if s, ok := sink.(*funcSink); ok {
+		clock = s.opts.Clock
+	}
+	alert := &Alert{
+		Time:          clock.Now(),
+		Message:       msg,
+		Err:           err,
+		Severity:      SeverityCritical,
+		KeysAndValues: keysAndValues,
+	}
+
+	// Buffered, so that a sink finishing after the deadline does not leak
+	// the goroutine.
+	sent := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				sent <- fmt.Errorf("emergency alert: sink panicked: %v", r)
+			}
+		}()
+		sent <- Send(sink, alert)
+	}()
+	expired := make(chan struct{})
+	timer := AfterFunc(clock, timeout, func() { close(expired) })
+	defer timer.Stop()
+	select {
+	case err := <-sent:
+		return err
+	case <-expired:
+		return fmt.Errorf("emergency alert not delivered within %s", timeout)
+	}
+}
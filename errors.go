@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import "fmt"
+
+// Keys under which Alerter.WithErrorChain attaches the expanded error chain.
+const (
+	// ErrorKindKey holds the Go type of the root cause, e.g.
+	// "*fs.PathError".
+	ErrorKindKey = "error.kind"
+
+	// ErrorCausesKey holds the messages of all errors wrapped by the
+	// alerted one, outermost first, as a []string.
+	ErrorCausesKey = "error.causes"
+)
+
+// ErrorChain walks the errors wrapped by err, through Unwrap() error as well
+// as Unwrap() []error such as errors.Join, depth first.  It returns the type
+// of the root cause, the first error wrapping nothing else, and the messages
+// of all wrapped errors, not including err itself.
+func ErrorChain(err error) (kind string, causes []string) {
+	if err == nil {
+		return "", nil
+	}
+	var walk func(err error)
+	walk = func(err error) {
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			if next := u.Unwrap(); next != nil {
+				causes = append(causes, next.Error())
+				walk(next)
+			}
+		case interface{ Unwrap() []error }:
+			for _, next := range u.Unwrap() {
+				if next != nil {
+					causes = append(causes, next.Error())
+					walk(next)
+				}
+			}
+		}
+	}
+	walk(err)
+	return fmt.Sprintf("%T", rootCause(err)), causes
+}
+
+// rootCause follows the first branch of err's chain to its end.
+func rootCause(err error) error {
+	for {
+		switch u := err.(type) {
+		case interface{ Unwrap() error }:
+			next := u.Unwrap()
+			if next == nil {
+				return err
+			}
+			err = next
+		case interface{ Unwrap() []error }:
+			var next error
+			for _, e := range u.Unwrap() {
+				if e != nil {
+					next = e
+					break
+				}
+			}
+			if next == nil {
+				return err
+			}
+			err = next
+		default:
+			return err
+		}
+	}
+}
+
+// WithErrorChain returns a new Alerter instance which expands the error
+// passed to Error into the ErrorKindKey and ErrorCausesKey fields, so that
+// structured sinks and deduplication see the root cause and not just the
+// outermost message.
+func (a Alerter) WithErrorChain(enabled bool) Alerter {
+	a.errorChain = enabled
+	return a
+}
+
+// withErrorChain returns keysAndValues with the chain of err appended.  The
+// original slice is never modified.
+func withErrorChain(err error, keysAndValues []interface{}) []interface{} {
+	kind, causes := ErrorChain(err)
+	if causes == nil {
+		causes = []string{}
+	}
+	return append(keysAndValues[:len(keysAndValues):len(keysAndValues)],
+		ErrorKindKey, kind, ErrorCausesKey, causes)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package events keeps a feed of the operational events of an alerting
+// pipeline, such as configuration changes and circuit breakers opening, so
+// that operators can tell what the pipeline itself did.
+//
+// A Feed is a Sink, so it plugs in wherever the pipeline reports on itself:
+//
+//	feed := events.New(events.Options{Publish: opsChannel})
+//	breaker := middleware.CircuitBreakerSink(slack, middleware.CircuitOptions{
+//		Name:   "slack",
+//		Notify: feed.WithName("circuit"),
+//	})
+//	config.Report(alerter.New(feed).WithName("config"), changes)
+//
+// The name of an event tells its kind, e.g. "config" or "circuit".
+package events
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// Event is an operational event recorded by a Feed.
+type Event struct {
+	// ID is unique among the events of the feed.
+	ID string
+
+	alerter.Alert
+}
+
+// Options carries parameters for New.
+type Options struct {
+	// Capacity is the number of most recent events kept.  Defaults to
+	// 1000.
+	Capacity int
+
+	// Publish, if set, receives every event as it is recorded, e.g. to
+	// make the events of the pipeline visible org-wide.
+	Publish alerter.Sink
+
+	// IDs generates the IDs of events.  Defaults to
+	// alerter.DefaultIDGenerator.
+	IDs alerter.IDGenerator
+
+	// Clock tells the time of events which carry none.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// sink is the set of interfaces implemented by the Sinks of
+// alerter.NewSink, embedded so that the Feed records resolves and alerts
+// with their own time and ID.
+type sink interface {
+	alerter.Sink
+	alerter.Resolver
+	alerter.AlertSink
+	alerter.BatchSink
+}
+
+// Feed is a Sink which records the alerts it receives as events and keeps
+// the most recent ones for Events.
+type Feed struct {
+	sink
+
+	opts Options
+
+	mu     sync.Mutex
+	events []Event
+	// next is the index in events the next event is written to once
+	// the feed is at capacity.
+	next int
+}
+
+// New returns an empty Feed.
+func New(opts Options) *Feed {
+	if opts.Capacity <= 0 {
+		opts.Capacity = 1000
+	}
+	if opts.IDs == nil {
+		opts.IDs = alerter.DefaultIDGenerator
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	f := &Feed{opts: opts}
+	f.sink = alerter.NewSink(f.record, alerter.SinkOptions{Clock: opts.Clock}).(sink)
+	return f
+}
+
+// record stores a as an event and publishes it.
+func (f *Feed) record(a *alerter.Alert) error {
+	e := Event{ID: f.opts.IDs.NewID(), Alert: *a}
+	if e.Time.IsZero() {
+		e.Time = f.opts.Clock.Now()
+	}
+	f.mu.Lock()
+	if len(f.events) < f.opts.Capacity {
+		f.events = append(f.events, e)
+	} else {
+		f.events[f.next] = e
+		f.next = (f.next + 1) % len(f.events)
+	}
+	f.mu.Unlock()
+	if f.opts.Publish != nil {
+		return alerter.Send(f.opts.Publish, a)
+	}
+	return nil
+}
+
+// Query selects events.  Conditions which are not set match all events.
+type Query struct {
+	// Since and Until bound the times of events, Since inclusive and
+	// Until exclusive.
+	Since, Until time.Time
+
+	// Kind matches events whose name is it or starts with it followed by
+	// a slash, e.g. "config".
+	Kind string
+
+	// Matchers matches events whose labels match them.
+	Matchers matchers.Matchers
+
+	// Limit is the maximum number of events returned, the most recent
+	// ones.
+	Limit int
+}
+
+func (q *Query) matches(e *Event) bool {
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+	if q.Kind != "" && e.Name != q.Kind && !strings.HasPrefix(e.Name, q.Kind+"/") {
+		return false
+	}
+	return q.Matchers.Matches(&e.Alert)
+}
+
+// Events returns the recorded events selected by q, oldest first.
+func (f *Feed) Events(q Query) []Event {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var selected []Event
+	for i := range f.events {
+		e := &f.events[(f.next+i)%len(f.events)]
+		if q.matches(e) {
+			selected = append(selected, *e)
+		}
+	}
+	if q.Limit > 0 && len(selected) > q.Limit {
+		selected = selected[len(selected)-q.Limit:]
+	}
+	return selected
+}
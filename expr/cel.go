@@ -0,0 +1,732 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// Condition is a compiled boolean expression over an alert, in a subset of
+// the Common Expression Language (CEL), for routes and filters which go
+// beyond label matchers:
+//
+//	alert.severity == 'critical' && alert.labels['env'] != 'dev'
+//	alert.name.startsWith('billing/') || 'customer' in alert.labels
+//	alert.severity in ['error', 'critical'] && alert.message.matches('(?i)timeout')
+//
+// The variable alert has the fields name, message, severity (its name),
+// level, error (its message, or null), resolved, values and kv (the
+// key/value pairs of WithValues and of the call, as maps) and labels (as
+// seen by matchers.Labels).  Keys an alert does not have are null.
+//
+// The operators are ||, &&, !, ==, !=, <, <=, >, >=, in, + and -, and the
+// functions size(x) and, as methods on strings, startsWith, endsWith,
+// contains and matches, which takes a regular expression.  Ordering
+// alert.severity compares severities by rank, as in
+// alert.severity >= 'error'; the other operand must name a severity.
+type Condition struct {
+	src  string
+	root node
+}
+
+// CompileCondition parses src into a Condition.  Errors give the byte offset
+// of the mistake.
+func CompileCondition(src string) (*Condition, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	p := &celParser{parser{toks: toks}}
+	root, err := p.or()
+	if err == nil && p.peek().kind != tokEOF {
+		err = p.errorf(p.peek(), "expected end of expression")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	return &Condition{src: src, root: root}, nil
+}
+
+// MustCompileCondition is like CompileCondition but panics if src cannot be
+// parsed.
+func MustCompileCondition(src string) *Condition {
+	c, err := CompileCondition(src)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// String returns the source of the condition.
+func (c *Condition) String() string {
+	return c.src
+}
+
+// MarshalText implements encoding.TextMarshaler, so that conditions can be
+// written as strings in configuration.
+func (c *Condition) MarshalText() ([]byte, error) {
+	return []byte(c.src), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, compiling the
+// condition when the configuration is loaded.
+func (c *Condition) UnmarshalText(text []byte) error {
+	parsed, err := CompileCondition(string(text))
+	if err != nil {
+		return err
+	}
+	*c = *parsed
+	return nil
+}
+
+// Eval reports whether a satisfies the condition.  It fails if the
+// condition does not evaluate to a boolean or applies an operator to values
+// of the wrong type.
+func (c *Condition) Eval(a *alerter.Alert) (bool, error) {
+	v, err := c.root.eval(a)
+	if err != nil {
+		return false, fmt.Errorf("expr: %w", err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expr: condition is %s, not a boolean", typeName(v))
+	}
+	return b, nil
+}
+
+// Match is like Eval, but treats errors as not satisfying the condition.
+// It suits middleware.Route.Match.
+func (c *Condition) Match(a *alerter.Alert) bool {
+	ok, _ := c.Eval(a)
+	return ok
+}
+
+type celParser struct {
+	parser
+}
+
+func (p *celParser) or() (node, error) {
+	return p.binaryLeft(p.and, "||")
+}
+
+func (p *celParser) and() (node, error) {
+	return p.binaryLeft(p.comparison, "&&")
+}
+
+// binaryLeft parses left-associative operators.
+func (p *celParser) binaryLeft(operand func() (node, error), ops ...string) (node, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		found := false
+		for _, op := range ops {
+			found = found || t.kind == op
+		}
+		if !found {
+			return l, nil
+		}
+		p.next()
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l = celBinary{t.kind, l, r}
+	}
+}
+
+func (p *celParser) comparison() (node, error) {
+	l, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	switch {
+	case t.kind == "==", t.kind == "!=", t.kind == "<", t.kind == "<=", t.kind == ">", t.kind == ">=",
+		t.kind == tokIdent && t.text == "in":
+		p.next()
+		r, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind != "==" && t.kind != "!=" && t.kind != tokIdent && (isSeverity(l) || isSeverity(r)) {
+			for _, x := range []node{l, r} {
+				if lit, ok := x.(literal); ok {
+					if _, err := severityRank(lit.value); err != nil {
+						return nil, p.errorf(t, "%v", err)
+					}
+				}
+			}
+			return celSeverityOrder{t.text, l, r}, nil
+		}
+		return celBinary{t.text, l, r}, nil
+	}
+	return l, nil
+}
+
+// isSeverity reports whether x is alert.severity.
+func isSeverity(x node) bool {
+	s, ok := x.(celSelect)
+	if !ok || s.field != "severity" {
+		return false
+	}
+	_, ok = s.x.(celAlert)
+	return ok
+}
+
+func (p *celParser) sum() (node, error) {
+	return p.binaryLeft(p.unary, "+", "-")
+}
+
+func (p *celParser) unary() (node, error) {
+	if t := p.peek(); t.kind == "!" || t.kind == "-" {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return celUnary{t.kind, x}, nil
+	}
+	return p.postfix()
+}
+
+// postfix parses a primary expression followed by field selections,
+// indexes and method calls.
+func (p *celParser) postfix() (node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek().kind {
+		case ".":
+			p.next()
+			name, err := p.expect(tokIdent)
+			if err != nil {
+				return nil, err
+			}
+			if p.peek().kind != "(" {
+				if _, ok := x.(celAlert); ok && !isAlertField(name.text) {
+					return nil, fmt.Errorf("%d: alert has no field %s", name.pos, name.text)
+				}
+				x = celSelect{x, name.text}
+				continue
+			}
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			if x, err = p.method(name, x, args); err != nil {
+				return nil, err
+			}
+		case "[":
+			p.next()
+			i, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = celIndex{x, i}
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *celParser) primary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokString, tokNumber:
+		return p.term()
+	case "(":
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		_, err = p.expect(")")
+		return x, err
+	case "[":
+		p.next()
+		var list celList
+		for p.peek().kind != "]" {
+			x, err := p.or()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, x)
+			if p.peek().kind != "," {
+				break
+			}
+			p.next()
+		}
+		_, err := p.expect("]")
+		return list, err
+	case tokIdent:
+		switch t.text {
+		case "true", "false", "null":
+			return p.term()
+		case "alert":
+			p.next()
+			return celAlert{}, nil
+		case "size":
+			p.next()
+			args, err := p.args()
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, p.errorf(t, "size takes 1 argument")
+			}
+			return celSize{args[0]}, nil
+		}
+		return nil, fmt.Errorf("%d: unknown identifier %s", t.pos, t.text)
+	}
+	return nil, p.errorf(t, "expected expression")
+}
+
+// args parses the parenthesized arguments of a call.
+func (p *celParser) args() ([]node, error) {
+	if _, err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []node
+	for p.peek().kind != ")" {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, x)
+		if p.peek().kind != "," {
+			break
+		}
+		p.next()
+	}
+	_, err := p.expect(")")
+	return args, err
+}
+
+func (p *celParser) method(name token, recv node, args []node) (node, error) {
+	if name.text == "size" && len(args) == 0 {
+		return celSize{recv}, nil
+	}
+	fn, ok := stringMethods[name.text]
+	if !ok {
+		return nil, fmt.Errorf("%d: unknown method %s", name.pos, name.text)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%d: %s takes 1 argument", name.pos, name.text)
+	}
+	m := celMethod{name: name.text, fn: fn, recv: recv, arg: args[0]}
+	if name.text == "matches" {
+		// Compile constant expressions with the condition rather than on
+		// every evaluation.
+		if l, ok := args[0].(literal); ok {
+			s, _ := l.value.(string)
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", name.pos, err)
+			}
+			m.fn = func(s, _ string) (bool, error) { return re.MatchString(s), nil }
+		}
+	}
+	return m, nil
+}
+
+var stringMethods = map[string]func(s, arg string) (bool, error){
+	"startsWith": func(s, arg string) (bool, error) { return strings.HasPrefix(s, arg), nil },
+	"endsWith":   func(s, arg string) (bool, error) { return strings.HasSuffix(s, arg), nil },
+	"contains":   func(s, arg string) (bool, error) { return strings.Contains(s, arg), nil },
+	"matches": func(s, arg string) (bool, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	},
+}
+
+// celAlert is the variable alert.
+type celAlert struct{}
+
+func (celAlert) eval(a *alerter.Alert) (interface{}, error) {
+	return a, nil
+}
+
+type celSelect struct {
+	x     node
+	field string
+}
+
+func (s celSelect) eval(a *alerter.Alert) (interface{}, error) {
+	x, err := s.x.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case *alerter.Alert:
+		switch s.field {
+		case "labels":
+			labels := matchers.Labels(x)
+			m := make(map[string]interface{}, len(labels))
+			for k, v := range labels {
+				m[k] = v
+			}
+			return m, nil
+		case "values":
+			return toMap(x.Values), nil
+		case "kv":
+			return toMap(x.KeysAndValues), nil
+		}
+		if p, ok := alertFields[s.field]; ok {
+			return p.eval(x)
+		}
+		return nil, fmt.Errorf("alert has no field %s", s.field)
+	case map[string]interface{}:
+		return x[s.field], nil
+	}
+	return nil, fmt.Errorf("cannot select %s of %s", s.field, typeName(x))
+}
+
+// alertFields are the scalar fields of alert, shared with rewrite programs.
+var alertFields = map[string]path{
+	"name":     {field: "name"},
+	"message":  {field: "message"},
+	"severity": {field: "severity"},
+	"level":    {field: "level"},
+	"error":    {field: "error"},
+	"resolved": {field: "resolved"},
+}
+
+func isAlertField(name string) bool {
+	_, ok := alertFields[name]
+	return ok || name == "labels" || name == "values" || name == "kv"
+}
+
+func toMap(kvs []interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(kvs)/2)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if k, ok := kvs[i].(string); ok {
+			m[k] = kvs[i+1]
+		}
+	}
+	return m
+}
+
+type celIndex struct {
+	x, i node
+}
+
+func (n celIndex) eval(a *alerter.Alert) (interface{}, error) {
+	x, err := n.x.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.i.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case map[string]interface{}:
+		k, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("map key must be a string, not %s", typeName(i))
+		}
+		return x[k], nil
+	case []interface{}:
+		k, ok := integer(i)
+		if !ok || k < 0 || k >= int64(len(x)) {
+			return nil, fmt.Errorf("invalid list index %v", i)
+		}
+		return x[k], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(x))
+}
+
+type celList []node
+
+func (l celList) eval(a *alerter.Alert) (interface{}, error) {
+	list := make([]interface{}, len(l))
+	for i, x := range l {
+		v, err := x.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+type celSize struct {
+	x node
+}
+
+func (s celSize) eval(a *alerter.Alert) (interface{}, error) {
+	x, err := s.x.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch x := x.(type) {
+	case string:
+		return int64(len([]rune(x))), nil
+	case []interface{}:
+		return int64(len(x)), nil
+	case map[string]interface{}:
+		return int64(len(x)), nil
+	}
+	return nil, fmt.Errorf("size of %s", typeName(x))
+}
+
+type celMethod struct {
+	name      string
+	fn        func(s, arg string) (bool, error)
+	recv, arg node
+}
+
+func (m celMethod) eval(a *alerter.Alert) (interface{}, error) {
+	recv, err := m.recv.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	arg, err := m.arg.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := recv.(string)
+	if recv == nil {
+		s, ok = "", true
+	}
+	t, ok2 := arg.(string)
+	if !ok || !ok2 {
+		return nil, fmt.Errorf("%s on %s with %s", m.name, typeName(recv), typeName(arg))
+	}
+	return m.fn(s, t)
+}
+
+type celUnary struct {
+	op string
+	x  node
+}
+
+func (u celUnary) eval(a *alerter.Alert) (interface{}, error) {
+	x, err := u.x.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	if u.op == "!" {
+		if b, ok := x.(bool); ok {
+			return !b, nil
+		}
+	} else if n, ok := integer(x); ok {
+		return -n, nil
+	} else if f, ok := number(x); ok {
+		return -f, nil
+	}
+	return nil, fmt.Errorf("%s applied to %s", u.op, typeName(x))
+}
+
+type celBinary struct {
+	op   string
+	l, r node
+}
+
+func (b celBinary) eval(a *alerter.Alert) (interface{}, error) {
+	l, err := b.l.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	// && and || short-circuit, so that the right operand may assume the
+	// left one, e.g. 'env' in alert.labels && alert.labels['env'] == 'prod'.
+	if b.op == "&&" || b.op == "||" {
+		lb, ok := l.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s applied to %s", b.op, typeName(l))
+		}
+		if lb == (b.op == "||") {
+			return lb, nil
+		}
+		r, err := b.r.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := r.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s applied to %s", b.op, typeName(r))
+		}
+		return rb, nil
+	}
+	r, err := b.r.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "in":
+		switch r := r.(type) {
+		case []interface{}:
+			for _, e := range r {
+				if equal(l, e) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			k, ok := l.(string)
+			_, found := r[k]
+			return ok && found, nil
+		}
+		return nil, fmt.Errorf("in applied to %s", typeName(r))
+	case "+", "-":
+		if x, ok := integer(l); ok {
+			if y, ok := integer(r); ok {
+				if b.op == "-" {
+					return x - y, nil
+				}
+				return x + y, nil
+			}
+		}
+		if x, ok := number(l); ok {
+			if y, ok := number(r); ok {
+				if b.op == "-" {
+					return x - y, nil
+				}
+				return x + y, nil
+			}
+		}
+		if x, ok := l.(string); ok && b.op == "+" {
+			if y, ok := r.(string); ok {
+				return x + y, nil
+			}
+		}
+		return nil, fmt.Errorf("%s applied to %s and %s", b.op, typeName(l), typeName(r))
+	}
+	c, err := compare(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	}
+	return c >= 0, nil
+}
+
+// celSeverityOrder orders severities by rank rather than by name, for
+// comparisons with alert.severity such as alert.severity >= 'error'.
+type celSeverityOrder struct {
+	op   string
+	l, r node
+}
+
+func (o celSeverityOrder) eval(a *alerter.Alert) (interface{}, error) {
+	var ranks [2]alerter.Severity
+	for i, x := range []node{o.l, o.r} {
+		v, err := x.eval(a)
+		if err != nil {
+			return nil, err
+		}
+		if ranks[i], err = severityRank(v); err != nil {
+			return nil, err
+		}
+	}
+	switch l, r := ranks[0], ranks[1]; o.op {
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	default:
+		return l >= r, nil
+	}
+}
+
+// severityRank returns the severity named by v.
+func severityRank(v interface{}) (alerter.Severity, error) {
+	name, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("cannot compare severity and %s", typeName(v))
+	}
+	return alerter.ParseSeverity(name)
+}
+
+// equal compares values, numbers by value regardless of their type.
+func equal(l, r interface{}) bool {
+	if x, ok := number(l); ok {
+		y, ok := number(r)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+// compare orders numbers and strings.
+func compare(l, r interface{}) (int, error) {
+	if x, ok := number(l); ok {
+		if y, ok := number(r); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if x, ok := l.(string); ok {
+		if y, ok := r.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %s and %s", typeName(l), typeName(r))
+}
+
+// typeName names the type of a value in errors.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	if _, ok := number(v); ok {
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
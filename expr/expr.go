@@ -0,0 +1,367 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package expr implements a small JQ-like language for rewriting alerts, so
+// that provider-specific payload quirks can be handled in configuration
+// rather than code:
+//
+//	.kv.cluster = .values.region + "-" + .values.zone
+//	| del(.values.zone, .kv.request_body)
+//	| .message = upper(.severity) + ": " + .message
+//
+// A program is a list of statements separated by "|" or ";", each either
+// assigning an expression to a field or deleting fields with del.  Fields
+// are addressed as .name, .message, .severity, .level, .error and
+// .resolved, and the key/value pairs of an alert as .values.key and
+// .kv.key, or .values["key"] for keys which are not identifiers.
+// Expressions are fields, literals ("text", 'text', 42, 1.5, true, false,
+// null), the functions upper, lower, trim and string, and "+", which adds
+// numbers and concatenates anything else.
+//
+// Conditions, the boolean expressions of routes and filters, are written in
+// a subset of CEL instead, see Condition.
+//
+// Programs and conditions are compiled once, e.g. when the configuration is
+// loaded, and may then be evaluated concurrently.
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Program is a compiled rewrite program.
+type Program struct {
+	src   string
+	stmts []stmt
+}
+
+// Compile parses src into a Program.  Errors give the byte offset of the
+// mistake.
+func Compile(src string) (*Program, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	p := &parser{toks: toks}
+	stmts, err := p.statements()
+	if err != nil {
+		return nil, fmt.Errorf("expr: %w", err)
+	}
+	return &Program{src: src, stmts: stmts}, nil
+}
+
+// MustCompile is like Compile but panics if src cannot be parsed.
+func MustCompile(src string) *Program {
+	p, err := Compile(src)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source of the program.
+func (p *Program) String() string {
+	return p.src
+}
+
+// Apply runs the program on a copy of a and returns the copy.  a itself is
+// not modified.
+func (p *Program) Apply(a *alerter.Alert) (*alerter.Alert, error) {
+	c := *a
+	c.Values = append([]interface{}(nil), a.Values...)
+	c.KeysAndValues = append([]interface{}(nil), a.KeysAndValues...)
+	for _, s := range p.stmts {
+		if err := s.exec(&c); err != nil {
+			return nil, fmt.Errorf("expr: %w", err)
+		}
+	}
+	return &c, nil
+}
+
+type stmt interface {
+	exec(a *alerter.Alert) error
+}
+
+type node interface {
+	eval(a *alerter.Alert) (interface{}, error)
+}
+
+// assign sets a field to the value of an expression.
+type assign struct {
+	path path
+	expr node
+}
+
+func (s assign) exec(a *alerter.Alert) error {
+	v, err := s.expr.eval(a)
+	if err != nil {
+		return err
+	}
+	return s.path.set(a, v)
+}
+
+// del deletes fields.
+type del []path
+
+func (d del) exec(a *alerter.Alert) error {
+	for _, p := range d {
+		p.delete(a)
+	}
+	return nil
+}
+
+// path addresses a field of an alert, or a key of its Values or
+// KeysAndValues.
+type path struct {
+	field string
+	key   string
+}
+
+func (p path) String() string {
+	if p.key != "" {
+		return fmt.Sprintf(".%s[%q]", p.field, p.key)
+	}
+	return "." + p.field
+}
+
+func (p path) eval(a *alerter.Alert) (interface{}, error) {
+	switch p.field {
+	case "name":
+		return a.Name, nil
+	case "message":
+		return a.Message, nil
+	case "severity":
+		return a.Severity.String(), nil
+	case "level":
+		return int64(a.Level), nil
+	case "error":
+		if a.Err == nil {
+			return nil, nil
+		}
+		return a.Err.Error(), nil
+	case "resolved":
+		return a.Resolved, nil
+	case "values":
+		return lookup(a.Values, p.key), nil
+	default:
+		return lookup(a.KeysAndValues, p.key), nil
+	}
+}
+
+func (p path) set(a *alerter.Alert, v interface{}) error {
+	var ok bool
+	switch p.field {
+	case "name":
+		a.Name, ok = v.(string)
+	case "message":
+		a.Message, ok = v.(string)
+	case "severity":
+		switch v := v.(type) {
+		case string:
+			s, err := alerter.ParseSeverity(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", p, err)
+			}
+			a.Severity, ok = s, true
+		default:
+			var n int64
+			n, ok = integer(v)
+			a.Severity = alerter.Severity(n)
+		}
+	case "level":
+		var n int64
+		n, ok = integer(v)
+		a.Level = int(n)
+	case "error":
+		switch v := v.(type) {
+		case nil:
+			a.Err, ok = nil, true
+		case string:
+			a.Err, ok = errors.New(v), true
+		}
+	case "resolved":
+		a.Resolved, ok = v.(bool)
+	case "values":
+		a.Values, ok = store(a.Values, p.key, v), true
+	default:
+		a.KeysAndValues, ok = store(a.KeysAndValues, p.key, v), true
+	}
+	if !ok {
+		return fmt.Errorf("%s: cannot assign %T", p, v)
+	}
+	return nil
+}
+
+func (p path) delete(a *alerter.Alert) {
+	switch p.field {
+	case "name":
+		a.Name = ""
+	case "message":
+		a.Message = ""
+	case "severity":
+		a.Severity = alerter.SeverityInfo
+	case "level":
+		a.Level = 0
+	case "error":
+		a.Err = nil
+	case "resolved":
+		a.Resolved = false
+	case "values":
+		a.Values = remove(a.Values, p.key)
+	default:
+		a.KeysAndValues = remove(a.KeysAndValues, p.key)
+	}
+}
+
+// lookup returns the value of the last pair with the given key, or nil.
+func lookup(kvs []interface{}, key string) interface{} {
+	var v interface{}
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if kvs[i] == key {
+			v = kvs[i+1]
+		}
+	}
+	return v
+}
+
+// store replaces the values of the pairs with the given key, keeping their
+// position, or appends a pair if there is none.
+func store(kvs []interface{}, key string, v interface{}) []interface{} {
+	found := false
+	for i := 0; i+1 < len(kvs); i += 2 {
+		if kvs[i] == key {
+			kvs[i+1] = v
+			found = true
+		}
+	}
+	if !found {
+		kvs = append(kvs, key, v)
+	}
+	return kvs
+}
+
+// remove deletes the pairs with the given key.
+func remove(kvs []interface{}, key string) []interface{} {
+	out := kvs[:0]
+	for i := 0; i < len(kvs); i += 2 {
+		if kvs[i] == key {
+			continue
+		}
+		out = append(out, kvs[i])
+		if i+1 < len(kvs) {
+			out = append(out, kvs[i+1])
+		}
+	}
+	return out
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(*alerter.Alert) (interface{}, error) {
+	return l.value, nil
+}
+
+type binary struct {
+	op   string
+	l, r node
+}
+
+func (b binary) eval(a *alerter.Alert) (interface{}, error) {
+	l, err := b.l.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.r.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	if x, ok := integer(l); ok {
+		if y, ok := integer(r); ok {
+			return x + y, nil
+		}
+	}
+	if x, ok := number(l); ok {
+		if y, ok := number(r); ok {
+			return x + y, nil
+		}
+	}
+	return toString(l) + toString(r), nil
+}
+
+// integer converts signed integers to int64.
+func integer(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// number converts numeric values to float64.
+func number(v interface{}) (float64, bool) {
+	if n, ok := integer(v); ok {
+		return float64(n), true
+	}
+	switch v := v.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// toString formats v for concatenation.  null is the empty string.
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+type call struct {
+	name string
+	fn   func(interface{}) interface{}
+	arg  node
+}
+
+func (c call) eval(a *alerter.Alert) (interface{}, error) {
+	v, err := c.arg.eval(a)
+	if err != nil {
+		return nil, err
+	}
+	return c.fn(v), nil
+}
+
+var functions = map[string]func(interface{}) interface{}{
+	"upper":  func(v interface{}) interface{} { return strings.ToUpper(toString(v)) },
+	"lower":  func(v interface{}) interface{} { return strings.ToLower(toString(v)) },
+	"trim":   func(v interface{}) interface{} { return strings.TrimSpace(toString(v)) },
+	"string": func(v interface{}) interface{} { return toString(v) },
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tokens of the lexer.  Operators and punctuation are tokens of their own
+// text.
+const (
+	tokEOF    = "EOF"
+	tokIdent  = "identifier"
+	tokString = "string"
+	tokNumber = "number"
+)
+
+type token struct {
+	kind string
+	text string
+	pos  int
+}
+
+// operators lists the operators, longest first so that, say, "==" is not lexed
+// as "=" twice.
+var operators = []string{
+	"==", "!=", "<=", ">=", "&&", "||",
+	"|", ";", "=", "+", "-", "!", "<", ">", "(", ")", "[", "]", ",", ".",
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"' || c == '\'':
+			j := i + 1
+			for ; j < len(src) && rune(src[j]) != c; j++ {
+				if src[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("%d: unterminated string", i)
+			}
+			s, err := unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = j + 1
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(src) && (src[j] == '_' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("%d: unexpected %q", i, c)
+			}
+			toks = append(toks, token{op, op, i})
+			i += len(op)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+// unquote unquotes a string literal in double or single quotes, both of
+// which may contain several characters and Go escape sequences.
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		s = `"` + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	return strconv.Unquote(s)
+}
+
+type parser struct {
+	toks []token
+	i    int
+}
+
+func (p *parser) peek() token {
+	return p.toks[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) expect(kind string) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, p.errorf(t, "expected %s", kind)
+	}
+	return t, nil
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	found := t.text
+	if t.kind == tokEOF {
+		found = "end of input"
+	}
+	return fmt.Errorf("%d: %s, found %q", t.pos, fmt.Sprintf(format, args...), found)
+}
+
+// statements parses statements separated by "|" or ";".
+func (p *parser) statements() ([]stmt, error) {
+	var stmts []stmt
+	for {
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+		switch t := p.next(); t.kind {
+		case "|", ";":
+		case tokEOF:
+			return stmts, nil
+		default:
+			return nil, p.errorf(t, `expected "|"`)
+		}
+	}
+}
+
+// statement parses "del(path, ...)" or "path = expr".
+func (p *parser) statement() (stmt, error) {
+	if t := p.peek(); t.kind == tokIdent && t.text == "del" {
+		p.next()
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		var d del
+		for {
+			path, err := p.path()
+			if err != nil {
+				return nil, err
+			}
+			d = append(d, path)
+			if t := p.next(); t.kind == ")" {
+				return d, nil
+			} else if t.kind != "," {
+				return nil, p.errorf(t, `expected ")"`)
+			}
+		}
+	}
+	path, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect("="); err != nil {
+		return nil, err
+	}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return assign{path, e}, nil
+}
+
+// expr parses terms joined by "+".
+func (p *parser) expr() (node, error) {
+	l, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "+" {
+		p.next()
+		r, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		l = binary{"+", l, r}
+	}
+	return l, nil
+}
+
+func (p *parser) term() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case ".":
+		return p.path()
+	case tokString:
+		p.next()
+		return literal{t.text}, nil
+	case tokNumber:
+		p.next()
+		if n, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return literal{n}, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number")
+		}
+		return literal{f}, nil
+	case "(":
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case tokIdent:
+		p.next()
+		switch t.text {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		case "null":
+			return literal{nil}, nil
+		}
+		fn, ok := functions[t.text]
+		if !ok {
+			return nil, p.errorf(t, "unknown function")
+		}
+		if _, err := p.expect("("); err != nil {
+			return nil, err
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return call{t.text, fn, arg}, nil
+	}
+	return nil, p.errorf(t, "expected expression")
+}
+
+// path parses ".field", ".values.key" or `.values["key"]`.
+func (p *parser) path() (path, error) {
+	if _, err := p.expect("."); err != nil {
+		return path{}, err
+	}
+	f, err := p.expect(tokIdent)
+	if err != nil {
+		return path{}, err
+	}
+	switch f.text {
+	case "name", "message", "severity", "level", "error", "resolved":
+		return path{field: f.text}, nil
+	case "values", "kv":
+		var key token
+		switch t := p.next(); t.kind {
+		case ".":
+			key, err = p.expect(tokIdent)
+		case "[":
+			if key, err = p.expect(tokString); err == nil {
+				_, err = p.expect("]")
+			}
+		default:
+			err = p.errorf(t, "expected key of .%s", f.text)
+		}
+		if err != nil {
+			return path{}, err
+		}
+		return path{field: f.text, key: key.text}, nil
+	}
+	return path{}, fmt.Errorf("%d: unknown field .%s", f.pos, f.text)
+}
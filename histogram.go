@@ -0,0 +1,271 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Histogram is an exponential histogram of observations, such as request
+// latencies, which gives responders the distribution behind a threshold
+// alert rather than a single number:
+//
+//	var latency alerter.Histogram
+//	...
+//	latency.Observe(elapsed.Seconds())
+//	...
+//	a.Error(nil, "p99 latency above SLO", "latency", latency.Snapshot())
+//
+// Buckets grow by a constant factor, as in the exponential histograms of
+// OpenTelemetry, so that it stays small over any range of values.  The zero
+// value is ready to use and safe for concurrent use.
+type Histogram struct {
+	// Scale sets the resolution, from 0 to 2: bucket bounds grow by a
+	// factor of 2^(2^-Scale), i.e. 2 for the default of 0 and about 1.19
+	// for 2.  It must not be changed after the first observation.
+	Scale int
+
+	mu       sync.Mutex
+	counts   map[int]uint64
+	zero     uint64
+	count    uint64
+	sum      float64
+	min, max float64
+}
+
+// Observe adds an observation.  Values which are not positive are counted
+// in the zero bucket; NaN is ignored.
+func (h *Histogram) Observe(v float64) {
+	if math.IsNaN(v) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+	if v <= 0 {
+		h.zero++
+		return
+	}
+	if h.counts == nil {
+		h.counts = map[int]uint64{}
+	}
+	h.counts[bucketIndex(v, h.scale())]++
+}
+
+// Reset removes all observations, e.g. to start the next minute.
+func (h *Histogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts, h.zero, h.count, h.sum, h.min, h.max = nil, 0, 0, 0, 0, 0
+}
+
+func (h *Histogram) scale() int {
+	switch {
+	case h.Scale < 0:
+		return 0
+	case h.Scale > 2:
+		return 2
+	}
+	return h.Scale
+}
+
+// Snapshot returns the current distribution, to be attached to an alert.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := HistogramSnapshot{Count: h.count, Sum: h.sum, Min: h.min, Max: h.max, Zero: h.zero}
+	indexes := make([]int, 0, len(h.counts))
+	for i := range h.counts {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	scale := h.scale()
+	for _, i := range indexes {
+		s.Buckets = append(s.Buckets, HistogramBucket{
+			Lower: bucketBound(i, scale),
+			Upper: bucketBound(i+1, scale),
+			Count: h.counts[i],
+		})
+	}
+	return s
+}
+
+// bucketIndex returns the bucket of v > 0, which covers
+// (bound(i), bound(i+1)].
+func bucketIndex(v float64, scale int) int {
+	return int(math.Ceil(math.Log2(v)*math.Ldexp(1, scale))) - 1
+}
+
+// bucketBound returns the lower bound of bucket i.
+func bucketBound(i, scale int) float64 {
+	return math.Exp2(float64(i) / math.Ldexp(1, scale))
+}
+
+// HistogramBucket is a bucket of a HistogramSnapshot, holding the
+// observations greater than Lower and at most Upper.
+type HistogramBucket struct {
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	Count uint64  `json:"count"`
+}
+
+// HistogramSnapshot is the distribution of a Histogram at one point in
+// time.  Structured sinks write it with its buckets and common quantiles,
+// text sinks as a line such as
+//
+//	n=1200 min=0.012 p50=0.031 p90=0.12 p99=0.48 max=1.3 ▁▃█▆▃▂▁ ▁
+type HistogramSnapshot struct {
+	Count    uint64
+	Sum      float64
+	Min, Max float64
+	// Zero is the number of observations which were not positive.
+	Zero    uint64
+	Buckets []HistogramBucket
+}
+
+// Mean returns the average of the observations.
+func (s HistogramSnapshot) Mean() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / float64(s.Count)
+}
+
+// Quantile estimates the q-quantile, for q between 0 and 1, by
+// interpolating within its bucket.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := q * float64(s.Count)
+	seen := float64(s.Zero)
+	if rank <= seen {
+		return math.Min(0, s.Max)
+	}
+	for _, b := range s.Buckets {
+		n := float64(b.Count)
+		if rank <= seen+n {
+			v := b.Lower + (b.Upper-b.Lower)*(rank-seen)/n
+			return math.Max(s.Min, math.Min(s.Max, v))
+		}
+		seen += n
+	}
+	return s.Max
+}
+
+// sparkTicks are the bars of a sparkline, from the lowest to the highest.
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// maxSparkline is the number of bars of a sparkline; more buckets are
+// merged.
+const maxSparkline = 24
+
+// maxBucketSpan is the number of buckets between the lowest and the highest
+// float64 at the highest Scale.
+const maxBucketSpan = 4 * 2100
+
+// Sparkline returns the bucket counts as a bar chart of block characters,
+// with a space for empty buckets, from the lowest bucket to the highest.
+func (s HistogramSnapshot) Sparkline() string {
+	if len(s.Buckets) == 0 {
+		return ""
+	}
+	// Lay the buckets out by their index, including empty ones.  Bounds
+	// of subnormal or huge observations round to zero or overflow, which
+	// leaves no layout: all buckets are then shown as one.
+	first := s.Buckets[0].Lower
+	last := s.Buckets[len(s.Buckets)-1].Lower
+	step := math.Log(s.Buckets[0].Upper / first)
+	width := math.Round(math.Log(last/first)/step) + 1
+	if !(width >= 1 && width <= maxBucketSpan) {
+		width, step = 1, math.Inf(1)
+	}
+	bars := make([]uint64, int(width))
+	for _, b := range s.Buckets {
+		i := int(math.Round(math.Log(b.Lower/first) / step))
+		bars[max(0, min(i, len(bars)-1))] += b.Count
+	}
+	for len(bars) > maxSparkline {
+		merged := make([]uint64, (len(bars)+1)/2)
+		for i, n := range bars {
+			merged[i/2] += n
+		}
+		bars = merged
+	}
+	var highest uint64
+	for _, n := range bars {
+		if n > highest {
+			highest = n
+		}
+	}
+	var b strings.Builder
+	for _, n := range bars {
+		if n == 0 {
+			b.WriteByte(' ')
+			continue
+		}
+		b.WriteRune(sparkTicks[(n*uint64(len(sparkTicks))-1)/highest])
+	}
+	return b.String()
+}
+
+// String returns the count, extremes, common quantiles and sparkline of
+// the distribution on a single line.
+func (s HistogramSnapshot) String() string {
+	if s.Count == 0 {
+		return "n=0"
+	}
+	line := fmt.Sprintf("n=%d min=%.3g p50=%.3g p90=%.3g p99=%.3g max=%.3g",
+		s.Count, s.Min, s.Quantile(0.5), s.Quantile(0.9), s.Quantile(0.99), s.Max)
+	if spark := s.Sparkline(); spark != "" {
+		line += " " + spark
+	}
+	return line
+}
+
+// MarshalJSON implements json.Marshaler, so structured sinks write the
+// distribution as an object rather than its text form.
+func (s HistogramSnapshot) MarshalJSON() ([]byte, error) {
+	buckets := s.Buckets
+	if buckets == nil {
+		buckets = []HistogramBucket{}
+	}
+	return json.Marshal(struct {
+		Count   uint64            `json:"count"`
+		Sum     float64           `json:"sum"`
+		Min     float64           `json:"min"`
+		Max     float64           `json:"max"`
+		Mean    float64           `json:"mean"`
+		P50     float64           `json:"p50"`
+		P90     float64           `json:"p90"`
+		P99     float64           `json:"p99"`
+		Zero    uint64            `json:"zero,omitempty"`
+		Buckets []HistogramBucket `json:"buckets"`
+	}{s.Count, s.Sum, s.Min, s.Max, s.Mean(), s.Quantile(0.5), s.Quantile(0.9), s.Quantile(0.99), s.Zero, buckets})
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"math"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSparkline(t *testing.T) {
+	var h Histogram
+	for _, v := range []float64{1, 1, 3, 20} {
+		h.Observe(v)
+	}
+	// Buckets (0.5, 1], (2, 4] and (16, 32], with the empty ones between.
+	if got, want := h.Snapshot().Sparkline(), "█ ▄  ▄"; got != want {
+		t.Errorf("sparkline %q, want %q", got, want)
+	}
+}
+
+func TestSparklineExtremes(t *testing.T) {
+	for _, values := range [][]float64{
+		{5e-324},
+		{5e-324, 1},
+		{math.MaxFloat64},
+		{1, math.MaxFloat64},
+	} {
+		var h Histogram
+		h.Scale = 2
+		for _, v := range values {
+			h.Observe(v)
+		}
+		spark := h.Snapshot().Sparkline()
+		if n := utf8.RuneCountInString(spark); n < 1 || n > maxSparkline {
+			t.Errorf("sparkline of %v is %q", values, spark)
+		}
+	}
+}
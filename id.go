@@ -0,0 +1,237 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// IDGenerator generates the IDs of stored alerts, incidents and delivery
+// receipts.  Components which assign IDs take an IDGenerator option and
+// default to DefaultIDGenerator, so that IDs can be made compatible with
+// existing incident tooling.  Implementations must be safe for concurrent
+// use.
+type IDGenerator interface {
+	// NewID returns a new, unique ID.
+	NewID() string
+}
+
+// IDGeneratorFunc adapts a function to the IDGenerator interface.
+type IDGeneratorFunc func() string
+
+// NewID implements IDGenerator.
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// DefaultIDGenerator is the IDGenerator used where none is set.  It
+// generates ULIDs.
+var DefaultIDGenerator IDGenerator = NewULIDGenerator(nil)
+
+// ParseIDGenerator returns the IDGenerator called name: "ulid", "uuidv7" or
+// "snowflake", the latter for node 0.
+func ParseIDGenerator(name string) (IDGenerator, error) {
+	switch name {
+	case "ulid":
+		return NewULIDGenerator(nil), nil
+	case "uuidv7":
+		return NewUUIDv7Generator(nil), nil
+	case "snowflake":
+		return NewSnowflakeGenerator(SnowflakeOptions{})
+	}
+	return nil, fmt.Errorf("unknown ID generator %q", name)
+}
+
+// NewULIDGenerator returns an IDGenerator of ULIDs, 26 character strings
+// of Crockford's base 32 which sort by the time of clock, or SystemClock if
+// nil, at which they were generated.  IDs generated within the same
+// millisecond increase monotonically.
+func NewULIDGenerator(clock Clock) IDGenerator {
+	m := &monotonic{clock: clockOr(clock), bits: 80}
+	return IDGeneratorFunc(func() string {
+		var id [16]byte
+		ms, rnd := m.next()
+		putMillis(id[:6], ms)
+		copy(id[6:], rnd[:])
+		return encodeCrockford(id)
+	})
+}
+
+// NewUUIDv7Generator returns an IDGenerator of version 7 UUIDs as defined
+// by RFC 9562, in their canonical hyphenated form.  Like ULIDs, they sort
+// by the time of clock, or SystemClock if nil, and increase monotonically
+// within the same millisecond.
+func NewUUIDv7Generator(clock Clock) IDGenerator {
+	m := &monotonic{clock: clockOr(clock), bits: 74}
+	return IDGeneratorFunc(func() string {
+		var id [16]byte
+		ms, rnd := m.next()
+		putMillis(id[:6], ms)
+		// The 74 bits of rnd follow the version and variant fields.
+		r := rnd[1:]
+		id[6] = 0x70 | (rnd[0]&0x03)<<2 | r[0]>>6
+		id[7] = r[0]<<2 | r[1]>>6
+		id[8] = 0x80 | r[1]&0x3f
+		copy(id[9:], r[2:])
+		var b [36]byte
+		hex.Encode(b[0:8], id[0:4])
+		hex.Encode(b[9:13], id[4:6])
+		hex.Encode(b[14:18], id[6:8])
+		hex.Encode(b[19:23], id[8:10])
+		hex.Encode(b[24:], id[10:])
+		b[8], b[13], b[18], b[23] = '-', '-', '-', '-'
+		return string(b[:])
+	})
+}
+
+// SnowflakeOptions carries parameters for NewSnowflakeGenerator.
+type SnowflakeOptions struct {
+	// Node is the number of the generating process, between 0 and 1023,
+	// which must be unique among the processes sharing IDs.
+	Node int64
+
+	// Epoch is the time IDs count from.  Defaults to the epoch of Twitter,
+	// 2010-11-04 01:42:54.657 UTC.
+	Epoch time.Time
+
+	// Clock defaults to SystemClock.
+	Clock Clock
+}
+
+// snowflakeEpoch is the default SnowflakeOptions.Epoch.
+var snowflakeEpoch = time.UnixMilli(1288834974657)
+
+// NewSnowflakeGenerator returns an IDGenerator of snowflake IDs, decimal
+// 63 bit integers made of 41 bits of milliseconds since the epoch, 10 bits
+// of node and 12 bits of sequence.  IDs increase monotonically: if the
+// sequence of a millisecond is exhausted or the clock goes backwards, the
+// generator counts on from the last millisecond it used instead.
+func NewSnowflakeGenerator(opts SnowflakeOptions) (IDGenerator, error) {
+	if opts.Node < 0 || opts.Node > 1023 {
+		return nil, fmt.Errorf("snowflake node %d out of range [0, 1023]", opts.Node)
+	}
+	if opts.Epoch.IsZero() {
+		opts.Epoch = snowflakeEpoch
+	}
+	clock := clockOr(opts.Clock)
+	var (
+		mu   sync.Mutex
+		last int64
+		seq  int64
+	)
+	return IDGeneratorFunc(func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		ms := clock.Now().Sub(opts.Epoch).Milliseconds()
+		switch {
+		case ms > last:
+			last, seq = ms, 0
+		case seq < 4095:
+			seq++
+		default:
+			last, seq = last+1, 0
+		}
+		return strconv.FormatInt((last&(1<<41-1))<<22|opts.Node<<12|seq, 10)
+	}), nil
+}
+
+// monotonic generates the time and random parts of ULIDs and UUIDs.
+// Within the same millisecond, the random part of the previous ID is
+// incremented rather than drawn anew, so that IDs keep their order.
+type monotonic struct {
+	clock Clock
+	// bits is the size of the random part, at most 80 bits.
+	bits int
+
+	mu  sync.Mutex
+	ms  uint64
+	rnd [10]byte
+}
+
+func (m *monotonic) next() (uint64, [10]byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ms := uint64(m.clock.Now().UnixMilli())
+	if ms > m.ms {
+		m.ms = ms
+		if _, err := rand.Read(m.rnd[:]); err != nil {
+			panic(fmt.Sprintf("alerter: reading random bytes: %v", err))
+		}
+		m.mask()
+		return m.ms, m.rnd
+	}
+	// Same millisecond or the clock went backwards: increment, moving on
+	// to the next millisecond if the random part overflows.
+	for i := len(m.rnd) - 1; i >= 0; i-- {
+		m.rnd[i]++
+		if m.rnd[i] != 0 {
+			break
+		}
+	}
+	if m.overflowed() {
+		m.ms++
+		m.rnd = [10]byte{}
+	}
+	return m.ms, m.rnd
+}
+
+// mask clears the bits of rnd above m.bits.
+func (m *monotonic) mask() {
+	if extra := 80 - m.bits; extra > 0 {
+		m.rnd[0] &= 0xff >> extra
+	}
+}
+
+// overflowed reports whether an increment carried beyond m.bits.
+func (m *monotonic) overflowed() bool {
+	if extra := 80 - m.bits; extra > 0 {
+		return m.rnd[0]>>(8-extra) != 0
+	}
+	return m.rnd == [10]byte{}
+}
+
+// putMillis writes the low 48 bits of ms to b, big-endian.
+func putMillis(b []byte, ms uint64) {
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeCrockford encodes the 128 bits of id, padded to 130 bits at the
+// front, as 26 characters of Crockford's base 32.
+func encodeCrockford(id [16]byte) string {
+	var b [26]byte
+	for i := range b {
+		var c byte
+		for j := i * 5; j < i*5+5; j++ {
+			c <<= 1
+			if bit := j - 2; bit >= 0 {
+				c |= id[bit/8] >> (7 - bit%8) & 1
+			}
+		}
+		b[i] = crockford[c]
+	}
+	return string(b[:])
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import "sort"
+
+// KV returns a key/value pair whose value has the static type T, for
+// passing to KVs.  Unlike a loose keysAndValues list, a list of KV calls
+// cannot have keys which are not strings or a key without a value:
+//
+//	a.Error(err, "payment failed", alerter.KVs(
+//		alerter.KV("order", order.ID),
+//		alerter.KV("amount", order.Amount),
+//	)...)
+func KV[T any](key string, value T) Field {
+	return Field{Key: key, Value: value}
+}
+
+// KVs flattens fields into a keysAndValues list, as taken by Info, Error,
+// Resolve and WithValues.
+func KVs(fields ...Field) []interface{} {
+	kvs := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		kvs = append(kvs, f.Key, f.Value)
+	}
+	return kvs
+}
+
+// KVMap flattens a map into a keysAndValues list, ordered by key so that
+// alerts with the same map have the same fingerprint.
+func KVMap[T any](m map[string]T) []interface{} {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]interface{}, 0, 2*len(m))
+	for _, k := range keys {
+		kvs = append(kvs, k, m[k])
+	}
+	return kvs
+}
+
+// Key is a key whose values always have the type T, so that the type of a
+// key is declared once and checked by the compiler wherever it is used:
+//
+//	var OrderID = alerter.Key[int64]("order")
+//
+//	a.Info("order shipped", alerter.KVs(OrderID.Field(id))...)
+type Key[T any] string
+
+// Field returns the key/value pair of the key with value.
+func (k Key[T]) Field(value T) Field {
+	return Field{Key: string(k), Value: value}
+}
+
+// Lookup returns the last value of the key among keysAndValues, and whether
+// there is one of type T.
+func (k Key[T]) Lookup(keysAndValues []interface{}) (T, bool) {
+	var value T
+	found := false
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] != string(k) {
+			continue
+		}
+		if v, ok := keysAndValues[i+1].(T); ok {
+			value, found = v, true
+		}
+	}
+	return value, found
+}
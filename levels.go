@@ -0,0 +1,82 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+// Conventions maps Warn and Debug onto V-levels and severities, so that
+// an organization decides once what a warning or a debug alert is instead
+// of every team picking its own arguments for V and SeverityKey.
+type Conventions struct {
+	// WarnLevel and WarnSeverity are the V-level, relative to the
+	// Alerter, and the severity of Warn alerts.
+	WarnLevel    int
+	WarnSeverity Severity
+
+	// DebugLevel and DebugSeverity are the V-level, relative to the
+	// Alerter, and the severity of Debug alerts.
+	DebugLevel    int
+	DebugSeverity Severity
+}
+
+// DefaultConventions are the Conventions of Alerters for which none are
+// set: warnings at V-level 0 with SeverityWarning and debug alerts at
+// V-level 2 with SeverityInfo.
+var DefaultConventions = Conventions{
+	WarnLevel:     0,
+	WarnSeverity:  SeverityWarning,
+	DebugLevel:    2,
+	DebugSeverity: SeverityInfo,
+}
+
+// WithConventions returns a new Alerter instance whose Warn and Debug
+// follow c.
+func (a Alerter) WithConventions(c Conventions) Alerter {
+	a.conventions = &c
+	return a
+}
+
+// Warn alerts a message which deserves attention but is not an error, at
+// the V-level and with the severity of the Alerter's Conventions.  A
+// severity passed with SeverityKey takes precedence.
+func (a Alerter) Warn(msg string, keysAndValues ...interface{}) {
+	c := a.conventionsOrDefault()
+	a.WithCallDepth(1).V(c.WarnLevel).Info(msg, withConventionSeverity(keysAndValues, c.WarnSeverity)...)
+}
+
+// Debug alerts a message which only matters while debugging, at the
+// V-level and with the severity of the Alerter's Conventions.  A severity
+// passed with SeverityKey takes precedence.
+func (a Alerter) Debug(msg string, keysAndValues ...interface{}) {
+	c := a.conventionsOrDefault()
+	a.WithCallDepth(1).V(c.DebugLevel).Info(msg, withConventionSeverity(keysAndValues, c.DebugSeverity)...)
+}
+
+func (a Alerter) conventionsOrDefault() Conventions {
+	if a.conventions != nil {
+		return *a.conventions
+	}
+	return DefaultConventions
+}
+
+// withConventionSeverity returns keysAndValues with sev in front, so that
+// a severity among them overrides it.  The original slice is never
+// modified.
+func withConventionSeverity(keysAndValues []interface{}, sev Severity) []interface{} {
+	if sev == SeverityInfo {
+		return keysAndValues
+	}
+	return append([]interface{}{SeverityKey, sev}, keysAndValues...)
+}
@@ -0,0 +1,577 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lifecycle tracks alerts through the states of an incident, from
+// pending over firing and acknowledged to resolved, recording when and by
+// whom every transition happened:
+//
+//	tracker := lifecycle.New(pager, lifecycle.Options{
+//		Pending: 2 * time.Minute,
+//		Notify:  auditLog,
+//	})
+//	a := alerter.New(tracker)
+//	...
+//	err := tracker.Acknowledge(fingerprint, "alice")
+//
+// Records are queried with Records or over HTTP, as the Tracker is an
+// http.Handler, and Scoped limits handlers to some of the alerts.
+package lifecycle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/store"
+)
+
+// State is the state of an alert.
+type State string
+
+// States of alerts.
+const (
+	// Pending alerts were raised but are held back until they persisted
+	// for Options.Pending.
+	Pending State = "pending"
+
+	// Firing alerts were delivered.
+	Firing State = "firing"
+
+	// Acknowledged alerts are firing and taken care of by someone.  Their
+	// occurrences are no longer delivered.
+	Acknowledged State = "acknowledged"
+
+	// Resolved alerts are over.
+	Resolved State = "resolved"
+)
+
+// Keys of the key/value pairs of the state changes sent to Options.Notify.
+const (
+	// StateKey carries the new State.
+	StateKey = "state"
+
+	// PreviousStateKey carries the State before the change.
+	PreviousStateKey = "previous_state"
+
+	// ActorKey carries who caused the change, if it was not the alert
+	// itself.
+	ActorKey = "actor"
+)
+
+// ErrUnknownAlert is returned for fingerprints without a record.
+var ErrUnknownAlert = errors.New("lifecycle: unknown alert")
+
+// Transition is a change of the state of an alert.
+type Transition struct {
+	From  State     `json:"from,omitempty"`
+	To    State     `json:"to"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor,omitempty"`
+}
+
+// Record is the lifecycle of an alert.
+type Record struct {
+	Fingerprint string
+
+	// Alert is the latest occurrence of the alert.
+	Alert alerter.Alert
+
+	// State is the current state, entered at Updated.
+	State   State
+	Updated time.Time
+
+	// Since is the time the alert was first raised.
+	Since time.Time
+
+	// History holds the transitions, oldest first.
+	History []Transition
+}
+
+// MarshalJSON implements json.Marshaler, encoding the alert by its labels.
+func (r Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Fingerprint string            `json:"fingerprint"`
+		State       State             `json:"state"`
+		Updated     time.Time         `json:"updated"`
+		Since       time.Time         `json:"since"`
+		Name        string            `json:"name,omitempty"`
+		Message     string            `json:"message"`
+		Severity    alerter.Severity  `json:"severity"`
+		Labels      map[string]string `json:"labels"`
+		History     []Transition      `json:"history"`
+	}{r.Fingerprint, r.State, r.Updated, r.Since, r.Alert.Name, r.Alert.Message, r.Alert.Severity, matchers.Labels(&r.Alert), r.History})
+}
+
+// Options carries parameters for New.
+type Options struct {
+	// Pending is the time alerts stay pending before they fire, so that
+	// conditions which go away quickly are never delivered.  Zero fires
+	// them right away.
+	Pending time.Duration
+
+	// Notify, if set, receives every state change, as the alert with
+	// StateKey, PreviousStateKey and ActorKey attached.  Changes to
+	// Resolved are resolves.
+	Notify alerter.Sink
+
+	// Retention is the time records of resolved alerts are kept.
+	// Defaults to 24 hours.
+	Retention time.Duration
+
+	// OnError is called with the alerts which fired after being pending
+	// but could not be delivered, and with a nil alert for errors of
+	// Acks.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock tells the time of transitions and when pending alerts fire.
+	// Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+
+	// Acks, if its Store is set, shares acknowledgements with the
+	// Trackers of other replicas using the same Store: the next
+	// occurrence of an alert acknowledged through any of them moves it to
+	// Acknowledged, with the same actor, instead of being delivered.
+	// Resolves clear the acknowledgement.
+	Acks store.Acks
+}
+
+// sink is the set of interfaces implemented by the Sinks of
+// alerter.NewSink, embedded so that the Tracker supports resolves and
+// assembled alerts.
+type sink interface {
+	alerter.Sink
+	alerter.Resolver
+	alerter.AlertSink
+	alerter.BatchSink
+}
+
+// Tracker is a Sink which tracks the lifecycle of the alerts it passes on
+// to the inner Sink.  Alerts are identified by their fingerprint; an alert
+// raised again after it was resolved starts a new record.
+type Tracker struct {
+	sink
+
+	inner alerter.Sink
+	opts  Options
+
+	mu        sync.Mutex
+	records   map[string]*record
+	nextSweep int
+}
+
+// record is the state of an alert.  gen tells the timers of pending alerts
+// apart.
+type record struct {
+	Record
+	timer alerter.Timer
+	gen   int
+}
+
+// New returns a Tracker delivering to inner.
+func New(inner alerter.Sink, opts Options) *Tracker {
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	t := &Tracker{inner: inner, opts: opts, records: map[string]*record{}, nextSweep: 1024}
+	t.sink = alerter.NewSink(t.send, alerter.SinkOptions{Enabled: inner.Enabled, Clock: opts.Clock}).(sink)
+	return t
+}
+
+func (t *Tracker) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	fp := a.Fingerprint()
+	if a.Resolved {
+		t.storeError(t.opts.Acks.Clear(ctx, fp))
+		t.mu.Lock()
+		r := t.records[fp]
+		if r == nil || r.State == Resolved {
+			t.mu.Unlock()
+			return alerter.Send(t.inner, a)
+		}
+		delivered := r.State != Pending
+		change := t.transition(r, Resolved, "", t.opts.Clock.Now())
+		r.Alert = *a
+		t.mu.Unlock()
+		t.notify(change)
+		if !delivered {
+			return nil
+		}
+		return alerter.Send(t.inner, a)
+	}
+
+	actor, acked, err := t.opts.Acks.Acknowledged(ctx, fp)
+	t.storeError(err)
+	t.mu.Lock()
+	now := t.opts.Clock.Now()
+	r := t.records[fp]
+
+	if r == nil || r.State == Resolved {
+		t.sweep(now)
+		r = &record{Record: Record{Fingerprint: fp, Alert: *a, Since: now}}
+		t.records[fp] = r
+		changes := []*alerter.Alert{t.transition(r, Pending, "", now)}
+		if t.opts.Pending > 0 {
+			r.gen++
+			gen := r.gen
+			r.timer = alerter.AfterFunc(t.opts.Clock, t.opts.Pending, func() { t.fire(r, gen) })
+			t.mu.Unlock()
+			t.notify(changes...)
+			return nil
+		}
+		changes = append(changes, t.transition(r, Firing, "", now))
+		if acked {
+			changes = append(changes, t.transition(r, Acknowledged, actor, now))
+			t.mu.Unlock()
+			t.notify(changes...)
+			return nil
+		}
+		t.mu.Unlock()
+		t.notify(changes...)
+		return alerter.Send(t.inner, a)
+	}
+
+	r.Alert = *a
+	if acked && r.State == Firing {
+		change := t.transition(r, Acknowledged, actor, now)
+		t.mu.Unlock()
+		t.notify(change)
+		return nil
+	}
+	state := r.State
+	t.mu.Unlock()
+	if state != Firing {
+		return nil
+	}
+	return alerter.Send(t.inner, a)
+}
+
+// fire delivers the pending alert of r, unless it was resolved since.
+func (t *Tracker) fire(r *record, gen int) {
+	actor, acked, err := t.opts.Acks.Acknowledged(context.Background(), r.Fingerprint)
+	t.storeError(err)
+	t.mu.Lock()
+	if r.gen != gen || r.State != Pending {
+		t.mu.Unlock()
+		return
+	}
+	now := t.opts.Clock.Now()
+	change := t.transition(r, Firing, "", now)
+	if acked {
+		ack := t.transition(r, Acknowledged, actor, now)
+		t.mu.Unlock()
+		t.notify(change, ack)
+		return
+	}
+	a := r.Alert
+	t.mu.Unlock()
+	t.notify(change)
+	if err := alerter.Send(t.inner, &a); err != nil && t.opts.OnError != nil {
+		t.opts.OnError(&a, err)
+	}
+}
+
+// transition moves r to state and returns the state change to notify.  It
+// must be called with t.mu held.
+func (t *Tracker) transition(r *record, to State, actor string, now time.Time) *alerter.Alert {
+	from := r.State
+	r.State, r.Updated = to, now
+	r.History = append(r.History, Transition{From: from, To: to, Time: now, Actor: actor})
+	if to == Resolved && r.timer != nil {
+		r.timer.Stop()
+		r.gen++
+	}
+	if t.opts.Notify == nil {
+		return nil
+	}
+	c := r.Alert
+	c.Time = now
+	c.Resolved = to == Resolved
+	c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)], StateKey, string(to))
+	if from != "" {
+		c.KeysAndValues = append(c.KeysAndValues, PreviousStateKey, string(from))
+	}
+	if actor != "" {
+		c.KeysAndValues = append(c.KeysAndValues, ActorKey, actor)
+	}
+	return &c
+}
+
+// storeError passes an error of Options.Acks to OnError.
+func (t *Tracker) storeError(err error) {
+	if err != nil && t.opts.OnError != nil {
+		t.opts.OnError(nil, err)
+	}
+}
+
+func (t *Tracker) notify(changes ...*alerter.Alert) {
+	for _, c := range changes {
+		if c != nil {
+			_ = alerter.Send(t.opts.Notify, c)
+		}
+	}
+}
+
+// Acknowledge marks the firing alert with the given fingerprint as taken
+// care of by actor, e.g. the name of a responder, and records the
+// acknowledgement in Options.Acks.  With a Store, alerts this Tracker has
+// no record of are acknowledged too, as another replica may have; errors
+// of the Store are returned.
+func (t *Tracker) Acknowledge(fingerprint, actor string) error {
+	t.mu.Lock()
+	r := t.records[fingerprint]
+	if r == nil {
+		t.mu.Unlock()
+		if t.opts.Acks.Store == nil {
+			return ErrUnknownAlert
+		}
+		return t.opts.Acks.Acknowledge(context.Background(), fingerprint, actor)
+	}
+	if r.State != Firing {
+		state := r.State
+		t.mu.Unlock()
+		return fmt.Errorf("lifecycle: cannot acknowledge %s alert", state)
+	}
+	change := t.transition(r, Acknowledged, actor, t.opts.Clock.Now())
+	t.mu.Unlock()
+	t.notify(change)
+	return t.opts.Acks.Acknowledge(context.Background(), fingerprint, actor)
+}
+
+// ResolveAs resolves the alert with the given fingerprint on behalf of
+// actor, e.g. for conditions which do not resolve themselves, and delivers
+// the resolve if the alert fired.  Resolve is the alerter.Resolver method
+// through which alerts resolve themselves.
+func (t *Tracker) ResolveAs(fingerprint, actor string) error {
+	t.mu.Lock()
+	r := t.records[fingerprint]
+	if r == nil {
+		t.mu.Unlock()
+		return ErrUnknownAlert
+	}
+	if r.State == Resolved {
+		t.mu.Unlock()
+		return errors.New("lifecycle: alert is resolved already")
+	}
+	now := t.opts.Clock.Now()
+	delivered := r.State != Pending
+	change := t.transition(r, Resolved, actor, now)
+	resolve := &alerter.Alert{
+		Time:          now,
+		Name:          r.Alert.Name,
+		Level:         r.Alert.Level,
+		Message:       r.Alert.Message,
+		Severity:      r.Alert.Severity,
+		Resolved:      true,
+		Values:        r.Alert.Values,
+		KeysAndValues: []interface{}{ActorKey, actor},
+	}
+	t.mu.Unlock()
+	t.storeError(t.opts.Acks.Clear(context.Background(), fingerprint))
+	t.notify(change)
+	if !delivered {
+		return nil
+	}
+	return alerter.Send(t.inner, resolve)
+}
+
+// Get returns the record of the alert with the given fingerprint.
+func (t *Tracker) Get(fingerprint string) (Record, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.records[fingerprint]
+	if r == nil {
+		return Record{}, false
+	}
+	return r.snapshot(), true
+}
+
+// snapshot returns a copy of the record.  It must be called with the lock
+// of the Tracker held.
+func (r *record) snapshot() Record {
+	c := r.Record
+	c.History = append([]Transition(nil), r.History...)
+	return c
+}
+
+// Query selects records.  Conditions which are not set match all records.
+type Query struct {
+	// States matches records in one of them.
+	States []State
+
+	// Matchers matches records whose alerts' labels match them.
+	Matchers matchers.Matchers
+
+	// At, if set, matches the records of alerts which were raised by
+	// then, and States their state at that time as told by their
+	// History, e.g. to find the alerts which were firing when a change
+	// was deployed.  Records are returned as they are now.  Only records
+	// kept for Options.Retention can be found, and an alert raised again
+	// after its resolve keeps the History of its latest occurrence.
+	At time.Time
+
+	// Limit is the maximum number of records returned, the most
+	// recently updated ones.
+	Limit int
+}
+
+func (q *Query) matches(r *record) bool {
+	state := r.State
+	if !q.At.IsZero() {
+		var ok bool
+		if state, ok = r.stateAt(q.At); !ok {
+			return false
+		}
+	}
+	if len(q.States) > 0 {
+		found := false
+		for _, s := range q.States {
+			found = found || s == state
+		}
+		if !found {
+			return false
+		}
+	}
+	return q.Matchers.Matches(&r.Alert)
+}
+
+// stateAt returns the state of r at t, and false if it was not raised by
+// then.
+func (r *record) stateAt(t time.Time) (State, bool) {
+	var state State
+	for _, tr := range r.History {
+		if tr.Time.After(t) {
+			break
+		}
+		state = tr.To
+	}
+	return state, state != ""
+}
+
+// Records returns the records selected by q, least recently updated first.
+func (t *Tracker) Records(q Query) []Record {
+	t.mu.Lock()
+	var selected []Record
+	for _, r := range t.records {
+		if q.matches(r) {
+			selected = append(selected, r.snapshot())
+		}
+	}
+	t.mu.Unlock()
+	sort.Slice(selected, func(i, j int) bool {
+		if !selected[i].Updated.Equal(selected[j].Updated) {
+			return selected[i].Updated.Before(selected[j].Updated)
+		}
+		return selected[i].Fingerprint < selected[j].Fingerprint
+	})
+	if q.Limit > 0 && len(selected) > q.Limit {
+		selected = selected[len(selected)-q.Limit:]
+	}
+	return selected
+}
+
+// ServeHTTP implements http.Handler, answering GET requests with the
+// records as a JSON array.  The query parameters state, which may be
+// repeated, match, with matchers such as `{team="db"}`, at, a time in RFC
+// 3339 format, and limit select records as Query does.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t.serve(w, r, nil)
+}
+
+// Scoped returns an http.Handler which answers like the Tracker, but only
+// with the records of alerts matching scope, so that teams can be given
+// access to their own alerts only, each through a handler behind their
+// credentials:
+//
+//	http.Handle("/alerts/db", requireTeam("db", tracker.Scoped(matchers.MustParse(`{team="db"}`))))
+//
+// The matchers of requests narrow the scope further.
+func (t *Tracker) Scoped(scope matchers.Matchers) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.serve(w, r, scope)
+	})
+}
+
+// serve answers a query for the records matching scope.
+func (t *Tracker) serve(w http.ResponseWriter, r *http.Request, scope matchers.Matchers) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var q Query
+	params := r.URL.Query()
+	for _, s := range params["state"] {
+		q.States = append(q.States, State(s))
+	}
+	if m := params.Get("match"); m != "" {
+		ms, err := matchers.Parse(m)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Matchers = ms
+	}
+	q.Matchers = append(scope[:len(scope):len(scope)], q.Matchers...)
+	if at := params.Get("at"); at != "" {
+		when, err := time.Parse(time.RFC3339Nano, at)
+		if err != nil {
+			http.Error(w, "invalid at", http.StatusBadRequest)
+			return
+		}
+		q.At = when
+	}
+	if l := params.Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+	records := t.Records(q)
+	if records == nil {
+		records = []Record{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// sweep forgets resolved alerts after the retention period, once there are
+// many records.  It must be called with t.mu held.
+func (t *Tracker) sweep(now time.Time) {
+	if len(t.records) < t.nextSweep {
+		return
+	}
+	for fp, r := range t.records {
+		if r.State == Resolved && now.Sub(r.Updated) >= t.opts.Retention {
+			delete(t.records, fp)
+		}
+	}
+	t.nextSweep = 2 * len(t.records)
+	if t.nextSweep < 1024 {
+		t.nextSweep = 1024
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package matchers implements label matchers in the syntax of Prometheus
+// and Alertmanager, for selecting alerts in routes, silences and
+// inhibitions:
+//
+//	ms, err := matchers.Parse(`severity="critical", team=~"db|storage"`)
+//	if err != nil {
+//		...
+//	}
+//	if ms.Matches(a) {
+//		...
+//	}
+//
+// Matchers compare label values with "=", "!=", "=~" and "!~", the latter
+// two with regular expressions which must match the whole value.  The
+// labels of an alert are described at Labels.
+package matchers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Type is the comparison of a Matcher.
+type Type int
+
+const (
+	// Equal matches labels with the value.
+	Equal Type = iota
+	// NotEqual matches labels without the value.
+	NotEqual
+	// Regexp matches labels whose whole value matches the expression.
+	Regexp
+	// NotRegexp matches labels whose whole value does not match the
+	// expression.
+	NotRegexp
+)
+
+var typeOperators = [...]string{
+	Equal:     "=",
+	NotEqual:  "!=",
+	Regexp:    "=~",
+	NotRegexp: "!~",
+}
+
+// String returns the operator of the type.
+func (t Type) String() string {
+	if t >= 0 && int(t) < len(typeOperators) {
+		return typeOperators[t]
+	}
+	return fmt.Sprintf("Type(%d)", int(t))
+}
+
+// Matcher matches one label of an alert.  Labels an alert does not have
+// have the empty value, so `team=""` matches alerts without a team and
+// `team!=""` those with one.
+type Matcher struct {
+	Name  string
+	Type  Type
+	Value string
+
+	re *regexp.Regexp
+}
+
+// New returns a Matcher, compiling value for Regexp and NotRegexp.
+func New(t Type, name, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if t == Regexp || t == NotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("matcher %s: %w", name, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// String returns the matcher in the syntax Parse accepts.
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+// MatchValue reports whether the label value v matches.
+func (m *Matcher) MatchValue(v string) bool {
+	switch m.Type {
+	case Equal:
+		return v == m.Value
+	case NotEqual:
+		return v != m.Value
+	case Regexp:
+		return m.re.MatchString(v)
+	case NotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+// Matches reports whether the labels of a match.
+func (m *Matcher) Matches(a *alerter.Alert) bool {
+	return m.MatchValue(Labels(a)[m.Name])
+}
+
+// Matchers is a list of Matchers, all of which must match.
+type Matchers []*Matcher
+
+// Matches reports whether the labels of a match all matchers.  An empty
+// list matches all alerts.
+func (ms Matchers) Matches(a *alerter.Alert) bool {
+	if len(ms) == 0 {
+		return true
+	}
+	return ms.MatchLabels(Labels(a))
+}
+
+// MatchLabels reports whether labels match all matchers.
+func (ms Matchers) MatchLabels(labels map[string]string) bool {
+	for _, m := range ms {
+		if !m.MatchValue(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the matchers in the syntax Parse accepts.
+func (ms Matchers) String() string {
+	parts := make([]string, len(ms))
+	for i, m := range ms {
+		parts[i] = m.String()
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// MarshalText implements encoding.TextMarshaler, so that Matchers can be
+// written as strings in configuration.
+func (ms Matchers) MarshalText() ([]byte, error) {
+	return []byte(ms.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (ms *Matchers) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*ms = parsed
+	return nil
+}
+
+// JSONSchema describes Matchers in config.Schema.
+func (Matchers) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "examples": []interface{}{`{severity="critical", team=~"db|storage"}`}}
+}
+
+// Labels returns the labels matchers see on an alert: its values and the
+// key/value pairs of the call, the latter taking precedence, formatted with
+// fmt, and in addition "severity" with the name of its severity and
+// "alertname" with its name.
+func Labels(a *alerter.Alert) map[string]string {
+	fields := a.Fields()
+	labels := make(map[string]string, len(fields)+2)
+	for _, f := range fields {
+		labels[f.Key] = fmt.Sprint(f.Value)
+	}
+	labels[alerter.SeverityKey] = a.Severity.String()
+	if a.Name != "" {
+		labels["alertname"] = a.Name
+	}
+	return labels
+}
+
+// Parse parses a comma-separated list of matchers, optionally in braces,
+// such as `{severity="critical", team=~"db|storage"}`.  Values are quoted
+// with double or single quotes.
+func Parse(s string) (Matchers, error) {
+	p := &parser{s: strings.TrimSpace(s)}
+	if strings.HasPrefix(p.s, "{") {
+		if !strings.HasSuffix(p.s, "}") {
+			return nil, fmt.Errorf("matchers %q: missing closing brace", s)
+		}
+		p.s = p.s[1 : len(p.s)-1]
+	}
+	var ms Matchers
+	for {
+		p.space()
+		if p.i == len(p.s) {
+			return ms, nil
+		}
+		m, err := p.matcher()
+		if err != nil {
+			return nil, fmt.Errorf("matchers %q: %w", s, err)
+		}
+		ms = append(ms, m)
+		p.space()
+		if p.i == len(p.s) {
+			return ms, nil
+		}
+		if p.s[p.i] != ',' {
+			return nil, fmt.Errorf("matchers %q: expected \",\" at offset %d", s, p.i)
+		}
+		p.i++
+	}
+}
+
+// MustParse is like Parse but panics if s cannot be parsed.
+func MustParse(s string) Matchers {
+	ms, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return ms
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) space() {
+	for p.i < len(p.s) && (p.s[p.i] == ' ' || p.s[p.i] == '\t' || p.s[p.i] == '\n') {
+		p.i++
+	}
+}
+
+// matcher parses `name op "value"`.
+func (p *parser) matcher() (*Matcher, error) {
+	start := p.i
+	for p.i < len(p.s) && isNameChar(p.s[p.i], p.i == start) {
+		p.i++
+	}
+	name := p.s[start:p.i]
+	if name == "" {
+		return nil, fmt.Errorf("expected label name at offset %d", start)
+	}
+	p.space()
+	var t Type
+	switch {
+	case strings.HasPrefix(p.s[p.i:], "=~"):
+		t = Regexp
+	case strings.HasPrefix(p.s[p.i:], "!~"):
+		t = NotRegexp
+	case strings.HasPrefix(p.s[p.i:], "!="):
+		t = NotEqual
+	case strings.HasPrefix(p.s[p.i:], "="):
+		t = Equal
+	default:
+		return nil, fmt.Errorf("expected operator after %s at offset %d", name, p.i)
+	}
+	p.i += len(t.String())
+	p.space()
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	return New(t, name, value)
+}
+
+// quoted parses a string in double or single quotes.
+func (p *parser) quoted() (string, error) {
+	start := p.i
+	if p.i == len(p.s) || p.s[p.i] != '"' && p.s[p.i] != '\'' {
+		return "", fmt.Errorf("expected quoted value at offset %d", start)
+	}
+	q := p.s[p.i]
+	for p.i++; p.i < len(p.s) && p.s[p.i] != q; p.i++ {
+		if p.s[p.i] == '\\' {
+			p.i++
+		}
+	}
+	if p.i >= len(p.s) {
+		return "", fmt.Errorf("unterminated value at offset %d", start)
+	}
+	p.i++
+	raw := p.s[start:p.i]
+	if q == '\'' {
+		raw = `"` + strings.ReplaceAll(strings.ReplaceAll(raw[1:len(raw)-1], `\'`, `'`), `"`, `\"`) + `"`
+	}
+	v, err := strconv.Unquote(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid value at offset %d: %w", start, err)
+	}
+	return v, nil
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		!first && (c >= '0' && c <= '9' || c == '.' || c == '-')
+}
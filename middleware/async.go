@@ -0,0 +1,394 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// AsyncOption configures AsyncSink.
+type AsyncOption func(*asyncOptions)
+
+type asyncOptions struct {
+	queueSize   int
+	workers     int
+	overflow    OverflowPolicy
+	metrics     string
+	onError     func(a *alerter.Alert, err error)
+	onDrop      func(a *alerter.Alert)
+	maxWait     time.Duration
+	reserved    int
+	reserveFrom alerter.Severity
+	fairKey     func(a *alerter.Alert) string
+	fairWeights map[string]int
+	clock       alerter.Clock
+	spill       *DiskQueueOptions
+}
+
+// OverflowPolicy selects what AsyncSink does with an alert when its queue is
+// full, trading the latency of callers against completeness.
+type OverflowPolicy int
+
+const (
+	// DropNewest drops the alert being raised.  Callers never wait.
+	DropNewest OverflowPolicy = iota
+
+	// DropOldest drops the alert which waited longest to make room for
+	// the new one.  Callers never wait, and recent alerts, which are
+	// more likely to still be relevant, are kept.
+	DropOldest
+
+	// Block makes callers wait until there is room in the queue.  No
+	// alert is lost, but a slow sink slows down the code alerting.
+	Block
+
+	// Spill writes the alert to a DiskQueue, which feeds spilled alerts
+	// back into the queue, in order, as room becomes available.  Callers
+	// only wait for the disk, and no alert is lost, even if the process
+	// exits.  It is selected with WithSpill.
+	Spill
+)
+
+// WithQueueSize sets the number of alerts which may wait for delivery.
+// Defaults to 1024.
+func WithQueueSize(n int) AsyncOption {
+	return func(o *asyncOptions) { o.queueSize = n }
+}
+
+// WithWorkers sets the number of goroutines delivering alerts.  Defaults to
+// 1, which is the only setting preserving the order of alerts, so that a
+// resolve cannot overtake its alert.
+func WithWorkers(n int) AsyncOption {
+	return func(o *asyncOptions) { o.workers = n }
+}
+
+// WithOverflow sets the policy applied when the queue is full.  Defaults to
+// DropNewest.
+func WithOverflow(p OverflowPolicy) AsyncOption {
+	return func(o *asyncOptions) { o.overflow = p }
+}
+
+// WithSpill selects the Spill policy, with the DiskQueue configured by opts.
+// Alerts which are still spilled when the Async is closed are delivered when
+// a queue with the same path is opened next; they are replayed as described
+// for DiskQueue.  If the DiskQueue cannot be opened, AsyncSink falls back to
+// Block and passes the error to the function of WithOnError, if any, with a
+// nil alert.
+func WithSpill(opts DiskQueueOptions) AsyncOption {
+	return func(o *asyncOptions) {
+		o.overflow = Spill
+		o.spill = &opts
+	}
+}
+
+// WithMetrics publishes the counters of the sink as an expvar map of the
+// given name, with the keys "queued", "dropped", "spilled", "sent" and
+// "failed".  If a Var other than a map is published under the name, the
+// counters are not published and AsyncSink passes the error to the function
+// of WithOnError, if any, with a nil alert.
+func WithMetrics(name string) AsyncOption {
+	return func(o *asyncOptions) { o.metrics = name }
+}
+
+// WithOnError sets a function called with every alert which the inner Sink
+// failed to deliver.
+func WithOnError(fn func(a *alerter.Alert, err error)) AsyncOption {
+	return func(o *asyncOptions) { o.onError = fn }
+}
+
+// WithOnDrop sets a function called with every alert dropped because the
+// queue was full.
+func WithOnDrop(fn func(a *alerter.Alert)) AsyncOption {
+	return func(o *asyncOptions) { o.onDrop = fn }
+}
+
+// WithClock sets the clock which tells how long alerts waited in the queue,
+// for WithPriority.  Defaults to alerter.SystemClock.
+func WithClock(c alerter.Clock) AsyncOption {
+	return func(o *asyncOptions) { o.clock = c }
+}
+
+// orderedQueue is the queue of an Async which orders alerts other than by
+// their arrival, as with WithPriority or WithFairQueuing.
+type orderedQueue interface {
+	// push queues a according to overflow.  It reports whether a was
+	// queued and returns the alert dropped to make room for it, if any.
+	push(a *alerter.Alert, overflow OverflowPolicy) (queued bool, dropped *alerter.Alert)
+
+	// pop waits for the next alert and returns it, with a function to
+	// call once it was delivered, if any, or false once the queue is
+	// closed and empty.
+	pop() (a *alerter.Alert, done func(), ok bool)
+
+	length() int
+	close()
+}
+
+// Async is a Sink which decouples alerting from delivery: alerts are put
+// into a bounded queue and delivered to the inner Sink by a pool of
+// workers, so that callers do not wait for slow network sinks.  What
+// happens when the queue is full is chosen with WithOverflow.
+type Async struct {
+	sink
+
+	inner   alerter.Sink
+	opts    asyncOptions
+	queue   chan *alerter.Alert
+	ordered orderedQueue
+	spill   *DiskQueue
+	dropped atomic.Uint64
+	metrics *expvar.Map
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// AsyncSink returns an Async delivering to inner.  Close must be called to
+// deliver the queued alerts and stop the workers.
+func AsyncSink(inner alerter.Sink, opts ...AsyncOption) *Async {
+	o := asyncOptions{queueSize: 1024, workers: 1}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.queueSize <= 0 {
+		o.queueSize = 1024
+	}
+	if o.workers <= 0 {
+		o.workers = 1
+	}
+	if o.clock == nil {
+		o.clock = alerter.SystemClock
+	}
+	s := &Async{inner: inner, opts: o}
+	if o.reserved > 0 && o.maxWait <= 0 {
+		o.maxWait = defaultMaxWait
+	}
+	switch {
+	case o.fairKey != nil:
+		s.ordered = newFairQueue(o.queueSize, o.fairKey, o.fairWeights)
+	case o.maxWait > 0:
+		s.ordered = newPriorityQueue(o.queueSize, o.maxWait, o.clock, o.reserveFrom, o.reserved)
+	default:
+		s.queue = make(chan *alerter.Alert, o.queueSize)
+	}
+	if o.metrics != "" {
+		var err error
+		if s.metrics, err = metricsMap(o.metrics); err != nil && o.onError != nil {
+			o.onError(nil, err)
+		}
+	}
+	if o.overflow == Spill {
+		var err error
+		if o.spill == nil {
+			err = errors.New("async: Spill needs WithSpill")
+		} else {
+			s.spill, err = DiskQueueSink(wrap(inner, s.unspill), *o.spill)
+		}
+		if err != nil {
+			s.opts.overflow = Block
+			if o.onError != nil {
+				o.onError(nil, err)
+			}
+		}
+	}
+	s.sink = wrap(inner, s.send).(sink)
+	s.wg.Add(o.workers)
+	for i := 0; i < o.workers; i++ {
+		go s.work()
+	}
+	return s
+}
+
+func (s *Async) send(a *alerter.Alert) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		// Deliver late alerts directly rather than losing them.
+		s.deliver(a)
+		return nil
+	}
+	if s.spill != nil && s.spill.Len() > 0 {
+		// Alerts must not overtake those spilled before them.
+		return s.spillover(a)
+	}
+	if s.ordered != nil {
+		queued, dropped := s.ordered.push(a, s.opts.overflow)
+		if dropped != nil {
+			s.drop(dropped)
+		}
+		if !queued && s.spill != nil {
+			return s.spillover(a)
+		}
+		if !queued {
+			s.drop(a)
+			return nil
+		}
+		s.add("queued", 1)
+		return nil
+	}
+	switch s.opts.overflow {
+	case Block:
+		s.queue <- a
+	case Spill:
+		select {
+		case s.queue <- a:
+		default:
+			return s.spillover(a)
+		}
+	case DropOldest:
+		for {
+			select {
+			case s.queue <- a:
+				s.add("queued", 1)
+				return nil
+			default:
+			}
+			select {
+			case old := <-s.queue:
+				s.drop(old)
+			default:
+				// A worker made room in the meantime.
+			}
+		}
+	default:
+		select {
+		case s.queue <- a:
+		default:
+			s.drop(a)
+			return nil
+		}
+	}
+	s.add("queued", 1)
+	return nil
+}
+
+// spillover writes a to the DiskQueue.
+func (s *Async) spillover(a *alerter.Alert) error {
+	if err := alerter.Send(s.spill, a); err != nil {
+		return err
+	}
+	s.add("spilled", 1)
+	return nil
+}
+
+// unspill puts an alert of the DiskQueue into the queue, waiting for room.
+func (s *Async) unspill(a *alerter.Alert) error {
+	if s.ordered != nil {
+		s.ordered.push(a, Block)
+	} else {
+		s.queue <- a
+	}
+	s.add("queued", 1)
+	return nil
+}
+
+func (s *Async) drop(a *alerter.Alert) {
+	s.dropped.Add(1)
+	s.add("dropped", 1)
+	if s.opts.onDrop != nil {
+		s.opts.onDrop(a)
+	}
+}
+
+func (s *Async) add(key string, delta int64) {
+	if s.metrics != nil {
+		s.metrics.Add(key, delta)
+	}
+}
+
+func (s *Async) work() {
+	defer s.wg.Done()
+	if s.ordered != nil {
+		for {
+			a, done, ok := s.ordered.pop()
+			if !ok {
+				return
+			}
+			s.deliver(a)
+			if done != nil {
+				done()
+			}
+		}
+	}
+	for a := range s.queue {
+		s.deliver(a)
+	}
+}
+
+func (s *Async) deliver(a *alerter.Alert) {
+	if err := alerter.Send(s.inner, a); err != nil {
+		s.add("failed", 1)
+		if s.opts.onError != nil {
+			s.opts.onError(a, err)
+		}
+		return
+	}
+	s.add("sent", 1)
+}
+
+// Dropped returns the number of alerts dropped because the queue was full.
+func (s *Async) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Len returns the number of alerts waiting for delivery, spilled ones
+// included.
+func (s *Async) Len() int {
+	n := len(s.queue)
+	if s.ordered != nil {
+		n = s.ordered.length()
+	}
+	if s.spill != nil {
+		n += s.spill.Len()
+	}
+	return n
+}
+
+// Close stops accepting alerts into the queue, waits until the queued ones
+// are delivered and stops the workers.  Alerts raised afterwards are
+// delivered synchronously.
+func (s *Async) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	var err error
+	if s.spill != nil {
+		// Stop feeding the queue before closing it.  Spilled alerts
+		// stay on disk.
+		err = s.spill.Close()
+	}
+	s.mu.Lock()
+	if s.ordered != nil {
+		s.ordered.close()
+	} else {
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
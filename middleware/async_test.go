@@ -0,0 +1,292 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// gate is a Sink which holds every delivery to a recorder until it is
+// opened, so that tests can fill the queue of an Async.
+type gate struct {
+	*recorder
+	started chan string
+	open    chan struct{}
+	once    sync.Once
+}
+
+func newGate() *gate {
+	return &gate{recorder: newRecorder(), started: make(chan string, 100), open: make(chan struct{})}
+}
+
+// sink returns the Sink delivering through g.
+func (g *gate) sink() alerter.Sink {
+	return wrap(g.recorder, func(a *alerter.Alert) error {
+		g.started <- a.Message
+		<-g.open
+		return alerter.Send(g.recorder, a)
+	})
+}
+
+func (g *gate) release() {
+	g.once.Do(func() { close(g.open) })
+}
+
+// raise sends alerts of the given severity and messages to s.
+func raise(s alerter.Sink, severity alerter.Severity, msgs ...string) {
+	for _, msg := range msgs {
+		_ = alerter.Send(s, &alerter.Alert{Message: msg, Severity: severity})
+	}
+}
+
+func TestAsyncDeliversInOrder(t *testing.T) {
+	rec := newRecorder()
+	s := AsyncSink(rec)
+	var want []string
+	for i := 0; i < 100; i++ {
+		msg := string(rune('a' + i%26))
+		want = append(want, msg)
+		raise(s, alerter.SeverityError, msg)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := rec.messages(); !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+
+	// Alerts raised after Close are delivered right away.
+	raise(s, alerter.SeverityError, "late")
+	if got := rec.messages(); got[len(got)-1] != "late" {
+		t.Errorf("late alert not delivered: %v", got[len(got)-4:])
+	}
+}
+
+func TestAsyncOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy             OverflowPolicy
+		delivered, dropped []string
+	}{
+		{DropNewest, []string{"a", "b"}, []string{"c", "d"}},
+		{DropOldest, []string{"a", "d"}, []string{"b", "c"}},
+	} {
+		g := newGate()
+		var dropped []string
+		s := AsyncSink(g.sink(), WithQueueSize(1), WithOverflow(tc.policy), WithOnDrop(func(a *alerter.Alert) {
+			dropped = append(dropped, a.Message)
+		}))
+		raise(s, alerter.SeverityError, "a")
+		<-g.started
+		raise(s, alerter.SeverityError, "b", "c", "d")
+		if n := s.Len(); n != 1 {
+			t.Errorf("policy %d: %d alerts queued, want 1", tc.policy, n)
+		}
+		g.release()
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := g.messages(); !slices.Equal(got, tc.delivered) {
+			t.Errorf("policy %d: delivered %v, want %v", tc.policy, got, tc.delivered)
+		}
+		if !slices.Equal(dropped, tc.dropped) || s.Dropped() != uint64(len(tc.dropped)) {
+			t.Errorf("policy %d: dropped %v (%d), want %v", tc.policy, dropped, s.Dropped(), tc.dropped)
+		}
+	}
+}
+
+func TestAsyncBlock(t *testing.T) {
+	g := newGate()
+	s := AsyncSink(g.sink(), WithQueueSize(1), WithOverflow(Block))
+	raise(s, alerter.SeverityError, "a")
+	<-g.started
+	raise(s, alerter.SeverityError, "b")
+	raised := make(chan struct{})
+	go func() {
+		raise(s, alerter.SeverityError, "c")
+		close(raised)
+	}()
+	select {
+	case <-raised:
+		t.Fatal("raising into a full queue did not block")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.release()
+	<-raised
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.messages(), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestAsyncSpill(t *testing.T) {
+	g := newGate()
+	path := filepath.Join(t.TempDir(), "spill.log")
+	var errs []error
+	s := AsyncSink(g.sink(), WithQueueSize(1), WithSpill(DiskQueueOptions{Path: path}), WithOnError(func(_ *alerter.Alert, err error) {
+		errs = append(errs, err)
+	}))
+	raise(s, alerter.SeverityError, "a")
+	<-g.started
+	raise(s, alerter.SeverityError, "b", "c", "d", "e")
+	if n := s.Len(); n < 3 {
+		t.Errorf("Len is %d, want at least 3 with spilled alerts", n)
+	}
+	g.release()
+	eventually(t, func() bool { return s.Len() == 0 && len(g.recorded()) == 5 })
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.messages(), []string{"a", "b", "c", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+	if s.Dropped() != 0 || len(errs) != 0 {
+		t.Errorf("%d alerts dropped, errors %v", s.Dropped(), errs)
+	}
+}
+
+func TestAsyncSpillWithoutQueue(t *testing.T) {
+	var errs []error
+	rec := newRecorder()
+	s := AsyncSink(rec, WithOverflow(Spill), WithOnError(func(a *alerter.Alert, err error) {
+		if a != nil {
+			t.Errorf("error reported for alert %q", a.Message)
+		}
+		errs = append(errs, err)
+	}))
+	raise(s, alerter.SeverityError, "a")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 1 {
+		t.Errorf("errors %v, want one for the missing spill", errs)
+	}
+	if got := rec.messages(); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("delivered %v, want [a]", got)
+	}
+}
+
+func TestAsyncPriority(t *testing.T) {
+	g := newGate()
+	s := AsyncSink(g.sink(), WithPriority(time.Minute), WithClock(alerter.NewManualClock(epoch)))
+	raise(s, alerter.SeverityInfo, "first")
+	<-g.started
+	raise(s, alerter.SeverityInfo, "info 1")
+	raise(s, alerter.SeverityWarning, "warning")
+	raise(s, alerter.SeverityInfo, "info 2")
+	raise(s, alerter.SeverityCritical, "critical")
+	raise(s, alerter.SeverityError, "error")
+	_ = alerter.Send(s, &alerter.Alert{Message: "critical", Resolved: true})
+	g.release()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "critical", "error", "warning", "info 1", "info 2", "critical resolved"}
+	if got := g.messages(); !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestAsyncPriorityMaxWait(t *testing.T) {
+	g := newGate()
+	clock := alerter.NewManualClock(epoch)
+	s := AsyncSink(g.sink(), WithPriority(time.Minute), WithClock(clock))
+	raise(s, alerter.SeverityInfo, "first")
+	<-g.started
+	raise(s, alerter.SeverityInfo, "old info")
+	clock.Advance(2 * time.Minute)
+	raise(s, alerter.SeverityCritical, "critical")
+	g.release()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "old info", "critical"}
+	if got := g.messages(); !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestAsyncPriorityFull(t *testing.T) {
+	g := newGate()
+	var dropped []string
+	s := AsyncSink(g.sink(), WithQueueSize(2), WithPriority(time.Minute), WithOnDrop(func(a *alerter.Alert) {
+		dropped = append(dropped, a.Message)
+	}))
+	raise(s, alerter.SeverityInfo, "first")
+	<-g.started
+	raise(s, alerter.SeverityInfo, "info")
+	raise(s, alerter.SeverityWarning, "warning")
+	// A page makes room by dropping the oldest alert of the lowest
+	// severity; another info alert is dropped itself.
+	raise(s, alerter.SeverityCritical, "critical")
+	raise(s, alerter.SeverityInfo, "info 2")
+	g.release()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.messages(), []string{"first", "critical", "warning"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+	if want := []string{"info", "info 2"}; !slices.Equal(dropped, want) {
+		t.Errorf("dropped %v, want %v", dropped, want)
+	}
+}
+
+func TestAsyncReserve(t *testing.T) {
+	g := newGate()
+	s := AsyncSink(g.sink(), WithQueueSize(3), WithOverflow(Block), WithReserve(alerter.SeverityCritical, 1))
+	raise(s, alerter.SeverityInfo, "first")
+	<-g.started
+	raise(s, alerter.SeverityInfo, "info 1", "info 2")
+	blocked := make(chan struct{})
+	go func() {
+		raise(s, alerter.SeverityInfo, "info 3")
+		close(blocked)
+	}()
+	// The page takes the reserved place right away.
+	raised := make(chan struct{})
+	go func() {
+		raise(s, alerter.SeverityCritical, "critical")
+		close(raised)
+	}()
+	select {
+	case <-raised:
+	case <-time.After(time.Second):
+		t.Fatal("critical alert waited for room")
+	}
+	select {
+	case <-blocked:
+		t.Error("info alert took the reserved place")
+	default:
+	}
+	g.release()
+	<-blocked
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "critical", "info 1", "info 2", "info 3"}
+	if got := g.messages(); !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// BatchOptions carries parameters which influence the way a Batcher groups
+// alerts.
+type BatchOptions struct {
+	// MaxSize is the number of alerts at which a batch is delivered
+	// right away.  Defaults to 100.
+	MaxSize int
+
+	// FlushInterval is the longest time an alert waits for its batch to
+	// be delivered.  Defaults to 10 seconds.
+	FlushInterval time.Duration
+
+	// OnError is called with batches which could not be delivered.
+	OnError func(alerts []*alerter.Alert, err error)
+
+	// Clock times FlushInterval.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Batcher is a Sink which collects alerts and delivers them to its inner
+// Sink in batches, as a single payload if the inner Sink implements
+// alerter.BatchSink.  Batches are delivered once they reach
+// BatchOptions.MaxSize or their oldest alert has waited for
+// BatchOptions.FlushInterval, whichever comes first.
+//
+// Alerts still waiting are lost unless Close is called before the program
+// exits.
+type Batcher struct {
+	sink
+
+	inner alerter.Sink
+	opts  BatchOptions
+
+	mu      sync.Mutex
+	pending []*alerter.Alert
+	timer   alerter.Timer
+	closed  bool
+}
+
+// BatchingSink returns a Batcher delivering to inner.
+func BatchingSink(inner alerter.Sink, opts BatchOptions) *Batcher {
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	b := &Batcher{inner: inner, opts: opts}
+	b.sink = wrap(inner, b.send).(sink)
+	return b
+}
+
+func (b *Batcher) send(a *alerter.Alert) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return alerter.Send(b.inner, a)
+	}
+	b.pending = append(b.pending, a)
+	if len(b.pending) < b.opts.MaxSize {
+		if b.timer == nil {
+			b.timer = alerter.AfterFunc(b.opts.Clock, b.opts.FlushInterval, func() { _ = b.Flush() })
+		}
+		b.mu.Unlock()
+		return nil
+	}
+	batch := b.take()
+	b.mu.Unlock()
+	return b.deliver(batch)
+}
+
+// Flush delivers the alerts collected so far.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	batch := b.take()
+	b.mu.Unlock()
+	return b.deliver(batch)
+}
+
+// Close delivers the alerts collected so far.  Alerts raised afterwards are
+// delivered one at a time.
+func (b *Batcher) Close() error {
+	b.mu.Lock()
+	b.closed = true
+	batch := b.take()
+	b.mu.Unlock()
+	return b.deliver(batch)
+}
+
+// take returns the pending batch and starts a new one.  It must be called
+// with b.mu held.
+func (b *Batcher) take() []*alerter.Alert {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	batch := b.pending
+	b.pending = nil
+	return batch
+}
+
+func (b *Batcher) deliver(batch []*alerter.Alert) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	err := alerter.SendBatch(b.inner, batch)
+	if err != nil && b.opts.OnError != nil {
+		b.opts.OnError(batch, err)
+	}
+	return err
+}
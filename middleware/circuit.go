@@ -0,0 +1,205 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker while it does not pass
+// alerts to its inner Sink.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitKey is the key under which the meta-alerts of a CircuitBreaker
+// carry its name.
+const CircuitKey = "circuit"
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed passes all alerts on.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects all alerts with ErrCircuitOpen.
+	CircuitOpen
+
+	// CircuitHalfOpen passes a single probe on, whose outcome decides
+	// whether the circuit closes or opens again.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("CircuitState(%d)", int(s))
+}
+
+// CircuitOptions carries parameters which influence the way a
+// CircuitBreaker trips.
+type CircuitOptions struct {
+	// Name identifies the backend in meta-alerts, e.g. "pagerduty".
+	Name string
+
+	// Threshold is the number of consecutive failures which open the
+	// circuit.  Defaults to 5.
+	Threshold int
+
+	// OpenTimeout is the time the circuit stays open before a probe is
+	// let through.  Defaults to 30 seconds.
+	OpenTimeout time.Duration
+
+	// Notify receives a meta-alert when the circuit opens, carrying the
+	// number of failures as "failures", and its resolve when the circuit
+	// closes again.  It should deliver through another backend than the
+	// one protected.  Meta-alerts are not sent if nil.
+	Notify alerter.Sink
+
+	// Clock is used to time the open state.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// CircuitBreaker is a Sink which stops calling a failing backend: after
+// CircuitOptions.Threshold consecutive failures the circuit opens and alerts
+// are rejected with ErrCircuitOpen right away instead of waiting for
+// timeouts.  Once CircuitOptions.OpenTimeout has passed, one alert is let
+// through as a probe; if it is delivered the circuit closes, otherwise it
+// opens again.
+type CircuitBreaker struct {
+	sink
+
+	inner alerter.Sink
+	opts  CircuitOptions
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	// alerted is set while the meta-alert of the open circuit is not
+	// resolved.
+	alerted bool
+}
+
+// CircuitBreakerSink returns a CircuitBreaker delivering to inner.
+func CircuitBreakerSink(inner alerter.Sink, opts CircuitOptions) *CircuitBreaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	c := &CircuitBreaker{inner: inner, opts: opts}
+	c.sink = wrap(inner, c.send).(sink)
+	return c
+}
+
+// State returns the current state of the circuit.
+func (c *CircuitBreaker) State() CircuitState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == CircuitOpen && c.opts.Clock.Now().Sub(c.openedAt) >= c.opts.OpenTimeout {
+		return CircuitHalfOpen
+	}
+	return c.state
+}
+
+func (c *CircuitBreaker) send(a *alerter.Alert) error {
+	c.mu.Lock()
+	switch c.state {
+	case CircuitOpen:
+		if c.opts.Clock.Now().Sub(c.openedAt) < c.opts.OpenTimeout {
+			c.mu.Unlock()
+			return ErrCircuitOpen
+		}
+		c.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		// Another probe is in flight.
+		c.mu.Unlock()
+		return ErrCircuitOpen
+	}
+	c.mu.Unlock()
+
+	err := alerter.Send(c.inner, a)
+
+	// Deliveries run concurrently, so the state may have changed since
+	// this one started, e.g. a delivery started before the circuit opened
+	// may close it.  Whether to alert is therefore decided by the
+	// transition, together with whether the open circuit was alerted.
+	c.mu.Lock()
+	if err == nil {
+		c.state = CircuitClosed
+		c.failures = 0
+	} else {
+		c.failures++
+		if c.state == CircuitHalfOpen || c.failures >= c.opts.Threshold {
+			c.state = CircuitOpen
+			c.openedAt = c.opts.Clock.Now()
+		}
+	}
+	opened := c.state == CircuitOpen && !c.alerted
+	closed := c.state == CircuitClosed && c.alerted
+	c.alerted = c.state != CircuitClosed
+	failures := c.failures
+	c.mu.Unlock()
+
+	// The message is the same for both, so that the resolve has the
+	// fingerprint of the alert it resolves.  The name of the circuit is
+	// a value, so that the alerts of circuits differ.
+	switch {
+	case opened:
+		c.notify(&alerter.Alert{
+			Message:  circuitOpenMessage,
+			Severity: alerter.SeverityError,
+			Err:      err,
+		}, "failures", failures)
+	case closed:
+		c.notify(&alerter.Alert{
+			Message:  circuitOpenMessage,
+			Severity: alerter.SeverityInfo,
+			Resolved: true,
+		})
+	}
+	return err
+}
+
+// circuitOpenMessage is the message of the meta-alert of an open circuit.
+const circuitOpenMessage = "circuit open"
+
+func (c *CircuitBreaker) notify(a *alerter.Alert, keysAndValues ...interface{}) {
+	if c.opts.Notify == nil {
+		return
+	}
+	a.Time = c.opts.Clock.Now()
+	a.Values = []interface{}{CircuitKey, c.opts.Name}
+	a.KeysAndValues = keysAndValues
+	_ = alerter.Send(c.opts.Notify, a)
+}
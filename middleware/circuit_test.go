@@ -0,0 +1,146 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	rec, notify := newRecorder(), newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	c := CircuitBreakerSink(rec, CircuitOptions{
+		Name:        "pagerduty",
+		Threshold:   3,
+		OpenTimeout: time.Minute,
+		Notify:      notify,
+		Clock:       clock,
+	})
+	send := func(msg string) error {
+		return alerter.Send(c, &alerter.Alert{Message: msg, Severity: alerter.SeverityError})
+	}
+
+	rec.fail(errTemporary)
+	for i := 0; i < 3; i++ {
+		if err := send("a"); !errors.Is(err, errTemporary) {
+			t.Fatalf("failure %d returned %v", i, err)
+		}
+		if i < 2 && c.State() != CircuitClosed {
+			t.Fatalf("circuit %v after %d failures, want closed", c.State(), i+1)
+		}
+	}
+	if c.State() != CircuitOpen {
+		t.Fatalf("circuit %v after 3 failures, want open", c.State())
+	}
+	alerts := notify.recorded()
+	if len(alerts) != 1 || alerts[0].Resolved || value(alerts[0], "failures") != 3 || value(alerts[0], CircuitKey) != "pagerduty" {
+		t.Fatalf("notified %v when opening", notify.messages())
+	}
+
+	// While open, alerts are rejected without calling the backend.
+	rec.fail(nil)
+	if err := send("b"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("open circuit returned %v", err)
+	}
+	if n := len(rec.recorded()); n != 0 {
+		t.Errorf("open circuit delivered %d alerts", n)
+	}
+
+	// A failed probe opens the circuit again.
+	clock.Advance(time.Minute)
+	if c.State() != CircuitHalfOpen {
+		t.Errorf("circuit %v after OpenTimeout, want half-open", c.State())
+	}
+	rec.fail(errTemporary)
+	if err := send("c"); !errors.Is(err, errTemporary) {
+		t.Errorf("probe returned %v", err)
+	}
+	if c.State() != CircuitOpen || len(notify.recorded()) != 1 {
+		t.Errorf("circuit %v after a failed probe, notified %v", c.State(), notify.messages())
+	}
+
+	// A delivered probe closes it, resolving the meta-alert.
+	clock.Advance(time.Minute)
+	rec.fail(nil)
+	if err := send("d"); err != nil {
+		t.Errorf("probe returned %v", err)
+	}
+	if c.State() != CircuitClosed {
+		t.Errorf("circuit %v after a delivered probe, want closed", c.State())
+	}
+	alerts = notify.recorded()
+	if len(alerts) != 2 || !alerts[1].Resolved || alerts[1].Fingerprint() != alerts[0].Fingerprint() {
+		t.Errorf("notified %v when closing, want the resolve of the open alert", notify.messages())
+	}
+	if err := send("e"); err != nil {
+		t.Errorf("closed circuit returned %v", err)
+	}
+	if got, want := rec.messages(), []string{"d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
+
+func TestCircuitBreakerFingerprints(t *testing.T) {
+	notify := newRecorder()
+	rec := newRecorder()
+	rec.fail(errTemporary)
+	for _, name := range []string{"pagerduty", "slack"} {
+		c := CircuitBreakerSink(rec, CircuitOptions{Name: name, Threshold: 1, Notify: notify})
+		_ = alerter.Send(c, &alerter.Alert{Message: "a", Severity: alerter.SeverityError})
+	}
+	alerts := notify.recorded()
+	if len(alerts) != 2 || alerts[0].Fingerprint() == alerts[1].Fingerprint() {
+		t.Errorf("circuits share the fingerprint of their meta-alerts")
+	}
+}
+
+func TestCircuitBreakerLateSuccess(t *testing.T) {
+	g, notify := newGate(), newRecorder()
+	failing := newRecorder()
+	failing.fail(errTemporary)
+	inner := wrap(failing, func(a *alerter.Alert) error {
+		if a.Message == "slow" {
+			return alerter.Send(g.sink(), a)
+		}
+		return alerter.Send(failing, a)
+	})
+	c := CircuitBreakerSink(inner, CircuitOptions{Threshold: 1, Notify: notify, Clock: alerter.NewManualClock(epoch)})
+	sent := make(chan error, 1)
+	go func() { sent <- alerter.Send(c, &alerter.Alert{Message: "slow", Severity: alerter.SeverityError}) }()
+	<-g.started
+	_ = alerter.Send(c, &alerter.Alert{Message: "a", Severity: alerter.SeverityError})
+	if c.State() != CircuitOpen {
+		t.Fatalf("circuit %v after a failure, want open", c.State())
+	}
+	// A delivery started before the circuit opened succeeds and closes
+	// it, which resolves the meta-alert.
+	g.release()
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if c.State() != CircuitClosed {
+		t.Errorf("circuit %v after a success, want closed", c.State())
+	}
+	if got, want := notify.messages(), []string{circuitOpenMessage, circuitOpenMessage + " resolved"}; !slices.Equal(got, want) {
+		t.Errorf("notified %v, want %v", got, want)
+	}
+}
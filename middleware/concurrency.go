@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+
+	"github.com/sumengzs/alerter"
+)
+
+// ConcurrencyOptions carries parameters for ConcurrencySink.
+type ConcurrencyOptions struct {
+	// MaxInFlight is the number of deliveries to inner which may run at
+	// the same time.  Defaults to 4.
+	MaxInFlight int
+
+	// Metrics, if set, publishes the counters of the sink as an expvar
+	// map of this name, e.g. "alerter.sinks.slack":
+	//
+	//   - in_flight: deliveries currently running
+	//   - waiting: deliveries waiting for a free slot
+	//   - sent: deliveries which succeeded
+	//   - failed: deliveries which returned an error
+	Metrics string
+}
+
+// ConcurrencySink returns a Sink which limits the number of concurrent
+// deliveries to inner.  Callers beyond the limit block until a delivery
+// finishes.  Combined with an asynchronous middleware in front of every sink,
+// this keeps one slow provider from tying up the whole pipeline.  It fails
+// if opts.Metrics names an expvar which is published but not a map.
+func ConcurrencySink(inner alerter.Sink, opts ConcurrencyOptions) (alerter.Sink, error) {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 4
+	}
+	c := &concurrency{inner: inner, slots: make(chan struct{}, opts.MaxInFlight)}
+	if opts.Metrics != "" {
+		var err error
+		if c.metrics, err = metricsMap(opts.Metrics); err != nil {
+			return nil, err
+		}
+	}
+	return wrap(inner, c.send), nil
+}
+
+type concurrency struct {
+	inner   alerter.Sink
+	slots   chan struct{}
+	metrics *expvar.Map
+}
+
+func (c *concurrency) send(a *alerter.Alert) error {
+	c.add("waiting", 1)
+	c.slots <- struct{}{}
+	c.add("waiting", -1)
+	c.add("in_flight", 1)
+	err := alerter.Send(c.inner, a)
+	c.add("in_flight", -1)
+	<-c.slots
+	if err != nil {
+		c.add("failed", 1)
+	} else {
+		c.add("sent", 1)
+	}
+	return err
+}
+
+func (c *concurrency) add(key string, delta int64) {
+	if c.metrics != nil {
+		c.metrics.Add(key, delta)
+	}
+}
+
+var metricsMu sync.Mutex
+
+// metricsMap returns the expvar map of the given name, publishing it if
+// needed.  Sinks created with the same name share their counters.  It fails
+// if a Var other than a map is published under the name, for which
+// expvar.NewMap would panic.
+func metricsMap(name string) (*expvar.Map, error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	switch v := expvar.Get(name).(type) {
+	case nil:
+		return expvar.NewMap(name), nil
+	case *expvar.Map:
+		return v, nil
+	default:
+		return nil, fmt.Errorf("metrics: expvar %q is a %T, not a map", name, v)
+	}
+}
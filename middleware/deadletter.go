@@ -0,0 +1,51 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+
+	"github.com/sumengzs/alerter"
+)
+
+// DeadLetterSink returns a Sink which hands the alerts inner fails to
+// deliver, with the error as RejectionKey, to deadLetter, so that no alert
+// is silently lost once retries and failover are exhausted.  It is placed
+// in front of them:
+//
+//	f, _ := file.NewWriter(file.Options{Path: "/var/lib/app/dead-letter.log"})
+//	sink := middleware.DeadLetterSink(
+//		middleware.RetrySink(middleware.Failover(slack, email), middleware.RetryOptions{}),
+//		file.New(f, 10).GetSink())
+//
+// Alerts kept by deadLetter count as delivered.  Those written with a file
+// Sink can be replayed later with file.ReadAlerts.  If deadLetter fails
+// too, both errors are returned.
+func DeadLetterSink(inner, deadLetter alerter.Sink) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		err := alerter.Send(inner, a)
+		if err == nil {
+			return nil
+		}
+		c := *a
+		c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)], RejectionKey, err.Error())
+		if dlErr := alerter.Send(deadLetter, &c); dlErr != nil {
+			return errors.Join(err, dlErr)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/store"
+)
+
+// OccurrencesKey is the key under which DedupSink attaches the number of
+// times a suppressed alert occurred.
+const OccurrencesKey = "occurrences"
+
+// DedupSink returns a Sink which passes the first alert with a given
+// fingerprint to inner and suppresses its duplicates for window.  When the
+// window closes after duplicates were suppressed, the last of them is sent
+// with the total number of occurrences attached as OccurrencesKey.
+//
+// Resolving an alert closes its window early: the summary of suppressed
+// duplicates, if any, is sent before the resolve, and the next occurrence
+// passes immediately.
+func DedupSink(inner alerter.Sink, window time.Duration) alerter.Sink {
+	return DedupSinkWithOptions(inner, DedupOptions{Window: window})
+}
+
+// DedupOptions carries parameters for DedupSinkWithOptions.
+type DedupOptions struct {
+	// Window is the time the duplicates of an alert are suppressed for.
+	Window time.Duration
+
+	// Clock times the windows.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// DedupSinkWithOptions is like DedupSink, with the window and clock set by
+// opts.
+func DedupSinkWithOptions(inner alerter.Sink, opts DedupOptions) alerter.Sink {
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	d := &dedup{inner: inner, window: opts.Window, clock: opts.Clock, seen: map[string]*dedupEntry{}}
+	return wrap(inner, d.send)
+}
+
+type dedup struct {
+	inner  alerter.Sink
+	window time.Duration
+	clock  alerter.Clock
+
+	mu   sync.Mutex
+	seen map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	count int
+	last  *alerter.Alert
+	timer alerter.Timer
+}
+
+func (d *dedup) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	d.mu.Lock()
+	e := d.seen[fp]
+	if a.Resolved {
+		var summary *alerter.Alert
+		if e != nil {
+			e.timer.Stop()
+			delete(d.seen, fp)
+			summary = e.summary()
+		}
+		d.mu.Unlock()
+		if summary != nil {
+			_ = alerter.Send(d.inner, summary)
+		}
+		return alerter.Send(d.inner, a)
+	}
+	if e != nil {
+		e.count++
+		e.last = a
+		d.mu.Unlock()
+		return nil
+	}
+	e = &dedupEntry{count: 1, last: a}
+	d.seen[fp] = e
+	e.timer = alerter.AfterFunc(d.clock, d.window, func() { d.expire(fp, e) })
+	d.mu.Unlock()
+	return alerter.Send(d.inner, a)
+}
+
+// expire closes the window of e.
+func (d *dedup) expire(fp string, e *dedupEntry) {
+	d.mu.Lock()
+	if d.seen[fp] != e {
+		// Resolved in the meantime.
+		d.mu.Unlock()
+		return
+	}
+	delete(d.seen, fp)
+	summary := e.summary()
+	d.mu.Unlock()
+	if summary != nil {
+		_ = alerter.Send(d.inner, summary)
+	}
+}
+
+// summary returns the alert reporting the suppressed duplicates of e, or
+// nil if there were none.
+func (e *dedupEntry) summary() *alerter.Alert {
+	if e.count <= 1 {
+		return nil
+	}
+	c := *e.last
+	c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)], OccurrencesKey, e.count)
+	return &c
+}
+
+// StoreDedupSink returns a Sink which, like DedupSink, passes the first
+// alert with a given fingerprint to inner and suppresses its duplicates for
+// window, but keeps track of them in s, so that the replicas of a service
+// sharing s deliver every alert once between them:
+//
+//	sink := middleware.StoreDedupSink(inner, store.NewRedis(store.RedisOptions{Addr: "redis:6379"}), 10*time.Minute)
+//
+// Duplicates are not summarized.  If s fails, alerts are delivered, as a
+// duplicate is better than a lost alert, and if delivery fails, the
+// fingerprint is released, so that a retry or another replica can deliver
+// it.  Resolves are passed on and end the suppression early.
+func StoreDedupSink(inner alerter.Sink, s store.Store, window time.Duration) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		ctx := context.Background()
+		key := "alerter/dedup/" + a.Fingerprint()
+		if a.Resolved {
+			_ = s.Delete(ctx, key)
+			return alerter.Send(inner, a)
+		}
+		claimed, err := s.CompareAndSwap(ctx, key, nil, []byte(a.Time.UTC().Format(time.RFC3339Nano)), window)
+		if err == nil && !claimed {
+			return nil
+		}
+		sendErr := alerter.Send(inner, a)
+		if sendErr != nil && claimed {
+			_ = s.Delete(ctx, key)
+		}
+		return sendErr
+	})
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestDedupSuppressesDuplicates(t *testing.T) {
+	rec := newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	log := alerter.New(DedupSinkWithOptions(rec, DedupOptions{Window: time.Minute, Clock: clock}))
+	for i := 0; i < 3; i++ {
+		log.Error(nil, "disk full", "attempt", i)
+	}
+	log.Error(nil, "disk full", "attempt", 3)
+	// Values make an alert of its own.
+	log.WithValues("mount", "/var").Error(nil, "disk full")
+	if got, want := rec.messages(), []string{"disk full", "disk full"}; !slices.Equal(got, want) {
+		t.Fatalf("delivered %v, want %v", got, want)
+	}
+
+	clock.Advance(time.Minute)
+	alerts := rec.recorded()
+	if len(alerts) != 3 {
+		t.Fatalf("delivered %d alerts when the window closed, want 3", len(alerts))
+	}
+	summary := alerts[2]
+	if n := value(summary, OccurrencesKey); n != 4 {
+		t.Errorf("summary counts %v occurrences, want 4", n)
+	}
+	if n := value(summary, "attempt"); n != 3 {
+		t.Errorf("summary is of attempt %v, want the last one", n)
+	}
+	if clock.Len() != 0 {
+		t.Errorf("%d timers left after the windows closed", clock.Len())
+	}
+
+	// A window without duplicates closes silently.
+	clock.Advance(time.Minute)
+	if n := len(rec.recorded()); n != 3 {
+		t.Errorf("delivered %d alerts, want 3", n)
+	}
+
+	log.Error(nil, "disk full")
+	if n := len(rec.recorded()); n != 4 {
+		t.Errorf("alert after the window suppressed")
+	}
+}
+
+func TestDedupResolveClosesWindow(t *testing.T) {
+	rec := newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	log := alerter.New(DedupSinkWithOptions(rec, DedupOptions{Window: time.Hour, Clock: clock}))
+	log.Error(nil, "disk full")
+	log.Error(nil, "disk full")
+	log.Resolve("disk full")
+	log.Error(nil, "disk full")
+	want := []string{"disk full", "disk full", "disk full resolved", "disk full"}
+	if got := rec.messages(); !slices.Equal(got, want) {
+		t.Fatalf("delivered %v, want %v", got, want)
+	}
+	if n := value(rec.recorded()[1], OccurrencesKey); n != 2 {
+		t.Errorf("summary before the resolve counts %v occurrences, want 2", n)
+	}
+	if clock.Len() != 1 {
+		t.Errorf("%d timers, want only the one of the new window", clock.Len())
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// DelayKey sets the time an alert is held back before it is delivered, as a
+// time.Duration or a string such as "15m".
+const DelayKey = "delay"
+
+// DelayOptions carries parameters for DelaySink.
+type DelayOptions struct {
+	// Delay is the delay of alerts without a DelayKey.  Zero delivers
+	// them right away.
+	Delay time.Duration
+
+	// OnError is called with the delayed alerts which could not be
+	// delivered.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock times the delays.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Delayer is a Sink which delivers alerts only after a delay, and not at all
+// if they are resolved in the meantime, for conditions which are worth an
+// alert only if they persist:
+//
+//	a.Error(nil, "replication lag above 30s", middleware.DelayKey, 15*time.Minute)
+//
+// The delay is set per alert with DelayKey or for all alerts with
+// DelayOptions.Delay.  An alert raised again while it is held back replaces
+// the held one without restarting the delay.  Resolves of alerts which are
+// held back drop them and are dropped too; other resolves are passed on.
+//
+// Alerts still held back are delivered by Close.
+type Delayer struct {
+	sink
+
+	inner alerter.Sink
+	opts  DelayOptions
+
+	mu      sync.Mutex
+	delayed map[string]*delayed
+	closed  bool
+}
+
+type delayed struct {
+	alert *alerter.Alert
+	timer alerter.Timer
+}
+
+// DelaySink returns a Delayer delivering to inner.
+func DelaySink(inner alerter.Sink, opts DelayOptions) *Delayer {
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	d := &Delayer{inner: inner, opts: opts, delayed: map[string]*delayed{}}
+	d.sink = wrap(inner, d.send).(sink)
+	return d
+}
+
+func (d *Delayer) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	d.mu.Lock()
+	if held := d.delayed[fp]; held != nil {
+		if a.Resolved {
+			held.timer.Stop()
+			delete(d.delayed, fp)
+		} else {
+			held.alert = a
+		}
+		d.mu.Unlock()
+		return nil
+	}
+	delay := durationOf(a, DelayKey, d.opts.Delay)
+	if a.Resolved || delay <= 0 || d.closed {
+		d.mu.Unlock()
+		return alerter.Send(d.inner, a)
+	}
+	held := &delayed{alert: a}
+	held.timer = alerter.AfterFunc(d.opts.Clock, delay, func() { d.deliver(fp, held) })
+	d.delayed[fp] = held
+	d.mu.Unlock()
+	return nil
+}
+
+// deliver delivers the alert held, unless it was resolved since.
+func (d *Delayer) deliver(fp string, held *delayed) {
+	d.mu.Lock()
+	if d.delayed[fp] != held {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.delayed, fp)
+	a := held.alert
+	d.mu.Unlock()
+	if err := alerter.Send(d.inner, a); err != nil && d.opts.OnError != nil {
+		d.opts.OnError(a, err)
+	}
+}
+
+// Cancel drops the alert with the given fingerprint if it is held back, and
+// reports whether it was.
+func (d *Delayer) Cancel(fingerprint string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	held := d.delayed[fingerprint]
+	if held == nil {
+		return false
+	}
+	held.timer.Stop()
+	delete(d.delayed, fingerprint)
+	return true
+}
+
+// Len returns the number of alerts held back.
+func (d *Delayer) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.delayed)
+}
+
+// Close delivers the alerts still held back right away.  Alerts raised
+// afterwards are delivered without delay.
+func (d *Delayer) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	var alerts []*alerter.Alert
+	for fp, held := range d.delayed {
+		held.timer.Stop()
+		alerts = append(alerts, held.alert)
+		delete(d.delayed, fp)
+	}
+	d.mu.Unlock()
+	var errs []error
+	for _, a := range alerts {
+		if err := alerter.Send(d.inner, a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
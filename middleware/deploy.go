@@ -0,0 +1,275 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/expr"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// DeployKey is the key under which a DeploySuppressor attaches the service
+// whose deployment held back an alert it delivers late.
+const DeployKey = "deploy"
+
+// DeployOptions carries parameters which influence the way a
+// DeploySuppressor suppresses alerts during deployments.
+type DeployOptions struct {
+	// ServiceKey is the label, as seen by matchers.Labels, naming the
+	// service of an alert.  Defaults to "service".
+	ServiceKey string
+
+	// Grace is the time after a deployment finished during which alerts
+	// are still suppressed, while the service settles.  Defaults to 10
+	// minutes.
+	Grace time.Duration
+
+	// MaxDuration ends deployments which are not reported as finished
+	// after this time.  Defaults to one hour.
+	MaxDuration time.Duration
+
+	// Condition, if set, selects the alerts which are suppressed, e.g.
+	// expr.MustCompileCondition(`alert.labels['kind'] in ['latency', 'errors']`).
+	Condition *expr.Condition
+
+	// MinSeverity, if set, points to the lowest severity which is never
+	// suppressed, so that any severity including alerter.SeverityInfo can
+	// be chosen.  Defaults to alerter.SeverityCritical.
+	MinSeverity *alerter.Severity
+
+	// Discard drops suppressed alerts instead of delivering those still
+	// firing once the deployment and its grace period are over.
+	Discard bool
+
+	// Token, if set, is the bearer token webhook requests must carry.
+	Token string
+
+	// OnError is called with the alerts delivered late which could not
+	// be delivered.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock times the grace periods and MaxDuration.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// DeploySuppressor is a Sink which holds back the alerts of a service while
+// it is being deployed and for a grace period after, when restarts and
+// cold caches are expected to cause noise.  Deployments are reported with
+// Started and Finished, or by posting to the DeploySuppressor as an
+// http.Handler:
+//
+//	deploys := middleware.DeploySuppressionSink(inner, middleware.DeployOptions{})
+//	http.Handle("/deploys", deploys)
+//	...
+//	curl -d '{"service": "checkout", "status": "started"}' http://.../deploys
+//
+// Alerts which are still firing when the suppression ends are delivered
+// then, carrying DeployKey, unless DeployOptions.Discard is set, so that
+// deployments which really broke a service are not hidden.  Resolves are
+// always passed on.
+type DeploySuppressor struct {
+	sink
+
+	inner alerter.Sink
+	opts  DeployOptions
+	// minSeverity is DeployOptions.MinSeverity or its default.
+	minSeverity alerter.Severity
+
+	mu      sync.Mutex
+	deploys map[string]*deploy
+}
+
+// deploy is the state of a deployment of a service.
+type deploy struct {
+	service string
+	// held are the suppressed alerts by fingerprint.
+	held map[string]*alerter.Alert
+	// timer ends the suppression; gen tells the timers apart.
+	timer alerter.Timer
+	gen   int
+}
+
+// DeploySuppressionSink returns a DeploySuppressor delivering to inner.
+func DeploySuppressionSink(inner alerter.Sink, opts DeployOptions) *DeploySuppressor {
+	if opts.ServiceKey == "" {
+		opts.ServiceKey = "service"
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = 10 * time.Minute
+	}
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	d := &DeploySuppressor{inner: inner, opts: opts, minSeverity: alerter.SeverityCritical, deploys: map[string]*deploy{}}
+	if opts.MinSeverity != nil {
+		d.minSeverity = *opts.MinSeverity
+	}
+	d.sink = wrap(inner, d.send).(sink)
+	return d
+}
+
+func (d *DeploySuppressor) send(a *alerter.Alert) error {
+	service := matchers.Labels(a)[d.opts.ServiceKey]
+	d.mu.Lock()
+	dep := d.deploys[service]
+	if service == "" || dep == nil || a.Severity >= d.minSeverity {
+		d.mu.Unlock()
+		return alerter.Send(d.inner, a)
+	}
+	fp := a.Fingerprint()
+	if a.Resolved {
+		delete(dep.held, fp)
+		d.mu.Unlock()
+		return alerter.Send(d.inner, a)
+	}
+	if d.opts.Condition != nil && !d.opts.Condition.Match(a) {
+		d.mu.Unlock()
+		return alerter.Send(d.inner, a)
+	}
+	if !d.opts.Discard {
+		dep.held[fp] = a
+	}
+	d.mu.Unlock()
+	return nil
+}
+
+// Started reports that a deployment of service started.  Its alerts are
+// suppressed until it finished and the grace period is over, or at most
+// for DeployOptions.MaxDuration.
+func (d *DeploySuppressor) Started(service string) {
+	d.extend(service, d.opts.MaxDuration)
+}
+
+// Finished reports that a deployment of service finished.  Its alerts are
+// suppressed for the grace period after.
+func (d *DeploySuppressor) Finished(service string) {
+	d.extend(service, d.opts.Grace)
+}
+
+// extend sets the suppression of service to end once after has passed.
+func (d *DeploySuppressor) extend(service string, after time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dep := d.deploys[service]
+	if dep == nil {
+		dep = &deploy{service: service, held: map[string]*alerter.Alert{}}
+		d.deploys[service] = dep
+	} else {
+		dep.timer.Stop()
+	}
+	dep.gen++
+	gen := dep.gen
+	dep.timer = alerter.AfterFunc(d.opts.Clock, after, func() { d.end(dep, gen) })
+}
+
+// Deploying reports whether the alerts of service are suppressed.
+func (d *DeploySuppressor) Deploying(service string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.deploys[service] != nil
+}
+
+// end ends the suppression of dep and delivers the alerts still held.
+func (d *DeploySuppressor) end(dep *deploy, gen int) {
+	d.mu.Lock()
+	if d.deploys[dep.service] != dep || dep.gen != gen {
+		d.mu.Unlock()
+		return
+	}
+	delete(d.deploys, dep.service)
+	d.mu.Unlock()
+	d.deliver(dep)
+}
+
+func (d *DeploySuppressor) deliver(dep *deploy) {
+	for _, a := range dep.held {
+		c := *a
+		c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)], DeployKey, dep.service)
+		if err := alerter.Send(d.inner, &c); err != nil && d.opts.OnError != nil {
+			d.opts.OnError(&c, err)
+		}
+	}
+}
+
+// Close ends all suppressions, delivering the alerts still held.
+func (d *DeploySuppressor) Close() error {
+	d.mu.Lock()
+	deploys := d.deploys
+	d.deploys = map[string]*deploy{}
+	for _, dep := range deploys {
+		dep.timer.Stop()
+	}
+	d.mu.Unlock()
+	for _, dep := range deploys {
+		d.deliver(dep)
+	}
+	return nil
+}
+
+// deployEvent is the body of deployment webhooks.
+type deployEvent struct {
+	Service string `json:"service"`
+	Status  string `json:"status"`
+}
+
+// ServeHTTP implements http.Handler for deployment webhooks, which post
+// {"service": "...", "status": "started"} or "finished".
+func (d *DeploySuppressor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if d.opts.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(d.opts.Token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+	}
+	var ev deployEvent
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<16)).Decode(&ev); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ev.Service == "" {
+		http.Error(w, "missing service", http.StatusBadRequest)
+		return
+	}
+	switch ev.Status {
+	case "started":
+		d.Started(ev.Service)
+	case "finished":
+		d.Finished(ev.Service)
+	default:
+		http.Error(w, fmt.Sprintf("unknown status %q", ev.Status), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
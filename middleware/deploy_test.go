@@ -0,0 +1,50 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestDeployMinSeverity(t *testing.T) {
+	for i, tc := range []struct {
+		min  *alerter.Severity
+		want []string
+	}{
+		{nil, []string{"critical"}},
+		{ptr(alerter.SeverityWarning), []string{"warning", "critical"}},
+		{ptr(alerter.SeverityInfo), []string{"info", "warning", "critical"}},
+	} {
+		rec := newRecorder()
+		d := DeploySuppressionSink(rec, DeployOptions{MinSeverity: tc.min, Discard: true, Clock: alerter.NewManualClock(epoch)})
+		d.Started("checkout")
+		log := alerter.New(d).WithValues("service", "checkout")
+		log.Info("info")
+		log.WithSeverity(alerter.SeverityWarning).Info("warning")
+		log.WithSeverity(alerter.SeverityCritical).Error(nil, "critical")
+		if got := rec.messages(); !slices.Equal(got, tc.want) {
+			t.Errorf("case %d: delivered %v, want %v", i, got, tc.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// DigestOptions carries parameters which influence the way a Digester
+// summarizes alerts.
+type DigestOptions struct {
+	// Below is the severity from which alerts are passed on in real
+	// time.  Alerts of lower severity go into the digest.  Defaults to
+	// alerter.SeverityError.
+	Below alerter.Severity
+
+	// Interval is the period a digest covers, e.g. an hour or a day.
+	// Defaults to one hour.
+	Interval time.Duration
+
+	// Top is the number of most frequent alerts listed in a digest.
+	// Defaults to 10.
+	Top int
+
+	// Channel receives the digests.  Defaults to the Sink the Digester
+	// wraps.
+	Channel alerter.Sink
+
+	// Clock stamps the digests and times Interval.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// DigestEntry is one of the most frequent alerts of a digest.
+type DigestEntry struct {
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// Keys of the key/value pairs of a digest.
+const (
+	DigestCountKey      = "count"
+	DigestSeveritiesKey = "severities"
+	DigestNamesKey      = "names"
+	DigestTopKey        = "top"
+)
+
+// Digester is a Sink which keeps low-severity alerts out of real-time
+// channels and instead sends a periodic digest of them: a single info alert
+// with their total count, counts per severity and per name, and the most
+// frequent alerts.  Resolves of alerts which went into a digest are
+// dropped; all other resolves are passed on, so that alerts delivered in
+// real time are cleared downstream.
+type Digester struct {
+	sink
+
+	inner alerter.Sink
+	opts  DigestOptions
+
+	mu       sync.Mutex
+	start    time.Time
+	count    int
+	severity map[string]int
+	names    map[string]int
+	entries  map[string]*DigestEntry
+
+	// folded are the fingerprints of the alerts which went into a
+	// digest, with the time they were last seen.
+	folded    map[string]time.Time
+	nextSweep int
+
+	timer  alerter.Timer
+	closed bool
+}
+
+// DigestSink returns a Digester passing important alerts on to inner.  Close
+// must be called to stop it, which also sends the current digest.
+func DigestSink(inner alerter.Sink, opts DigestOptions) *Digester {
+	if opts.Below == 0 {
+		opts.Below = alerter.SeverityError
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.Top <= 0 {
+		opts.Top = 10
+	}
+	if opts.Channel == nil {
+		opts.Channel = inner
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	d := &Digester{inner: inner, opts: opts, folded: map[string]time.Time{}, nextSweep: 1024}
+	d.sink = wrap(inner, d.send).(sink)
+	d.mu.Lock()
+	d.reset()
+	d.timer = alerter.AfterFunc(opts.Clock, opts.Interval, d.tick)
+	d.mu.Unlock()
+	return d
+}
+
+func (d *Digester) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	d.mu.Lock()
+	if a.Resolved {
+		// Resolves carry no severity of their own, so they are told apart
+		// by the alerts they resolve.
+		_, folded := d.folded[fp]
+		delete(d.folded, fp)
+		d.mu.Unlock()
+		if folded {
+			return nil
+		}
+		return alerter.Send(d.inner, a)
+	}
+	if a.Severity >= d.opts.Below {
+		delete(d.folded, fp)
+		d.mu.Unlock()
+		return alerter.Send(d.inner, a)
+	}
+	defer d.mu.Unlock()
+	now := d.opts.Clock.Now()
+	d.sweep(now)
+	d.folded[fp] = now
+	d.count++
+	d.severity[a.Severity.String()]++
+	d.names[a.Name]++
+	e := d.entries[fp]
+	if e == nil {
+		e = &DigestEntry{Name: a.Name, Message: a.Message}
+		d.entries[fp] = e
+	}
+	e.Count++
+	return nil
+}
+
+// tick sends the digest every interval.
+func (d *Digester) tick() {
+	_ = d.Flush()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.timer.Reset(d.opts.Interval)
+	}
+}
+
+// Flush sends the digest of the alerts collected so far, if there are any,
+// and starts a new one.
+func (d *Digester) Flush() error {
+	d.mu.Lock()
+	start, count, severity, names, entries := d.start, d.count, d.severity, d.names, d.entries
+	d.reset()
+	d.mu.Unlock()
+	if count == 0 {
+		return nil
+	}
+
+	top := make([]DigestEntry, 0, len(entries))
+	for _, e := range entries {
+		top = append(top, *e)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Name+top[i].Message < top[j].Name+top[j].Message
+	})
+	if len(top) > d.opts.Top {
+		top = top[:d.opts.Top]
+	}
+
+	now := d.opts.Clock.Now()
+	return alerter.Send(d.opts.Channel, &alerter.Alert{
+		Time:     now,
+		Message:  fmt.Sprintf("digest of %d alerts since %s", count, start.Format(time.RFC3339)),
+		Severity: alerter.SeverityInfo,
+		KeysAndValues: []interface{}{
+			DigestCountKey, count,
+			DigestSeveritiesKey, severity,
+			DigestNamesKey, names,
+			DigestTopKey, top,
+		},
+	})
+}
+
+// Close stops the Digester and sends the current digest.
+func (d *Digester) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	d.timer.Stop()
+	d.mu.Unlock()
+	return d.Flush()
+}
+
+// sweep forgets the alerts which went into a digest but were not seen for a
+// day, or for two intervals if they are longer, once there are many of
+// them.  Resolves of forgotten alerts are passed on.  It must be called with
+// d.mu held.
+func (d *Digester) sweep(now time.Time) {
+	if len(d.folded) < d.nextSweep {
+		return
+	}
+	retention := max(24*time.Hour, 2*d.opts.Interval)
+	for fp, seen := range d.folded {
+		if now.Sub(seen) >= retention {
+			delete(d.folded, fp)
+		}
+	}
+	d.nextSweep = max(1024, 2*len(d.folded))
+}
+
+// reset starts a new digest.  It must be called with d.mu held.
+func (d *Digester) reset() {
+	d.start = d.opts.Clock.Now()
+	d.count = 0
+	d.severity = map[string]int{}
+	d.names = map[string]int{}
+	d.entries = map[string]*DigestEntry{}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestDigest(t *testing.T) {
+	rec := newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	d := DigestSink(rec, DigestOptions{Interval: time.Hour, Top: 2, Clock: clock})
+	log := alerter.New(d)
+	log.Info("cache miss")
+	log.Info("cache miss")
+	log.WithName("gc").Info("slow pause")
+	_ = alerter.Send(d, &alerter.Alert{Message: "high latency", Severity: alerter.SeverityWarning})
+	log.Error(nil, "disk full")
+	if got, want := rec.messages(), []string{"disk full"}; !slices.Equal(got, want) {
+		t.Fatalf("delivered %v in real time, want %v", got, want)
+	}
+
+	clock.Advance(time.Hour)
+	alerts := rec.recorded()
+	if len(alerts) != 2 {
+		t.Fatalf("delivered %d alerts after an interval, want 2", len(alerts))
+	}
+	digest := alerts[1]
+	if digest.Severity != alerter.SeverityInfo || !digest.Time.Equal(epoch.Add(time.Hour)) {
+		t.Errorf("digest has severity %v and time %v", digest.Severity, digest.Time)
+	}
+	if n := value(digest, DigestCountKey); n != 4 {
+		t.Errorf("digest counts %v alerts, want 4", n)
+	}
+	if got, want := value(digest, DigestSeveritiesKey), map[string]int{"info": 3, "warning": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("digest counts severities %v, want %v", got, want)
+	}
+	if got, want := value(digest, DigestNamesKey), map[string]int{"": 3, "gc": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("digest counts names %v, want %v", got, want)
+	}
+	top := []DigestEntry{{Message: "cache miss", Count: 2}, {Name: "gc", Message: "slow pause", Count: 1}}
+	if got := value(digest, DigestTopKey); !reflect.DeepEqual(got, top) {
+		t.Errorf("digest lists %v, want %v", got, top)
+	}
+
+	// An interval without alerts sends no digest.
+	clock.Advance(time.Hour)
+	if n := len(rec.recorded()); n != 2 {
+		t.Errorf("delivered %d alerts after an empty interval, want 2", n)
+	}
+
+	// Resolves of digested alerts are dropped, the others passed on.
+	log.Resolve("cache miss")
+	log.Resolve("disk full")
+	log.Resolve("unknown")
+	if got, want := rec.messages()[2:], []string{"disk full resolved", "unknown resolved"}; !slices.Equal(got, want) {
+		t.Errorf("delivered resolves %v, want %v", got, want)
+	}
+
+	log.Info("cache miss")
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	alerts = rec.recorded()
+	if n := len(alerts); n != 5 || value(alerts[4], DigestCountKey) != 1 {
+		t.Errorf("Close did not send the current digest: %v", rec.messages())
+	}
+	if clock.Len() != 0 {
+		t.Errorf("%d timers left after Close", clock.Len())
+	}
+}
+
+func TestDigestChannel(t *testing.T) {
+	rec, channel := newRecorder(), newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	d := DigestSink(rec, DigestOptions{Below: alerter.SeverityWarning, Channel: channel, Clock: clock})
+	_ = alerter.Send(d, &alerter.Alert{Message: "high latency", Severity: alerter.SeverityWarning})
+	alerter.New(d).Info("cache miss")
+	clock.Advance(time.Hour)
+	if got, want := rec.messages(), []string{"high latency"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v in real time, want %v", got, want)
+	}
+	if alerts := channel.recorded(); len(alerts) != 1 || value(alerts[0], DigestCountKey) != 1 {
+		t.Errorf("channel received %v, want a digest of one alert", channel.messages())
+	}
+	_ = d.Close()
+}
@@ -0,0 +1,668 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// DiskQueueOptions carries parameters which influence the way a DiskQueue
+// stores and delivers alerts.
+type DiskQueueOptions struct {
+	// Path is the file of the write-ahead log.  Its directory is created
+	// if it does not exist.
+	Path string
+
+	// NoSync skips syncing the log to disk after every record.  Alerts
+	// still survive crashes of the process, but not necessarily of the
+	// machine.
+	NoSync bool
+
+	// RetryInterval is the wait before retrying an alert whose delivery
+	// failed with a retryable error.  Defaults to 10 seconds.
+	RetryInterval time.Duration
+
+	// Retryable classifies errors.  Alerts failing with an error which is
+	// not retryable are dropped from the queue.  Defaults to IsRetryable.
+	Retryable func(err error) bool
+
+	// OnError is called with every failed delivery.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock times the waits before retries.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// DiskQueue is a Sink which appends every alert to a write-ahead log on disk
+// before delivering it to the inner Sink, in order, by a single worker, so
+// that alerts survive crashes and restarts of the process: an alert counts
+// as delivered once the inner Sink accepted it, and alerts which were not
+// delivered are delivered again when the queue is opened next time.
+//
+// Alerts are delivered at least once; the inner Sink may see an alert again
+// after a crash.  They are replayed with their values decoded from JSON,
+// i.e. numbers as json.Number and other values as what they encode to.
+// Failed deliveries are retried until they succeed, so that a permanently
+// failing alert holds up the queue unless its error is not retryable, see
+// PoisonSink.
+//
+// The log is compacted when it is opened and as alerts are delivered.  A log
+// with corrupt records is moved aside first, see QueueCorruptError.
+type DiskQueue struct {
+	sink
+
+	inner alerter.Sink
+	opts  DiskQueueOptions
+
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	seq     uint64
+	pending []queued
+	acked   int
+	closed  bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// queued is an alert waiting for delivery.
+type queued struct {
+	seq   uint64
+	alert *alerter.Alert
+}
+
+// queueCompaction is the number of delivered alerts after which the log is
+// compacted once the queue is empty.
+const queueCompaction = 1024
+
+// DiskQueueSink opens the queue at opts.Path, which is created if it does
+// not exist, and returns a DiskQueue delivering to inner, starting with the
+// alerts which were not delivered before.  Close must be called to stop
+// delivery.
+func DiskQueueSink(inner alerter.Sink, opts DiskQueueOptions) (*DiskQueue, error) {
+	if opts.Path == "" {
+		return nil, errors.New("disk queue: empty path")
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 10 * time.Second
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	if err := os.MkdirAll(filepath.Dir(opts.Path), 0o755); err != nil {
+		return nil, err
+	}
+	pending, seq, _, err := readQueue(opts.Path)
+	var corrupt *QueueCorruptError
+	if errors.As(err, &corrupt) {
+		if corrupt.MovedTo, err = moveAside(opts.Path); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	q := &DiskQueue{
+		inner:   inner,
+		opts:    opts,
+		seq:     seq,
+		pending: pending,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	if corrupt != nil && opts.OnError != nil {
+		opts.OnError(nil, corrupt)
+	}
+	q.sink = wrap(inner, q.send).(sink)
+	go q.work()
+	return q, nil
+}
+
+// ReadDiskQueue returns the alerts of the queue at path which were not
+// delivered, without opening it for delivery, e.g. to inspect it.  If the
+// log is corrupt, it returns the alerts of its valid records along with a
+// *QueueCorruptError.
+func ReadDiskQueue(path string) ([]*alerter.Alert, error) {
+	entries, err := ListDiskQueue(path)
+	alerts := make([]*alerter.Alert, len(entries))
+	for i, e := range entries {
+		alerts[i] = e.Alert
+	}
+	return alerts, err
+}
+
+// QueueEntry is an alert of a DiskQueue which was not delivered.
+type QueueEntry struct {
+	// Seq is the sequence number of the alert, which identifies it in
+	// the queue.
+	Seq   uint64
+	Alert *alerter.Alert
+}
+
+// ListDiskQueue is like ReadDiskQueue, but returns the alerts with their
+// sequence numbers, for DropFromDiskQueue and RequeueInDiskQueue.
+func ListDiskQueue(path string) ([]QueueEntry, error) {
+	pending, _, _, err := readQueue(path)
+	var corrupt *QueueCorruptError
+	if err != nil && !errors.As(err, &corrupt) {
+		return nil, err
+	}
+	entries := make([]QueueEntry, len(pending))
+	for i, p := range pending {
+		entries[i] = QueueEntry{Seq: p.seq, Alert: p.alert}
+	}
+	return entries, err
+}
+
+// DropFromDiskQueue removes the alerts with the given sequence numbers from
+// the queue at path without delivering them, e.g. alerts which no Sink
+// accepts and hold up the queue.  It fails without changing the queue if
+// any of them is not waiting for delivery, or if the log is corrupt; opening
+// the queue with DiskQueueSink repairs it.
+//
+// The queue must not be open: DropFromDiskQueue appends to the log, which
+// a running DiskQueue would not see and could overwrite when compacting.
+func DropFromDiskQueue(path string, seqs ...uint64) error {
+	return editDiskQueue(path, seqs, false)
+}
+
+// RequeueInDiskQueue moves the alerts with the given sequence numbers to the
+// end of the queue at path, in the given order, with new sequence numbers,
+// so that the alerts after them are delivered first.  It fails and must be
+// called like DropFromDiskQueue.
+func RequeueInDiskQueue(path string, seqs ...uint64) error {
+	return editDiskQueue(path, seqs, true)
+}
+
+// editDiskQueue acknowledges the alerts with the given sequence numbers in
+// the log at path, and appends them again if requeue is set.
+func editDiskQueue(path string, seqs []uint64, requeue bool) error {
+	pending, seq, end, err := readQueue(path)
+	if err != nil {
+		return err
+	}
+	alerts := make(map[uint64]*alerter.Alert, len(pending))
+	for _, p := range pending {
+		alerts[p.seq] = p.alert
+	}
+	var recs []queueRecord
+	for _, s := range seqs {
+		a, ok := alerts[s]
+		if !ok {
+			return fmt.Errorf("disk queue: %s: no alert %d waiting for delivery", path, s)
+		}
+		delete(alerts, s)
+		recs = append(recs, queueRecord{Seq: s, Ack: true})
+		if requeue {
+			seq++
+			recs = append(recs, queueRecord{Seq: seq, Alert: encodeQueued(a)})
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// Drop a torn write at the end, which would turn into corruption in
+	// the middle of the log.
+	err = f.Truncate(int64(end))
+	if err == nil {
+		_, err = f.Seek(int64(end), io.SeekStart)
+	}
+	w := bufio.NewWriter(f)
+	for _, rec := range recs {
+		if err != nil {
+			break
+		}
+		err = writeRecord(w, rec)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	return nil
+}
+
+func (q *DiskQueue) send(a *alerter.Alert) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return alerter.Send(q.inner, a)
+	}
+	q.seq++
+	rec := queueRecord{Seq: q.seq, Alert: encodeQueued(a)}
+	if err := q.append(rec); err != nil {
+		q.mu.Unlock()
+		return fmt.Errorf("disk queue: %w", err)
+	}
+	q.pending = append(q.pending, queued{seq: q.seq, alert: a})
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// append writes rec to the log.  It must be called with q.mu held.
+func (q *DiskQueue) append(rec queueRecord) error {
+	if err := writeRecord(q.w, rec); err != nil {
+		return err
+	}
+	if err := q.w.Flush(); err != nil {
+		return err
+	}
+	if q.opts.NoSync {
+		return nil
+	}
+	return q.file.Sync()
+}
+
+func (q *DiskQueue) work() {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.mu.Unlock()
+			select {
+			case <-q.wake:
+				continue
+			case <-q.stop:
+				return
+			}
+		}
+		head := q.pending[0]
+		q.mu.Unlock()
+
+		err := alerter.Send(q.inner, head.alert)
+		if err != nil && q.opts.OnError != nil {
+			q.opts.OnError(head.alert, err)
+		}
+		if err != nil && q.opts.Retryable(err) {
+			retry := make(chan struct{})
+			timer := alerter.AfterFunc(q.opts.Clock, q.opts.RetryInterval, func() { close(retry) })
+			select {
+			case <-retry:
+				continue
+			case <-q.stop:
+				timer.Stop()
+				return
+			}
+		}
+		q.ack(head.seq)
+	}
+}
+
+// ack removes the alert with sequence number seq from the queue.
+func (q *DiskQueue) ack(seq uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.pending = q.pending[1:]
+	if err := q.append(queueRecord{Seq: seq, Ack: true}); err != nil && q.opts.OnError != nil {
+		// The alert is delivered again after a restart.
+		q.opts.OnError(nil, fmt.Errorf("disk queue: %w", err))
+	}
+	q.acked++
+	if q.acked >= queueCompaction && len(q.pending) == 0 {
+		if err := q.compact(); err != nil && q.opts.OnError != nil {
+			q.opts.OnError(nil, fmt.Errorf("disk queue: %w", err))
+		}
+	}
+}
+
+// Len returns the number of alerts waiting for delivery.
+func (q *DiskQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Close stops delivery after the current attempt and closes the log.  The
+// alerts still waiting are delivered when the queue is opened again;
+// alerts raised afterwards are delivered synchronously.
+func (q *DiskQueue) Close() error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.mu.Unlock()
+	close(q.stop)
+	<-q.done
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.file.Close()
+}
+
+// compact rewrites the log with the pending alerts only.  It must be called
+// with q.mu held, or before the worker started.
+func (q *DiskQueue) compact() error {
+	tmp := q.opts.Path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, p := range q.pending {
+		if err = writeRecord(w, queueRecord{Seq: p.seq, Alert: encodeQueued(p.alert)}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, q.opts.Path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if q.file != nil {
+		q.file.Close()
+	}
+	if q.file, err = os.OpenFile(q.opts.Path, os.O_APPEND|os.O_WRONLY, 0o644); err != nil {
+		return err
+	}
+	q.w = bufio.NewWriter(q.file)
+	q.acked = 0
+	return nil
+}
+
+// The log is a sequence of records, each made of the length of its payload
+// and the CRC-32C of the payload, both as little-endian uint32, and the
+// payload, a queueRecord as JSON.  A record either adds an alert or
+// acknowledges the alert with its sequence number.
+var queueCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// maxQueueRecord bounds the payload of a record, so that a corrupt length
+// does not make readQueue allocate huge buffers.
+const maxQueueRecord = 64 << 20
+
+type queueRecord struct {
+	Seq   uint64       `json:"seq"`
+	Ack   bool         `json:"ack,omitempty"`
+	Alert *queuedAlert `json:"alert,omitempty"`
+}
+
+// queuedAlert is the encoding of an alert in the log.
+type queuedAlert struct {
+	Time          time.Time         `json:"time"`
+	Name          string            `json:"name,omitempty"`
+	Level         int               `json:"level,omitempty"`
+	Message       string            `json:"msg"`
+	Err           *string           `json:"error,omitempty"`
+	Severity      alerter.Severity  `json:"severity"`
+	Resolved      bool              `json:"resolved,omitempty"`
+	Values        []json.RawMessage `json:"values,omitempty"`
+	KeysAndValues []json.RawMessage `json:"kvs,omitempty"`
+}
+
+func writeRecord(w io.Writer, rec queueRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.Checksum(payload, queueCRC))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// QueueCorruptError reports corrupt records in the middle of the log of a
+// DiskQueue, which were skipped.  DiskQueueSink moves such a log aside
+// before compacting it and passes the error to DiskQueueOptions.OnError.
+type QueueCorruptError struct {
+	// Path is the log.
+	Path string
+
+	// MovedTo is the file the log was moved to, if it was.
+	MovedTo string
+
+	// Offset is the offset of the first corrupt record.
+	Offset int64
+
+	// Skipped is the number of bytes skipped.
+	Skipped int64
+}
+
+func (e *QueueCorruptError) Error() string {
+	msg := fmt.Sprintf("disk queue: %s: skipped %d corrupt bytes from offset %d", e.Path, e.Skipped, e.Offset)
+	if e.MovedTo != "" {
+		msg += ", original kept as " + e.MovedTo
+	}
+	return msg
+}
+
+// readQueue returns the alerts of the log at path which were not
+// acknowledged, the highest sequence number and the offset where the last
+// valid record ends.  A corrupt or incomplete
+// record at the end of the log is where a crash interrupted a write, and is
+// ignored.  Corrupt records followed by valid ones are skipped, returning
+// the alerts of all valid records with a *QueueCorruptError.
+func readQueue(path string) ([]queued, uint64, int, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, 0, nil
+	}
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	var (
+		pending []queued
+		index   = map[uint64]int{}
+		seq     uint64
+		end     int
+		corrupt *QueueCorruptError
+	)
+	for off := 0; off < len(data); {
+		rec, n, ok := parseRecord(data[off:])
+		if !ok {
+			next := off + 1
+			for next < len(data) {
+				if _, _, ok := parseRecord(data[next:]); ok {
+					break
+				}
+				next++
+			}
+			if next == len(data) {
+				// Nothing valid follows: a torn write.
+				break
+			}
+			if corrupt == nil {
+				corrupt = &QueueCorruptError{Path: path, Offset: int64(off)}
+			}
+			corrupt.Skipped += int64(next - off)
+			off = next
+			continue
+		}
+		off += n
+		end = off
+		if rec.Seq > seq {
+			seq = rec.Seq
+		}
+		switch {
+		case rec.Ack:
+			if i, ok := index[rec.Seq]; ok {
+				pending[i].alert = nil
+			}
+		case rec.Alert != nil:
+			index[rec.Seq] = len(pending)
+			pending = append(pending, queued{seq: rec.Seq, alert: rec.Alert.decode()})
+		}
+	}
+	out := pending[:0]
+	for _, p := range pending {
+		if p.alert != nil {
+			out = append(out, p)
+		}
+	}
+	if corrupt != nil {
+		return out, seq, end, corrupt
+	}
+	return out, seq, end, nil
+}
+
+// parseRecord parses the record at the start of data and returns it with
+// its length, or false if there is no valid record.
+func parseRecord(data []byte) (queueRecord, int, bool) {
+	var rec queueRecord
+	if len(data) < 8 {
+		return rec, 0, false
+	}
+	n := binary.LittleEndian.Uint32(data[:4])
+	if n > maxQueueRecord || int(n) > len(data)-8 {
+		return rec, 0, false
+	}
+	payload := data[8 : 8+n]
+	if crc32.Checksum(payload, queueCRC) != binary.LittleEndian.Uint32(data[4:8]) {
+		return rec, 0, false
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil || rec.Seq == 0 || !rec.Ack && rec.Alert == nil {
+		return rec, 0, false
+	}
+	return rec, 8 + int(n), true
+}
+
+// moveAside renames the log at path to a free name ending in ".corrupt",
+// so that compacting does not destroy what could not be read.
+func moveAside(path string) (string, error) {
+	target := path + ".corrupt"
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		target = fmt.Sprintf("%s.corrupt.%d", path, i)
+	}
+	return target, os.Rename(path, target)
+}
+
+func encodeQueued(a *alerter.Alert) *queuedAlert {
+	q := &queuedAlert{
+		Time:          a.Time,
+		Name:          a.Name,
+		Level:         a.Level,
+		Message:       a.Message,
+		Severity:      a.Severity,
+		Resolved:      a.Resolved,
+		Values:        encodeQueuedValues(a.Values),
+		KeysAndValues: encodeQueuedValues(a.KeysAndValues),
+	}
+	if a.Err != nil {
+		text := a.Err.Error()
+		q.Err = &text
+	}
+	return q
+}
+
+// encodeQueuedValues encodes keys and values as JSON, errors and values
+// implementing fmt.Stringer but not json.Marshaler as their text.
+func encodeQueuedValues(kvs []interface{}) []json.RawMessage {
+	if len(kvs) == 0 {
+		return nil
+	}
+	out := make([]json.RawMessage, len(kvs))
+	for i, v := range kvs {
+		switch x := v.(type) {
+		case error:
+			v = x.Error()
+		case json.Marshaler, json.Number:
+		case fmt.Stringer:
+			v = x.String()
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			data, _ = json.Marshal(fmt.Sprintf("%+v", v))
+		}
+		out[i] = data
+	}
+	return out
+}
+
+func (q *queuedAlert) decode() *alerter.Alert {
+	a := &alerter.Alert{
+		Time:          q.Time,
+		Name:          q.Name,
+		Level:         q.Level,
+		Message:       q.Message,
+		Severity:      q.Severity,
+		Resolved:      q.Resolved,
+		Values:        decodeQueuedValues(q.Values),
+		KeysAndValues: decodeQueuedValues(q.KeysAndValues),
+	}
+	if q.Err != nil {
+		a.Err = errors.New(*q.Err)
+	}
+	return a
+}
+
+func decodeQueuedValues(raw []json.RawMessage) []interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	out := make([]interface{}, len(raw))
+	for i, data := range raw {
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			v = string(data)
+		}
+		out[i] = v
+	}
+	return out
+}
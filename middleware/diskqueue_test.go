@@ -0,0 +1,220 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// fillDiskQueue returns the path of a queue holding alerts with the given
+// messages, which were not delivered.
+func fillDiskQueue(t *testing.T, msgs ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "queue", "alerts.log")
+	rec := newRecorder()
+	rec.fail(errTemporary)
+	failed := make(chan struct{}, len(msgs))
+	q, err := DiskQueueSink(rec, DiskQueueOptions{Path: path, RetryInterval: time.Hour, OnError: func(*alerter.Alert, error) {
+		failed <- struct{}{}
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range msgs {
+		_ = alerter.Send(q, &alerter.Alert{Message: msg, Severity: alerter.SeverityError, Values: []interface{}{"host", "a"}, KeysAndValues: []interface{}{"n", 1}})
+	}
+	<-failed
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// drain opens the queue at path, waits until it delivered its alerts and
+// returns them.
+func drain(t *testing.T, path string, opts DiskQueueOptions) []*alerter.Alert {
+	t.Helper()
+	rec := newRecorder()
+	opts.Path = path
+	q, err := DiskQueueSink(rec, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return q.Len() == 0 })
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rec.recorded()
+}
+
+func TestDiskQueueReplay(t *testing.T) {
+	path := fillDiskQueue(t, "a", "b", "c")
+	entries, err := ListDiskQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for i, e := range entries {
+		listed = append(listed, e.Alert.Message)
+		if e.Seq != uint64(i+1) {
+			t.Errorf("alert %q has seq %d, want %d", e.Alert.Message, e.Seq, i+1)
+		}
+	}
+	if want := []string{"a", "b", "c"}; !slices.Equal(listed, want) {
+		t.Fatalf("queue holds %v, want %v", listed, want)
+	}
+
+	alerts := drain(t, path, DiskQueueOptions{})
+	if got, want := messages(alerts), []string{"a", "b", "c"}; !slices.Equal(got, want) {
+		t.Fatalf("replayed %v, want %v", got, want)
+	}
+	a := alerts[0]
+	if a.Severity != alerter.SeverityError || value(a, "host") != "a" || value(a, "n") != json.Number("1") {
+		t.Errorf("replayed alert %+v", a)
+	}
+	if alerts, err := ReadDiskQueue(path); err != nil || len(alerts) != 0 {
+		t.Errorf("queue holds %d alerts after delivery, error %v", len(alerts), err)
+	}
+}
+
+func TestDiskQueueNotRetryable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	rec := newRecorder()
+	rec.fail(errTemporary)
+	q, err := DiskQueueSink(rec, DiskQueueOptions{Path: path, Retryable: func(error) bool { return false }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = alerter.Send(q, &alerter.Alert{Message: "a", Severity: alerter.SeverityError})
+	eventually(t, func() bool { return q.Len() == 0 })
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if alerts, err := ReadDiskQueue(path); err != nil || len(alerts) != 0 {
+		t.Errorf("queue holds %d alerts which cannot be delivered, error %v", len(alerts), err)
+	}
+}
+
+func TestDiskQueueRetriesOnTheClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "alerts.log")
+	rec := newRecorder()
+	rec.fail(errTemporary)
+	clock := alerter.NewManualClock(epoch)
+	q, err := DiskQueueSink(rec, DiskQueueOptions{Path: path, RetryInterval: time.Minute, Clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	_ = alerter.Send(q, &alerter.Alert{Message: "a", Severity: alerter.SeverityError})
+	eventually(t, func() bool { return clock.Len() == 1 })
+	rec.fail(nil)
+	clock.Advance(time.Minute)
+	eventually(t, func() bool { return q.Len() == 0 })
+	if got := rec.messages(); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("delivered %v, want [a]", got)
+	}
+}
+
+func TestDiskQueueEdit(t *testing.T) {
+	path := fillDiskQueue(t, "a", "b", "c", "d")
+	if err := DropFromDiskQueue(path, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := RequeueInDiskQueue(path, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := DropFromDiskQueue(path, 3, 2); err == nil {
+		t.Error("dropped an alert which is not in the queue")
+	}
+	entries, err := ListDiskQueue(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Alert.Message)
+	}
+	if want := []string{"c", "d", "a"}; !slices.Equal(got, want) {
+		t.Fatalf("queue holds %v after editing, want %v", got, want)
+	}
+	if entries[2].Seq != 5 {
+		t.Errorf("requeued alert has seq %d, want 5", entries[2].Seq)
+	}
+	if got, want := messages(drain(t, path, DiskQueueOptions{})), []string{"c", "d", "a"}; !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestDiskQueueTornWrite(t *testing.T) {
+	path := fillDiskQueue(t, "a", "b")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte{42, 0, 0, 0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if alerts, err := ReadDiskQueue(path); err != nil || len(alerts) != 2 {
+		t.Fatalf("read %d alerts from a torn log, error %v", len(alerts), err)
+	}
+	var errs []error
+	alerts := drain(t, path, DiskQueueOptions{OnError: func(_ *alerter.Alert, err error) { errs = append(errs, err) }})
+	if got, want := messages(alerts), []string{"a", "b"}; !slices.Equal(got, want) || len(errs) != 0 {
+		t.Errorf("replayed %v with errors %v, want %v", got, errs, want)
+	}
+}
+
+func TestDiskQueueCorrupt(t *testing.T) {
+	path := fillDiskQueue(t, "a", "b", "c")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Damage the payload of the first record.
+	data[10] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var corrupt *QueueCorruptError
+	if _, err := ReadDiskQueue(path); !errors.As(err, &corrupt) {
+		t.Fatalf("reading a corrupt log returned %v", err)
+	}
+	if err := DropFromDiskQueue(path, 2); err == nil {
+		t.Error("edited a corrupt log")
+	}
+
+	var errs []error
+	alerts := drain(t, path, DiskQueueOptions{OnError: func(_ *alerter.Alert, err error) { errs = append(errs, err) }})
+	if got, want := messages(alerts), []string{"b", "c"}; !slices.Equal(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+	if len(errs) != 1 || !errors.As(errs[0], &corrupt) || corrupt.Offset != 0 || corrupt.MovedTo == "" {
+		t.Fatalf("errors %v, want a QueueCorruptError", errs)
+	}
+	if moved, err := os.ReadFile(corrupt.MovedTo); err != nil || len(moved) != len(data) {
+		t.Errorf("corrupt log not moved aside: %v", err)
+	}
+}
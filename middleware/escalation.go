@@ -0,0 +1,319 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/store"
+)
+
+// EscalationKey is the key under which an Escalator attaches the number of
+// the step, starting at 1, an alert is delivered to.
+const EscalationKey = "escalation"
+
+// EscalationStep is a tier of an escalation chain.
+type EscalationStep struct {
+	// Sink notifies the tier, e.g. the on-call engineer, their team or
+	// their manager.
+	Sink alerter.Sink
+
+	// After is the time an alert has to go unacknowledged at the
+	// previous step before this one is notified.  It is ignored for the
+	// first step, which is notified right away.
+	After time.Duration
+}
+
+// EscalationOptions carries parameters for EscalationSink.
+type EscalationOptions struct {
+	// Steps are the tiers of the chain, in order.
+	Steps []EscalationStep
+
+	// Repeat is the number of times the chain starts over from the first
+	// step once the last one was notified, for as long as the alert stays
+	// unacknowledged.
+	Repeat int
+
+	// RepeatInterval is the time between notifying the last step and
+	// starting over.  Defaults to 30 minutes.
+	RepeatInterval time.Duration
+
+	// OnError is called with the alerts which could not be delivered to a
+	// step that was reached by escalation, after the alert was raised.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock times the steps.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+
+	// Acks, if its Store is set, shares acknowledgements with the
+	// replicas using the same Store: an alert acknowledged through any of
+	// them stops escalating on all of them before their next step.
+	// Errors of the Store are passed to OnError with a nil alert, and
+	// escalations go on.
+	Acks store.Acks
+}
+
+// escalationLimit bounds the number of alerts an Escalator keeps track of
+// after their escalation has ended.
+const escalationLimit = 4096
+
+// Escalator is a Sink which escalates alerts along a chain of steps: an
+// alert goes to the first step right away and, unless it is acknowledged or
+// resolved in time, to the following ones after their delay:
+//
+//	esc := middleware.EscalationSink(middleware.EscalationOptions{
+//		Steps: []middleware.EscalationStep{
+//			{Sink: primaryOnCall},
+//			{Sink: secondaryOnCall, After: 15 * time.Minute},
+//			{Sink: engineeringManager, After: 30 * time.Minute},
+//		},
+//		Repeat: 2,
+//	})
+//
+// Alerts are identified by their fingerprint, which is attached to every
+// delivery as FingerprintKey so that recipients can acknowledge them with
+// Acknowledge.  Alerts raised again while escalating are delivered to the
+// step reached so far, without restarting the chain.  Resolves end the
+// escalation and go to every step which was notified.
+//
+// Escalations still pending are abandoned by Close.
+type Escalator struct {
+	sink
+
+	opts EscalationOptions
+
+	mu     sync.Mutex
+	active map[string]*escalation
+	closed bool
+}
+
+// escalation is the state of the escalation of one alert.
+type escalation struct {
+	alert *alerter.Alert
+	// step is the step notified last, reached the highest one notified
+	// and pass the number of times the chain started over.
+	step, reached int
+	pass          int
+	// timer escalates to the next step; it is nil once the escalation
+	// has ended.
+	timer alerter.Timer
+}
+
+// EscalationSink returns an Escalator delivering to the steps of opts.
+func EscalationSink(opts EscalationOptions) *Escalator {
+	if opts.RepeatInterval <= 0 {
+		opts.RepeatInterval = 30 * time.Minute
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	e := &Escalator{opts: opts, active: map[string]*escalation{}}
+	e.sink = alerter.NewSink(e.send, alerter.SinkOptions{Enabled: e.enabled, Clock: opts.Clock}).(sink)
+	return e
+}
+
+func (e *Escalator) enabled(level int) bool {
+	for _, step := range e.opts.Steps {
+		if step.Sink.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *Escalator) send(a *alerter.Alert) error {
+	if len(e.opts.Steps) == 0 {
+		return nil
+	}
+	fp := a.Fingerprint()
+	e.mu.Lock()
+	esc := e.active[fp]
+	if a.Resolved {
+		if esc == nil {
+			e.mu.Unlock()
+			return alerter.Send(e.opts.Steps[0].Sink, a)
+		}
+		delete(e.active, fp)
+		esc.stop()
+		reached := esc.reached
+		e.mu.Unlock()
+		e.storeError(e.opts.Acks.Clear(context.Background(), fp))
+		var errs []error
+		for _, step := range e.opts.Steps[:reached+1] {
+			if err := alerter.Send(step.Sink, a); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return errors.Join(errs...)
+	}
+	if esc != nil {
+		esc.alert = a
+		step := esc.step
+		e.mu.Unlock()
+		return alerter.Send(e.opts.Steps[step].Sink, e.tagged(a, fp, step))
+	}
+	esc = &escalation{alert: a}
+	if !e.closed {
+		e.forget()
+		e.active[fp] = esc
+		e.schedule(fp, esc)
+	}
+	e.mu.Unlock()
+	return alerter.Send(e.opts.Steps[0].Sink, e.tagged(a, fp, 0))
+}
+
+// schedule starts the timer for the step after esc.step, or ends the
+// escalation if there is none.  It must be called with e.mu held.
+func (e *Escalator) schedule(fp string, esc *escalation) {
+	next, delay := esc.step+1, time.Duration(0)
+	switch {
+	case next < len(e.opts.Steps):
+		delay = e.opts.Steps[next].After
+	case esc.pass < e.opts.Repeat:
+		next, delay = 0, e.opts.RepeatInterval
+	default:
+		esc.timer = nil
+		return
+	}
+	esc.timer = alerter.AfterFunc(e.opts.Clock, delay, func() { e.escalate(fp, esc, next) })
+}
+
+// escalate notifies step of the alert of esc, unless its escalation ended
+// in the meantime.
+func (e *Escalator) escalate(fp string, esc *escalation, step int) {
+	_, acked, err := e.opts.Acks.Acknowledged(context.Background(), fp)
+	e.storeError(err)
+	e.mu.Lock()
+	if e.active[fp] != esc || esc.timer == nil {
+		e.mu.Unlock()
+		return
+	}
+	if acked {
+		esc.stop()
+		e.mu.Unlock()
+		return
+	}
+	if step == 0 {
+		esc.pass++
+	}
+	esc.step = step
+	if step > esc.reached {
+		esc.reached = step
+	}
+	a := e.tagged(esc.alert, fp, step)
+	e.schedule(fp, esc)
+	e.mu.Unlock()
+	if err := alerter.Send(e.opts.Steps[step].Sink, a); err != nil && e.opts.OnError != nil {
+		e.opts.OnError(a, err)
+	}
+}
+
+// tagged returns a with the fingerprint and step attached.
+func (e *Escalator) tagged(a *alerter.Alert, fp string, step int) *alerter.Alert {
+	c := *a
+	c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)],
+		FingerprintKey, fp, EscalationKey, step+1)
+	return &c
+}
+
+// forget makes room for a new alert by dropping one whose escalation has
+// ended.  It must be called with e.mu held.
+func (e *Escalator) forget() {
+	if len(e.active) < escalationLimit {
+		return
+	}
+	for fp, esc := range e.active {
+		if esc.timer == nil {
+			delete(e.active, fp)
+			return
+		}
+	}
+}
+
+// Acknowledge stops the escalation of the alert with the given fingerprint,
+// as attached to its deliveries under FingerprintKey, and records the
+// acknowledgement in EscalationOptions.Acks.  It reports whether the alert
+// was still escalating in this process.
+func (e *Escalator) Acknowledge(fingerprint string) bool {
+	e.storeError(e.opts.Acks.Acknowledge(context.Background(), fingerprint, ""))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	esc := e.active[fingerprint]
+	if esc == nil || esc.timer == nil {
+		return false
+	}
+	esc.stop()
+	return true
+}
+
+// AcknowledgeMatching acknowledges all alerts matching ms whose escalation are
+// pending, e.g. all alerts of a team during a large incident, and returns
+// their fingerprints in order.  With dryRun, it only returns them.
+func (e *Escalator) AcknowledgeMatching(ms matchers.Matchers, dryRun bool) []string {
+	e.mu.Lock()
+	var fps []string
+	for fp, esc := range e.active {
+		if esc.timer == nil || !ms.Matches(esc.alert) {
+			continue
+		}
+		if !dryRun {
+			esc.stop()
+		}
+		fps = append(fps, fp)
+	}
+	e.mu.Unlock()
+	sort.Strings(fps)
+	if !dryRun {
+		for _, fp := range fps {
+			e.storeError(e.opts.Acks.Acknowledge(context.Background(), fp, ""))
+		}
+	}
+	return fps
+}
+
+// storeError passes an error of EscalationOptions.Acks to OnError.
+func (e *Escalator) storeError(err error) {
+	if err != nil && e.opts.OnError != nil {
+		e.opts.OnError(nil, err)
+	}
+}
+
+// Close stops all pending escalations.  Alerts raised afterwards are only
+// delivered to the first step.
+func (e *Escalator) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.closed = true
+	for _, esc := range e.active {
+		esc.stop()
+	}
+	return nil
+}
+
+// stop ends the escalation.
+func (esc *escalation) stop() {
+	if esc.timer != nil {
+		esc.timer.Stop()
+		esc.timer = nil
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Keys of the key/value pairs ExpirySink reads and attaches.
+const (
+	// TTLKey sets the time an alert stays open without being raised
+	// again, as a time.Duration or a string such as "15m".
+	TTLKey = "ttl"
+
+	// ExpiredKey is attached, set to true, to the resolves ExpirySink
+	// emits for expired alerts.
+	ExpiredKey = "expired"
+)
+
+// ExpiryOptions carries parameters for ExpirySink.
+type ExpiryOptions struct {
+	// TTL is the time to live of alerts without a TTLKey.  Zero leaves
+	// them open until they are resolved.
+	TTL time.Duration
+
+	// OnError is called with the resolves of expired alerts which could
+	// not be delivered.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock tells the time of the resolves and runs the TTLs.  Defaults
+	// to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// ExpirySink returns a Sink which resolves alerts that are not raised
+// again within their time to live, so that incidents for conditions which
+// went away without anyone calling Resolve close themselves.  The TTL is
+// set per alert with TTLKey or for all alerts with ExpiryOptions.TTL:
+//
+//	a.Error(err, "queue backlog growing", middleware.TTLKey, 10*time.Minute)
+//
+// Every occurrence of an alert, identified by its fingerprint, restarts its
+// TTL.  The resolve emitted on expiry has the name, message and values of
+// the alert, so that it matches it downstream, and ExpiredKey attached.
+func ExpirySink(inner alerter.Sink, opts ExpiryOptions) alerter.Sink {
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	e := &expiry{inner: inner, opts: opts, open: map[string]*expiryEntry{}}
+	return wrap(inner, e.send)
+}
+
+type expiry struct {
+	inner alerter.Sink
+	opts  ExpiryOptions
+
+	mu   sync.Mutex
+	open map[string]*expiryEntry
+}
+
+type expiryEntry struct {
+	timer alerter.Timer
+}
+
+func (e *expiry) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	e.mu.Lock()
+	if old := e.open[fp]; old != nil {
+		old.timer.Stop()
+		delete(e.open, fp)
+	}
+	if ttl := durationOf(a, TTLKey, e.opts.TTL); !a.Resolved && ttl > 0 {
+		entry := &expiryEntry{}
+		entry.timer = alerter.AfterFunc(e.opts.Clock, ttl, func() { e.expire(fp, entry, a) })
+		e.open[fp] = entry
+	}
+	e.mu.Unlock()
+	return alerter.Send(e.inner, a)
+}
+
+// expire resolves a, unless it was raised again or resolved since entry
+// was created.
+func (e *expiry) expire(fp string, entry *expiryEntry, a *alerter.Alert) {
+	e.mu.Lock()
+	if e.open[fp] != entry {
+		e.mu.Unlock()
+		return
+	}
+	delete(e.open, fp)
+	e.mu.Unlock()
+	resolve := &alerter.Alert{
+		Time:          e.opts.Clock.Now(),
+		Name:          a.Name,
+		Level:         a.Level,
+		Message:       a.Message,
+		Resolved:      true,
+		Values:        a.Values,
+		KeysAndValues: []interface{}{ExpiredKey, true},
+	}
+	if err := alerter.Send(e.inner, resolve); err != nil && e.opts.OnError != nil {
+		e.opts.OnError(resolve, err)
+	}
+}
+
+// durationOf returns the last duration a carries under key, or def.
+func durationOf(a *alerter.Alert, key string, def time.Duration) time.Duration {
+	d := def
+	for i := 0; i+1 < len(a.KeysAndValues); i += 2 {
+		if a.KeysAndValues[i] != key {
+			continue
+		}
+		switch v := a.KeysAndValues[i+1].(type) {
+		case time.Duration:
+			d = v
+		case string:
+			if parsed, err := time.ParseDuration(v); err == nil {
+				d = parsed
+			}
+		}
+	}
+	return d
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sumengzs/alerter"
+)
+
+// FailoverKey is the key under which alerts delivered by a secondary Sink of
+// a FailoverChain carry its position, starting at 1, so that recipients know
+// the primary is down.
+const FailoverKey = "failover"
+
+// FailoverChain is a Sink which tries a list of Sinks in order for every
+// alert until one of them delivers it, so that an outage of the primary
+// pager provider falls back to, say, email and SMS.  Sinks whose verbosity
+// filters an Info alert out are skipped, as they would drop it without
+// reporting an error.  It counts the alerts every Sink delivered.
+type FailoverChain struct {
+	sink
+
+	sinks     []alerter.Sink
+	delivered []atomic.Uint64
+	failed    atomic.Uint64
+}
+
+// Failover returns a FailoverChain trying primary first and then each of
+// secondaries.
+func Failover(primary alerter.Sink, secondaries ...alerter.Sink) *FailoverChain {
+	f := &FailoverChain{sinks: append([]alerter.Sink{primary}, secondaries...)}
+	f.delivered = make([]atomic.Uint64, len(f.sinks))
+	f.sink = alerter.NewSink(f.send, alerter.SinkOptions{Enabled: f.enabled}).(sink)
+	return f
+}
+
+func (f *FailoverChain) enabled(level int) bool {
+	for _, s := range f.sinks {
+		if s.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FailoverChain) send(a *alerter.Alert) error {
+	var errs []error
+	for i, s := range f.sinks {
+		if !accepts(s, a) {
+			continue
+		}
+		c := *a
+		if i > 0 {
+			c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)], FailoverKey, i)
+		}
+		err := alerter.Send(s, &c)
+		if err == nil {
+			f.delivered[i].Add(1)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("sink %d: %w", i, err))
+	}
+	if len(errs) == 0 {
+		// No Sink is verbose enough for the alert.
+		return nil
+	}
+	f.failed.Add(1)
+	return errors.Join(errs...)
+}
+
+// accepts reports whether s delivers a rather than dropping it for its
+// verbosity, as alerter.Send only asks Enabled for Info alerts.
+func accepts(s alerter.Sink, a *alerter.Alert) bool {
+	return a.Resolved || a.Err != nil || a.Severity >= alerter.SeverityError || s.Enabled(a.Level)
+}
+
+// Delivered returns the number of alerts each Sink delivered, the primary
+// first.
+func (f *FailoverChain) Delivered() []uint64 {
+	n := make([]uint64, len(f.delivered))
+	for i := range f.delivered {
+		n[i] = f.delivered[i].Load()
+	}
+	return n
+}
+
+// Failed returns the number of alerts none of the Sinks delivered.
+func (f *FailoverChain) Failed() uint64 {
+	return f.failed.Load()
+}
@@ -0,0 +1,43 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestFailoverSkipsFilteringSinks(t *testing.T) {
+	primary, secondary := newRecorder(), newRecorder()
+	quiet := alerter.NewSink(primary.record, alerter.SinkOptions{Enabled: func(level int) bool { return level == 0 }})
+	f := Failover(quiet, secondary)
+	log := alerter.New(f)
+	log.V(1).Info("debug")
+	log.Error(nil, "down")
+	if got := primary.messages(); !slices.Equal(got, []string{"down"}) {
+		t.Errorf("primary delivered %v, want [down]", got)
+	}
+	alerts := secondary.recorded()
+	if len(alerts) != 1 || alerts[0].Message != "debug" || value(alerts[0], FailoverKey) != 1 {
+		t.Errorf("secondary delivered %v, want the debug alert", secondary.messages())
+	}
+	if got, want := f.Delivered(), []uint64{1, 1}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"sync"
+
+	"github.com/sumengzs/alerter"
+)
+
+// WithFairQueuing makes the queue serve the alerts of different keys in
+// turn, so that one noisy tenant, route or component cannot monopolize
+// delivery.  key returns the key of an alert, e.g. its tenant label or the
+// first segment of its name; weights gives a key as many alerts per turn,
+// and keys without a weight get one.  Alerts of a key are delivered in
+// order, by one worker at a time, so that with several workers a key whose
+// sink is slow only holds up its own alerts.
+//
+// A full queue makes room for an alert by dropping the oldest alert of the
+// key with the most queued alerts, if that key has more than the one of the
+// new alert, before applying the overflow policy; with DropOldest, it drops
+// the oldest alert of the key with the most queued alerts in any case.  Only
+// Block and Spill never drop queued alerts.
+//
+// WithFairQueuing replaces the ordering by severity of WithPriority and
+// WithReserve.
+func WithFairQueuing(key func(a *alerter.Alert) string, weights map[string]int) AsyncOption {
+	return func(o *asyncOptions) {
+		o.fairKey = key
+		o.fairWeights = weights
+	}
+}
+
+// fairQueue is the queue of an Async with WithFairQueuing.
+type fairQueue struct {
+	size    int
+	key     func(a *alerter.Alert) string
+	weights map[string]int
+
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	keys     map[string]*fairKey
+	// ready holds the keys with queued alerts which are not being
+	// delivered, in the order they are served.
+	ready  []*fairKey
+	len    int
+	closed bool
+}
+
+// fairKey holds the queued alerts of a key.
+type fairKey struct {
+	name   string
+	alerts []*alerter.Alert
+	// busy is set while an alert of the key is delivered, and served is
+	// the number of alerts delivered in its current turn.
+	busy   bool
+	served int
+}
+
+func newFairQueue(size int, key func(a *alerter.Alert) string, weights map[string]int) *fairQueue {
+	q := &fairQueue{size: size, key: key, weights: weights, keys: map[string]*fairKey{}}
+	q.notEmpty.L = &q.mu
+	q.notFull.L = &q.mu
+	return q
+}
+
+// push queues a according to overflow.  It reports whether a was queued
+// and returns the alert dropped to make room for it, if any.
+func (q *fairQueue) push(a *alerter.Alert, overflow OverflowPolicy) (bool, *alerter.Alert) {
+	name := q.key(a)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var dropped *alerter.Alert
+	for q.len >= q.size {
+		switch overflow {
+		case Block:
+			q.notFull.Wait()
+			continue
+		case Spill:
+			return false, nil
+		}
+		longest := q.longest()
+		own := 0
+		if k := q.keys[name]; k != nil {
+			own = len(k.alerts)
+		}
+		if longest != nil && (overflow == DropOldest || len(longest.alerts) > own) {
+			dropped = longest.alerts[0]
+			q.remove(longest)
+			continue
+		}
+		return false, nil
+	}
+	k := q.keys[name]
+	if k == nil {
+		k = &fairKey{name: name}
+		q.keys[name] = k
+	}
+	k.alerts = append(k.alerts, a)
+	q.len++
+	if len(k.alerts) == 1 && !k.busy {
+		q.ready = append(q.ready, k)
+		q.notEmpty.Signal()
+	}
+	return true, dropped
+}
+
+// pop waits for an alert of the key whose turn it is and returns it, with
+// the function to call once it was delivered, or false once the queue is
+// closed and empty.
+func (q *fairQueue) pop() (*alerter.Alert, func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.ready) == 0 {
+		if q.closed && q.len == 0 {
+			return nil, nil, false
+		}
+		q.notEmpty.Wait()
+	}
+	k := q.ready[0]
+	q.ready[0] = nil
+	q.ready = q.ready[1:]
+	a := k.alerts[0]
+	k.busy = true
+	k.served++
+	q.remove(k)
+	return a, func() { q.done(k) }, true
+}
+
+// done makes k ready again after one of its alerts was delivered: first in
+// line if its turn goes on, and last otherwise.
+func (q *fairQueue) done(k *fairKey) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	k.busy = false
+	if len(k.alerts) == 0 {
+		k.served = 0
+		if q.keys[k.name] == k {
+			delete(q.keys, k.name)
+		}
+		if q.closed && q.len == 0 {
+			// Let the other workers see that the queue drained.
+			q.notEmpty.Broadcast()
+		}
+		return
+	}
+	if k.served < max(q.weights[k.name], 1) {
+		q.ready = append([]*fairKey{k}, q.ready...)
+	} else {
+		k.served = 0
+		q.ready = append(q.ready, k)
+	}
+	q.notEmpty.Signal()
+}
+
+// longest returns the key with the most queued alerts, or nil if there is
+// none.  It must be called with q.mu held.
+func (q *fairQueue) longest() *fairKey {
+	var longest *fairKey
+	for _, k := range q.keys {
+		if len(k.alerts) > 0 && (longest == nil || len(k.alerts) > len(longest.alerts)) {
+			longest = k
+		}
+	}
+	return longest
+}
+
+// remove removes the first alert of k, forgetting k once it has neither
+// queued alerts nor one being delivered.  It must be called with q.mu held.
+func (q *fairQueue) remove(k *fairKey) {
+	k.alerts[0] = nil
+	k.alerts = k.alerts[1:]
+	q.len--
+	q.notFull.Broadcast()
+	if len(k.alerts) > 0 || k.busy {
+		return
+	}
+	for i, r := range q.ready {
+		if r == k {
+			q.ready = append(q.ready[:i], q.ready[i+1:]...)
+			break
+		}
+	}
+	delete(q.keys, k.name)
+}
+
+func (q *fairQueue) length() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.len
+}
+
+// close makes pop return false once the queue is empty.
+func (q *fairQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
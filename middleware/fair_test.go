@@ -0,0 +1,122 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sumengzs/alerter"
+)
+
+// byName is the key of WithFairQueuing in tests.
+func byName(a *alerter.Alert) string {
+	return a.Name
+}
+
+// raiseNamed sends error alerts of the given name and messages to s.
+func raiseNamed(s alerter.Sink, name string, msgs ...string) {
+	for _, msg := range msgs {
+		_ = alerter.Send(s, &alerter.Alert{Name: name, Message: msg, Severity: alerter.SeverityError})
+	}
+}
+
+func TestAsyncFairQueuing(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		weights map[string]int
+		want    []string
+	}{
+		{"round robin", nil, []string{"first", "a1", "b1", "c1", "a2", "b2", "a3", "a4"}},
+		{"weights", map[string]int{"a": 2}, []string{"first", "a1", "a2", "b1", "c1", "a3", "a4", "b2"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newGate()
+			s := AsyncSink(g.sink(), WithFairQueuing(byName, tc.weights))
+			raiseNamed(s, "x", "first")
+			<-g.started
+			raiseNamed(s, "a", "a1", "a2", "a3", "a4")
+			raiseNamed(s, "b", "b1", "b2")
+			raiseNamed(s, "c", "c1")
+			g.release()
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := g.messages(); !slices.Equal(got, tc.want) {
+				t.Errorf("delivered %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAsyncFairQueuingFull(t *testing.T) {
+	g := newGate()
+	var dropped []string
+	s := AsyncSink(g.sink(), WithQueueSize(3), WithFairQueuing(byName, nil), WithOnDrop(func(a *alerter.Alert) {
+		dropped = append(dropped, a.Message)
+	}))
+	raiseNamed(s, "x", "first")
+	<-g.started
+	raiseNamed(s, "a", "a1", "a2", "a3")
+	// Another key takes the place of the oldest alert of the noisy one;
+	// the noisy key then drops its own new alerts.
+	raiseNamed(s, "b", "b1")
+	raiseNamed(s, "a", "a4")
+	g.release()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.messages(), []string{"first", "a2", "b1", "a3"}; !slices.Equal(got, want) {
+		t.Errorf("delivered %v, want %v", got, want)
+	}
+	if want := []string{"a1", "a4"}; !slices.Equal(dropped, want) {
+		t.Errorf("dropped %v, want %v", dropped, want)
+	}
+}
+
+func TestAsyncFairQueuingSlowKey(t *testing.T) {
+	g := newGate()
+	fast := newRecorder()
+	inner := wrap(fast, func(a *alerter.Alert) error {
+		if a.Name == "slow" {
+			return alerter.Send(g.sink(), a)
+		}
+		return alerter.Send(fast, a)
+	})
+	s := AsyncSink(inner, WithWorkers(3), WithFairQueuing(byName, nil))
+	raiseNamed(s, "slow", "s1", "s2")
+	<-g.started
+	raiseNamed(s, "fast", "f1", "f2", "f3")
+	// The other workers deliver the fast key, but not the second alert of
+	// the slow one, which waits for the first.
+	eventually(t, func() bool { return len(fast.recorded()) == 3 })
+	select {
+	case msg := <-g.started:
+		t.Errorf("delivering %s while s1 is delivered", msg)
+	default:
+	}
+	g.release()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := g.messages(), []string{"s1", "s2"}; !slices.Equal(got, want) {
+		t.Errorf("slow key delivered %v, want %v", got, want)
+	}
+	if got, want := fast.messages(), []string{"f1", "f2", "f3"}; !slices.Equal(got, want) {
+		t.Errorf("fast key delivered %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/sumengzs/alerter"
+)
+
+// FieldFilter selects the keys of alerts passed on to a sink.  Patterns are
+// globs as for Route.NameGlob, matched case-insensitively.
+type FieldFilter struct {
+	// Allow, if set, are the patterns of the only keys kept.
+	Allow []string
+
+	// Deny are the patterns of keys removed, even if allowed, e.g.
+	// "request_body" or "*_payload".
+	Deny []string
+}
+
+// compiledFilter is a FieldFilter ready to be applied.
+type compiledFilter struct {
+	allow, deny []*regexp.Regexp
+}
+
+func (f *FieldFilter) compile() *compiledFilter {
+	if f == nil || len(f.Allow) == 0 && len(f.Deny) == 0 {
+		return nil
+	}
+	return &compiledFilter{allow: compileKeyGlobs(f.Allow), deny: compileKeyGlobs(f.Deny)}
+}
+
+// keeps reports whether the filter passes key on.
+func (f *compiledFilter) keeps(key string) bool {
+	if len(f.allow) > 0 && !matchesAny(f.allow, key) {
+		return false
+	}
+	return !matchesAny(f.deny, key)
+}
+
+// apply returns a copy of a without the keys the filter removes.
+func (f *compiledFilter) apply(a *alerter.Alert) *alerter.Alert {
+	if f == nil {
+		return a
+	}
+	c := *a
+	c.Values = f.filter(a.Values)
+	c.KeysAndValues = f.filter(a.KeysAndValues)
+	return &c
+}
+
+func (f *compiledFilter) filter(kvs []interface{}) []interface{} {
+	if len(kvs) == 0 {
+		return kvs
+	}
+	out := make([]interface{}, 0, len(kvs))
+	for i := 0; i < len(kvs); i += 2 {
+		key, _ := kvs[i].(string)
+		if !f.keeps(key) {
+			continue
+		}
+		out = append(out, kvs[i:min(i+2, len(kvs))]...)
+	}
+	return out
+}
+
+// FieldFilterSink returns a Sink which removes the keys filter does not keep
+// from alerts before passing them on to inner, e.g. to keep request bodies
+// away from external services:
+//
+//	sink := middleware.FieldFilterSink(slack, middleware.FieldFilter{
+//		Deny: []string{"request_body", "*_payload"},
+//	})
+//
+// Within a RouterSink, Route.Fields filters the alerts of a single route.
+func FieldFilterSink(inner alerter.Sink, filter FieldFilter) alerter.Sink {
+	f := filter.compile()
+	if f == nil {
+		return inner
+	}
+	return wrap(inner, func(a *alerter.Alert) error {
+		return alerter.Send(inner, f.apply(a))
+	})
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"github.com/sumengzs/alerter"
+)
+
+// FilterSink returns a Sink which passes only the alerts for which keep
+// returns true on to inner, such as those satisfying a condition:
+//
+//	cond, err := expr.CompileCondition(`alert.labels['env'] != 'dev'`)
+//	...
+//	sink := middleware.FilterSink(pager, cond.Match)
+//
+// Resolves are always passed on, as they may not satisfy the condition
+// their alert did, e.g. one on severity.
+func FilterSink(inner alerter.Sink, keep func(a *alerter.Alert) bool) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		if !a.Resolved && !keep(a) {
+			return nil
+		}
+		return alerter.Send(inner, a)
+	})
+}
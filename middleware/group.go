@@ -0,0 +1,259 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// GroupKey is the key under which a Grouper attaches the labels of the
+// group, such as `{alertname="disk-full", team="db"}`, to the alerts of a
+// notification.
+const GroupKey = "group"
+
+// GroupOptions carries parameters which influence the way a Grouper groups
+// alerts, with the semantics of the options of the same names of
+// Alertmanager.
+type GroupOptions struct {
+	// By are the labels, as seen by matchers.Labels, whose values make up
+	// a group, e.g. "alertname" and "team".  Without labels, all alerts
+	// form a single group.
+	By []string
+
+	// Wait is the time the first notification of a new group waits for
+	// more alerts of the group.  Defaults to 30 seconds.
+	Wait time.Duration
+
+	// Interval is the time between notifications of a group about alerts
+	// which were added or resolved since the last one.  Defaults to 5
+	// minutes.
+	Interval time.Duration
+
+	// Repeat is the time after which a notification is sent again for a
+	// group whose alerts did not change.  Defaults to 4 hours.
+	Repeat time.Duration
+
+	// OnError is called with the notifications which could not be
+	// delivered.
+	OnError func(alerts []*alerter.Alert, err error)
+
+	// Clock tells the time notifications are sent at and runs the
+	// timers sending them.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Grouper is a Sink which turns a burst of related alerts into a single
+// notification: alerts with the same values of GroupOptions.By form a
+// group, whose firing and newly resolved alerts are delivered together
+// through alerter.SendBatch, so that inner Sinks implementing
+// alerter.BatchSink send them in one payload.
+//
+// A group is notified GroupOptions.Wait after its first alert, then every
+// GroupOptions.Interval if alerts were added or resolved meanwhile, and
+// otherwise every GroupOptions.Repeat while it has firing alerts.  Alerts
+// raised again replace their earlier occurrence within the group.
+// Resolves go to the group of the alert they resolve, even if their labels,
+// such as "severity", differ from those of the alert.
+//
+// Alerts still waiting are lost unless Close is called before the program
+// exits.
+type Grouper struct {
+	sink
+
+	inner alerter.Sink
+	opts  GroupOptions
+
+	mu     sync.Mutex
+	groups map[string]*group
+	// index has the group of every firing alert by fingerprint.
+	index  map[string]string
+	closed bool
+}
+
+// group holds the alerts of a group by fingerprint.
+type group struct {
+	key      string
+	alerts   map[string]*alerter.Alert
+	changed  bool
+	notified time.Time
+	timer    alerter.Timer
+}
+
+// GroupingSink returns a Grouper delivering to inner.
+func GroupingSink(inner alerter.Sink, opts GroupOptions) *Grouper {
+	if opts.Wait <= 0 {
+		opts.Wait = 30 * time.Second
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Minute
+	}
+	if opts.Repeat <= 0 {
+		opts.Repeat = 4 * time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	g := &Grouper{inner: inner, opts: opts, groups: map[string]*group{}, index: map[string]string{}}
+	g.sink = wrap(inner, g.send).(sink)
+	return g
+}
+
+func (g *Grouper) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	key := g.key(a)
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return alerter.Send(g.inner, a)
+	}
+	if prev, ok := g.index[fp]; ok && prev != key {
+		if a.Resolved {
+			key = prev
+		} else if old := g.groups[prev]; old != nil {
+			// The alert moved to another group.
+			delete(old.alerts, fp)
+		}
+	}
+	gr := g.groups[key]
+	if gr == nil {
+		if a.Resolved {
+			// Its alert was notified and the group is gone already.
+			g.mu.Unlock()
+			return alerter.Send(g.inner, a)
+		}
+		gr = &group{key: key, alerts: map[string]*alerter.Alert{}}
+		g.groups[key] = gr
+		gr.timer = alerter.AfterFunc(g.opts.Clock, g.opts.Wait, func() { g.tick(gr) })
+	}
+	gr.alerts[fp] = a
+	gr.changed = true
+	g.index[fp] = key
+	g.mu.Unlock()
+	return nil
+}
+
+// key returns the group of a, formatted like matchers.
+func (g *Grouper) key(a *alerter.Alert) string {
+	labels := matchers.Labels(a)
+	parts := make([]string, len(g.opts.By))
+	for i, l := range g.opts.By {
+		parts[i] = l + "=" + strconv.Quote(labels[l])
+	}
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+// tick notifies gr if it is due and schedules the next tick.
+func (g *Grouper) tick(gr *group) {
+	g.mu.Lock()
+	if g.groups[gr.key] != gr {
+		g.mu.Unlock()
+		return
+	}
+	now := g.opts.Clock.Now()
+	var batch []*alerter.Alert
+	if gr.changed || now.Sub(gr.notified) >= g.opts.Repeat {
+		batch = g.take(gr, now)
+	}
+	if len(gr.alerts) == 0 {
+		delete(g.groups, gr.key)
+	} else {
+		gr.timer = alerter.AfterFunc(g.opts.Clock, g.opts.Interval, func() { g.tick(gr) })
+	}
+	g.mu.Unlock()
+	_ = g.deliver(batch)
+}
+
+// take returns the notification of gr, sorted by time, and forgets its
+// resolved alerts.  It must be called with g.mu held.
+func (g *Grouper) take(gr *group, now time.Time) []*alerter.Alert {
+	batch := make([]*alerter.Alert, 0, len(gr.alerts))
+	for fp, a := range gr.alerts {
+		c := *a
+		c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)], GroupKey, gr.key)
+		batch = append(batch, &c)
+		if a.Resolved {
+			delete(gr.alerts, fp)
+			if g.index[fp] == gr.key {
+				delete(g.index, fp)
+			}
+		}
+	}
+	sort.Slice(batch, func(i, j int) bool {
+		if !batch[i].Time.Equal(batch[j].Time) {
+			return batch[i].Time.Before(batch[j].Time)
+		}
+		return batch[i].Name+batch[i].Message < batch[j].Name+batch[j].Message
+	})
+	gr.changed = false
+	gr.notified = now
+	return batch
+}
+
+// Flush notifies all groups with changes right away.
+func (g *Grouper) Flush() error {
+	return g.flush(false)
+}
+
+// Close notifies all groups with changes and stops the Grouper.  Alerts
+// raised afterwards are delivered one at a time.
+func (g *Grouper) Close() error {
+	return g.flush(true)
+}
+
+func (g *Grouper) flush(stop bool) error {
+	g.mu.Lock()
+	now := g.opts.Clock.Now()
+	var batches [][]*alerter.Alert
+	for key, gr := range g.groups {
+		if gr.changed {
+			batches = append(batches, g.take(gr, now))
+		}
+		if stop {
+			gr.timer.Stop()
+			delete(g.groups, key)
+		}
+	}
+	if stop {
+		g.closed = true
+		g.index = map[string]string{}
+	}
+	g.mu.Unlock()
+	var errs []error
+	for _, batch := range batches {
+		errs = append(errs, g.deliver(batch))
+	}
+	return errors.Join(errs...)
+}
+
+func (g *Grouper) deliver(batch []*alerter.Alert) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	err := alerter.SendBatch(g.inner, batch)
+	if err != nil && g.opts.OnError != nil {
+		g.opts.OnError(batch, err)
+	}
+	return err
+}
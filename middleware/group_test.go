@@ -0,0 +1,152 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// groups returns the batches of rec from the n-th on by group, with the
+// messages and hosts of their alerts.
+func groups(t *testing.T, rec *recorder, n int) map[string][]string {
+	t.Helper()
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	groups := map[string][]string{}
+	for _, batch := range rec.batches[n:] {
+		key := value(batch[0], GroupKey).(string)
+		if _, ok := groups[key]; ok {
+			t.Errorf("group %s notified twice", key)
+		}
+		for _, a := range batch {
+			if k := value(a, GroupKey); k != key {
+				t.Errorf("alert %q of group %s carries group %v", a.Message, key, k)
+			}
+			msg := messages([]*alerter.Alert{a})[0]
+			if host := value(a, "host"); host != nil {
+				msg += " on " + host.(string)
+			}
+			groups[key] = append(groups[key], msg)
+		}
+	}
+	return groups
+}
+
+func TestGrouper(t *testing.T) {
+	rec := newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	g := GroupingSink(rec, GroupOptions{
+		By:       []string{"alertname"},
+		Wait:     30 * time.Second,
+		Interval: 5 * time.Minute,
+		Repeat:   time.Hour,
+		Clock:    clock,
+	})
+	disk := alerter.New(g).WithName("disk")
+	disk.WithValues("host", "a").Error(nil, "disk full")
+	alerter.New(g).WithName("cpu").Error(nil, "load high")
+	clock.Advance(10 * time.Second)
+	disk.WithValues("host", "b").Error(nil, "disk full")
+
+	clock.Advance(19 * time.Second)
+	if n := len(rec.recorded()); n != 0 {
+		t.Fatalf("delivered %d alerts before the wait passed", n)
+	}
+	clock.Advance(time.Second)
+	want := map[string][]string{
+		`{alertname="disk"}`: {"disk full on a", "disk full on b"},
+		`{alertname="cpu"}`:  {"load high"},
+	}
+	if got := groups(t, rec, 0); !maps.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Fatalf("notified %v, want %v", got, want)
+	}
+
+	// Only groups with changes are notified at the next interval, with
+	// their resolves.
+	disk.WithValues("host", "b").Resolve("disk full")
+	clock.Advance(5 * time.Minute)
+	want = map[string][]string{
+		`{alertname="disk"}`: {"disk full on a", "disk full resolved on b"},
+	}
+	if got := groups(t, rec, 2); !maps.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Fatalf("notified %v, want %v", got, want)
+	}
+
+	// Unchanged groups are notified again after Repeat.
+	clock.Advance(50 * time.Minute)
+	if n := len(rec.batches); n != 3 {
+		t.Fatalf("%d notifications before Repeat, want 3", n)
+	}
+	clock.Advance(5 * time.Minute)
+	want = map[string][]string{
+		`{alertname="cpu"}`: {"load high"},
+	}
+	if got := groups(t, rec, 3); !maps.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Fatalf("notified %v after Repeat, want %v", got, want)
+	}
+
+	alerter.New(g).WithName("cpu").Resolve("load high")
+	if err := g.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want = map[string][]string{
+		`{alertname="cpu"}`: {"load high resolved"},
+	}
+	if got := groups(t, rec, 4); !maps.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("Close notified %v, want %v", got, want)
+	}
+	if clock.Len() != 0 {
+		t.Errorf("%d timers left after Close", clock.Len())
+	}
+
+	// Alerts raised after Close are delivered one at a time.
+	disk.Error(nil, "disk full")
+	if n := len(rec.batches); n != 5 || len(rec.recorded()) != 8 {
+		t.Errorf("alert after Close not delivered alone: %v", rec.messages())
+	}
+}
+
+func TestGrouperResolveFollowsAlert(t *testing.T) {
+	rec := newRecorder()
+	clock := alerter.NewManualClock(epoch)
+	g := GroupingSink(rec, GroupOptions{By: []string{alerter.SeverityKey}, Clock: clock})
+	log := alerter.New(g)
+	log.Error(nil, "disk full")
+	clock.Advance(30 * time.Second)
+	log.Resolve("disk full")
+	clock.Advance(5 * time.Minute)
+	want := map[string][]string{`{severity="error"}`: {"disk full resolved"}}
+	if got := groups(t, rec, 1); !maps.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("resolve notified as %v, want %v", got, want)
+	}
+
+	// With no pending alerts left, the group is gone and resolves of
+	// its alerts are delivered right away.
+	clock.Advance(5 * time.Minute)
+	if clock.Len() != 0 {
+		t.Errorf("%d timers left for a group without alerts", clock.Len())
+	}
+	log.Resolve("disk full")
+	if got := rec.messages(); !slices.Equal(got, []string{"disk full", "disk full resolved", "disk full resolved"}) {
+		t.Errorf("delivered %v", got)
+	}
+}
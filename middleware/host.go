@@ -0,0 +1,65 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"os"
+	"runtime/debug"
+)
+
+// Keys of the key/value pairs returned by HostValues.
+const (
+	HostKey        = "host"
+	PIDKey         = "pid"
+	VersionKey     = "version"
+	EnvironmentKey = "env"
+)
+
+// HostOptions carries parameters for HostValues.
+type HostOptions struct {
+	// Environment names the deployment, e.g. "production".  It is left
+	// out when empty.
+	Environment string
+
+	// Version is the version of the running binary.  Defaults to the
+	// version of the main module as recorded by the Go toolchain.
+	Version string
+}
+
+// HostValues returns key/value pairs describing the running process: its
+// hostname, PID, binary version and environment.  They are meant to be
+// passed to EnrichSink.
+func HostValues(opts HostOptions) []interface{} {
+	var kvs []interface{}
+	if host, err := os.Hostname(); err == nil {
+		kvs = append(kvs, HostKey, host)
+	}
+	kvs = append(kvs, PIDKey, os.Getpid())
+	version := opts.Version
+	if version == "" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			version = info.Main.Version
+		}
+	}
+	if version != "" {
+		kvs = append(kvs, VersionKey, version)
+	}
+	if opts.Environment != "" {
+		kvs = append(kvs, EnvironmentKey, opts.Environment)
+	}
+	return kvs
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// InhibitRule mutes target alerts while a source alert is firing, like the
+// inhibition rules of Alertmanager.
+type InhibitRule struct {
+	// Source matches the alerts which inhibit, e.g.
+	// matchers.MustParse(`alertname="datacenter-down"`).
+	Source matchers.Matchers
+
+	// Target matches the alerts which are inhibited.
+	Target matchers.Matchers
+
+	// Equal are labels which must have the same value in the source and
+	// the target alert, e.g. "datacenter", so that an outage only mutes
+	// alerts from where it happened.
+	Equal []string
+}
+
+// InhibitOptions carries parameters for InhibitSink.
+type InhibitOptions struct {
+	// Rules are the inhibition rules.
+	Rules []InhibitRule
+
+	// Expiry is the time after which a source alert which was neither
+	// raised again nor resolved stops inhibiting.  Zero keeps it
+	// inhibiting until it is resolved.
+	Expiry time.Duration
+
+	// Clock is used to expire source alerts.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// InhibitSink returns a Sink which drops the alerts matched by the Target
+// of a rule while an alert matched by its Source is firing, so that one
+// alert about a cause replaces many about its symptoms:
+//
+//	sink := middleware.InhibitSink(inner, middleware.InhibitOptions{
+//		Rules: []middleware.InhibitRule{{
+//			Source: matchers.MustParse(`alertname="datacenter-down"`),
+//			Target: matchers.MustParse(`severity!="critical"`),
+//			Equal:  []string{"datacenter"},
+//		}},
+//	})
+//
+// Labels are those of matchers.Labels.  Source alerts are passed on, and
+// fire from the time they pass until they are resolved; an alert never
+// inhibits itself.  Resolves are always passed on.
+func InhibitSink(inner alerter.Sink, opts InhibitOptions) alerter.Sink {
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	i := &inhibitor{inner: inner, opts: opts, firing: make([]map[string]*source, len(opts.Rules))}
+	for r := range i.firing {
+		i.firing[r] = map[string]*source{}
+	}
+	return wrap(inner, i.send)
+}
+
+type inhibitor struct {
+	inner alerter.Sink
+	opts  InhibitOptions
+
+	mu sync.Mutex
+	// firing holds the firing source alerts of every rule by fingerprint.
+	firing []map[string]*source
+}
+
+// source is a firing source alert.
+type source struct {
+	labels map[string]string
+	seen   time.Time
+}
+
+func (i *inhibitor) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	labels := matchers.Labels(a)
+	now := i.opts.Clock.Now()
+
+	i.mu.Lock()
+	if a.Resolved {
+		for _, firing := range i.firing {
+			delete(firing, fp)
+		}
+		i.mu.Unlock()
+		return alerter.Send(i.inner, a)
+	}
+	inhibited := false
+	for r := range i.opts.Rules {
+		rule := &i.opts.Rules[r]
+		if !inhibited && rule.Target.MatchLabels(labels) && i.inhibits(r, fp, labels, now) {
+			inhibited = true
+		}
+		if rule.Source.MatchLabels(labels) {
+			i.firing[r][fp] = &source{labels: labels, seen: now}
+		}
+	}
+	i.mu.Unlock()
+	if inhibited {
+		return nil
+	}
+	return alerter.Send(i.inner, a)
+}
+
+// inhibits reports whether a source alert of rule r other than fp, which
+// agrees on the Equal labels, is firing.  It must be called with i.mu held.
+func (i *inhibitor) inhibits(r int, fp string, labels map[string]string, now time.Time) bool {
+	for sfp, s := range i.firing[r] {
+		if i.opts.Expiry > 0 && now.Sub(s.seen) >= i.opts.Expiry {
+			delete(i.firing[r], sfp)
+			continue
+		}
+		if sfp == fp {
+			continue
+		}
+		equal := true
+		for _, l := range i.opts.Rules[r].Equal {
+			if s.labels[l] != labels[l] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			return true
+		}
+	}
+	return false
+}
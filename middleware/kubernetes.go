@@ -0,0 +1,154 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Keys of the key/value pairs returned by KubernetesValues, following the
+// OpenTelemetry resource conventions.
+const (
+	PodKey       = "k8s.pod.name"
+	NamespaceKey = "k8s.namespace.name"
+	NodeKey      = "k8s.node.name"
+	ContainerKey = "k8s.container.name"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubernetesOptions carries parameters for KubernetesValues.
+type KubernetesOptions struct {
+	// Container is the name of the container the process runs in.  The
+	// downward API cannot expose it, so it defaults to $CONTAINER_NAME.
+	Container string
+
+	// QueryAPI asks the API server, with the pod's service account, for
+	// the node when the downward API does not provide it.  The service
+	// account needs permission to get its own pod.
+	QueryAPI bool
+}
+
+// KubernetesValues returns key/value pairs locating the running process in
+// a Kubernetes cluster: pod, namespace, node and container.  They are meant
+// to be passed to EnrichSink.
+//
+// Values are read from the environment variables POD_NAME, POD_NAMESPACE and
+// NODE_NAME, which the downward API can provide:
+//
+//	env:
+//	- name: POD_NAME
+//	  valueFrom: {fieldRef: {fieldPath: metadata.name}}
+//	- name: POD_NAMESPACE
+//	  valueFrom: {fieldRef: {fieldPath: metadata.namespace}}
+//	- name: NODE_NAME
+//	  valueFrom: {fieldRef: {fieldPath: spec.nodeName}}
+//
+// Without them, the pod name falls back to the hostname and the namespace to
+// the one of the mounted service account.  Values which cannot be determined
+// are left out, so that KubernetesValues returns nothing outside a cluster.
+func KubernetesValues(ctx context.Context, opts KubernetesOptions) ([]interface{}, error) {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return nil, nil
+	}
+	pod := os.Getenv("POD_NAME")
+	if pod == "" {
+		pod, _ = os.Hostname()
+	}
+	namespace := os.Getenv("POD_NAMESPACE")
+	if namespace == "" {
+		if data, err := os.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	node := os.Getenv("NODE_NAME")
+	container := opts.Container
+	if container == "" {
+		container = os.Getenv("CONTAINER_NAME")
+	}
+
+	var err error
+	if node == "" && opts.QueryAPI && pod != "" && namespace != "" {
+		node, err = podNode(ctx, namespace, pod)
+	}
+
+	var kvs []interface{}
+	for _, kv := range [][2]string{
+		{PodKey, pod},
+		{NamespaceKey, namespace},
+		{NodeKey, node},
+		{ContainerKey, container},
+	} {
+		if kv[1] != "" {
+			kvs = append(kvs, kv[0], kv[1])
+		}
+	}
+	return kvs, err
+}
+
+// podNode asks the API server for the node a pod is scheduled on.
+func podNode(ctx context.Context, namespace, pod string) (string, error) {
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return "", err
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return "", err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return "", errors.New("kubernetes: invalid service account CA")
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+
+	host := net.JoinHostPort(os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT"))
+	url := "https://" + host + "/api/v1/namespaces/" + namespace + "/pods/" + pod
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("kubernetes: get pod %s/%s: %s", namespace, pod, resp.Status)
+	}
+	var p struct {
+		Spec struct {
+			NodeName string `json:"nodeName"`
+		} `json:"spec"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return "", err
+	}
+	return p.Spec.NodeName, nil
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/store"
+)
+
+// LeaderSink returns a Sink which passes alerts on to inner only while
+// leader is the leader, so that of several replicas raising the same
+// alerts, such as those of a shared queue or database, only one delivers
+// them:
+//
+//	s := store.NewRedis(store.RedisOptions{Addr: "redis:6379"})
+//	leader := store.NewLeader(s, store.LeaderOptions{Key: "billing/alerts"})
+//	defer leader.Close()
+//	sink := middleware.LeaderSink(inner, leader)
+//
+// Alerts, including resolves, raised by the other replicas are dropped, as
+// are alerts raised while no replica leads, i.e. for up to the TTL of the
+// lease after the leader died.
+func LeaderSink(inner alerter.Sink, leader *store.Leader) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		if !leader.IsLeader() {
+			return nil
+		}
+		return alerter.Send(inner, a)
+	})
+}
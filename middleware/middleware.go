@@ -0,0 +1,60 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package middleware implements Sinks which wrap other Sinks to enrich,
+// filter or reshape alerts on their way, so that such policies are set up
+// once where the Alerter is constructed:
+//
+//	sink := middleware.EnrichSink(file.New(w, 0).GetSink(),
+//		middleware.HostValues(middleware.HostOptions{Environment: "production"})...)
+//	alerter := alerter.New(sink)
+//
+// Middleware receive every alert as an assembled alerter.Alert and pass it
+// on with alerter.Send, so they can be stacked in any order.
+package middleware
+
+import (
+	"github.com/sumengzs/alerter"
+)
+
+// sink is the set of interfaces implemented by the Sinks wrap returns.
+// Middleware which return their own type embed it, so that those types keep
+// supporting resolves and assembled alerts.
+type sink interface {
+	alerter.Sink
+	alerter.Resolver
+	alerter.AlertSink
+	alerter.BatchSink
+}
+
+// wrap returns a Sink which hands every alert to send and is enabled
+// whenever inner is.
+func wrap(inner alerter.Sink, send alerter.SendFunc) alerter.Sink {
+	return alerter.NewSink(send, alerter.SinkOptions{Enabled: inner.Enabled})
+}
+
+// EnrichSink returns a Sink which adds keysAndValues to every alert before
+// passing it to inner.  They are added in front of the key/value pairs of
+// the call, which describe an alert, rather than to its values, which
+// identify it, so that enrichment which changes over time, such as a PID,
+// does not keep resolves from matching their alerts.
+func EnrichSink(inner alerter.Sink, keysAndValues ...interface{}) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		c := *a
+		c.KeysAndValues = append(keysAndValues[:len(keysAndValues):len(keysAndValues)], a.KeysAndValues...)
+		return alerter.Send(inner, &c)
+	})
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// epoch is the time ManualClocks of tests start at.
+var epoch = time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// recorder is a Sink which records the alerts delivered to it, and the
+// batches when used as an alerter.BatchSink.
+type recorder struct {
+	sink
+
+	mu      sync.Mutex
+	alerts  []*alerter.Alert
+	batches [][]*alerter.Alert
+	err     error
+}
+
+func newRecorder() *recorder {
+	r := &recorder{}
+	r.sink = alerter.NewSink(r.record, alerter.SinkOptions{SendBatch: r.recordBatch}).(sink)
+	return r
+}
+
+func (r *recorder) record(a *alerter.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func (r *recorder) recordBatch(alerts []*alerter.Alert) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, alerts)
+	r.alerts = append(r.alerts, alerts...)
+	return nil
+}
+
+// fail makes deliveries fail with err, or succeed again if err is nil.
+func (r *recorder) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+}
+
+// recorded returns the alerts delivered so far.
+func (r *recorder) recorded() []*alerter.Alert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*alerter.Alert(nil), r.alerts...)
+}
+
+// messages returns the messages of the alerts delivered so far, those of
+// resolves suffixed with " resolved".
+func (r *recorder) messages() []string {
+	return messages(r.recorded())
+}
+
+func messages(alerts []*alerter.Alert) []string {
+	msgs := make([]string, len(alerts))
+	for i, a := range alerts {
+		msgs[i] = a.Message
+		if a.Resolved {
+			msgs[i] += " resolved"
+		}
+	}
+	return msgs
+}
+
+// value returns the value of key in the key/value pairs of a.
+func value(a *alerter.Alert, key string) interface{} {
+	for _, f := range a.Fields() {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// eventually fails t unless cond holds within a second.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// errTemporary is a retryable delivery error.
+var errTemporary = errors.New("temporary failure")
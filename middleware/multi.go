@@ -0,0 +1,137 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/sumengzs/alerter"
+)
+
+// SuccessPolicy selects when a MultiSink reports an alert as delivered.
+type SuccessPolicy int
+
+const (
+	// RequireAll reports an error if any Sink failed.
+	RequireAll SuccessPolicy = iota
+
+	// RequireAny reports an error only if all Sinks failed.
+	RequireAny
+)
+
+// MultiOptions carries parameters which influence the way a MultiSink fans
+// out alerts.
+type MultiOptions struct {
+	// Require selects when an alert counts as delivered.  Defaults to
+	// RequireAll.
+	Require SuccessPolicy
+
+	// Parallel delivers to all Sinks at the same time rather than one
+	// after the other.
+	Parallel bool
+
+	// OnError is called for every Sink which failed, with its position.
+	OnError func(index int, a *alerter.Alert, err error)
+}
+
+// MultiSink returns a Sink which delivers every alert to all of sinks, so
+// that one alert can go to, say, Slack, Alertmanager and long-term storage.
+// Sinks are isolated from each other: one failing, or even panicking, does
+// not keep the alert from the others.  The errors of all failed Sinks are
+// reported together.
+func MultiSink(sinks ...alerter.Sink) alerter.Sink {
+	return MultiSinkWithOptions(MultiOptions{}, sinks...)
+}
+
+// MultiSinkWithOptions is like MultiSink, with more control over delivery.
+func MultiSinkWithOptions(opts MultiOptions, sinks ...alerter.Sink) alerter.Sink {
+	m := &multi{sinks: sinks, opts: opts}
+	return alerter.NewSink(m.send, alerter.SinkOptions{
+		Enabled:   m.enabled,
+		SendBatch: m.sendBatch,
+	})
+}
+
+type multi struct {
+	sinks []alerter.Sink
+	opts  MultiOptions
+}
+
+func (m *multi) enabled(level int) bool {
+	for _, s := range m.sinks {
+		if s.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multi) send(a *alerter.Alert) error {
+	return m.fanOut(func(s alerter.Sink) error { return alerter.Send(s, a) }, a)
+}
+
+func (m *multi) sendBatch(alerts []*alerter.Alert) error {
+	return m.fanOut(func(s alerter.Sink) error { return alerter.SendBatch(s, alerts) }, nil)
+}
+
+// fanOut calls deliver for every Sink and combines their errors according
+// to the success policy.  a is passed to OnError, and nil for batches.
+func (m *multi) fanOut(deliver func(s alerter.Sink) error, a *alerter.Alert) error {
+	errs := make([]error, len(m.sinks))
+	if m.opts.Parallel {
+		var wg sync.WaitGroup
+		wg.Add(len(m.sinks))
+		for i, s := range m.sinks {
+			go func(i int, s alerter.Sink) {
+				defer wg.Done()
+				errs[i] = isolate(deliver, s)
+			}(i, s)
+		}
+		wg.Wait()
+	} else {
+		for i, s := range m.sinks {
+			errs[i] = isolate(deliver, s)
+		}
+	}
+
+	var failed []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if m.opts.OnError != nil {
+			m.opts.OnError(i, a, err)
+		}
+		failed = append(failed, fmt.Errorf("sink %d: %w", i, err))
+	}
+	if len(failed) == 0 || m.opts.Require == RequireAny && len(failed) < len(m.sinks) {
+		return nil
+	}
+	return errors.Join(failed...)
+}
+
+// isolate calls deliver for s, turning a panic into an error.
+func isolate(deliver func(s alerter.Sink) error, s alerter.Sink) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return deliver(s)
+}
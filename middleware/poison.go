@@ -0,0 +1,178 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Keys of the key/value pairs PoisonSink attaches to the alerts it diverts
+// and to its meta-alerts.
+const (
+	// RejectionKey carries the error of the last delivery attempt.
+	RejectionKey = "rejection"
+
+	// AttemptsKey carries the number of failed delivery attempts.
+	AttemptsKey = "attempts"
+
+	// FingerprintKey carries the fingerprint of the poison alert in
+	// meta-alerts.
+	FingerprintKey = "fingerprint"
+)
+
+// PoisonOptions carries parameters which influence the way PoisonSink
+// detects poison alerts.
+type PoisonOptions struct {
+	// MaxFailures is the number of failed deliveries after which an alert
+	// is considered poison.  Defaults to 3.
+	MaxFailures int
+
+	// Window is the time after which the failures of an alert are
+	// forgotten.  Defaults to one hour.
+	Window time.Duration
+
+	// Retryable classifies errors.  Alerts failing with an error which is
+	// not retryable, such as a provider rejecting the payload, are poison
+	// right away.  Defaults to IsRetryable.
+	Retryable func(err error) bool
+
+	// DeadLetter receives the poison alerts, with the rejection reason as
+	// RejectionKey.  Poison alerts are dropped if nil.
+	DeadLetter alerter.Sink
+
+	// Notify receives a meta-alert for every poison alert.  Meta-alerts
+	// are not sent if nil.
+	Notify alerter.Sink
+
+	// Clock is used to expire failures.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// PoisonSink returns a Sink which keeps alerts which can never be delivered
+// from blocking the Sinks in front of it: once an alert with the same
+// fingerprint failed PoisonOptions.MaxFailures times, or failed with an
+// error which is not retryable, it is diverted to PoisonOptions.DeadLetter
+// and reported as delivered, so that RetrySink or a queue stops retrying
+// it.  It is placed between them and the backend:
+//
+//	RetrySink(PoisonSink(backend, opts), RetryOptions{})
+func PoisonSink(inner alerter.Sink, opts PoisonOptions) alerter.Sink {
+	if opts.MaxFailures <= 0 {
+		opts.MaxFailures = 3
+	}
+	if opts.Window <= 0 {
+		opts.Window = time.Hour
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	p := &poison{inner: inner, opts: opts, failures: map[string]*failures{}, nextSweep: 1024}
+	return wrap(inner, p.send)
+}
+
+type poison struct {
+	inner alerter.Sink
+	opts  PoisonOptions
+
+	mu        sync.Mutex
+	failures  map[string]*failures
+	nextSweep int
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+func (p *poison) send(a *alerter.Alert) error {
+	err := alerter.Send(p.inner, a)
+	fp := a.Fingerprint()
+	now := p.opts.Clock.Now()
+
+	p.mu.Lock()
+	if err == nil {
+		delete(p.failures, fp)
+		p.mu.Unlock()
+		return nil
+	}
+	f := p.failures[fp]
+	if f == nil || now.Sub(f.last) >= p.opts.Window {
+		p.sweep(now)
+		f = &failures{}
+		p.failures[fp] = f
+	}
+	f.count++
+	f.last = now
+	attempts := f.count
+	poisoned := attempts >= p.opts.MaxFailures || !p.opts.Retryable(err)
+	if poisoned {
+		delete(p.failures, fp)
+	}
+	p.mu.Unlock()
+
+	if !poisoned {
+		return err
+	}
+	p.divert(a, err, attempts)
+	return nil
+}
+
+// divert hands a poison alert to the dead-letter Sink and reports it.
+func (p *poison) divert(a *alerter.Alert, err error, attempts int) {
+	if p.opts.DeadLetter != nil {
+		c := *a
+		c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)],
+			RejectionKey, err.Error(), AttemptsKey, attempts)
+		_ = alerter.Send(p.opts.DeadLetter, &c)
+	}
+	if p.opts.Notify != nil {
+		_ = alerter.Send(p.opts.Notify, &alerter.Alert{
+			Time:     p.opts.Clock.Now(),
+			Message:  fmt.Sprintf("alert %q could not be delivered after %d attempts", a.Message, attempts),
+			Severity: alerter.SeverityWarning,
+			Err:      err,
+			KeysAndValues: []interface{}{
+				FingerprintKey, a.Fingerprint(),
+				AttemptsKey, attempts,
+			},
+		})
+	}
+}
+
+// sweep forgets the failures of alerts which did not fail within the
+// window, once there are many of them.  It must be called with p.mu held.
+func (p *poison) sweep(now time.Time) {
+	if len(p.failures) < p.nextSweep {
+		return
+	}
+	for fp, f := range p.failures {
+		if now.Sub(f.last) >= p.opts.Window {
+			delete(p.failures, fp)
+		}
+	}
+	p.nextSweep = 2 * len(p.failures)
+	if p.nextSweep < 1024 {
+		p.nextSweep = 1024
+	}
+}
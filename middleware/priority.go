@@ -0,0 +1,219 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// WithPriority makes the queue deliver alerts by severity, the highest
+// first and in order within a severity, so that a backlog of informational
+// alerts does not delay a page.  Alerts which waited for maxWait are
+// delivered first regardless of their severity, so that lower severities
+// are not starved; it defaults to 30 seconds if not positive.
+//
+// Resolves are queued with the lowest priority, so that they never overtake
+// their alert.  A full queue makes room for an alert by dropping the oldest
+// alert of the lowest severity below that of the new one, before applying
+// the overflow policy; with DropOldest, it drops the oldest alert of the
+// lowest severity in any case.  Only Block and Spill never drop queued
+// alerts.
+func WithPriority(maxWait time.Duration) AsyncOption {
+	if maxWait <= 0 {
+		maxWait = defaultMaxWait
+	}
+	return func(o *asyncOptions) { o.maxWait = maxWait }
+}
+
+// WithReserve keeps the last n places of the queue for alerts of at least
+// severity, so that a flood of lower alerts cannot take all of them.
+// Alerts below it, and resolves, find the queue full once only n places are
+// left, and the overflow policy applies to them.  This matters with Block
+// and Spill, which never drop queued alerts: without a reserve, a queue
+// full of warnings makes a page wait as well.  n is capped at the queue
+// size less one.
+//
+// WithReserve implies WithPriority, with a maxWait of 30 seconds unless
+// WithPriority sets another one.
+func WithReserve(severity alerter.Severity, n int) AsyncOption {
+	return func(o *asyncOptions) {
+		o.reserveFrom = severity
+		o.reserved = n
+	}
+}
+
+// defaultMaxWait is the maxWait of WithPriority if it is not positive.
+const defaultMaxWait = 30 * time.Second
+
+// priorityQueue is the queue of an Async with WithPriority.
+type priorityQueue struct {
+	size    int
+	maxWait time.Duration
+	clock   alerter.Clock
+
+	// reserved places are kept for the FIFOs from reserveFrom on.
+	reserved    int
+	reserveFrom int
+
+	mu       sync.Mutex
+	notEmpty sync.Cond
+	notFull  sync.Cond
+	// fifos holds the queued alerts by severity.
+	fifos  [alerter.SeverityCritical + 1][]prioritized
+	len    int
+	seq    uint64
+	closed bool
+}
+
+type prioritized struct {
+	alert  *alerter.Alert
+	seq    uint64
+	queued time.Time
+}
+
+func newPriorityQueue(size int, maxWait time.Duration, clock alerter.Clock, reserveFrom alerter.Severity, reserved int) *priorityQueue {
+	q := &priorityQueue{size: size, maxWait: maxWait, clock: clock}
+	if reserved > 0 {
+		q.reserved = min(reserved, size-1)
+		q.reserveFrom = priority(&alerter.Alert{Severity: reserveFrom})
+	}
+	q.notEmpty.L = &q.mu
+	q.notFull.L = &q.mu
+	return q
+}
+
+// priority returns the FIFO of a.
+func priority(a *alerter.Alert) int {
+	switch {
+	case a.Resolved || a.Severity < alerter.SeverityInfo:
+		return int(alerter.SeverityInfo)
+	case a.Severity > alerter.SeverityCritical:
+		return int(alerter.SeverityCritical)
+	}
+	return int(a.Severity)
+}
+
+// push queues a according to overflow.  It reports whether a was queued
+// and returns the alert dropped to make room for it, if any.
+func (q *priorityQueue) push(a *alerter.Alert, overflow OverflowPolicy) (bool, *alerter.Alert) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var dropped *alerter.Alert
+	p := priority(a)
+	for q.len >= q.capacity(p) {
+		below := p
+		switch overflow {
+		case Block:
+			q.notFull.Wait()
+			continue
+		case Spill:
+			return false, nil
+		case DropOldest:
+			below = len(q.fifos)
+		}
+		if lowest := q.lowest(below); lowest >= 0 {
+			dropped = q.fifos[lowest][0].alert
+			q.remove(lowest)
+			continue
+		}
+		return false, nil
+	}
+	q.seq++
+	q.fifos[p] = append(q.fifos[p], prioritized{alert: a, seq: q.seq, queued: q.clock.Now()})
+	q.len++
+	q.notEmpty.Signal()
+	return true, dropped
+}
+
+// capacity returns the number of places alerts of the FIFO p may fill.
+func (q *priorityQueue) capacity(p int) int {
+	if p < q.reserveFrom {
+		return q.size - q.reserved
+	}
+	return q.size
+}
+
+// pop waits for an alert and returns it, or false once the queue is closed
+// and empty.
+func (q *priorityQueue) pop() (*alerter.Alert, func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.len == 0 {
+		if q.closed {
+			return nil, nil, false
+		}
+		q.notEmpty.Wait()
+	}
+	// The oldest alert goes first if it waited too long, otherwise the
+	// oldest of the highest severity.
+	oldest, highest := -1, -1
+	for p := range q.fifos {
+		if len(q.fifos[p]) == 0 {
+			continue
+		}
+		if oldest < 0 || q.fifos[p][0].seq < q.fifos[oldest][0].seq {
+			oldest = p
+		}
+		highest = p
+	}
+	p := highest
+	if q.clock.Now().Sub(q.fifos[oldest][0].queued) >= q.maxWait {
+		p = oldest
+	}
+	a := q.fifos[p][0].alert
+	q.remove(p)
+	return a, nil, true
+}
+
+// lowest returns the lowest non-empty FIFO below below, or -1 if there is
+// none.  It must be called with q.mu held.
+func (q *priorityQueue) lowest(below int) int {
+	for p := 0; p < below; p++ {
+		if len(q.fifos[p]) > 0 {
+			return p
+		}
+	}
+	return -1
+}
+
+// remove removes the first alert of the FIFO p.  It must be called with
+// q.mu held.
+func (q *priorityQueue) remove(p int) {
+	q.fifos[p][0] = prioritized{}
+	q.fifos[p] = q.fifos[p][1:]
+	q.len--
+	// Waiters may be below the reserve and unable to use the place, so
+	// all of them are woken.
+	q.notFull.Broadcast()
+}
+
+func (q *priorityQueue) length() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.len
+}
+
+// close makes pop return false once the queue is empty.
+func (q *priorityQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// QuietHoursOptions carries parameters for QuietHoursSink.
+type QuietHoursOptions struct {
+	// Quiet is the schedule of quiet hours, e.g. nights, weekends and
+	// holidays.
+	Quiet *Schedule
+
+	// MinSeverity is the lowest severity still delivered during quiet
+	// hours.  Defaults to alerter.SeverityCritical.
+	MinSeverity alerter.Severity
+
+	// Divert receives the alerts held back during quiet hours, e.g. an
+	// email sink read in the morning.  They are dropped if nil.
+	Divert alerter.Sink
+
+	// Clock tells the time of alerts which carry none.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// QuietHoursSink returns a Sink which, during quiet hours, passes only
+// alerts of at least opts.MinSeverity on to inner and diverts the others,
+// so that only critical alerts page at night:
+//
+//	sink := middleware.QuietHoursSink(pager, middleware.QuietHoursOptions{
+//		Quiet:  offHours,
+//		Divert: email,
+//	})
+//
+// Alerts are judged by the time they were raised at.  Resolves go to both
+// inner and opts.Divert, as their alert may have gone to either.  Per-route
+// schedules are set with Route.Schedule.
+func QuietHoursSink(inner alerter.Sink, opts QuietHoursOptions) alerter.Sink {
+	if opts.MinSeverity == alerter.SeverityInfo {
+		opts.MinSeverity = alerter.SeverityCritical
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	return wrap(inner, func(a *alerter.Alert) error {
+		if a.Resolved {
+			err := alerter.Send(inner, a)
+			if opts.Divert != nil {
+				err = errors.Join(err, alerter.Send(opts.Divert, a))
+			}
+			return err
+		}
+		if a.Severity >= opts.MinSeverity || opts.Quiet == nil || !opts.Quiet.Contains(alertTime(a, opts.Clock)) {
+			return alerter.Send(inner, a)
+		}
+		if opts.Divert != nil {
+			return alerter.Send(opts.Divert, a)
+		}
+		return nil
+	})
+}
+
+// alertTime returns the time a was raised at, or the time of clock if it
+// carries none.
+func alertTime(a *alerter.Alert, clock alerter.Clock) time.Time {
+	if a.Time.IsZero() {
+		return clock.Now()
+	}
+	return a.Time
+}
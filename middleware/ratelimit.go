@@ -0,0 +1,195 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// DroppedKey is the key under which RateLimitSink attaches the number of
+// alerts with the same fingerprint it dropped before the current one.
+const DroppedKey = "dropped"
+
+// RateLimitOptions carries parameters which influence the way RateLimitSink
+// limits alerts.  Rates are in alerts per second; a zero rate is not
+// enforced.
+type RateLimitOptions struct {
+	// Rate is the sustained rate of alerts per fingerprint.
+	Rate float64
+
+	// Burst is the number of alerts per fingerprint which may be sent at
+	// once.  Defaults to Rate rounded up, and at least 1.
+	Burst int
+
+	// GlobalRate is the sustained rate of all alerts together.
+	GlobalRate float64
+
+	// GlobalBurst is the number of alerts which may be sent at once.
+	// Defaults to GlobalRate rounded up, and at least 1.
+	GlobalBurst int
+
+	// OnDrop is called with every alert which exceeded a limit.
+	OnDrop func(a *alerter.Alert)
+
+	// Clock is used to refill the buckets.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// RateLimitSink returns a Sink which limits the alerts passed to inner with
+// a token bucket per fingerprint and one for all alerts together, so that a
+// crash loop raising the same alert thousands of times cannot flood
+// downstream channels.  The next alert passing for a fingerprint carries
+// the number of its alerts which were dropped as DroppedKey.
+//
+// Resolves are neither limited nor counted, as dropping one would leave
+// its alert open downstream.
+func RateLimitSink(inner alerter.Sink, opts RateLimitOptions) alerter.Sink {
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	r := &rateLimit{
+		inner:     inner,
+		opts:      opts,
+		buckets:   map[string]*bucket{},
+		nextSweep: 1024,
+	}
+	if opts.GlobalRate > 0 {
+		r.global = newBucket(opts.GlobalRate, opts.GlobalBurst, opts.Clock.Now())
+	}
+	return wrap(inner, r.send)
+}
+
+type rateLimit struct {
+	inner alerter.Sink
+	opts  RateLimitOptions
+
+	mu        sync.Mutex
+	global    *bucket
+	buckets   map[string]*bucket
+	nextSweep int
+}
+
+func (r *rateLimit) send(a *alerter.Alert) error {
+	if a.Resolved {
+		return alerter.Send(r.inner, a)
+	}
+	now := r.opts.Clock.Now()
+	fp := a.Fingerprint()
+
+	r.mu.Lock()
+	var b *bucket
+	if r.opts.Rate > 0 {
+		if b = r.buckets[fp]; b == nil {
+			r.sweep(now)
+			b = newBucket(r.opts.Rate, r.opts.Burst, now)
+			r.buckets[fp] = b
+		}
+	}
+	// Only take a global token when the fingerprint has one, so that a
+	// single noisy alert does not use up the global budget.
+	ok := b == nil || b.available(now)
+	if ok && r.global != nil {
+		ok = r.global.take(now)
+	}
+	if ok && b != nil {
+		b.take(now)
+	}
+	var dropped int
+	if b != nil {
+		if ok {
+			dropped, b.dropped = b.dropped, 0
+		} else {
+			b.dropped++
+		}
+	}
+	r.mu.Unlock()
+
+	if !ok {
+		if r.opts.OnDrop != nil {
+			r.opts.OnDrop(a)
+		}
+		return nil
+	}
+	if dropped > 0 {
+		c := *a
+		c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)], DroppedKey, dropped)
+		a = &c
+	}
+	return alerter.Send(r.inner, a)
+}
+
+// sweep forgets buckets which are full again, and thus behave like new
+// ones, once there are many of them.  It must be called with r.mu held.
+func (r *rateLimit) sweep(now time.Time) {
+	if len(r.buckets) < r.nextSweep {
+		return
+	}
+	for fp, b := range r.buckets {
+		b.refill(now)
+		if b.tokens >= b.burst && b.dropped == 0 {
+			delete(r.buckets, fp)
+		}
+	}
+	r.nextSweep = 2 * len(r.buckets)
+	if r.nextSweep < 1024 {
+		r.nextSweep = 1024
+	}
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return &bucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed*b.rate)
+	}
+	b.last = now
+}
+
+func (b *bucket) available(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= 1
+}
+
+func (b *bucket) take(now time.Time) bool {
+	if !b.available(now) {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Scrubber replaces sensitive parts of strings, such as email addresses.
+type Scrubber struct {
+	// Pattern matches the sensitive parts.
+	Pattern *regexp.Regexp
+
+	// Valid, if set, confirms matches, e.g. with a checksum, so that only
+	// those are replaced.
+	Valid func(match string) bool
+
+	// Replacement replaces the matches.  Defaults to the Replacement of
+	// the RedactOptions.
+	Replacement string
+}
+
+// scrub returns s with the matches of the scrubber replaced.
+func (sc *Scrubber) scrub(s, replacement string) string {
+	if sc.Replacement != "" {
+		replacement = sc.Replacement
+	}
+	return sc.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+		if sc.Valid != nil && !sc.Valid(match) {
+			return match
+		}
+		return replacement
+	})
+}
+
+// Built-in scrubbers.
+var (
+	// EmailScrubber replaces email addresses.
+	EmailScrubber = Scrubber{
+		Pattern: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	}
+
+	// TokenScrubber replaces bearer tokens, JWTs and the access keys of
+	// AWS, GitHub and Slack.
+	TokenScrubber = Scrubber{
+		Pattern: regexp.MustCompile(`(?i:bearer\s+[A-Za-z0-9._~+/-]+=*)` +
+			`|\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+` +
+			`|\b(?:AKIA|ASIA)[0-9A-Z]{16}\b` +
+			`|\bgh[pousr]_[A-Za-z0-9]{36,}\b` +
+			`|\bxox[abprs]-[A-Za-z0-9-]{10,}`),
+	}
+
+	// CreditCardScrubber replaces credit card numbers, optionally
+	// grouped with spaces or dashes, which pass the Luhn check.
+	CreditCardScrubber = Scrubber{
+		Pattern: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`),
+		Valid:   luhn,
+	}
+)
+
+// DefaultScrubbers are the scrubbers of RedactOptions which set none.
+var DefaultScrubbers = []Scrubber{EmailScrubber, TokenScrubber, CreditCardScrubber}
+
+// RedactOptions carries parameters which influence the way RedactSink
+// redacts alerts.  Key patterns are globs as for Route.NameGlob, matched
+// case-insensitively.
+type RedactOptions struct {
+	// Keys are the patterns of keys whose values are replaced as a whole,
+	// e.g. "password", "*token*" or "*_secret".
+	Keys []string
+
+	// Scrubbers replace sensitive parts of the message, the error and all
+	// other values.  Defaults to DefaultScrubbers; an empty, non-nil list
+	// disables scrubbing.
+	Scrubbers []Scrubber
+
+	// Strict drops all keys which do not match Allowed, so that new
+	// fields do not reach sinks before they were reviewed.
+	Strict bool
+
+	// Allowed are the patterns of keys passed on in strict mode.
+	Allowed []string
+
+	// Replacement replaces redacted values.  Defaults to "[REDACTED]".
+	Replacement string
+}
+
+// RedactSink returns a Sink which removes sensitive data from alerts before
+// passing them on to inner, so that it is set up once in front of every
+// sink instead of being left to the code raising alerts:
+//
+//	sink := middleware.RedactSink(inner, middleware.RedactOptions{
+//		Keys: []string{"password", "*token*", "authorization"},
+//	})
+//
+// Values are scrubbed if they are strings, errors or implement
+// fmt.Stringer, in which case they are replaced by their scrubbed text;
+// values of other types are passed on unchanged unless their key is
+// redacted.  As alerts and their resolves are redacted the same way, their
+// fingerprints still match downstream.
+func RedactSink(inner alerter.Sink, opts RedactOptions) alerter.Sink {
+	if opts.Scrubbers == nil {
+		opts.Scrubbers = DefaultScrubbers
+	}
+	if opts.Replacement == "" {
+		opts.Replacement = "[REDACTED]"
+	}
+	r := &redactor{opts: opts, keys: compileKeyGlobs(opts.Keys), allowed: compileKeyGlobs(opts.Allowed)}
+	return wrap(inner, func(a *alerter.Alert) error {
+		return alerter.Send(inner, r.redact(a))
+	})
+}
+
+type redactor struct {
+	opts          RedactOptions
+	keys, allowed []*regexp.Regexp
+}
+
+// redact returns a redacted copy of a.
+func (r *redactor) redact(a *alerter.Alert) *alerter.Alert {
+	c := *a
+	c.Message = r.scrub(a.Message)
+	if a.Err != nil {
+		c.Err = redactedError(r.scrub(a.Err.Error()))
+	}
+	c.Values = r.redactKeysAndValues(a.Values)
+	c.KeysAndValues = r.redactKeysAndValues(a.KeysAndValues)
+	return &c
+}
+
+func (r *redactor) redactKeysAndValues(kvs []interface{}) []interface{} {
+	if len(kvs) == 0 {
+		return kvs
+	}
+	out := make([]interface{}, 0, len(kvs))
+	for i := 0; i < len(kvs); i += 2 {
+		key, _ := kvs[i].(string)
+		if r.opts.Strict && !matchesAny(r.allowed, key) {
+			continue
+		}
+		if i+1 == len(kvs) {
+			out = append(out, kvs[i])
+			break
+		}
+		var value interface{}
+		switch v := kvs[i+1].(type) {
+		case string:
+			value = r.scrub(v)
+		case error:
+			value = r.scrub(v.Error())
+		case fmt.Stringer:
+			value = r.scrub(v.String())
+		default:
+			value = v
+		}
+		if matchesAny(r.keys, key) {
+			value = r.opts.Replacement
+		}
+		out = append(out, kvs[i], value)
+	}
+	return out
+}
+
+func (r *redactor) scrub(s string) string {
+	for i := range r.opts.Scrubbers {
+		s = r.opts.Scrubbers[i].scrub(s, r.opts.Replacement)
+	}
+	return s
+}
+
+// redactedError is the scrubbed text of an error.  It does not wrap the
+// original, which would let its text be recovered.
+type redactedError string
+
+func (e redactedError) Error() string {
+	return string(e)
+}
+
+// compileKeyGlobs compiles key patterns for matching lower-cased keys.
+func compileKeyGlobs(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, len(patterns))
+	for i, p := range patterns {
+		res[i] = compileGlob(strings.ToLower(p))
+	}
+	return res
+}
+
+// matchesAny reports whether key matches one of the compiled patterns.
+func matchesAny(patterns []*regexp.Regexp, key string) bool {
+	key = strings.ToLower(key)
+	for _, re := range patterns {
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits of s pass the Luhn check.
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/store"
+)
+
+// ReminderKey is the key under which Reminders attaches the number of the
+// reminder, starting at 1, to the alerts it re-sends.
+const ReminderKey = "reminder"
+
+// ReminderOptions carries parameters for ReminderSink.
+type ReminderOptions struct {
+	// Interval is the time after which a firing alert which was neither
+	// acknowledged nor resolved is sent again.  Defaults to 4 hours.
+	Interval time.Duration
+
+	// MaxReminders is the number of reminders sent for an alert.  Zero
+	// keeps reminding until it is acknowledged or resolved.
+	MaxReminders int
+
+	// Escalate raises the severity of every reminder by one over the
+	// previous one, up to alerter.SeverityCritical.
+	Escalate bool
+
+	// OnError is called with the reminders which could not be delivered.
+	OnError func(a *alerter.Alert, err error)
+
+	// Clock tells the time of reminders and when they are due.
+	// Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+
+	// Acks, if its Store is set, shares acknowledgements with the
+	// replicas using the same Store, so that an alert acknowledged
+	// through any of them is no longer reminded of by the others.
+	// Errors of the Store are passed to OnError with a nil alert, and
+	// reminders go on.
+	Acks store.Acks
+}
+
+// reminderLimit bounds the number of alerts Reminders keeps track of.
+const reminderLimit = 4096
+
+// Reminders is a Sink which passes alerts on to its inner Sink and re-sends
+// those still firing at an interval, so that an alert nobody acted upon is
+// not forgotten.  Reminders carry their number as ReminderKey and the
+// fingerprint of their alert as FingerprintKey, with which recipients stop
+// them through Acknowledge.
+//
+// An alert raised again restarts the interval, as it was just delivered.
+// Resolving an alert stops its reminders, and a later occurrence starts
+// over.  Reminders still pending are abandoned by Close.
+//
+// At most 4096 alerts are kept track of: once as many are, those which are
+// no longer reminded of and were last raised more than an Interval ago are
+// forgotten, or else the one raised longest ago, so that alerts which are
+// never resolved do not pile up.
+type Reminders struct {
+	sink
+
+	inner alerter.Sink
+	opts  ReminderOptions
+
+	mu     sync.Mutex
+	firing map[string]*reminder
+	closed bool
+}
+
+// reminder is the state of a firing alert.
+type reminder struct {
+	alert *alerter.Alert
+	// seen is when the alert was last raised.
+	seen time.Time
+	sent int
+	// timer sends the next reminder; it is nil once the alert was
+	// acknowledged or all reminders were sent.  gen tells the timers
+	// apart.
+	timer alerter.Timer
+	gen   int
+}
+
+// ReminderSink returns a Reminders delivering to inner.
+func ReminderSink(inner alerter.Sink, opts ReminderOptions) *Reminders {
+	if opts.Interval <= 0 {
+		opts.Interval = 4 * time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	r := &Reminders{inner: inner, opts: opts, firing: map[string]*reminder{}}
+	r.sink = wrap(inner, r.send).(sink)
+	return r
+}
+
+func (r *Reminders) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	r.mu.Lock()
+	rem := r.firing[fp]
+	switch {
+	case a.Resolved:
+		if rem != nil {
+			rem.stop()
+			delete(r.firing, fp)
+		}
+	case r.closed:
+	case rem == nil:
+		r.forget()
+		rem = &reminder{alert: a, seen: r.opts.Clock.Now()}
+		r.firing[fp] = rem
+		r.schedule(fp, rem)
+	default:
+		rem.alert, rem.seen = a, r.opts.Clock.Now()
+		if rem.timer != nil {
+			rem.stop()
+			r.schedule(fp, rem)
+		}
+	}
+	r.mu.Unlock()
+	if a.Resolved {
+		r.storeError(r.opts.Acks.Clear(context.Background(), fp))
+	}
+	return alerter.Send(r.inner, a)
+}
+
+// schedule starts the timer for the next reminder of rem, if any is left.
+// It must be called with r.mu held.
+func (r *Reminders) schedule(fp string, rem *reminder) {
+	if r.opts.MaxReminders > 0 && rem.sent >= r.opts.MaxReminders {
+		return
+	}
+	rem.gen++
+	gen := rem.gen
+	rem.timer = alerter.AfterFunc(r.opts.Clock, r.opts.Interval, func() { r.remind(fp, rem, gen) })
+}
+
+// forget makes room for a new alert once reminderLimit alerts are kept
+// track of.  It must be called with r.mu held.
+func (r *Reminders) forget() {
+	if len(r.firing) < reminderLimit {
+		return
+	}
+	stale := r.opts.Clock.Now().Add(-r.opts.Interval)
+	var oldest string
+	for fp, rem := range r.firing {
+		if rem.timer == nil && rem.seen.Before(stale) {
+			delete(r.firing, fp)
+			continue
+		}
+		if oldest == "" || rem.seen.Before(r.firing[oldest].seen) {
+			oldest = fp
+		}
+	}
+	if len(r.firing) >= reminderLimit {
+		r.firing[oldest].stop()
+		delete(r.firing, oldest)
+	}
+}
+
+// remind re-sends the alert of rem, unless it was acknowledged, resolved
+// or raised again since the timer of gen was started.
+func (r *Reminders) remind(fp string, rem *reminder, gen int) {
+	_, acked, err := r.opts.Acks.Acknowledged(context.Background(), fp)
+	r.storeError(err)
+	r.mu.Lock()
+	if r.firing[fp] != rem || rem.timer == nil || rem.gen != gen {
+		r.mu.Unlock()
+		return
+	}
+	if acked {
+		rem.stop()
+		r.mu.Unlock()
+		return
+	}
+	rem.sent++
+	rem.timer = nil
+	c := *rem.alert
+	c.Time = r.opts.Clock.Now()
+	c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)],
+		FingerprintKey, fp, ReminderKey, rem.sent)
+	if r.opts.Escalate {
+		c.Severity += alerter.Severity(rem.sent)
+		if c.Severity > alerter.SeverityCritical {
+			c.Severity = alerter.SeverityCritical
+		}
+	}
+	r.schedule(fp, rem)
+	r.mu.Unlock()
+	if err := alerter.Send(r.inner, &c); err != nil && r.opts.OnError != nil {
+		r.opts.OnError(&c, err)
+	}
+}
+
+// Acknowledge stops the reminders of the alert with the given fingerprint,
+// as attached to them under FingerprintKey, until it is resolved, and
+// records the acknowledgement in ReminderOptions.Acks.  It reports whether
+// reminders were pending in this process.
+func (r *Reminders) Acknowledge(fingerprint string) bool {
+	r.storeError(r.opts.Acks.Acknowledge(context.Background(), fingerprint, ""))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rem := r.firing[fingerprint]
+	if rem == nil || rem.timer == nil {
+		return false
+	}
+	rem.stop()
+	return true
+}
+
+// AcknowledgeMatching acknowledges all alerts matching ms whose reminders are
+// pending, e.g. all alerts of a team during a large incident, and returns
+// their fingerprints in order.  With dryRun, it only returns them.
+func (r *Reminders) AcknowledgeMatching(ms matchers.Matchers, dryRun bool) []string {
+	r.mu.Lock()
+	var fps []string
+	for fp, rem := range r.firing {
+		if rem.timer == nil || !ms.Matches(rem.alert) {
+			continue
+		}
+		if !dryRun {
+			rem.stop()
+		}
+		fps = append(fps, fp)
+	}
+	r.mu.Unlock()
+	sort.Strings(fps)
+	if !dryRun {
+		for _, fp := range fps {
+			r.storeError(r.opts.Acks.Acknowledge(context.Background(), fp, ""))
+		}
+	}
+	return fps
+}
+
+// storeError passes an error of ReminderOptions.Acks to OnError.
+func (r *Reminders) storeError(err error) {
+	if err != nil && r.opts.OnError != nil {
+		r.opts.OnError(nil, err)
+	}
+}
+
+// Close stops all pending reminders.  Alerts raised afterwards are passed
+// on without reminders.
+func (r *Reminders) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	for _, rem := range r.firing {
+		rem.stop()
+	}
+	return nil
+}
+
+// stop cancels the next reminder.
+func (rem *reminder) stop() {
+	if rem.timer != nil {
+		rem.timer.Stop()
+		rem.timer = nil
+	}
+}
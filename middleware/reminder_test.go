@@ -0,0 +1,58 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestRemindersLimit(t *testing.T) {
+	clock := alerter.NewManualClock(epoch)
+	r := ReminderSink(newRecorder(), ReminderOptions{Interval: time.Minute, MaxReminders: 1, Clock: clock})
+	log := alerter.New(r)
+	raise := func(from, to int) {
+		for i := from; i < to; i++ {
+			clock.Advance(time.Millisecond)
+			log.WithName(strconv.Itoa(i)).Error(nil, "down")
+		}
+	}
+	tracked := func() int {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return len(r.firing)
+	}
+
+	raise(0, reminderLimit)
+	raise(reminderLimit, reminderLimit+1)
+	if n := tracked(); n != reminderLimit {
+		t.Fatalf("%d alerts tracked, want %d", n, reminderLimit)
+	}
+	if n := clock.Len(); n != reminderLimit {
+		t.Errorf("%d reminders pending, want %d", n, reminderLimit)
+	}
+
+	// Once reminded of, the alerts are forgotten an Interval later.
+	clock.Advance(2 * time.Minute)
+	raise(reminderLimit+1, reminderLimit+2)
+	if n := tracked(); n != 1 {
+		t.Errorf("%d alerts tracked after the sweep, want 1", n)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// RetryableError is an optional interface that errors returned by sinks may
+// implement to tell RetrySink whether delivering again could succeed, e.g.
+// true for HTTP 5xx responses and false for 4xx ones.
+type RetryableError interface {
+	error
+	Retryable() bool
+}
+
+// IsRetryable reports whether a delivery which failed with err should be
+// retried.  Errors implementing RetryableError anywhere in their chain are
+// asked; all other errors, including timeouts and connection failures, are
+// retried.
+func IsRetryable(err error) bool {
+	var r RetryableError
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return true
+}
+
+// RetryOptions carries parameters which influence the way RetrySink retries
+// deliveries.
+type RetryOptions struct {
+	// MaxAttempts is the number of deliveries attempted per alert,
+	// including the first one.  Defaults to 5.
+	MaxAttempts int
+
+	// InitialBackoff is the wait before the first retry.  Defaults to
+	// 500 milliseconds.
+	InitialBackoff time.Duration
+
+	// MaxBackoff caps the wait between two attempts.  Defaults to 30
+	// seconds.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor by which the wait grows after every
+	// attempt.  Defaults to 2.
+	Multiplier float64
+
+	// Jitter is the fraction by which every wait is randomly shortened or
+	// lengthened, so that many senders do not retry in lockstep.
+	// Defaults to 0.2; a negative value disables jitter.
+	Jitter float64
+
+	// Retryable classifies errors.  Defaults to IsRetryable.
+	Retryable func(err error) bool
+
+	// Clock times the waits between attempts.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// RetrySink returns a Sink which retries failed deliveries to inner with
+// exponential backoff as long as their errors are retryable.  It blocks the
+// caller while waiting, so it is normally placed behind AsyncSink.
+func RetrySink(inner alerter.Sink, opts RetryOptions) alerter.Sink {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.InitialBackoff <= 0 {
+		opts.InitialBackoff = 500 * time.Millisecond
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = 30 * time.Second
+	}
+	if opts.Multiplier < 1 {
+		opts.Multiplier = 2
+	}
+	if opts.Jitter == 0 {
+		opts.Jitter = 0.2
+	}
+	if opts.Retryable == nil {
+		opts.Retryable = IsRetryable
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	return wrap(inner, func(a *alerter.Alert) error {
+		backoff := opts.InitialBackoff
+		for attempt := 1; ; attempt++ {
+			err := alerter.Send(inner, a)
+			if err == nil || attempt >= opts.MaxAttempts || !opts.Retryable(err) {
+				return err
+			}
+			alerter.Sleep(opts.Clock, jitter(backoff, opts.Jitter))
+			backoff = time.Duration(float64(backoff) * opts.Multiplier)
+			if backoff > opts.MaxBackoff {
+				backoff = opts.MaxBackoff
+			}
+		}
+	})
+}
+
+// jitter returns d randomly changed by up to the fraction f.
+func jitter(d time.Duration, f float64) time.Duration {
+	if f <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 + f*(2*rand.Float64()-1)))
+}
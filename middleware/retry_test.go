@@ -0,0 +1,66 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestRetryWaitsOnTheClock(t *testing.T) {
+	rec := newRecorder()
+	rec.fail(errTemporary)
+	clock := alerter.NewManualClock(epoch)
+	s := RetrySink(rec, RetryOptions{MaxAttempts: 3, InitialBackoff: time.Second, Jitter: -1, Clock: clock})
+	sent := make(chan error, 1)
+	go func() { sent <- alerter.Send(s, &alerter.Alert{Message: "a", Severity: alerter.SeverityError}) }()
+
+	eventually(t, func() bool { return clock.Len() == 1 })
+	clock.Advance(time.Second)
+	// The wait doubles before the last attempt, which succeeds.
+	eventually(t, func() bool { return clock.Len() == 1 })
+	rec.fail(nil)
+	clock.Advance(time.Second)
+	select {
+	case <-sent:
+		t.Fatal("retried before the backoff passed")
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-sent; err != nil {
+		t.Fatalf("Send returned %v", err)
+	}
+	if n := len(rec.recorded()); n != 1 {
+		t.Errorf("delivered %d alerts, want 1", n)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	rec := newRecorder()
+	rec.fail(errTemporary)
+	clock := alerter.NewManualClock(epoch)
+	s := RetrySink(rec, RetryOptions{MaxAttempts: 2, Clock: clock})
+	sent := make(chan error, 1)
+	go func() { sent <- alerter.Send(s, &alerter.Alert{Message: "a", Severity: alerter.SeverityError}) }()
+	eventually(t, func() bool { return clock.Len() == 1 })
+	clock.Advance(time.Minute)
+	if err := <-sent; err != errTemporary {
+		t.Errorf("Send returned %v after the last attempt, want %v", err, errTemporary)
+	}
+}
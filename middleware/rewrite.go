@@ -0,0 +1,49 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/expr"
+)
+
+// RewriteErrorKey is the key under which RewriteSink attaches the error of a
+// program which failed on an alert.
+const RewriteErrorKey = "rewrite.error"
+
+// RewriteSink returns a Sink which rewrites every alert with p, a program
+// of the JQ-like language of package expr, before passing it to inner:
+//
+//	p, err := expr.Compile(`.kv.service = .name | del(.kv.request_body)`)
+//	...
+//	sink := middleware.RewriteSink(slack, p)
+//
+// Wrapping the Sinks of different routes with different programs adapts
+// alerts to what each provider expects.  Alerts the program fails on, e.g.
+// because it assigns an unknown severity, are passed on unchanged with the
+// error attached as RewriteErrorKey rather than lost.
+func RewriteSink(inner alerter.Sink, p *expr.Program) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		c, err := p.Apply(a)
+		if err != nil {
+			c = &alerter.Alert{}
+			*c = *a
+			c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)], RewriteErrorKey, err.Error())
+		}
+		return alerter.Send(inner, c)
+	})
+}
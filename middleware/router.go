@@ -0,0 +1,279 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// Route selects the alerts a RouterSink sends to a Sink.  An alert matches
+// a route if it matches all of the conditions which are set.
+type Route struct {
+	// MinSeverity is the lowest severity which matches.
+	MinSeverity alerter.Severity
+
+	// NamePrefix matches alerts whose name starts with it, e.g.
+	// "controllers/" or "billing".
+	NamePrefix string
+
+	// NameGlob matches alerts whose whole name matches the pattern, in
+	// which "*" stands for any part of a single name element, "**" for
+	// any number of elements and "?" for a single character, e.g.
+	// "controllers/*/reconciler" or "storage/**".
+	NameGlob string
+
+	// NameRegexp matches alerts whose name it matches, anywhere unless
+	// anchored.
+	NameRegexp *regexp.Regexp
+
+	// Labels matches alerts which have all of these keys, among their
+	// values or the key/value pairs of the call, with values which
+	// format to the given strings.
+	Labels map[string]string
+
+	// Matchers matches alerts whose labels match all of them, e.g.
+	// matchers.MustParse(`team=~"db|storage", env!="dev"`).
+	Matchers matchers.Matchers
+
+	// MinLevel and MaxLevel bound the V-levels which match.  A MaxLevel
+	// of zero does not limit levels; Match can select V-level 0 only.
+	MinLevel, MaxLevel int
+
+	// Schedule matches alerts raised at times within it, e.g. to send
+	// warnings to email at night and to chat during the day.
+	Schedule *Schedule
+
+	// Match, if set, is called for alerts matching the other conditions
+	// and decides.
+	Match func(a *alerter.Alert) bool
+
+	// Sink receives the matching alerts.  They are dropped if nil, e.g. to
+	// silence some alerts ahead of broader routes.
+	Sink alerter.Sink
+
+	// Fields, if set, selects the keys of the alerts passed on to Sink,
+	// e.g. to send request bodies to the internal store only.
+	Fields *FieldFilter
+
+	// Continue makes alerts which match the route also go on to the
+	// following routes, instead of stopping at the first match.
+	Continue bool
+}
+
+// RouterOptions carries the routes of a RouterSink.
+type RouterOptions struct {
+	// Routes are tried in order.
+	Routes []Route
+
+	// Default receives the alerts which match no route.  They are
+	// dropped if nil.
+	Default alerter.Sink
+}
+
+// matches reports whether a matches the route, whose NameGlob is compiled
+// to glob.
+func (r *Route) matches(a *alerter.Alert, glob *regexp.Regexp) bool {
+	if a.Severity < r.MinSeverity {
+		return false
+	}
+	if !strings.HasPrefix(a.Name, r.NamePrefix) {
+		return false
+	}
+	if glob != nil && !glob.MatchString(a.Name) {
+		return false
+	}
+	if r.NameRegexp != nil && !r.NameRegexp.MatchString(a.Name) {
+		return false
+	}
+	if a.Level < r.MinLevel || r.MaxLevel > 0 && a.Level > r.MaxLevel {
+		return false
+	}
+	if len(r.Labels) > 0 {
+		found := 0
+		for _, f := range a.Fields() {
+			if want, ok := r.Labels[f.Key]; ok && fmt.Sprint(f.Value) == want {
+				found++
+			}
+		}
+		if found < len(r.Labels) {
+			return false
+		}
+	}
+	if !r.Matchers.Matches(a) {
+		return false
+	}
+	if r.Schedule != nil && !r.Schedule.Contains(alertTime(a, alerter.SystemClock)) {
+		return false
+	}
+	return r.Match == nil || r.Match(a)
+}
+
+// routedLimit bounds the number of alerts a RouterSink remembers the routes
+// of.
+const routedLimit = 4096
+
+// RouterSink returns a Sink which dispatches alerts to different Sinks by
+// severity, name, labels, V-level and time, similar to the routing tree of
+// Alertmanager but in-process:
+//
+//	sink := middleware.RouterSink(middleware.RouterOptions{
+//		Routes: []middleware.Route{
+//			{MinSeverity: alerter.SeverityCritical, Sink: pager, Continue: true},
+//			{Schedule: offHours, Sink: email},
+//			{NameGlob: "controllers/*/reconciler", Sink: platformChannel},
+//			{Labels: map[string]string{"team": "db"}, Sink: dbChannel},
+//			{MinLevel: 2, Sink: debugFile},
+//		},
+//		Default: opsChannel,
+//	})
+//
+// Resolves follow the routes their alert took, even though they are
+// usually of lower severity, as long as the router still remembers them.
+func RouterSink(opts RouterOptions) alerter.Sink {
+	r := &router{
+		opts:    opts,
+		globs:   make([]*regexp.Regexp, len(opts.Routes)),
+		filters: make([]*compiledFilter, len(opts.Routes)),
+		routed:  map[string][]int{},
+	}
+	for i, route := range opts.Routes {
+		if route.NameGlob != "" {
+			r.globs[i] = compileGlob(route.NameGlob)
+		}
+		r.filters[i] = route.Fields.compile()
+	}
+	return alerter.NewSink(r.send, alerter.SinkOptions{Enabled: r.enabled})
+}
+
+type router struct {
+	opts    RouterOptions
+	globs   []*regexp.Regexp
+	filters []*compiledFilter
+
+	mu     sync.Mutex
+	routed map[string][]int
+}
+
+func (r *router) enabled(level int) bool {
+	if r.opts.Default != nil && r.opts.Default.Enabled(level) {
+		return true
+	}
+	for _, route := range r.opts.Routes {
+		if route.Sink != nil && route.Sink.Enabled(level) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRoute stands for RouterOptions.Default in the remembered routes.
+const defaultRoute = -1
+
+func (r *router) send(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	var routes []int
+	if a.Resolved {
+		r.mu.Lock()
+		routes = r.routed[fp]
+		delete(r.routed, fp)
+		r.mu.Unlock()
+	}
+	if routes == nil {
+		routes = r.match(a)
+		if !a.Resolved {
+			r.remember(fp, routes)
+		}
+	}
+
+	var errs []error
+	for _, i := range routes {
+		s, routed := r.opts.Default, a
+		if i != defaultRoute {
+			s, routed = r.opts.Routes[i].Sink, r.filters[i].apply(a)
+		}
+		if s == nil {
+			continue
+		}
+		if err := alerter.Send(s, routed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// match returns the routes a matches.
+func (r *router) match(a *alerter.Alert) []int {
+	var routes []int
+	for i := range r.opts.Routes {
+		route := &r.opts.Routes[i]
+		if !route.matches(a, r.globs[i]) {
+			continue
+		}
+		routes = append(routes, i)
+		if !route.Continue {
+			break
+		}
+	}
+	if len(routes) == 0 {
+		routes = []int{defaultRoute}
+	}
+	return routes
+}
+
+// compileGlob translates a name pattern into an anchored regular
+// expression.  Every pattern is valid, as characters other than "*" and "?"
+// stand for themselves.
+func compileGlob(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '*' && i+1 < len(pattern) && pattern[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.MustCompile(b.String())
+}
+
+// remember records the routes of an alert for its resolve.
+func (r *router) remember(fp string, routes []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.routed[fp]; !ok && len(r.routed) >= routedLimit {
+		// Forget an arbitrary alert, which is likely never resolved.
+		for old := range r.routed {
+			delete(r.routed, old)
+			break
+		}
+	}
+	r.routed[fp] = routes
+}
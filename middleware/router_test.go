@@ -0,0 +1,43 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/sumengzs/alerter"
+)
+
+func TestRouterNilSink(t *testing.T) {
+	rec := newRecorder()
+	sink := RouterSink(RouterOptions{
+		Routes: []Route{
+			{NameGlob: "noisy", Sink: nil},
+			{Sink: rec},
+		},
+	})
+	if !sink.Enabled(0) {
+		t.Fatal("router is disabled")
+	}
+	log := alerter.New(sink)
+	log.WithName("noisy").Info("dropped")
+	log.Info("kept")
+	if got := rec.messages(); !slices.Equal(got, []string{"kept"}) {
+		t.Errorf("delivered %v, want [kept]", got)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Keys of the key/value pairs SampleSink attaches to the alerts it keeps.
+const (
+	// SampledKey carries the number of alerts with the same fingerprint
+	// which were sampled out before the current one.
+	SampledKey = "sampled"
+
+	// SampleRateKey carries SampleOptions.Rate, so that recipients can
+	// extrapolate counts.
+	SampleRateKey = "sample_rate"
+)
+
+// SampleOptions carries parameters which influence the way SampleSink
+// samples alerts.
+type SampleOptions struct {
+	// Rate is the fraction of alerts kept, e.g. 0.01 for 1%.  Rates of 0
+	// or at least 1 keep all alerts.
+	Rate float64
+
+	// Consistent samples by fingerprint instead of by occurrence: all
+	// alerts with a fingerprint are kept or none, the same way in every
+	// process, so that replicas agree on which alerts they report.
+	Consistent bool
+
+	// KeepFirst keeps the first alert with a fingerprint, and the first
+	// one after Window passed without any, regardless of Rate.
+	KeepFirst bool
+
+	// Window is the time after which a fingerprint without alerts is
+	// forgotten, including the count of its alerts sampled out.  Defaults
+	// to one hour.
+	Window time.Duration
+
+	// MaxSeverity is the highest severity which is sampled; alerts of
+	// higher severity are always kept.  Defaults to
+	// alerter.SeverityInfo, so that only informational alerts are
+	// sampled.
+	MaxSeverity alerter.Severity
+
+	// Clock is used to expire fingerprints.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// SampleSink returns a Sink which passes only a sample of very frequent,
+// low-severity alerts on to inner.  The alerts it keeps carry the number of
+// alerts with their fingerprint sampled out before them as SampledKey, and
+// the rate as SampleRateKey.
+//
+// Resolves are never sampled out.
+func SampleSink(inner alerter.Sink, opts SampleOptions) alerter.Sink {
+	if opts.Window <= 0 {
+		opts.Window = time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	if opts.Rate <= 0 || opts.Rate >= 1 {
+		return inner
+	}
+	s := &sampler{inner: inner, opts: opts, seen: map[string]*sampled{}, nextSweep: 1024}
+	return wrap(inner, s.send)
+}
+
+type sampler struct {
+	inner alerter.Sink
+	opts  SampleOptions
+
+	mu        sync.Mutex
+	seen      map[string]*sampled
+	nextSweep int
+}
+
+// sampled is the state of a fingerprint.
+type sampled struct {
+	last    time.Time
+	dropped int
+}
+
+func (s *sampler) send(a *alerter.Alert) error {
+	if a.Resolved || a.Severity > s.opts.MaxSeverity {
+		return alerter.Send(s.inner, a)
+	}
+	now := s.opts.Clock.Now()
+	fp := a.Fingerprint()
+
+	s.mu.Lock()
+	e := s.seen[fp]
+	first := e == nil || now.Sub(e.last) >= s.opts.Window
+	if e == nil {
+		s.sweep(now)
+		e = &sampled{}
+		s.seen[fp] = e
+	} else if first {
+		e.dropped = 0
+	}
+	e.last = now
+	if !(first && s.opts.KeepFirst) && !s.keep(fp) {
+		e.dropped++
+		s.mu.Unlock()
+		return nil
+	}
+	dropped := e.dropped
+	e.dropped = 0
+	s.mu.Unlock()
+
+	c := *a
+	c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)], SampleRateKey, s.opts.Rate)
+	if dropped > 0 {
+		c.KeysAndValues = append(c.KeysAndValues, SampledKey, dropped)
+	}
+	return alerter.Send(s.inner, &c)
+}
+
+// keep decides whether an alert with fingerprint fp is in the sample.
+func (s *sampler) keep(fp string) bool {
+	if !s.opts.Consistent {
+		return rand.Float64() < s.opts.Rate
+	}
+	h := fnv.New64a()
+	h.Write([]byte(fp))
+	return float64(h.Sum64())/math.MaxUint64 < s.opts.Rate
+}
+
+// sweep forgets fingerprints without alerts for a window once there are
+// many of them.  It must be called with s.mu held.
+func (s *sampler) sweep(now time.Time) {
+	if len(s.seen) < s.nextSweep {
+		return
+	}
+	for fp, e := range s.seen {
+		if now.Sub(e.last) >= s.opts.Window {
+			delete(s.seen, fp)
+		}
+	}
+	s.nextSweep = 2 * len(s.seen)
+	if s.nextSweep < 1024 {
+		s.nextSweep = 1024
+	}
+}
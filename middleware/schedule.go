@@ -0,0 +1,260 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule is a set of times, such as night hours, weekends and holidays,
+// used by QuietHoursSink and by routes to deliver differently depending on
+// the time of day:
+//
+//	offHours := &middleware.Schedule{
+//		Windows:  []middleware.TimeWindow{
+//			middleware.MustParseTimeWindow("Mon-Fri 18:00-09:00"),
+//			middleware.MustParseTimeWindow("Sat,Sun 00:00-24:00"),
+//		},
+//		Holidays: middleware.Dates{"12-25", "2024-04-01"},
+//		Location: berlin,
+//	}
+type Schedule struct {
+	// Windows are recurring times of the week within the schedule.
+	Windows []TimeWindow
+
+	// Holidays are whole days within the schedule.
+	Holidays Calendar
+
+	// Location is the time zone the windows and holidays are in.
+	// Defaults to time.Local.
+	Location *time.Location
+}
+
+// Contains reports whether t is within the schedule.  A nil Schedule
+// contains all times.
+func (s *Schedule) Contains(t time.Time) bool {
+	if s == nil {
+		return true
+	}
+	if s.Location != nil {
+		t = t.In(s.Location)
+	} else {
+		t = t.Local()
+	}
+	if s.Holidays != nil && s.Holidays.Contains(t) {
+		return true
+	}
+	for _, w := range s.Windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Calendar is a set of days, such as public holidays.
+type Calendar interface {
+	// Contains reports whether the day of t, in the location of t, is in
+	// the calendar.
+	Contains(t time.Time) bool
+}
+
+// Dates is a Calendar of dates given as "2006-01-02" for single days or as
+// "01-02" for days recurring every year.
+type Dates []string
+
+// Contains implements Calendar.
+func (d Dates) Contains(t time.Time) bool {
+	day, yearly := t.Format("2006-01-02"), t.Format("01-02")
+	for _, date := range d {
+		if date == day || date == yearly {
+			return true
+		}
+	}
+	return false
+}
+
+// TimeWindow is a recurring time of day on some days of the week.  A window
+// whose End is before its Start spans midnight, and its days are those it
+// starts on: "Fri 22:00-06:00" contains Saturday 05:00 but not Friday
+// 05:00.
+type TimeWindow struct {
+	// Weekdays are the days the window applies to.  An empty list stands
+	// for every day.
+	Weekdays []time.Weekday
+
+	// Start and End are the times since midnight the window contains,
+	// from Start inclusive to End exclusive.  End may be 24 hours.
+	Start, End time.Duration
+}
+
+// Contains reports whether the time of day of t, in the location of t, is
+// within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	y, m, d := t.Date()
+	since := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	switch {
+	case w.Start < w.End:
+		return since >= w.Start && since < w.End && w.on(t.Weekday())
+	case w.Start > w.End:
+		if since >= w.Start {
+			return w.on(t.Weekday())
+		}
+		return since < w.End && w.on((t.Weekday()+6)%7)
+	}
+	return false
+}
+
+func (w TimeWindow) on(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the window in the syntax ParseTimeWindow accepts.
+func (w TimeWindow) String() string {
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	span := clock(w.Start) + "-" + clock(w.End)
+	if len(w.Weekdays) == 0 {
+		return span
+	}
+	days := make([]string, len(w.Weekdays))
+	for i, d := range w.Weekdays {
+		days[i] = d.String()[:3]
+	}
+	return strings.Join(days, ",") + " " + span
+}
+
+// MarshalText implements encoding.TextMarshaler, so that TimeWindows can
+// be written as strings in configuration.
+func (w TimeWindow) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (w *TimeWindow) UnmarshalText(text []byte) error {
+	parsed, err := ParseTimeWindow(string(text))
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
+
+// JSONSchema describes TimeWindows in config.Schema.
+func (TimeWindow) JSONSchema() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "examples": []interface{}{"Mon-Fri 18:00-09:00", "22:00-07:00"}}
+}
+
+// ParseTimeWindow parses a window such as "22:00-07:00", "Mon-Fri
+// 09:00-17:00" or "Sat,Sun 00:00-24:00".  Days are given as ranges or
+// comma-separated lists of English day names, which may be abbreviated to
+// three letters.
+func ParseTimeWindow(s string) (TimeWindow, error) {
+	var w TimeWindow
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+	case 2:
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return TimeWindow{}, fmt.Errorf("time window %q: %w", s, err)
+		}
+		w.Weekdays = days
+	default:
+		return TimeWindow{}, fmt.Errorf("time window %q: expected [days] HH:MM-HH:MM", s)
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return TimeWindow{}, fmt.Errorf("time window %q: expected HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.Start, err = parseTimeOfDay(start); err != nil {
+		return TimeWindow{}, fmt.Errorf("time window %q: %w", s, err)
+	}
+	if w.End, err = parseTimeOfDay(end); err != nil {
+		return TimeWindow{}, fmt.Errorf("time window %q: %w", s, err)
+	}
+	return w, nil
+}
+
+// MustParseTimeWindow is like ParseTimeWindow but panics if s cannot be
+// parsed.
+func MustParseTimeWindow(s string) TimeWindow {
+	w, err := ParseTimeWindow(s)
+	if err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// parseTimeOfDay parses "HH:MM", from "00:00" to "24:00".
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var h, m int
+	if n, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("time of day %q out of range", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseWeekdays parses "Mon-Fri" or "Sat,Sun".
+func parseWeekdays(s string) ([]time.Weekday, error) {
+	var days []time.Weekday
+	for _, part := range strings.Split(s, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseWeekday(from)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = parseWeekday(to); err != nil {
+				return nil, err
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days = append(days, d)
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := d.String()
+		if strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
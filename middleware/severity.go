@@ -0,0 +1,66 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+)
+
+// OriginalSeverityKey is the key under which RemapSeveritySink attaches the
+// severity an alert was raised with, if it changed it.
+const OriginalSeverityKey = "original_severity"
+
+// SeverityRule sets the severity of the alerts it matches.
+type SeverityRule struct {
+	// Matchers selects the alerts, e.g.
+	// matchers.MustParse(`component="payments", severity="warning"`).
+	Matchers matchers.Matchers
+
+	// Severity is the severity they are given.
+	Severity alerter.Severity
+}
+
+// RemapSeveritySink returns a Sink which sets the severity of alerts by the
+// first of rules they match before passing them on to inner, so that the
+// severity policy is configured in one place rather than at every call
+// site:
+//
+//	sink := middleware.RemapSeveritySink(inner,
+//		middleware.SeverityRule{Matchers: matchers.MustParse(`env="dev"`), Severity: alerter.SeverityInfo},
+//		middleware.SeverityRule{Matchers: matchers.MustParse(`component="payments", severity="warning"`), Severity: alerter.SeverityCritical},
+//	)
+//
+// Labels are those of matchers.Labels, including the original severity.
+// Alerts whose severity changed carry it as OriginalSeverityKey.
+func RemapSeveritySink(inner alerter.Sink, rules ...SeverityRule) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		for _, r := range rules {
+			if !r.Matchers.Matches(a) {
+				continue
+			}
+			if r.Severity != a.Severity {
+				c := *a
+				c.Severity = r.Severity
+				c.KeysAndValues = append(a.KeysAndValues[:len(a.KeysAndValues):len(a.KeysAndValues)], OriginalSeverityKey, a.Severity.String())
+				a = &c
+			}
+			break
+		}
+		return alerter.Send(inner, a)
+	})
+}
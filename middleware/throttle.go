@@ -0,0 +1,117 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// SuppressedKey is the key under which ThrottleSink reports the number of
+// alerts it suppressed.
+const SuppressedKey = "suppressed"
+
+// ThrottleOptions carries parameters which influence the way ThrottleSink
+// throttles alerts.
+type ThrottleOptions struct {
+	// Rate is the sustained number of alerts per second.  A zero rate is
+	// not enforced.
+	Rate float64
+
+	// Burst is the number of alerts which may be sent at once.  Defaults
+	// to Rate rounded up, and at least 1.
+	Burst int
+
+	// Cooldown is the period after which suppressed alerts are reported.
+	// Defaults to 5 minutes.
+	Cooldown time.Duration
+
+	// Clock is used to refill the bucket and to time the cooldown.
+	// Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// ThrottleSink returns a Sink which passes at most Rate alerts per second,
+// with bursts of up to Burst, to inner.  Alerts beyond that are suppressed,
+// and recipients are told so: a Cooldown after the first suppressed alert,
+// a warning such as "suppressed 42 alerts in the last 5m0s" is sent with the
+// count attached as SuppressedKey.  This repeats for as long as alerts keep
+// getting suppressed.
+//
+// Resolves are never suppressed, as dropping one would leave its alert open
+// downstream.
+func ThrottleSink(inner alerter.Sink, opts ThrottleOptions) alerter.Sink {
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 5 * time.Minute
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	t := &throttle{inner: inner, opts: opts}
+	if opts.Rate > 0 {
+		t.bucket = newBucket(opts.Rate, opts.Burst, opts.Clock.Now())
+	}
+	return wrap(inner, t.send)
+}
+
+type throttle struct {
+	inner alerter.Sink
+	opts  ThrottleOptions
+
+	mu         sync.Mutex
+	bucket     *bucket
+	suppressed int
+	timer      alerter.Timer
+}
+
+func (t *throttle) send(a *alerter.Alert) error {
+	if a.Resolved || t.bucket == nil {
+		return alerter.Send(t.inner, a)
+	}
+	t.mu.Lock()
+	if t.bucket.take(t.opts.Clock.Now()) {
+		t.mu.Unlock()
+		return alerter.Send(t.inner, a)
+	}
+	t.suppressed++
+	if t.timer == nil {
+		t.timer = alerter.AfterFunc(t.opts.Clock, t.opts.Cooldown, t.report)
+	}
+	t.mu.Unlock()
+	return nil
+}
+
+// report tells inner how many alerts were suppressed during the cooldown.
+func (t *throttle) report() {
+	t.mu.Lock()
+	n := t.suppressed
+	t.suppressed = 0
+	t.timer = nil
+	t.mu.Unlock()
+	if n == 0 {
+		return
+	}
+	_ = alerter.Send(t.inner, &alerter.Alert{
+		Time:          t.opts.Clock.Now(),
+		Message:       fmt.Sprintf("suppressed %d alerts in the last %s", n, t.opts.Cooldown),
+		Severity:      alerter.SeverityWarning,
+		KeysAndValues: []interface{}{SuppressedKey, n},
+	})
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"github.com/sumengzs/alerter"
+)
+
+// TransformSink returns a Sink which passes every alert through fn before
+// handing it to inner, to rewrite, enrich or drop alerts with Go code
+// without implementing a Sink:
+//
+//	sink := middleware.TransformSink(slack, func(a *alerter.Alert) *alerter.Alert {
+//		if strings.HasPrefix(a.Name, "canary/") {
+//			return nil
+//		}
+//		a.Message = "[" + region + "] " + a.Message
+//		return a
+//	})
+//
+// fn receives a copy of the alert, whose fields it may set, and returns the
+// alert to deliver, or nil to drop it.  The slices of keys and values are
+// shared with the caller, so they must be copied rather than modified in
+// place.
+func TransformSink(inner alerter.Sink, fn func(a *alerter.Alert) *alerter.Alert) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		c := *a
+		t := fn(&c)
+		if t == nil {
+			return nil
+		}
+		return alerter.Send(inner, t)
+	})
+}
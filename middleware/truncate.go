@@ -0,0 +1,155 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/sumengzs/alerter"
+)
+
+// TruncatedKey is the key under which TruncateSink attaches the number of
+// key/value pairs it dropped.
+const TruncatedKey = "truncated"
+
+// TruncateOptions carries the limits of TruncateSink.  Limits which are not
+// positive do not apply.
+type TruncateOptions struct {
+	// MaxMessageBytes limits the message.
+	MaxMessageBytes int
+
+	// MaxValues limits the number of key/value pairs, counting the one
+	// carrying TruncatedKey.  The values of the Alerter come first, then
+	// those of the call, and the last ones are dropped.
+	MaxValues int
+
+	// MaxStringLength limits the error text and the values which are
+	// strings, errors or implement fmt.Stringer, in bytes.
+	MaxStringLength int
+}
+
+// TruncateSink returns a Sink which cuts alerts down to the limits of opts
+// before passing them on to inner, so that huge values do not get them
+// rejected by providers such as Slack or PagerDuty:
+//
+//	sink := middleware.TruncateSink(slack, middleware.TruncateOptions{
+//		MaxMessageBytes: 3000,
+//		MaxValues:       20,
+//		MaxStringLength: 500,
+//	})
+//
+// Cut strings end in a marker such as "…[1234 bytes truncated]" within the
+// limit.  Truncation is deterministic, so alerts and their resolves keep
+// matching fingerprints downstream.  Values which are cut become strings.
+func TruncateSink(inner alerter.Sink, opts TruncateOptions) alerter.Sink {
+	return wrap(inner, func(a *alerter.Alert) error {
+		return alerter.Send(inner, truncateAlert(a, opts))
+	})
+}
+
+// truncateAlert returns a copy of a within the limits of opts.
+func truncateAlert(a *alerter.Alert, opts TruncateOptions) *alerter.Alert {
+	c := *a
+	c.Message = truncateString(a.Message, opts.MaxMessageBytes)
+	if a.Err != nil {
+		if text := a.Err.Error(); opts.MaxStringLength > 0 && len(text) > opts.MaxStringLength {
+			c.Err = truncatedError(truncateString(text, opts.MaxStringLength))
+		}
+	}
+	c.Values = truncateValues(a.Values, opts.MaxStringLength)
+	c.KeysAndValues = truncateValues(a.KeysAndValues, opts.MaxStringLength)
+
+	values, kvs := (len(c.Values)+1)/2, (len(c.KeysAndValues)+1)/2
+	if opts.MaxValues > 0 && values+kvs > opts.MaxValues {
+		keep := opts.MaxValues - 1
+		dropped := values + kvs - keep
+		if values > keep {
+			c.Values = c.Values[:2*keep]
+			c.KeysAndValues = nil
+		} else {
+			c.KeysAndValues = c.KeysAndValues[:2*(keep-values)]
+		}
+		c.KeysAndValues = append(c.KeysAndValues[:len(c.KeysAndValues):len(c.KeysAndValues)], TruncatedKey, dropped)
+	}
+	return &c
+}
+
+// truncateValues returns kvs with the values longer than max cut, copying
+// it only if there are any.
+func truncateValues(kvs []interface{}, max int) []interface{} {
+	if max <= 0 {
+		return kvs
+	}
+	var out []interface{}
+	for i := 1; i < len(kvs); i += 2 {
+		var text string
+		switch v := kvs[i].(type) {
+		case string:
+			text = v
+		case error:
+			text = v.Error()
+		case fmt.Stringer:
+			text = v.String()
+		default:
+			continue
+		}
+		if len(text) <= max {
+			continue
+		}
+		if out == nil {
+			out = append([]interface{}(nil), kvs...)
+		}
+		out[i] = truncateString(text, max)
+	}
+	if out == nil {
+		return kvs
+	}
+	return out
+}
+
+// truncateString cuts s to at most max bytes, at a character boundary,
+// ending in a marker with the number of bytes cut.
+func truncateString(s string, max int) string {
+	if max <= 0 || len(s) <= max {
+		return s
+	}
+	// The marker for all of s is at least as long as the final one.
+	n := max - len(truncationMarker(len(s)))
+	if n < 0 {
+		n = 0
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	marker := truncationMarker(len(s) - n)
+	if len(marker) > max {
+		return marker
+	}
+	return s[:n] + marker
+}
+
+func truncationMarker(cut int) string {
+	return fmt.Sprintf("…[%d bytes truncated]", cut)
+}
+
+// truncatedError is the cut text of an error.
+type truncatedError string
+
+func (e truncatedError) Error() string {
+	return string(e)
+}
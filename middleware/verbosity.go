@@ -0,0 +1,168 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package middleware
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Verbosity is a V-level which can be changed at runtime, e.g. to see the
+// debug alerts of a program while it misbehaves.  It is safe for
+// concurrent use.
+type Verbosity struct {
+	level atomic.Int64
+
+	mu      sync.Mutex
+	signals chan os.Signal
+	done    chan struct{}
+}
+
+// NewVerbosity returns a Verbosity of level.
+func NewVerbosity(level int) *Verbosity {
+	v := &Verbosity{}
+	v.level.Store(int64(level))
+	return v
+}
+
+// Level returns the current V-level.
+func (v *Verbosity) Level() int {
+	return int(v.level.Load())
+}
+
+// Set changes the V-level.
+func (v *Verbosity) Set(level int) {
+	v.level.Store(int64(level))
+}
+
+// add changes the V-level by delta within [min, max] and returns the old
+// and new level.
+func (v *Verbosity) add(delta, min, max int) (int, int) {
+	for {
+		old := v.level.Load()
+		level := old + int64(delta)
+		if level < int64(min) {
+			level = int64(min)
+		}
+		if level > int64(max) {
+			level = int64(max)
+		}
+		if v.level.CompareAndSwap(old, level) {
+			return int(old), int(level)
+		}
+	}
+}
+
+// Sink returns a Sink which passes the Info alerts of V-levels up to the
+// current one on to inner, as well as all errors and resolves.  As inner
+// discards alerts above its own verbosity, it should be built with the
+// highest one of interest, e.g. file.New(w, 10), so that the Verbosity
+// decides.
+func (v *Verbosity) Sink(inner alerter.Sink) alerter.Sink {
+	return alerter.NewSink(func(a *alerter.Alert) error {
+		return alerter.Send(inner, a)
+	}, alerter.SinkOptions{Enabled: func(level int) bool { return level <= v.Level() }})
+}
+
+// SignalOptions carries parameters which influence the way a Verbosity
+// follows signals.
+type SignalOptions struct {
+	// Increase and Decrease are the signals which raise and lower the
+	// V-level by one.  They default to SIGUSR1 and SIGUSR2 where those
+	// exist, i.e. not on Windows, where they must be set explicitly.
+	Increase, Decrease os.Signal
+
+	// Max is the highest V-level signals raise to.  Defaults to 10.  The
+	// lowest is 0.
+	Max int
+
+	// OnChange is called with the old and new V-level when a signal
+	// changes it.
+	OnChange func(old, new int)
+}
+
+// Notify makes signals change the V-level, like klog's verbosity flag but
+// at runtime, in environments without access to an admin API:
+//
+//	v := middleware.NewVerbosity(0)
+//	v.Notify(middleware.SignalOptions{})
+//	alerter := alerter.New(v.Sink(file.New(w, 10).GetSink()))
+//
+// and then `kill -USR1 <pid>` for more alerts and `kill -USR2 <pid>` for
+// fewer.  Calling Notify again replaces the signals; Stop stops following
+// them.
+func (v *Verbosity) Notify(opts SignalOptions) {
+	if opts.Increase == nil && opts.Decrease == nil {
+		opts.Increase, opts.Decrease = defaultVerbositySignals()
+	}
+	if opts.Max <= 0 {
+		opts.Max = 10
+	}
+	var sigs []os.Signal
+	for _, sig := range []os.Signal{opts.Increase, opts.Decrease} {
+		if sig != nil {
+			sigs = append(sigs, sig)
+		}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stop()
+	if len(sigs) == 0 {
+		return
+	}
+	signals, done := make(chan os.Signal, 1), make(chan struct{})
+	v.signals, v.done = signals, done
+	signal.Notify(signals, sigs...)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-signals:
+				delta := 1
+				if sig == opts.Decrease {
+					delta = -1
+				}
+				old, level := v.add(delta, 0, opts.Max)
+				if opts.OnChange != nil && old != level {
+					opts.OnChange(old, level)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops following signals.
+func (v *Verbosity) Stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stop()
+}
+
+// stop must be called with v.mu held.
+func (v *Verbosity) stop() {
+	if v.signals == nil {
+		return
+	}
+	signal.Stop(v.signals)
+	close(v.done)
+	v.signals, v.done = nil, nil
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Severity classifies how urgently an alert needs attention.  Unlike
+// V-levels, which control whether an alert is raised at all, severities are
+// passed on to sinks, which may use them to pick a channel, a color or a
+// priority.
+type Severity int
+
+const (
+	// SeverityInfo is the default severity of Info alerts.
+	SeverityInfo Severity = iota
+	// SeverityWarning marks conditions which need attention soon.
+	SeverityWarning
+	// SeverityError is the default severity of Error alerts.
+	SeverityError
+	// SeverityCritical marks conditions which need attention right away.
+	SeverityCritical
+)
+
+// SeverityKey is the key under which a Severity may be attached to an alert,
+// overriding the default severity of the call.  It may be added with
+// WithValues or passed with the key/value pairs of the call itself, which
+// takes precedence.  The value may be a Severity or its name.
+const SeverityKey = "severity"
+
+var severityNames = [...]string{
+	SeverityInfo:     "info",
+	SeverityWarning:  "warning",
+	SeverityError:    "error",
+	SeverityCritical: "critical",
+}
+
+// String returns the lower case name of the severity.
+func (s Severity) String() string {
+	if s >= 0 && int(s) < len(severityNames) {
+		return severityNames[s]
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// ParseSeverity returns the Severity with the given name, ignoring case.
+func ParseSeverity(name string) (Severity, error) {
+	for s, n := range severityNames {
+		if strings.EqualFold(name, n) {
+			return Severity(s), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown severity %q", name)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Severity) UnmarshalText(text []byte) error {
+	v, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+// WithSeverity returns a new Alerter instance whose alerts carry the given
+// severity, unless a call overrides it.  It is a shorthand for
+// WithValues(SeverityKey, s).
+func (a Alerter) WithSeverity(s Severity) Alerter {
+	return a.WithValues(SeverityKey, s)
+}
+
+// severityOf returns the severity attached to kvs with SeverityKey, if any.
+// The last occurrence wins.
+func severityOf(kvs []interface{}) (Severity, bool) {
+	for i := (len(kvs)/2 - 1) * 2; i >= 0; i -= 2 {
+		if k, ok := kvs[i].(string); !ok || k != SeverityKey {
+			continue
+		}
+		switch v := kvs[i+1].(type) {
+		case Severity:
+			return v, true
+		case string:
+			if s, err := ParseSeverity(v); err == nil {
+				return s, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// Colors of alerts, as 0xRRGGBB, which chat sinks show as the color bar of
+// a message.
+const (
+	ColorInfo     = 0x439FE0
+	ColorWarning  = 0xECB22E
+	ColorError    = 0xE01E5A
+	ColorCritical = 0x8B0000
+	ColorResolved = 0x2EB67D
+)
+
+// Color returns the color of a: ColorResolved for resolves and the color
+// of its severity otherwise.
+func Color(a *alerter.Alert) int {
+	switch {
+	case a.Resolved:
+		return ColorResolved
+	case a.Severity >= alerter.SeverityCritical:
+		return ColorCritical
+	case a.Severity == alerter.SeverityError:
+		return ColorError
+	case a.Severity == alerter.SeverityWarning:
+		return ColorWarning
+	}
+	return ColorInfo
+}
+
+// HexColor returns the color of a as "#RRGGBB".
+func HexColor(a *alerter.Alert) string {
+	return fmt.Sprintf("#%06X", Color(a))
+}
+
+// Title returns the title of a message about a, such as
+//
+//	[CRITICAL] billing/api: payment failed
+//
+// with RESOLVED in place of the severity for resolves.
+func Title(a *alerter.Alert) string {
+	label := strings.ToUpper(a.Severity.String())
+	if a.Resolved {
+		label = "RESOLVED"
+	}
+	title := "[" + label + "] "
+	if a.Name != "" {
+		title += a.Name + ": "
+	}
+	return title + a.Message
+}
+
+// Text returns the body of a message about a: its message, followed by its
+// error on a line of its own.
+func Text(a *alerter.Alert) string {
+	if a.Err == nil {
+		return a.Message
+	}
+	return a.Message + "\n" + a.Err.Error()
+}
+
+// Body returns the text of a message about a whose title, as returned by
+// Title, is cut off after titleLimit characters: its error, and its message
+// too if the title cuts it off.
+func Body(a *alerter.Alert, titleLimit int) string {
+	if len([]rune(Title(a))) > titleLimit {
+		return Text(a)
+	}
+	if a.Err != nil {
+		return a.Err.Error()
+	}
+	return ""
+}
+
+// Fields returns the key/value pairs of a, except its severity, which chat
+// sinks show in the title and color, with their values formatted.
+func Fields(a *alerter.Alert) []alerter.Field {
+	fields := a.Fields()
+	out := fields[:0]
+	for _, f := range fields {
+		if f.Key == alerter.SeverityKey {
+			continue
+		}
+		if _, ok := f.Value.(string); !ok {
+			f.Value = fmt.Sprint(f.Value)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+// PostJSON posts payload as JSON to url with the given headers, failing
+// with a *transport.StatusError for responses outside 2xx.  If response is
+// not nil, the body of the response is decoded into it.
+func PostJSON(ctx context.Context, client *http.Client, url string, header http.Header, payload, response interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := transport.CheckResponse(resp); err != nil {
+		return err
+	}
+	if response == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	return nil
+}
+
+// Threads remembers the messages alerts were posted as, by fingerprint, so
+// that sinks post later occurrences and resolves as replies to them.  The
+// zero value is ready to use and safe for concurrent use.
+type Threads struct {
+	// Retention is the time after which a thread without replies is
+	// forgotten.  Defaults to 7 days.
+	Retention time.Duration
+
+	// Clock tells the age of threads.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+
+	mu        sync.Mutex
+	threads   map[string]thread
+	nextSweep int
+}
+
+type thread struct {
+	id      string
+	updated time.Time
+}
+
+// Get returns the message the alert with the given fingerprint was posted
+// as.
+func (t *Threads) Get(fingerprint string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	th, ok := t.threads[fingerprint]
+	if !ok || now.Sub(th.updated) >= t.retention() {
+		return "", false
+	}
+	th.updated = now
+	t.threads[fingerprint] = th
+	return th.id, true
+}
+
+// Set remembers the message the alert with the given fingerprint was
+// posted as.
+func (t *Threads) Set(fingerprint, id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.threads == nil {
+		t.threads, t.nextSweep = map[string]thread{}, 1024
+	}
+	now := t.now()
+	if len(t.threads) >= t.nextSweep {
+		for fp, th := range t.threads {
+			if now.Sub(th.updated) >= t.retention() {
+				delete(t.threads, fp)
+			}
+		}
+		t.nextSweep = max(2*len(t.threads), 1024)
+	}
+	t.threads[fingerprint] = thread{id: id, updated: now}
+}
+
+// Delete forgets the message of the alert with the given fingerprint, e.g.
+// once it was resolved.
+func (t *Threads) Delete(fingerprint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.threads, fingerprint)
+}
+
+func (t *Threads) now() time.Time {
+	if t.Clock == nil {
+		return alerter.SystemClock.Now()
+	}
+	return t.Clock.Now()
+}
+
+func (t *Threads) retention() time.Duration {
+	if t.Retention <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return t.Retention
+}
@@ -0,0 +1,261 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package chat holds what the sinks of chat providers share, such as
+// fitting alerts into the length limits of messages.
+package chat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Overflow is the way a message longer than a provider allows is delivered.
+type Overflow string
+
+// Overflow strategies.
+const (
+	// OverflowTruncate cuts the message off with an ellipsis.
+	OverflowTruncate Overflow = "truncate"
+
+	// OverflowSplit sends the message in chunks, each ending in a marker
+	// such as "(2/3)", which sinks post as replies in a thread where the
+	// provider has them.
+	OverflowSplit Overflow = "split"
+
+	// OverflowUpload truncates the message and uploads it in full, as a
+	// snippet or attachment, appending a link to it.
+	OverflowUpload Overflow = "upload"
+)
+
+// Uploader stores the full text of oversized messages, e.g. as a Slack
+// snippet or in an object store, and returns a link to it.
+type Uploader interface {
+	Upload(ctx context.Context, name string, content []byte) (link string, err error)
+}
+
+// UploaderFunc adapts a function to the Uploader interface.
+type UploaderFunc func(ctx context.Context, name string, content []byte) (string, error)
+
+// Upload implements Uploader.
+func (f UploaderFunc) Upload(ctx context.Context, name string, content []byte) (string, error) {
+	return f(ctx, name, content)
+}
+
+// DefaultMaxChunks is the number of chunks of OverflowOptions which set
+// none.
+const DefaultMaxChunks = 10
+
+// OverflowOptions carries parameters which influence the way Fit delivers
+// oversized messages.  Chat sinks take them as an option, defaulting Limit
+// to the limit of their provider.
+type OverflowOptions struct {
+	// Limit is the number of characters of a message.  A Limit which is
+	// not positive does not limit messages.
+	Limit int
+
+	// Overflow selects the strategy.  Defaults to OverflowTruncate.
+	Overflow Overflow
+
+	// MaxChunks bounds the chunks of OverflowSplit; the last one is
+	// truncated, or links the rest if there is an Uploader.  Defaults to
+	// DefaultMaxChunks.
+	MaxChunks int
+
+	// Uploader stores the full text for OverflowUpload, and for
+	// OverflowSplit beyond MaxChunks.
+	Uploader Uploader
+
+	// Name is the name of uploads, such as "alert.txt".
+	Name string
+
+	// Compress gzips uploads, appending ".gz" to their name, for
+	// uploaders which store rather than display them.
+	Compress bool
+
+	// OnError is called if an upload fails, in which case the message is
+	// truncated instead of failing delivery.
+	OnError func(err error)
+}
+
+// Fit returns the messages which deliver text within opts.Limit, usually
+// just text itself.  It never fails: if an upload fails, the message is
+// truncated instead.
+func Fit(ctx context.Context, text string, opts OverflowOptions) []string {
+	return fit(ctx, text, opts, Block{})
+}
+
+// Block is the markup around a code block, such as "```text\n" and "\n```".
+type Block struct {
+	Open, Close string
+}
+
+// FitBlock is Fit for text shown in a code block: every message opens and
+// closes the block around its part of text, and the split markers and links
+// follow it, so that no message leaves a block open for the next one.
+func FitBlock(ctx context.Context, text string, block Block, opts OverflowOptions) []string {
+	if opts.Limit > 0 {
+		opts.Limit = max(1, opts.Limit-runeCount(block.Open+block.Close))
+	}
+	return fit(ctx, text, opts, block)
+}
+
+func fit(ctx context.Context, text string, opts OverflowOptions, block Block) []string {
+	if opts.Limit <= 0 || runeCount(text) <= opts.Limit {
+		return []string{block.wrap(text)}
+	}
+	if opts.MaxChunks <= 0 {
+		opts.MaxChunks = DefaultMaxChunks
+	}
+	switch opts.Overflow {
+	case OverflowSplit:
+		return split(ctx, text, opts, block)
+	case OverflowUpload:
+		if opts.Uploader != nil {
+			message, link := withLink(ctx, text, text, opts)
+			return []string{block.wrap(message) + link}
+		}
+	}
+	return []string{block.wrap(Truncate(text, opts.Limit))}
+}
+
+func (b Block) wrap(text string) string {
+	return b.Open + text + b.Close
+}
+
+// split returns the chunks of text.
+func split(ctx context.Context, text string, opts OverflowOptions, block Block) []string {
+	marker := func(i, n int) string { return fmt.Sprintf("\n(%d/%d)", i, n) }
+	room := opts.Limit - runeCount(marker(opts.MaxChunks, opts.MaxChunks))
+	if room < 1 {
+		return []string{block.wrap(Truncate(text, opts.Limit))}
+	}
+	var chunks []string
+	for rest := text; rest != ""; {
+		if len(chunks) == opts.MaxChunks-1 && runeCount(rest) > room {
+			chunks = append(chunks, rest)
+			break
+		}
+		chunk, tail := cut(rest, room)
+		chunks = append(chunks, chunk)
+		rest = tail
+	}
+	n := len(chunks)
+	var link string
+	if last := chunks[n-1]; runeCount(last) > room {
+		if opts.Uploader != nil {
+			chunks[n-1], link = withLink(ctx, last, text, opts)
+		} else {
+			chunks[n-1] = Truncate(last, room)
+		}
+	}
+	for i := range chunks {
+		chunks[i] = block.wrap(strings.TrimRight(chunks[i], " \n"))
+		if i == n-1 {
+			chunks[i] += link
+		}
+		chunks[i] += marker(i+1, n)
+	}
+	return chunks
+}
+
+// cut splits s after at most limit characters, preferably at a line break
+// and otherwise at a space, and trims the whitespace at the split.
+func cut(s string, limit int) (string, string) {
+	if runeCount(s) <= limit {
+		return s, ""
+	}
+	head := prefix(s, limit)
+	at := strings.LastIndexByte(head, '\n')
+	if at < len(head)/2 {
+		at = strings.LastIndexByte(head, ' ')
+	}
+	if at < len(head)/2 {
+		return head, strings.TrimLeft(s[len(head):], " \n")
+	}
+	return strings.TrimRight(head[:at], " \n"), strings.TrimLeft(s[at:], " \n")
+}
+
+// withLink returns the truncated message and the line linking to the
+// uploaded full text, or just the truncated message if the upload fails.
+func withLink(ctx context.Context, message, full string, opts OverflowOptions) (string, string) {
+	limit := opts.Limit
+	if opts.Overflow == OverflowSplit {
+		limit -= runeCount(fmt.Sprintf("\n(%d/%d)", opts.MaxChunks, opts.MaxChunks))
+	}
+	link, err := upload(ctx, full, opts)
+	if err != nil {
+		if opts.OnError != nil {
+			opts.OnError(err)
+		}
+		return Truncate(message, limit), ""
+	}
+	suffix := "\nFull alert: " + link
+	if room := limit - runeCount(suffix); room > 0 {
+		return Truncate(message, room), suffix
+	}
+	return Truncate(message, limit), ""
+}
+
+func upload(ctx context.Context, text string, opts OverflowOptions) (string, error) {
+	name, content := opts.Name, []byte(text)
+	if name == "" {
+		name = "alert.txt"
+	}
+	if opts.Compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(content); err != nil {
+			return "", err
+		}
+		if err := zw.Close(); err != nil {
+			return "", err
+		}
+		name, content = name+".gz", buf.Bytes()
+	}
+	link, err := opts.Uploader.Upload(ctx, name, content)
+	if err != nil {
+		return "", fmt.Errorf("upload oversized message: %w", err)
+	}
+	return link, nil
+}
+
+// Truncate cuts s to at most limit characters, ending in an ellipsis if it
+// was cut.
+func Truncate(s string, limit int) string {
+	if limit <= 0 || runeCount(s) <= limit {
+		return s
+	}
+	return strings.TrimRight(prefix(s, limit-1), " \n") + "…"
+}
+
+// prefix returns the first n characters of s.
+func prefix(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+func runeCount(s string) int {
+	return len([]rune(s))
+}
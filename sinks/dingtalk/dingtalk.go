@@ -0,0 +1,237 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package dingtalk implements an alerter.Sink which posts alerts to the
+// custom robots of DingTalk group chats as markdown messages:
+//
+//	a, err := dingtalk.New(dingtalk.Options{
+//		WebhookURL: "https://oapi.dingtalk.com/robot/send?access_token=xxx",
+//		Secret:     os.Getenv("DINGTALK_SECRET"),
+//		AtMobiles:  []string{"13800000000"},
+//	})
+//
+// Robots with the "sign" security setting require Secret; the others
+// accept alerts by IP address or keyword, in which case the keyword must
+// appear in every message, e.g. in the name of the Alerter.
+package dingtalk
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// maxTitle is the number of characters of a title.
+const maxTitle = 128
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// WebhookURL is the URL of the robot, including its access token.
+	WebhookURL string
+
+	// Secret signs requests, for robots with the "sign" security setting.
+	Secret string
+
+	// AtMobiles are the phone numbers of the members mentioned in the
+	// messages of alerts of at least MentionSeverity.
+	AtMobiles []string
+
+	// AtAll mentions all members in the messages of alerts of at least
+	// MentionSeverity.
+	AtAll bool
+
+	// MentionSeverity is the lowest severity which mentions members.
+	// Defaults to alerter.SeverityCritical.
+	MentionSeverity alerter.Severity
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than DingTalk allows.  Limit
+	// defaults to 4500 characters, leaving room for the title and
+	// mentions of the 5000 of markdown messages.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of DingTalk.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for messages the robot did not accept.
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("dingtalk: %d %s", e.Code, e.Message)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when the robot limits the rate of messages, so that APIError implements
+// middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Code == 130101 || e.Code == -1
+}
+
+// New returns an Alerter which posts every alert through the robot.  It
+// fails if opts configure no robot.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" {
+		return alerter.Alerter{}, errors.New("dingtalk: WebhookURL must be set")
+	}
+	webhook, err := url.Parse(opts.WebhookURL)
+	if err != nil {
+		// The URL contains the access token.
+		return alerter.Alerter{}, fmt.Errorf("dingtalk: invalid WebhookURL: %w", err.(*url.Error).Err)
+	}
+	if opts.MentionSeverity == alerter.SeverityInfo {
+		opts.MentionSeverity = alerter.SeverityCritical
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 4500
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	s := &sink{opts: opts, webhook: webhook, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts    Options
+	webhook *url.URL
+	client  *http.Client
+}
+
+// message is a markdown message of a robot.
+type message struct {
+	MsgType  string `json:"msgtype"`
+	Markdown struct {
+		Title string `json:"title"`
+		Text  string `json:"text"`
+	} `json:"markdown"`
+	At struct {
+		AtMobiles []string `json:"atMobiles,omitempty"`
+		IsAtAll   bool     `json:"isAtAll,omitempty"`
+	} `json:"at"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	title := chat.Truncate(chat.Title(a), maxTitle)
+	mention := !a.Resolved && a.Severity >= s.opts.MentionSeverity
+	for i, chunk := range chat.Fit(ctx, s.body(a), s.opts.Overflow) {
+		m := &message{MsgType: "markdown"}
+		m.Markdown.Title = title
+		m.Markdown.Text = chunk
+		if i == 0 {
+			m.Markdown.Text = fmt.Sprintf("<font color=\"%s\">**%s**</font>\n\n%s", chat.HexColor(a), title, chunk)
+			if mention {
+				// Members are only notified if the text mentions them.
+				m.At.AtMobiles, m.At.IsAtAll = s.opts.AtMobiles, s.opts.AtAll
+				for _, mobile := range s.opts.AtMobiles {
+					m.Markdown.Text += " @" + mobile
+				}
+			}
+		}
+		if err := s.post(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// body returns the markdown of a below its title.
+func (s *sink) body(a *alerter.Alert) string {
+	var b strings.Builder
+	if text := chat.Body(a, maxTitle); text != "" {
+		b.WriteString(strings.ReplaceAll(text, "\n", "\n\n"))
+		b.WriteString("\n\n")
+	}
+	for _, f := range chat.Fields(a) {
+		fmt.Fprintf(&b, "- **%s**: %s\n", f.Key, f.Value)
+	}
+	b.WriteString("\n")
+	b.WriteString(s.opts.TimeFormat.Format(a.Time))
+	return b.String()
+}
+
+// post sends m to the robot, signed if there is a secret.
+func (s *sink) post(ctx context.Context, m *message) error {
+	target := s.opts.WebhookURL
+	if s.opts.Secret != "" {
+		u := *s.webhook
+		q := u.Query()
+		timestamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		q.Set("timestamp", timestamp)
+		q.Set("sign", Sign(timestamp, s.opts.Secret))
+		u.RawQuery = q.Encode()
+		target = u.String()
+	}
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := chat.PostJSON(ctx, s.client, target, nil, m, &resp); err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// The URL contains the access token.
+			err = uerr.Err
+		}
+		return fmt.Errorf("dingtalk: %w", err)
+	}
+	if resp.ErrCode != 0 {
+		return &APIError{Code: resp.ErrCode, Message: resp.ErrMsg}
+	}
+	return nil
+}
+
+// Sign returns the signature of a request at timestamp, in milliseconds
+// since the Unix epoch, for robots with the "sign" security setting: the
+// base64-encoded HMAC-SHA256 of "timestamp\nsecret", keyed with secret.
+func Sign(timestamp, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// dryRunReply answers dry-run requests like the robot API.
+func dryRunReply(*http.Request, []byte, int) string {
+	return `{"errcode":0,"errmsg":"ok"}`
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package discord implements an alerter.Sink which posts alerts to Discord
+// webhooks as rich embeds, with a color bar for their severity and their
+// key/value pairs as fields:
+//
+//	a, err := discord.New(discord.Options{
+//		WebhookURL: os.Getenv("DISCORD_WEBHOOK"),
+//		Routes: []discord.Route{
+//			{Matchers: matchers.MustParse(`{team="db"}`), WebhookURL: os.Getenv("DISCORD_DB_WEBHOOK")},
+//		},
+//	})
+//
+// Critical alerts mention @here by default, so that they notify everyone
+// online in the channel.
+package discord
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// Limits of embeds which messages are fitted into.
+const (
+	maxTitle       = 256
+	maxDescription = 4096
+	maxFields      = 25
+	maxFieldName   = 256
+	maxFieldValue  = 1024
+	maxFooter      = 2048
+	// maxEmbed bounds the title, description, fields and footer together.
+	maxEmbed = 6000
+	// maxSummary is room for the field summing up those which do not fit.
+	maxSummary = 24
+)
+
+// Route sends the alerts matching Matchers to the channel of WebhookURL.
+type Route struct {
+	Matchers   matchers.Matchers
+	WebhookURL string
+}
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// WebhookURL is the URL of the webhook of the channel alerts are
+	// posted to if no route matches them.  It may be empty if the routes
+	// cover all alerts, and alerts no route matches are dropped.
+	WebhookURL string
+
+	// Routes send alerts to other channels, the first matching route
+	// winning.
+	Routes []Route
+
+	// Mentions are the mentions posted with alerts of at least a
+	// severity, such as "@here", "@everyone", "<@&ROLE_ID>" for a role or
+	// "<@USER_ID>" for a user.  Defaults to "@here" for critical alerts;
+	// an empty, non-nil map mentions no one.  Resolves mention no one.
+	Mentions map[alerter.Severity]string
+
+	// Username and AvatarURL override the name and avatar of the webhook.
+	Username  string
+	AvatarURL string
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// Overflow delivers messages longer than the description of an embed
+	// allows.  Limit defaults to 4096 characters; split chunks are posted
+	// as messages of their own.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  Schema defaults to
+	// transport.DiscordSchema.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// New returns an Alerter which posts every alert to Discord.  It fails if
+// opts configure no webhook.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" && len(opts.Routes) == 0 {
+		return alerter.Alerter{}, errors.New("discord: WebhookURL or Routes must be set")
+	}
+	if opts.Mentions == nil {
+		opts.Mentions = map[alerter.Severity]string{alerter.SeverityCritical: "@here"}
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = maxDescription
+	}
+	if opts.Transport.Schema == nil {
+		opts.Transport.Schema = transport.DiscordSchema
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+}
+
+// message is a webhook payload.
+type message struct {
+	Content         string           `json:"content,omitempty"`
+	Username        string           `json:"username,omitempty"`
+	AvatarURL       string           `json:"avatar_url,omitempty"`
+	Embeds          []embed          `json:"embeds"`
+	AllowedMentions *allowedMentions `json:"allowed_mentions,omitempty"`
+}
+
+type embed struct {
+	Title       string  `json:"title,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Color       int     `json:"color"`
+	Timestamp   string  `json:"timestamp,omitempty"`
+	Fields      []field `json:"fields,omitempty"`
+	Footer      *footer `json:"footer,omitempty"`
+}
+
+type field struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type footer struct {
+	Text string `json:"text"`
+}
+
+type allowedMentions struct {
+	Parse []string `json:"parse"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	url := s.route(a)
+	if url == "" {
+		return nil
+	}
+	ctx := context.Background()
+	for i, chunk := range chat.Fit(ctx, chat.Body(a, maxTitle), s.opts.Overflow) {
+		m := &message{Username: s.opts.Username, AvatarURL: s.opts.AvatarURL}
+		e := embed{Description: chunk, Color: chat.Color(a)}
+		if i == 0 {
+			m.Content = s.mention(a)
+			if m.Content != "" {
+				m.AllowedMentions = &allowedMentions{Parse: []string{"everyone", "roles", "users"}}
+			}
+			e.Title = chat.Truncate(chat.Title(a), maxTitle)
+			e.Timestamp = a.Time.UTC().Format(time.RFC3339)
+			budget := maxEmbed - utf8.RuneCountInString(e.Title)
+			if a.Name != "" {
+				e.Footer = &footer{Text: chat.Truncate(a.Name, maxFooter)}
+				budget -= utf8.RuneCountInString(e.Footer.Text)
+			}
+			e.Description = chat.Truncate(e.Description, budget)
+			budget -= utf8.RuneCountInString(e.Description)
+			e.Fields = fields(chat.Fields(a), budget)
+		}
+		m.Embeds = []embed{e}
+		if err := chat.PostJSON(ctx, s.client, url, nil, m, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route returns the webhook of the channel of a.
+func (s *sink) route(a *alerter.Alert) string {
+	for _, r := range s.opts.Routes {
+		if r.Matchers.Matches(a) {
+			return r.WebhookURL
+		}
+	}
+	return s.opts.WebhookURL
+}
+
+// mention returns the mention of the highest severity a reaches.
+func (s *sink) mention(a *alerter.Alert) string {
+	if a.Resolved {
+		return ""
+	}
+	mention, best := "", alerter.Severity(-1)
+	for sev, m := range s.opts.Mentions {
+		if a.Severity >= sev && sev > best {
+			mention, best = m, sev
+		}
+	}
+	return mention
+}
+
+// fields returns the fields of an embed, of at most budget characters, the
+// last one summing up those which do not fit.
+func fields(fs []alerter.Field, budget int) []field {
+	var out []field
+	for i, f := range fs {
+		name, value := nonEmpty(chat.Truncate(f.Key, maxFieldName)), nonEmpty(chat.Truncate(f.Value.(string), maxFieldValue))
+		n := utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+		// Unless f is the last one, room is left for the summary.
+		if i < len(fs)-1 && (i == maxFields-1 || n+maxSummary > budget) || n > budget {
+			if budget >= maxSummary {
+				out = append(out, field{Name: "…", Value: strconv.Itoa(len(fs)-i) + " more fields"})
+			}
+			break
+		}
+		out = append(out, field{Name: name, Value: value, Inline: true})
+		budget -= n
+	}
+	return out
+}
+
+// nonEmpty returns s, or "-" if it is empty, as Discord rejects empty
+// field names and values.
+func nonEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
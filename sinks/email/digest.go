@@ -0,0 +1,311 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/file"
+)
+
+// Format is the format of a file attached to digests.
+type Format string
+
+// Formats of attachments.
+const (
+	// CSV attaches alerts.csv, with a row per alert and its key/value
+	// pairs as a JSON object in the last column.
+	CSV Format = "csv"
+
+	// JSON attaches alerts.jsonl, with a line per alert as written by
+	// the file sink, so that file.ReadAlerts reads it back.
+	JSON Format = "json"
+)
+
+// DigestOptions carries parameters which influence the way a Digest mails
+// alerts.
+type DigestOptions struct {
+	// Interval is the period a digest covers, such as a day, timed by the
+	// Clock of the Mailer.  Defaults to 24 hours.
+	Interval time.Duration
+
+	// Formats are the formats of the attachments.  Defaults to CSV and
+	// JSON; an empty, non-nil list attaches nothing.
+	Formats []Format
+
+	// MaxAlerts is the number of alerts a digest holds.  Later alerts of
+	// the period are only counted.  Defaults to 10000.
+	MaxAlerts int
+
+	// Templates render digests.  Defaults to DefaultDigestTemplates.
+	Templates *Templates
+
+	// OnError is called with the digests which could not be mailed.
+	OnError func(alerts []*alerter.Alert, err error)
+}
+
+// DefaultDigestTemplates are the Templates of DigestOptions which set none.
+var DefaultDigestTemplates = &Templates{
+	Subject: texttemplate.Must(texttemplate.New("subject").Parse(`{{.Title}}`)),
+	Text: texttemplate.Must(texttemplate.New("text").Parse(`{{.Title}}
+{{.Since}} - {{.Until}}
+
+{{range .Summary}}{{printf "%6d" .Count}}  {{.Title}}{{if .Resolved}} (resolved){{end}}
+{{end}}{{if .Omitted}}
+{{.Omitted}} alerts are not attached.
+{{end}}`)),
+	HTML: htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif; font-size: 14px;">
+<h2 style="margin-bottom: 4px;">{{.Title}}</h2>
+<p style="color: #666; margin-top: 0;">{{.Since}} &ndash; {{.Until}}</p>
+<table style="border-collapse: collapse;">
+<tr style="text-align: left; border-bottom: 1px solid #ccc;"><th style="padding: 4px 10px;">Count</th><th style="padding: 4px 10px;">Alert</th><th style="padding: 4px 10px;">First</th><th style="padding: 4px 10px;">Last</th></tr>
+{{range .Summary}}<tr style="border-left: 6px solid {{.Color}};"><td style="padding: 4px 10px; text-align: right;">{{.Count}}</td><td style="padding: 4px 10px;">{{.Title}}{{if .Resolved}} <span style="color: #2EB67D;">(resolved)</span>{{end}}</td><td style="padding: 4px 10px; white-space: nowrap;">{{.First}}</td><td style="padding: 4px 10px; white-space: nowrap;">{{.Last}}</td></tr>
+{{end}}</table>
+{{if .Omitted}}<p style="color: #666;">{{.Omitted}} alerts are not attached.</p>
+{{end}}</body></html>
+`)),
+}
+
+// sink is the set of interfaces implemented by the Sinks of
+// alerter.NewSink, embedded so that the Digest lists
+// resolves and keeps the time and ID of the alerts it attaches.
+type sink interface {
+	alerter.Sink
+	alerter.Resolver
+	alerter.AlertSink
+	alerter.BatchSink
+}
+
+// Digest is a Sink which collects alerts and mails them as a single
+// message per period, such as a daily operations report: a table summing
+// up every alert, with the alerts themselves attached as files.  Close must
+// be called to stop it, which also mails the current digest.
+type Digest struct {
+	sink
+
+	m    *Mailer
+	opts DigestOptions
+
+	mu      sync.Mutex
+	since   time.Time
+	alerts  []*alerter.Alert
+	rows    map[string]*digestRow
+	omitted int
+
+	timer  alerter.Timer
+	closed bool
+}
+
+// digestRow is the state of an alert of a digest.
+type digestRow struct {
+	last        *alerter.Alert
+	count       int
+	first, seen time.Time
+}
+
+// NewDigest returns a Digest mailing through m to its recipients.
+func NewDigest(m *Mailer, opts DigestOptions) *Digest {
+	if opts.Interval <= 0 {
+		opts.Interval = 24 * time.Hour
+	}
+	if opts.Formats == nil {
+		opts.Formats = []Format{CSV, JSON}
+	}
+	if opts.MaxAlerts <= 0 {
+		opts.MaxAlerts = 10000
+	}
+	if opts.Templates == nil {
+		opts.Templates = DefaultDigestTemplates
+	}
+	d := &Digest{m: m, opts: opts, since: m.opts.Clock.Now(), rows: map[string]*digestRow{}}
+	d.sink = alerter.NewSink(d.add, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= m.opts.Verbosity },
+		Clock:   m.opts.Clock,
+	}).(sink)
+	d.mu.Lock()
+	d.timer = alerter.AfterFunc(m.opts.Clock, opts.Interval, d.tick)
+	d.mu.Unlock()
+	return d
+}
+
+func (d *Digest) add(a *alerter.Alert) error {
+	fp := a.Fingerprint()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.alerts) < d.opts.MaxAlerts {
+		d.alerts = append(d.alerts, a)
+	} else {
+		d.omitted++
+	}
+	r := d.rows[fp]
+	if r == nil {
+		r = &digestRow{first: a.Time}
+		d.rows[fp] = r
+	}
+	r.last, r.seen = a, a.Time
+	if !a.Resolved {
+		r.count++
+	}
+	return nil
+}
+
+// tick mails the digest every interval.
+func (d *Digest) tick() {
+	_ = d.Flush()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.closed {
+		d.timer.Reset(d.opts.Interval)
+	}
+}
+
+// Flush mails the current digest right away, if it holds alerts, and
+// starts the next one.
+func (d *Digest) Flush() error {
+	now := d.m.opts.Clock.Now()
+	d.mu.Lock()
+	alerts, rows, omitted, since := d.alerts, d.rows, d.omitted, d.since
+	d.alerts, d.rows, d.omitted, d.since = nil, map[string]*digestRow{}, 0, now
+	d.mu.Unlock()
+	if len(rows) == 0 {
+		return nil
+	}
+
+	data := d.data(alerts, rows, omitted, since, now)
+	var attachments []attachment
+	for _, f := range d.opts.Formats {
+		att, err := attach(f, alerts)
+		if err != nil {
+			return d.failed(alerts, err)
+		}
+		attachments = append(attachments, att)
+	}
+	to := d.m.recipients(alerts)
+	msg, err := d.m.render(d.opts.Templates, data, to, nil, attachments)
+	if err == nil {
+		err = d.m.Send(context.Background(), to, msg)
+	}
+	return d.failed(alerts, err)
+}
+
+func (d *Digest) failed(alerts []*alerter.Alert, err error) error {
+	if err != nil && d.opts.OnError != nil {
+		d.opts.OnError(alerts, err)
+	}
+	return err
+}
+
+// Close stops the Digest and mails the current digest.
+func (d *Digest) Close() error {
+	d.mu.Lock()
+	d.closed = true
+	d.timer.Stop()
+	d.mu.Unlock()
+	return d.Flush()
+}
+
+// data returns the data of a digest.
+func (d *Digest) data(alerts []*alerter.Alert, rows map[string]*digestRow, omitted int, since, until time.Time) *Message {
+	tf := d.m.opts.TimeFormat
+	msg := &Message{Since: tf.Format(since), Until: tf.Format(until), Omitted: omitted}
+	var ordered []*digestRow
+	highest := alerter.SeverityInfo
+	total := 0
+	for _, r := range rows {
+		ordered = append(ordered, r)
+		total += r.count
+		if r.count > 0 {
+			highest = max(highest, r.last.Severity)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].count != ordered[j].count {
+			return ordered[i].count > ordered[j].count
+		}
+		return ordered[i].first.Before(ordered[j].first)
+	})
+	for _, r := range ordered {
+		msg.Summary = append(msg.Summary, SummaryRow{
+			Title:    chat.Title(r.last),
+			Color:    chat.HexColor(r.last),
+			Count:    r.count,
+			First:    tf.Format(r.first),
+			Last:     tf.Format(r.seen),
+			Resolved: r.last.Resolved,
+		})
+	}
+	for _, a := range alerts {
+		msg.Alerts = append(msg.Alerts, Alert{
+			Alert:  a,
+			Title:  chat.Title(a),
+			Text:   chat.Text(a),
+			Time:   tf.Format(a.Time),
+			Color:  chat.HexColor(a),
+			Fields: chat.Fields(a),
+		})
+	}
+	msg.Title = fmt.Sprintf("Digest: %d alerts (%d distinct), highest %s", total, len(rows), highest)
+	return msg
+}
+
+// attach returns the attachment holding alerts in the given format.
+func attach(f Format, alerts []*alerter.Alert) (attachment, error) {
+	var buf bytes.Buffer
+	switch f {
+	case CSV:
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"time", "severity", "resolved", "name", "message", "error", "fields"})
+		for _, a := range alerts {
+			fields := map[string]interface{}{}
+			for _, field := range a.Fields() {
+				if field.Key != alerter.SeverityKey {
+					fields[field.Key] = field.Value
+				}
+			}
+			encoded, err := json.Marshal(fields)
+			if err != nil {
+				encoded = []byte(fmt.Sprint(fields))
+			}
+			var errText string
+			if a.Err != nil {
+				errText = a.Err.Error()
+			}
+			w.Write([]string{a.Time.Format(time.RFC3339Nano), a.Severity.String(), strconv.FormatBool(a.Resolved), a.Name, a.Message, errText, string(encoded)})
+		}
+		w.Flush()
+		return attachment{name: "alerts.csv", contentType: "text/csv", data: buf.Bytes()}, w.Error()
+	case JSON:
+		err := alerter.SendBatch(file.New(&buf, math.MaxInt).GetSink(), alerts)
+		return attachment{name: "alerts.jsonl", contentType: "application/x-ndjson", data: buf.Bytes()}, err
+	}
+	return attachment{}, errors.New("email: unknown digest format " + strconv.Quote(string(f)))
+}
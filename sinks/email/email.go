@@ -0,0 +1,434 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package email implements an alerter.Sink which mails alerts over SMTP,
+// with plain-text and HTML bodies rendered from templates:
+//
+//	m, err := email.NewMailer(email.Options{
+//		Addr:     "smtp.example.com:587",
+//		Username: "alerts@example.com",
+//		Password: os.Getenv("SMTP_PASSWORD"),
+//		From:     "alerts@example.com",
+//		To:       []string{"oncall@example.com"},
+//		Recipients: map[alerter.Severity][]string{
+//			alerter.SeverityCritical: {"sre-leads@example.com"},
+//		},
+//	})
+//	...
+//	a := email.New(m)
+//
+// Connections are kept open and reused, so that bursts of alerts do not
+// pay for a handshake each.  Batches, e.g. of a middleware.Grouper, are
+// mailed as a single message.
+//
+// A Digest mails a report per period instead, such as a daily summary for
+// operations, with the alerts attached as CSV and JSON files:
+//
+//	d := email.NewDigest(m, email.DigestOptions{Interval: 24 * time.Hour})
+//	defer d.Close()
+//	a := alerter.New(d)
+package email
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Security is the way connections to the server are secured.
+type Security string
+
+// Security modes.
+const (
+	// STARTTLS upgrades connections with the STARTTLS command, failing
+	// if the server does not support it.  This is the mode of port 587.
+	STARTTLS Security = "starttls"
+
+	// ImplicitTLS speaks TLS from the start, as on port 465.
+	ImplicitTLS Security = "tls"
+
+	// Plaintext does not secure connections, e.g. for a local relay.
+	// Authentication is refused over plaintext connections to other
+	// hosts.
+	Plaintext Security = "none"
+)
+
+// Options carries parameters which influence the way alerts are mailed.
+type Options struct {
+	// Addr is the address of the SMTP server, such as
+	// "smtp.example.com:587".
+	Addr string
+
+	// Security secures connections.  Defaults to STARTTLS.
+	Security Security
+
+	// TLSConfig configures TLS.  Its ServerName defaults to the host of
+	// Addr.
+	TLSConfig *tls.Config
+
+	// Username and Password authenticate with PLAIN, if Username is set.
+	Username string
+	Password string
+
+	// Auth, if set, authenticates instead of Username and Password, e.g.
+	// with smtp.CRAMMD5Auth.
+	Auth smtp.Auth
+
+	// LocalName is sent with HELO.  Defaults to "localhost".
+	LocalName string
+
+	// From is the sender address.
+	From string
+
+	// To are the recipients of all alerts.
+	To []string
+
+	// Recipients are mailed in addition to To the alerts of at least a
+	// severity.
+	Recipients map[alerter.Severity][]string
+
+	// Templates render messages.  Defaults to DefaultTemplates.
+	Templates *Templates
+
+	// MaxConns bounds the connections to the server, and thereby the
+	// messages mailed at once.  Defaults to 2.
+	MaxConns int
+
+	// IdleTimeout closes connections unused for this time.  Defaults to
+	// 30 seconds.
+	IdleTimeout time.Duration
+
+	// Timeout bounds connecting, and every command.  Defaults to 30
+	// seconds.
+	Timeout time.Duration
+
+	// Verbosity is the highest V-level of Info alerts which are mailed.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Clock stamps alerts and messages with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Mailer mails messages over a pool of connections.  It is safe for
+// concurrent use.
+type Mailer struct {
+	opts Options
+	host string
+
+	// slots bounds the connections in use and idle.
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is a connection to the server.
+type conn struct {
+	client *smtp.Client
+	raw    net.Conn
+	used   time.Time
+}
+
+// NewMailer returns a Mailer for opts.  It does not connect until the first
+// message is mailed.
+func NewMailer(opts Options) (*Mailer, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("email: %w", err)
+	}
+	if opts.From == "" {
+		return nil, errors.New("email: From must be set")
+	}
+	if opts.Security == "" {
+		opts.Security = STARTTLS
+	}
+	switch opts.Security {
+	case STARTTLS, ImplicitTLS, Plaintext:
+	default:
+		return nil, fmt.Errorf("email: unknown security %q", opts.Security)
+	}
+	if opts.TLSConfig == nil {
+		opts.TLSConfig = &tls.Config{}
+	}
+	if opts.TLSConfig.ServerName == "" {
+		opts.TLSConfig = opts.TLSConfig.Clone()
+		opts.TLSConfig.ServerName = host
+	}
+	if opts.Auth == nil && opts.Username != "" {
+		opts.Auth = smtp.PlainAuth("", opts.Username, opts.Password, host)
+	}
+	if opts.LocalName == "" {
+		opts.LocalName = "localhost"
+	}
+	if opts.Templates == nil {
+		opts.Templates = DefaultTemplates
+	}
+	if opts.MaxConns <= 0 {
+		opts.MaxConns = 2
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = 30 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	return &Mailer{opts: opts, host: host, slots: make(chan struct{}, opts.MaxConns)}, nil
+}
+
+// New returns an Alerter which mails every alert through m.
+func New(m *Mailer) alerter.Alerter {
+	return alerter.New(alerter.NewSink(m.send, alerter.SinkOptions{
+		Enabled:   func(level int) bool { return level <= m.opts.Verbosity },
+		Clock:     m.opts.Clock,
+		SendBatch: m.sendBatch,
+	}))
+}
+
+func (m *Mailer) send(a *alerter.Alert) error {
+	return m.sendBatch([]*alerter.Alert{a})
+}
+
+func (m *Mailer) sendBatch(alerts []*alerter.Alert) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	to := m.recipients(alerts)
+	msg, err := m.compose(alerts, to)
+	if err != nil {
+		return err
+	}
+	return m.Send(context.Background(), to, msg)
+}
+
+// recipients returns the recipients of alerts, those of the highest
+// severity among them included.
+func (m *Mailer) recipients(alerts []*alerter.Alert) []string {
+	highest := alerts[0].Severity
+	for _, a := range alerts[1:] {
+		highest = max(highest, a.Severity)
+	}
+	seen := map[string]bool{}
+	var to []string
+	add := func(addrs []string) {
+		for _, addr := range addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				to = append(to, addr)
+			}
+		}
+	}
+	add(m.opts.To)
+	severities := make([]alerter.Severity, 0, len(m.opts.Recipients))
+	for sev := range m.opts.Recipients {
+		severities = append(severities, sev)
+	}
+	sort.Slice(severities, func(i, j int) bool { return severities[i] < severities[j] })
+	for _, sev := range severities {
+		if highest >= sev {
+			add(m.opts.Recipients[sev])
+		}
+	}
+	return to
+}
+
+// Send mails msg, a complete message with headers, from Options.From to
+// the given recipients.  A connection which turns out to be broken is
+// replaced once.
+func (m *Mailer) Send(ctx context.Context, to []string, msg []byte) error {
+	if len(to) == 0 {
+		return errors.New("email: no recipients")
+	}
+	select {
+	case m.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-m.slots }()
+
+	c, reused, err := m.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = m.transmit(c, to, msg)
+	var reply *textproto.Error
+	if err != nil && reused && !errors.As(err, &reply) {
+		c.raw.Close()
+		if c, err = m.dial(ctx); err != nil {
+			return err
+		}
+		err = m.transmit(c, to, msg)
+	}
+	switch {
+	case err == nil:
+		m.put(c)
+		return nil
+	case errors.As(err, &reply):
+		// The server refused the message, but the connection is fine.
+		if c.client.Reset() == nil {
+			m.put(c)
+		} else {
+			c.raw.Close()
+		}
+		return &ReplyError{Code: reply.Code, Msg: reply.Msg}
+	}
+	c.raw.Close()
+	return fmt.Errorf("email: %w", err)
+}
+
+// ReplyError is returned for messages the server refused.
+type ReplyError struct {
+	// Code is the SMTP reply code, such as 550.
+	Code int
+
+	// Msg is the text of the reply.
+	Msg string
+}
+
+func (e *ReplyError) Error() string {
+	return fmt.Sprintf("email: %d %s", e.Code, e.Msg)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// for transient failures with 4xx codes, so that ReplyError implements
+// middleware.RetryableError.
+func (e *ReplyError) Retryable() bool {
+	return e.Code < 500
+}
+
+// get returns an idle connection, or a new one if there is none.
+func (m *Mailer) get(ctx context.Context) (*conn, bool, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil, false, errors.New("email: mailer closed")
+	}
+	now := time.Now()
+	for len(m.idle) > 0 {
+		c := m.idle[len(m.idle)-1]
+		m.idle = m.idle[:len(m.idle)-1]
+		if now.Sub(c.used) < m.opts.IdleTimeout {
+			m.mu.Unlock()
+			return c, true, nil
+		}
+		c.client.Close()
+	}
+	m.mu.Unlock()
+	c, err := m.dial(ctx)
+	return c, false, err
+}
+
+// put returns c to the idle connections.
+func (m *Mailer) put(c *conn) {
+	c.used = time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || len(m.idle) >= m.opts.MaxConns {
+		c.client.Quit()
+		return
+	}
+	m.idle = append(m.idle, c)
+}
+
+// dial connects, secures the connection and authenticates.
+func (m *Mailer) dial(ctx context.Context) (*conn, error) {
+	dialer := &net.Dialer{Timeout: m.opts.Timeout}
+	raw, err := dialer.DialContext(ctx, "tcp", m.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("email: %w", err)
+	}
+	if m.opts.Security == ImplicitTLS {
+		raw = tls.Client(raw, m.opts.TLSConfig)
+	}
+	raw.SetDeadline(time.Now().Add(m.opts.Timeout))
+	client, err := smtp.NewClient(raw, m.host)
+	if err == nil {
+		err = m.handshake(client)
+	}
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("email: %w", err)
+	}
+	return &conn{client: client, raw: raw}, nil
+}
+
+func (m *Mailer) handshake(client *smtp.Client) error {
+	if err := client.Hello(m.opts.LocalName); err != nil {
+		return err
+	}
+	if m.opts.Security == STARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("server does not support STARTTLS")
+		}
+		if err := client.StartTLS(m.opts.TLSConfig); err != nil {
+			return err
+		}
+	}
+	if m.opts.Auth != nil {
+		return client.Auth(m.opts.Auth)
+	}
+	return nil
+}
+
+// transmit mails msg over c.
+func (m *Mailer) transmit(c *conn, to []string, msg []byte) error {
+	c.raw.SetDeadline(time.Now().Add(m.opts.Timeout))
+	if err := c.client.Mail(m.opts.From); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err := c.client.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// Close closes the idle connections.  Messages cannot be mailed afterwards.
+func (m *Mailer) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	var errs []error
+	for _, c := range m.idle {
+		errs = append(errs, c.client.Quit())
+	}
+	m.idle = nil
+	return errors.Join(errs...)
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package email
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+)
+
+// Templates render the messages of alerts from a Message.
+type Templates struct {
+	// Subject renders the subject line.  Newlines are replaced by spaces.
+	Subject *texttemplate.Template
+
+	// Text renders the plain-text body.
+	Text *texttemplate.Template
+
+	// HTML, if set, renders the HTML body, which mail clients show
+	// instead of the plain-text one.
+	HTML *htmltemplate.Template
+}
+
+// Message is the data templates are executed with.
+type Message struct {
+	// Title sums the alerts up, such as "[ERROR] db: connection lost"
+	// for a single alert or "[CRITICAL] 3 alerts, 1 resolved" for more.
+	Title string
+
+	// Alerts are the alerts of the message, oldest first.
+	Alerts []Alert
+
+	// Since and Until are the period a digest covers, rendered with
+	// Options.TimeFormat.  They are empty for other messages.
+	Since, Until string
+
+	// Summary has a row per alert of a digest, most frequent first.
+	Summary []SummaryRow
+
+	// Omitted is the number of alerts of a digest beyond
+	// DigestOptions.MaxAlerts, which are counted in Summary but missing
+	// from Alerts and the attachments.
+	Omitted int
+}
+
+// SummaryRow sums up the occurrences of an alert in a digest.
+type SummaryRow struct {
+	// Title and Color are those of the latest occurrence.
+	Title string
+	Color string
+
+	// Count is the number of occurrences, not counting resolves.
+	Count int
+
+	// First and Last are the times of the first and latest occurrence.
+	First, Last string
+
+	// Resolved tells whether the latest occurrence is a resolve.
+	Resolved bool
+}
+
+// Alert is an alert as templates see it.
+type Alert struct {
+	*alerter.Alert
+
+	// Title is the title of the alert, such as "[ERROR] db: connection
+	// lost".
+	Title string
+
+	// Text is its message and error.
+	Text string
+
+	// Time is its time, rendered with Options.TimeFormat.
+	Time string
+
+	// Color is the color of its severity, as "#RRGGBB".
+	Color string
+
+	// Fields are its key/value pairs except the severity, with values
+	// formatted.
+	Fields []alerter.Field
+}
+
+// DefaultTemplates are the Templates of Options which set none.
+var DefaultTemplates = &Templates{
+	Subject: texttemplate.Must(texttemplate.New("subject").Parse(`{{.Title}}`)),
+	Text: texttemplate.Must(texttemplate.New("text").Parse(`{{range .Alerts}}{{.Title}}
+{{if .Err}}{{.Err}}
+{{end}}{{range .Fields}}{{.Key}}: {{.Value}}
+{{end}}{{.Time}}
+
+{{end}}`)),
+	HTML: htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html><body style="font-family: sans-serif; font-size: 14px;">
+{{range .Alerts}}<table style="border-left: 6px solid {{.Color}}; margin-bottom: 16px; border-collapse: collapse; width: 100%;">
+<tr><td colspan="2" style="padding: 6px 10px; font-size: 16px; font-weight: bold;">{{.Title}}</td></tr>
+{{if .Err}}<tr><td colspan="2" style="padding: 2px 10px;"><pre style="margin: 0; white-space: pre-wrap;">{{.Err}}</pre></td></tr>
+{{end}}{{range .Fields}}<tr><td style="padding: 2px 10px; color: #666; vertical-align: top; white-space: nowrap;">{{.Key}}</td><td style="padding: 2px 10px;">{{.Value}}</td></tr>
+{{end}}<tr><td colspan="2" style="padding: 6px 10px; color: #999; font-size: 12px;">{{.Time}}</td></tr>
+</table>
+{{end}}</body></html>
+`)),
+}
+
+// data returns the data of the message about alerts.
+func (m *Mailer) data(alerts []*alerter.Alert) *Message {
+	msg := &Message{Alerts: make([]Alert, len(alerts))}
+	highest, resolved := alerts[0].Severity, 0
+	for i, a := range alerts {
+		msg.Alerts[i] = Alert{
+			Alert:  a,
+			Title:  chat.Title(a),
+			Text:   chat.Text(a),
+			Time:   m.opts.TimeFormat.Format(a.Time),
+			Color:  chat.HexColor(a),
+			Fields: chat.Fields(a),
+		}
+		if !a.Resolved {
+			highest = max(highest, a.Severity)
+		}
+		if a.Resolved {
+			resolved++
+		}
+	}
+	if len(alerts) == 1 {
+		msg.Title = msg.Alerts[0].Title
+		return msg
+	}
+	label := strings.ToUpper(highest.String())
+	if resolved == len(alerts) {
+		label = "RESOLVED"
+	}
+	msg.Title = fmt.Sprintf("[%s] %d alerts", label, len(alerts))
+	if resolved > 0 && resolved < len(alerts) {
+		msg.Title += fmt.Sprintf(", %d resolved", resolved)
+	}
+	return msg
+}
+
+// attachment is a file attached to a message.
+type attachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+// compose returns the message mailing alerts to the given recipients.
+func (m *Mailer) compose(alerts []*alerter.Alert, to []string) ([]byte, error) {
+	return m.render(m.opts.Templates, m.data(alerts), to, alerts, nil)
+}
+
+// render returns the message rendering data with t, with the attachments.
+func (m *Mailer) render(t *Templates, data *Message, to []string, alerts []*alerter.Alert, attachments []attachment) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+	if err := t.Subject.Execute(&subject, data); err != nil {
+		return nil, fmt.Errorf("email: subject: %w", err)
+	}
+	if err := t.Text.Execute(&text, data); err != nil {
+		return nil, fmt.Errorf("email: text: %w", err)
+	}
+	if t.HTML != nil {
+		if err := t.HTML.Execute(&html, data); err != nil {
+			return nil, fmt.Errorf("email: html: %w", err)
+		}
+	}
+	header, body, err := bodyPart(text.Bytes(), html.Bytes(), t.HTML != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	m.writeHeader(&buf, strings.Join(strings.Fields(subject.String()), " "), to, alerts)
+	if len(attachments) == 0 {
+		for _, k := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+			if v := header.Get(k); v != "" {
+				fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+			}
+		}
+		buf.WriteString("\r\n")
+		buf.Write(body)
+		return buf.Bytes(), nil
+	}
+	mw := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	w, err := mw.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	w.Write(body)
+	for _, a := range attachments {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.contentType, map[string]string{"name": a.name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(w, a.data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyPart returns the header and content of the body of a message: the
+// plain text, or both the plain text and HTML as alternatives.
+func bodyPart(text, html []byte, hasHTML bool) (textproto.MIMEHeader, []byte, error) {
+	var buf bytes.Buffer
+	if !hasHTML {
+		writeQuotedPrintable(&buf, text)
+		return textproto.MIMEHeader{
+			"Content-Type":              {"text/plain; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		}, buf.Bytes(), nil
+	}
+	mw := multipart.NewWriter(&buf)
+	for _, part := range []struct {
+		contentType string
+		body        []byte
+	}{{"text/plain", text}, {"text/html", html}} {
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		writeQuotedPrintable(w, part.body)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return textproto.MIMEHeader{"Content-Type": {"multipart/alternative; boundary=" + mw.Boundary()}}, buf.Bytes(), nil
+}
+
+// writeHeader writes the header fields of a message about alerts, up to
+// its content type.
+func (m *Mailer) writeHeader(buf *bytes.Buffer, subject string, to []string, alerts []*alerter.Alert) {
+	var id [16]byte
+	rand.Read(id[:])
+	fmt.Fprintf(buf, "From: %s\r\n", m.opts.From)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", m.opts.Clock.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id[:]), m.host)
+	if len(alerts) == 1 {
+		// Mail clients thread the messages about an alert, including
+		// its resolve, by the reference to its fingerprint.
+		ref := fmt.Sprintf("<%s@alerter>", alerts[0].Fingerprint())
+		fmt.Fprintf(buf, "In-Reply-To: %s\r\nReferences: %s\r\n", ref, ref)
+		if a := alerts[0]; a.Severity >= alerter.SeverityError && !a.Resolved {
+			buf.WriteString("Importance: high\r\nX-Priority: 1\r\n")
+		}
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+}
+
+func writeQuotedPrintable(w io.Writer, body []byte) {
+	qw := quotedprintable.NewWriter(w)
+	qw.Write(body)
+	qw.Close()
+}
+
+// writeBase64 writes data base64-encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		io.WriteString(w, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(w, encoded+"\r\n")
+}
@@ -0,0 +1,239 @@
+//go:build windows
+
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package eventlog implements an alerter.Sink which writes alerts to the
+// Windows Event Log, for services deployed on Windows hosts.
+//
+// Event Viewer needs the event source to be registered in order to render
+// entries; Install does so once, usually from an installer running with
+// administrative rights:
+//
+//	if err := eventlog.Install("MyService"); err != nil {
+//		...
+//	}
+//	l, err := eventlog.Open(eventlog.Options{Source: "MyService"})
+//	if err != nil {
+//		...
+//	}
+//	defer l.Close()
+//	alerter := eventlog.New(l)
+package eventlog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Event types understood by the Event Log.
+const (
+	eventlogErrorType       = 0x0001
+	eventlogWarningType     = 0x0002
+	eventlogInformationType = 0x0004
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSourceW  = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEventW          = advapi32.NewProc("ReportEventW")
+	procRegCreateKeyExW       = advapi32.NewProc("RegCreateKeyExW")
+	procRegSetValueExW        = advapi32.NewProc("RegSetValueExW")
+)
+
+// Options carries parameters which influence the way alerts are written.
+type Options struct {
+	// Source is the event source name entries are written under.
+	Source string
+
+	// Verbosity is the highest V-level of Info alerts which are written.
+	Verbosity int
+
+	// EventID maps an alert to the ID of its event.  When nil,
+	// DefaultEventID is used.
+	EventID func(a *alerter.Alert) uint32
+}
+
+// DefaultEventID derives event IDs from the severity of an alert: 100 for
+// info, 200 for warning, 300 for error and 400 for critical alerts.
+// Resolved alerts use 101.  The IDs stay within the range 1 to 1000
+// supported by the message file registered by Install.
+func DefaultEventID(a *alerter.Alert) uint32 {
+	if a.Resolved {
+		return 101
+	}
+	switch {
+	case a.Severity >= alerter.SeverityCritical:
+		return 400
+	case a.Severity == alerter.SeverityError:
+		return 300
+	case a.Severity == alerter.SeverityWarning:
+		return 200
+	default:
+		return 100
+	}
+}
+
+// Log is a handle to a registered event source.
+type Log struct {
+	opts   Options
+	handle syscall.Handle
+}
+
+// Open registers opts.Source with the local Event Log.
+func Open(opts Options) (*Log, error) {
+	if opts.Source == "" {
+		return nil, errors.New("eventlog: empty source")
+	}
+	if opts.EventID == nil {
+		opts.EventID = DefaultEventID
+	}
+	source, err := syscall.UTF16PtrFromString(opts.Source)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := procRegisterEventSourceW.Call(0, uintptr(unsafe.Pointer(source)))
+	if h == 0 {
+		return nil, fmt.Errorf("eventlog: RegisterEventSource: %w", err)
+	}
+	return &Log{opts: opts, handle: syscall.Handle(h)}, nil
+}
+
+// New returns an Alerter which writes every alert to l.
+func New(l *Log) alerter.Alerter {
+	return alerter.New(alerter.NewSink(l.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= l.opts.Verbosity },
+	}))
+}
+
+// Close deregisters the event source.
+func (l *Log) Close() error {
+	r, _, err := procDeregisterEventSource.Call(uintptr(l.handle))
+	if r == 0 {
+		return fmt.Errorf("eventlog: DeregisterEventSource: %w", err)
+	}
+	return nil
+}
+
+func (l *Log) send(a *alerter.Alert) error {
+	var b strings.Builder
+	if a.Name != "" {
+		b.WriteString(a.Name)
+		b.WriteString(": ")
+	}
+	b.WriteString(a.Message)
+	if a.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(a.Err.Error())
+	}
+	if a.Resolved {
+		b.WriteString(" (resolved)")
+	}
+	b.WriteString("\r\n")
+	for _, f := range a.Fields() {
+		fmt.Fprintf(&b, "\r\n%s=%v", f.Key, f.Value)
+	}
+
+	msg, err := syscall.UTF16PtrFromString(strings.ReplaceAll(b.String(), "\x00", ""))
+	if err != nil {
+		return err
+	}
+	var etype uintptr = eventlogInformationType
+	switch {
+	case a.Resolved:
+	case a.Severity >= alerter.SeverityError:
+		etype = eventlogErrorType
+	case a.Severity == alerter.SeverityWarning:
+		etype = eventlogWarningType
+	}
+	strs := []*uint16{msg}
+	r, _, err := procReportEventW.Call(
+		uintptr(l.handle),
+		etype,
+		0, // category
+		uintptr(l.opts.EventID(a)),
+		0, // user SID
+		uintptr(len(strs)),
+		0, // raw data size
+		uintptr(unsafe.Pointer(&strs[0])),
+		0, // raw data
+	)
+	if r == 0 {
+		return fmt.Errorf("eventlog: ReportEvent: %w", err)
+	}
+	return nil
+}
+
+// Install registers source in the Application log, using the generic
+// message file of EventCreate.exe which renders the alert text as is.  It
+// requires administrative rights and only needs to be done once per host.
+func Install(source string) error {
+	path, err := syscall.UTF16PtrFromString(`SYSTEM\CurrentControlSet\Services\EventLog\Application\` + source)
+	if err != nil {
+		return err
+	}
+	const keyWrite = 0x20006
+	var key syscall.Handle
+	r, _, _ := procRegCreateKeyExW.Call(
+		uintptr(syscall.HKEY_LOCAL_MACHINE),
+		uintptr(unsafe.Pointer(path)),
+		0, 0, 0,
+		keyWrite,
+		0,
+		uintptr(unsafe.Pointer(&key)),
+		0,
+	)
+	if r != 0 {
+		return fmt.Errorf("eventlog: create registry key: %w", syscall.Errno(r))
+	}
+	defer syscall.RegCloseKey(key)
+
+	file, err := syscall.UTF16FromString(`%SystemRoot%\System32\EventCreate.exe`)
+	if err != nil {
+		return err
+	}
+	if err := setValue(key, "EventMessageFile", syscall.REG_EXPAND_SZ,
+		(*byte)(unsafe.Pointer(&file[0])), uint32(len(file)*2)); err != nil {
+		return err
+	}
+	types := uint32(eventlogErrorType | eventlogWarningType | eventlogInformationType)
+	return setValue(key, "TypesSupported", syscall.REG_DWORD, (*byte)(unsafe.Pointer(&types)), 4)
+}
+
+func setValue(key syscall.Handle, name string, typ uint32, data *byte, size uint32) error {
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	r, _, _ := procRegSetValueExW.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(n)),
+		0,
+		uintptr(typ),
+		uintptr(unsafe.Pointer(data)),
+		uintptr(size),
+	)
+	if r != 0 {
+		return fmt.Errorf("eventlog: set registry value %s: %w", name, syscall.Errno(r))
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package feishu implements an alerter.Sink which posts alerts to the
+// custom bots of Feishu and Lark group chats as interactive cards, with a
+// header colored by severity, the key/value pairs of alerts as fields and
+// buttons linking to runbooks or dashboards:
+//
+//	a, err := feishu.New(feishu.Options{
+//		WebhookURL: "https://open.feishu.cn/open-apis/bot/v2/hook/xxx",
+//		Secret:     os.Getenv("FEISHU_SECRET"),
+//		Buttons:    []feishu.Button{{Text: "Runbook", Key: "runbook_url"}},
+//	})
+package feishu
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// maxTitle is the number of characters of the header of a card.
+const maxTitle = 100
+
+// Button is a button of the cards of alerts, opening a URL.
+type Button struct {
+	// Text is the label of the button.
+	Text string
+
+	// URL is the page the button opens.
+	URL string
+
+	// Key, if set, is the key of the value of alerts which holds the URL
+	// instead, such as "runbook_url".  Alerts without it show no button.
+	Key string
+}
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// WebhookURL is the URL of the bot.
+	WebhookURL string
+
+	// Secret signs requests, for bots with signature verification
+	// enabled.
+	Secret string
+
+	// Buttons are the buttons of cards.
+	Buttons []Button
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than a card should hold.  Limit
+	// defaults to 4000 characters.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Feishu.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for messages the bot did not accept.
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("feishu: %d %s", e.Code, e.Message)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when the bot limits the rate of messages, so that APIError implements
+// middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Code == 11232
+}
+
+// New returns an Alerter which posts every alert through the bot.  It fails
+// if opts configure no bot.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" {
+		return alerter.Alerter{}, errors.New("feishu: WebhookURL must be set")
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 4000
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+}
+
+// message is a message of a custom bot.
+type message struct {
+	Timestamp string `json:"timestamp,omitempty"`
+	Sign      string `json:"sign,omitempty"`
+	MsgType   string `json:"msg_type"`
+	Card      card   `json:"card"`
+}
+
+type card struct {
+	Config struct {
+		WideScreenMode bool `json:"wide_screen_mode"`
+	} `json:"config"`
+	Header   *header   `json:"header,omitempty"`
+	Elements []element `json:"elements"`
+}
+
+type header struct {
+	Title    text   `json:"title"`
+	Template string `json:"template"`
+}
+
+type text struct {
+	Tag     string `json:"tag"`
+	Content string `json:"content"`
+}
+
+type element struct {
+	Tag      string   `json:"tag"`
+	Text     *text    `json:"text,omitempty"`
+	Fields   []field  `json:"fields,omitempty"`
+	Actions  []action `json:"actions,omitempty"`
+	Elements []text   `json:"elements,omitempty"`
+}
+
+type field struct {
+	IsShort bool `json:"is_short"`
+	Text    text `json:"text"`
+}
+
+type action struct {
+	Tag  string `json:"tag"`
+	Text text   `json:"text"`
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	for i, chunk := range chat.Fit(ctx, chat.Body(a, maxTitle), s.opts.Overflow) {
+		m := &message{MsgType: "interactive"}
+		m.Card.Config.WideScreenMode = true
+		m.Card.Header = &header{Title: text{Tag: "plain_text", Content: chat.Truncate(chat.Title(a), maxTitle)}, Template: template(a)}
+		if chunk != "" {
+			m.Card.Elements = append(m.Card.Elements, element{Tag: "div", Text: &text{Tag: "lark_md", Content: escape(chunk)}})
+		}
+		if i == 0 {
+			m.Card.Elements = append(m.Card.Elements, s.details(a)...)
+		}
+		if err := s.post(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// template returns the color of the header of a card about a.
+func template(a *alerter.Alert) string {
+	switch {
+	case a.Resolved:
+		return "green"
+	case a.Severity >= alerter.SeverityCritical:
+		return "carmine"
+	case a.Severity == alerter.SeverityError:
+		return "red"
+	case a.Severity == alerter.SeverityWarning:
+		return "orange"
+	}
+	return "blue"
+}
+
+// details returns the fields, buttons and time of a card about a.
+func (s *sink) details(a *alerter.Alert) []element {
+	var elements []element
+	fields := chat.Fields(a)
+	if len(fields) > 0 {
+		e := element{Tag: "div"}
+		for _, f := range fields {
+			value := f.Value.(string)
+			e.Fields = append(e.Fields, field{IsShort: len(value) <= 40, Text: text{Tag: "lark_md", Content: "**" + escape(f.Key) + "**\n" + escape(value)}})
+		}
+		elements = append(elements, e)
+	}
+	var actions []action
+	for _, b := range s.opts.Buttons {
+		link := b.URL
+		if b.Key != "" {
+			link = ""
+			for _, f := range fields {
+				if f.Key == b.Key {
+					link = f.Value.(string)
+				}
+			}
+		}
+		if link != "" {
+			actions = append(actions, action{Tag: "button", Text: text{Tag: "plain_text", Content: b.Text}, Type: "default", URL: link})
+		}
+	}
+	if len(actions) > 0 {
+		elements = append(elements, element{Tag: "action", Actions: actions})
+	}
+	note := s.opts.TimeFormat.Format(a.Time)
+	if a.Name != "" {
+		note = a.Name + " · " + note
+	}
+	return append(elements, element{Tag: "note", Elements: []text{{Tag: "plain_text", Content: note}}})
+}
+
+// escape escapes the characters lark_md would otherwise interpret.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "*", "\\*", "~", "\\~", "[", "\\[", "]", "\\]").Replace(s)
+}
+
+// post sends m to the bot, signed if there is a secret.
+func (s *sink) post(ctx context.Context, m *message) error {
+	if s.opts.Secret != "" {
+		m.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+		m.Sign = Sign(m.Timestamp, s.opts.Secret)
+	}
+	var resp struct {
+		Code int    `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := chat.PostJSON(ctx, s.client, s.opts.WebhookURL, nil, m, &resp); err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// The URL identifies the bot.
+			err = uerr.Err
+		}
+		return fmt.Errorf("feishu: %w", err)
+	}
+	if resp.Code != 0 {
+		return &APIError{Code: resp.Code, Message: resp.Msg}
+	}
+	return nil
+}
+
+// Sign returns the signature of a request at timestamp, in seconds since
+// the Unix epoch, for bots with signature verification: the base64-encoded
+// HMAC-SHA256 of nothing, keyed with "timestamp\nsecret".
+func Sign(timestamp, secret string) string {
+	mac := hmac.New(sha256.New, []byte(timestamp+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// dryRunReply answers dry-run requests like the bot API.
+func dryRunReply(*http.Request, []byte, int) string {
+	return `{"code":0,"msg":"success"}`
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package file implements an alerter.Sink which appends alerts to a file,
+// one JSON object per line.
+//
+// The Writer in this package takes care of size- and time-based rotation,
+// retention and compression of rotated files, so that an Alerter can write
+// to local disk without external tooling:
+//
+//	w, err := file.NewWriter(file.Options{
+//		Path:       "/var/log/myapp/alerts.log",
+//		MaxSize:    100 << 20,
+//		MaxBackups: 10,
+//		Compress:   true,
+//	})
+//	if err != nil {
+//		...
+//	}
+//	defer w.Close()
+//	alerter := file.New(w, 0)
+package file
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// New returns an Alerter which writes every alert to w as a single line of
+// JSON.  Info alerts with a V-level above verbosity are discarded.
+func New(w io.Writer, verbosity int) alerter.Alerter {
+	return NewWithOptions(w, SinkOptions{Verbosity: verbosity})
+}
+
+// SinkOptions carries parameters which influence the way alerts are
+// written.
+type SinkOptions struct {
+	// Verbosity is the highest V-level of Info alerts which are written.
+	Verbosity int
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock; alerter.UTC(alerter.SystemClock) writes all
+	// timestamps in UTC.
+	Clock alerter.Clock
+
+	// TimeFormat is the layout of the "time" field, see time.Layout, or
+	// one of alerter.UnixLayout, alerter.UnixMilliLayout and
+	// alerter.RelativeLayout.  Defaults to time.RFC3339Nano.
+	TimeFormat string
+
+	// Location is the time zone of the "time" field.  Defaults to the
+	// location of the Clock.
+	Location *time.Location
+}
+
+// NewWithOptions is like New, with more control over the output.
+func NewWithOptions(w io.Writer, opts SinkOptions) alerter.Alerter {
+	if opts.TimeFormat == "" {
+		opts.TimeFormat = time.RFC3339Nano
+	}
+	s := &sink{w: w, timeFormat: alerter.TimeFormat{
+		Layout:   opts.TimeFormat,
+		Location: opts.Location,
+		Clock:    opts.Clock,
+	}}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled:   func(level int) bool { return level <= opts.Verbosity },
+		Clock:     opts.Clock,
+		SendBatch: s.sendBatch,
+	}))
+}
+
+type sink struct {
+	mu         sync.Mutex
+	w          io.Writer
+	timeFormat alerter.TimeFormat
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	var buf bytes.Buffer
+	s.format(&buf, a)
+	return s.write(buf.Bytes())
+}
+
+// sendBatch writes all alerts with a single Write.
+func (s *sink) sendBatch(alerts []*alerter.Alert) error {
+	var buf bytes.Buffer
+	for _, a := range alerts {
+		s.format(&buf, a)
+	}
+	return s.write(buf.Bytes())
+}
+
+// format appends a as a line of JSON to buf.
+func (s *sink) format(buf *bytes.Buffer, a *alerter.Alert) {
+	buf.WriteByte('{')
+	writeField(buf, "time", s.timeFormat.Format(a.Time))
+	if a.Name != "" {
+		buf.WriteByte(',')
+		writeField(buf, "name", a.Name)
+	}
+	buf.WriteByte(',')
+	writeField(buf, "level", a.Level)
+	buf.WriteByte(',')
+	writeField(buf, "severity", a.Severity)
+	buf.WriteByte(',')
+	writeField(buf, "msg", a.Message)
+	if a.Err != nil {
+		buf.WriteByte(',')
+		writeField(buf, "error", a.Err)
+	}
+	if a.Resolved {
+		buf.WriteByte(',')
+		writeField(buf, "resolved", true)
+	}
+	for _, f := range a.Fields() {
+		if f.Key == alerter.SeverityKey {
+			continue
+		}
+		buf.WriteByte(',')
+		writeField(buf, f.Key, f.Value)
+	}
+	buf.WriteString("}\n")
+}
+
+func (s *sink) write(data []byte) error {
+	// A single Write per alert keeps lines intact when alerts are raised
+	// concurrently.
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(data)
+	return err
+}
+
+func writeField(buf *bytes.Buffer, key string, value interface{}) {
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteByte(':')
+	buf.Write(encode(value))
+}
+
+// encode returns the JSON encoding of value, falling back to a string for
+// values which cannot be encoded.
+func encode(value interface{}) []byte {
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case json.Marshaler, json.Number:
+	case fmt.Stringer:
+		value = v.String()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprintf("%+v", value))
+	}
+	return b
+}
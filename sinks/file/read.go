@@ -0,0 +1,117 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// ReadAlerts decodes the alerts a Sink of this package wrote to r, e.g. to
+// replay a dead-letter file into another Sink:
+//
+//	alerts, err := file.ReadAlerts(f)
+//	...
+//	for _, a := range alerts {
+//		err = alerter.Send(sink, a)
+//	}
+//
+// The format does not tell the values of a Sink from the key/value pairs of
+// a call, so all of them are returned as KeysAndValues, in the order of the
+// line, with numbers as json.Number.  Times are parsed as RFC 3339 and left
+// zero otherwise.  Lines which are not alerts are reported with their line
+// number.
+func ReadAlerts(r io.Reader) ([]*alerter.Alert, error) {
+	var alerts []*alerter.Alert
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		a, err := decodeAlert(line)
+		if err != nil {
+			return alerts, fmt.Errorf("line %d: %w", n, err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, scanner.Err()
+}
+
+// decodeAlert decodes a line written by format.
+func decodeAlert(line []byte) (*alerter.Alert, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, errors.New("not a JSON object")
+	}
+	a := &alerter.Alert{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := t.(string)
+		var value interface{}
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if err := setField(a, key, value); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return a, nil
+}
+
+// setField sets the field of a written under key.
+func setField(a *alerter.Alert, key string, value interface{}) error {
+	var err error
+	switch key {
+	case "time":
+		if s, ok := value.(string); ok {
+			a.Time, _ = time.Parse(time.RFC3339Nano, s)
+		}
+	case "name":
+		a.Name, _ = value.(string)
+	case "level":
+		if n, ok := value.(json.Number); ok {
+			var level int64
+			level, err = n.Int64()
+			a.Level = int(level)
+		}
+	case "severity":
+		s, _ := value.(string)
+		err = a.Severity.UnmarshalText([]byte(s))
+	case "msg":
+		a.Message, _ = value.(string)
+	case "error":
+		a.Err = errors.New(fmt.Sprint(value))
+	case "resolved":
+		a.Resolved, _ = value.(bool)
+	default:
+		a.KeysAndValues = append(a.KeysAndValues, key, value)
+	}
+	return err
+}
@@ -0,0 +1,422 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// backupTimeFormat is embedded in the names of rotated files, in the
+// location of the Clock.  It sorts lexically in chronological order.  Files
+// rotated within the same millisecond get a counter appended, as in
+// "app-20060102T150405.000-1.log".
+const backupTimeFormat = "20060102T150405.000"
+
+// Options carries parameters which influence the way a Writer rotates and
+// retains files.
+type Options struct {
+	// Path is the file alerts are written to.  Its directory is created
+	// if it does not exist.
+	Path string
+
+	// MaxSize is the size in bytes at which the file is rotated.  Zero
+	// disables size-based rotation.
+	MaxSize int64
+
+	// RotateEvery is the age at which the file is rotated.  Zero disables
+	// time-based rotation.
+	RotateEvery time.Duration
+
+	// MaxAge is the age after which rotated files are removed.  Zero
+	// retains files regardless of their age.
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated files to retain.  Zero retains
+	// all of them.
+	MaxBackups int
+
+	// Compress gzips rotated files in the background.
+	Compress bool
+
+	// ReopenOnSIGHUP reopens Path whenever the process receives SIGHUP, for
+	// use together with external rotation tools such as logrotate.
+	ReopenOnSIGHUP bool
+
+	// Clock is used to name rotated files and to measure RotateEvery and
+	// MaxAge.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Writer is an io.WriteCloser which appends to a file and rotates it
+// according to its Options.  It is safe for concurrent use.
+type Writer struct {
+	opts Options
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+	closed bool
+
+	signals chan os.Signal
+	mill    chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+var _ io.WriteCloser = &Writer{}
+
+// NewWriter opens the file named by opts.Path for appending and returns a
+// Writer for it.
+func NewWriter(opts Options) (*Writer, error) {
+	if opts.Path == "" {
+		return nil, errors.New("file: empty path")
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	w := &Writer{
+		opts: opts,
+		mill: make(chan struct{}, 1),
+		done: make(chan struct{}),
+	}
+	if err := w.Reopen(); err != nil {
+		return nil, err
+	}
+
+	w.wg.Add(1)
+	go w.runMill()
+	w.mill <- struct{}{}
+	if opts.ReopenOnSIGHUP {
+		w.signals = make(chan os.Signal, 1)
+		signal.Notify(w.signals, syscall.SIGHUP)
+		w.wg.Add(1)
+		go w.runSignals()
+	}
+	return w, nil
+}
+
+// Write appends p to the file, rotating it first if p would exceed
+// Options.MaxSize or the file is older than Options.RotateEvery.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	if w.file == nil {
+		// An earlier rotation could not open a new file.
+		if err := w.reopenLocked(); err != nil {
+			return 0, err
+		}
+	}
+	if w.dueLocked(int64(len(p))) {
+		if err := w.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, renames it to a timestamped backup and
+// opens a new one at Options.Path.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return os.ErrClosed
+	}
+	if w.file == nil {
+		return w.reopenLocked()
+	}
+	return w.rotateLocked()
+}
+
+// Reopen opens Options.Path anew and swaps it in for the current file,
+// which is closed afterwards.  Writes never observe a closed file, so it is
+// safe to call while other goroutines are writing.
+func (w *Writer) Reopen() error {
+	f, info, err := openFile(w.opts.Path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		f.Close()
+		return os.ErrClosed
+	}
+	old := w.file
+	w.file, w.size, w.opened = f, info.Size(), w.opts.Clock.Now()
+	w.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Close stops signal handling and background compression and closes the
+// file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return os.ErrClosed
+	}
+	w.closed = true
+	f := w.file
+	w.file = nil
+	w.mu.Unlock()
+
+	if w.signals != nil {
+		signal.Stop(w.signals)
+	}
+	close(w.done)
+	w.wg.Wait()
+	if f == nil {
+		return nil
+	}
+	return f.Close()
+}
+
+func (w *Writer) dueLocked(n int64) bool {
+	if w.opts.MaxSize > 0 && w.size > 0 && w.size+n > w.opts.MaxSize {
+		return true
+	}
+	return w.opts.RotateEvery > 0 && w.opts.Clock.Now().Sub(w.opened) >= w.opts.RotateEvery
+}
+
+func (w *Writer) rotateLocked() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	if err := os.Rename(w.opts.Path, w.backupName(w.opts.Clock.Now())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := w.reopenLocked(); err != nil {
+		return err
+	}
+
+	select {
+	case w.mill <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (w *Writer) reopenLocked() error {
+	f, info, err := openFile(w.opts.Path)
+	if err != nil {
+		return err
+	}
+	w.file, w.size, w.opened = f, info.Size(), w.opts.Clock.Now()
+	return nil
+}
+
+// backupName returns a free name for the file rotated at t.
+func (w *Writer) backupName(t time.Time) string {
+	dir, base := filepath.Split(w.opts.Path)
+	ext := filepath.Ext(base)
+	stamp := strings.TrimSuffix(base, ext) + "-" + t.Format(backupTimeFormat)
+	name := filepath.Join(dir, stamp+ext)
+	for n := 1; exists(name) || exists(name+".gz"); n++ {
+		name = filepath.Join(dir, fmt.Sprintf("%s-%d%s", stamp, n, ext))
+	}
+	return name
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (w *Writer) runSignals() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.signals:
+			// There is nobody to report a failure to; the current
+			// file stays in use in that case.
+			_ = w.Reopen()
+		}
+	}
+}
+
+func (w *Writer) runMill() {
+	defer w.wg.Done()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.mill:
+			_ = w.millOnce()
+		}
+	}
+}
+
+// millOnce compresses and removes rotated files according to the retention
+// options.
+func (w *Writer) millOnce() error {
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	cutoff := w.opts.Clock.Now().Add(-w.opts.MaxAge)
+	for i, b := range backups {
+		expired := w.opts.MaxAge > 0 && b.time.Before(cutoff)
+		if expired || (w.opts.MaxBackups > 0 && i >= w.opts.MaxBackups) {
+			errs = append(errs, os.Remove(b.path))
+			continue
+		}
+		if w.opts.Compress && !strings.HasSuffix(b.path, ".gz") {
+			errs = append(errs, compress(b.path))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type backup struct {
+	path string
+	time time.Time
+	// n is the counter of files rotated within the same millisecond.
+	n int
+}
+
+// backups returns the rotated files belonging to the Writer, newest first.
+func (w *Writer) backups() ([]backup, error) {
+	dir, base := filepath.Split(w.opts.Path)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	// Names are formatted in the location of the Clock.
+	loc := w.opts.Clock.Now().Location()
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, stamp[:len(backupTimeFormat)], loc)
+		if err != nil {
+			continue
+		}
+		b := backup{path: filepath.Join(dir, name), time: t}
+		if counter := stamp[len(backupTimeFormat):]; counter != "" {
+			if b.n, err = strconv.Atoi(strings.TrimPrefix(counter, "-")); err != nil || counter[0] != '-' || b.n <= 0 {
+				continue
+			}
+		}
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.After(backups[j].time)
+		}
+		return backups[i].n > backups[j].n
+	})
+	return backups, nil
+}
+
+func openFile(path string) (*os.File, os.FileInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// compress gzips path into path+".gz" and removes path.  The compressed
+// file is written under a temporary name first, so a crash never leaves a
+// truncated archive behind.
+func compress(path string) (err error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		return err
+	}
+	src.Close()
+	if err = zw.Close(); err != nil {
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
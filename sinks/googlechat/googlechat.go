@@ -0,0 +1,226 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package googlechat implements an alerter.Sink which posts alerts to the
+// incoming webhooks of Google Chat spaces as cards:
+//
+//	a, err := googlechat.New(googlechat.Options{
+//		WebhookURL: "https://chat.googleapis.com/v1/spaces/AAAA/messages?key=xxx&token=yyy",
+//	})
+//
+// Cards show the values of alerts, which identify them as their
+// fingerprint does, as labels, and the key/value pairs of the call as
+// annotations.  All messages about an alert, including its resolve, are
+// posted in a thread of their own.
+package googlechat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// maxTitle is the number of characters of the title of a card beyond which
+// the message is repeated in its body.
+const maxTitle = 200
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// WebhookURL is the URL of the webhook of a space, including its key
+	// and token.
+	WebhookURL string
+
+	// NoThreads posts every message in a thread of its own, for spaces
+	// which do not use threads.
+	NoThreads bool
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than a card should hold.  Limit
+	// defaults to 4000 characters.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// New returns an Alerter which posts every alert to Google Chat.  It fails
+// if opts configure no valid webhook.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" {
+		return alerter.Alerter{}, errors.New("googlechat: WebhookURL must be set")
+	}
+	u, err := url.Parse(opts.WebhookURL)
+	if err != nil {
+		return alerter.Alerter{}, fmt.Errorf("googlechat: %w", err)
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 4000
+	}
+	s := &sink{opts: opts, webhook: u, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts    Options
+	webhook *url.URL
+	client  *http.Client
+}
+
+// message is a message of a webhook.
+type message struct {
+	Text    string   `json:"text,omitempty"`
+	CardsV2 []cardV2 `json:"cardsV2,omitempty"`
+	Thread  *thread  `json:"thread,omitempty"`
+}
+
+type thread struct {
+	ThreadKey string `json:"threadKey"`
+}
+
+type cardV2 struct {
+	CardID string `json:"cardId"`
+	Card   card   `json:"card"`
+}
+
+type card struct {
+	Header   header    `json:"header"`
+	Sections []section `json:"sections"`
+}
+
+type header struct {
+	Title    string `json:"title"`
+	Subtitle string `json:"subtitle,omitempty"`
+}
+
+type section struct {
+	Header                    string   `json:"header,omitempty"`
+	Collapsible               bool     `json:"collapsible,omitempty"`
+	UncollapsibleWidgetsCount int      `json:"uncollapsibleWidgetsCount,omitempty"`
+	Widgets                   []widget `json:"widgets"`
+}
+
+type widget struct {
+	TextParagraph *textParagraph `json:"textParagraph,omitempty"`
+	DecoratedText *decoratedText `json:"decoratedText,omitempty"`
+}
+
+type textParagraph struct {
+	Text string `json:"text"`
+}
+
+type decoratedText struct {
+	TopLabel string `json:"topLabel"`
+	Text     string `json:"text"`
+	WrapText bool   `json:"wrapText"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	target := *s.webhook
+	var th *thread
+	if !s.opts.NoThreads {
+		q := target.Query()
+		q.Set("messageReplyOption", "REPLY_MESSAGE_FALLBACK_TO_NEW_THREAD")
+		target.RawQuery = q.Encode()
+		th = &thread{ThreadKey: a.Fingerprint()}
+	}
+	for i, chunk := range chat.Fit(ctx, chat.Body(a, maxTitle), s.opts.Overflow) {
+		m := &message{Thread: th}
+		if i > 0 {
+			m.Text = chunk
+		} else {
+			m.CardsV2 = []cardV2{{CardID: "alert", Card: s.card(a, chunk)}}
+		}
+		if err := s.post(ctx, target.String(), m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// card returns the card of a with the given part of its text.
+func (s *sink) card(a *alerter.Alert, text string) card {
+	c := card{Header: header{
+		Title:    chat.Truncate(chat.Title(a), maxTitle),
+		Subtitle: s.opts.TimeFormat.Format(a.Time),
+	}}
+	if text != "" {
+		c.Sections = append(c.Sections, section{Widgets: []widget{{TextParagraph: &textParagraph{Text: html.EscapeString(text)}}}})
+	}
+	labels := fieldSection("Labels", chat.Fields(&alerter.Alert{Values: a.Values}))
+	annotations := fieldSection("Annotations", chat.Fields(&alerter.Alert{KeysAndValues: a.KeysAndValues}))
+	for _, sec := range []*section{labels, annotations} {
+		if sec != nil {
+			c.Sections = append(c.Sections, *sec)
+		}
+	}
+	if len(c.Sections) == 0 {
+		// Cards need at least one section.
+		c.Sections = []section{{Widgets: []widget{{TextParagraph: &textParagraph{Text: html.EscapeString(a.Message)}}}}}
+	}
+	return c
+}
+
+// fieldSection returns a section listing fields, collapsed after the first
+// five, or nil if there are none.
+func fieldSection(title string, fields []alerter.Field) *section {
+	if len(fields) == 0 {
+		return nil
+	}
+	sec := &section{Header: title}
+	for _, f := range fields {
+		sec.Widgets = append(sec.Widgets, widget{DecoratedText: &decoratedText{
+			TopLabel: f.Key,
+			Text:     html.EscapeString(f.Value.(string)),
+			WrapText: true,
+		}})
+	}
+	if len(sec.Widgets) > 5 {
+		sec.Collapsible, sec.UncollapsibleWidgetsCount = true, 5
+	}
+	return sec
+}
+
+func (s *sink) post(ctx context.Context, target string, m *message) error {
+	if err := chat.PostJSON(ctx, s.client, target, nil, m, nil); err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// The URL contains the key and token of the webhook.
+			err = uerr.Err
+		}
+		return fmt.Errorf("googlechat: %w", err)
+	}
+	return nil
+}
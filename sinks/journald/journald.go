@@ -0,0 +1,191 @@
+//go:build linux
+
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package journald implements an alerter.Sink which writes alerts to the
+// systemd journal using its native protocol, so that every key/value pair
+// becomes a journal field which can be queried with journalctl:
+//
+//	journalctl ALERT_NAME=db/pool ALERT_SEVERITY=critical
+//
+// Besides MESSAGE and PRIORITY, every entry carries ALERT_NAME,
+// ALERT_SEVERITY and ALERT_LEVEL, ALERT_ERROR for errors and ALERT_RESOLVED
+// for resolved alerts.  Call sites attached with Alerter.WithCaller are
+// written as the standard CODE_FILE, CODE_LINE and CODE_FUNC fields.  Keys
+// are turned into field names by upper-casing them, replacing invalid
+// characters with "_" and prepending ALERT_.
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/syslog"
+)
+
+// SocketPath is where journald listens for native protocol messages.
+const SocketPath = "/run/systemd/journal/socket"
+
+// Options carries parameters which influence the way alerts are written.
+type Options struct {
+	// Identifier is sent as SYSLOG_IDENTIFIER.  Defaults to the name of
+	// the running executable.
+	Identifier string
+
+	// Verbosity is the highest V-level of Info alerts which are written.
+	Verbosity int
+
+	// Priority maps an alert to a syslog severity for the PRIORITY field.
+	// When nil, syslog.DefaultPriority is used.
+	Priority func(a *alerter.Alert) int
+}
+
+// New returns an Alerter which writes every alert to the journal.  It fails
+// if journald is not running.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.Identifier == "" {
+		opts.Identifier = filepath.Base(os.Args[0])
+	}
+	if opts.Priority == nil {
+		opts.Priority = syslog.DefaultPriority
+	}
+	if _, err := os.Stat(SocketPath); err != nil {
+		return alerter.Alerter{}, fmt.Errorf("journald: %w", err)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return alerter.Alerter{}, fmt.Errorf("journald: %w", err)
+	}
+
+	s := &sink{opts: opts, conn: conn, addr: &net.UnixAddr{Name: SocketPath, Net: "unixgram"}}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+	})), nil
+}
+
+type sink struct {
+	opts Options
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	var buf bytes.Buffer
+	msg := a.Message
+	if a.Err != nil {
+		msg += ": " + a.Err.Error()
+	}
+	writeField(&buf, "MESSAGE", msg)
+	writeField(&buf, "PRIORITY", strconv.Itoa(s.opts.Priority(a)))
+	writeField(&buf, "SYSLOG_IDENTIFIER", s.opts.Identifier)
+	if a.Name != "" {
+		writeField(&buf, "ALERT_NAME", a.Name)
+	}
+	writeField(&buf, "ALERT_SEVERITY", a.Severity.String())
+	writeField(&buf, "ALERT_LEVEL", strconv.Itoa(a.Level))
+	if a.Err != nil {
+		writeField(&buf, "ALERT_ERROR", a.Err.Error())
+	}
+	if a.Resolved {
+		writeField(&buf, "ALERT_RESOLVED", "1")
+	}
+	for _, f := range a.Fields() {
+		if f.Key == alerter.SeverityKey {
+			continue
+		}
+		if c, ok := f.Value.(alerter.Caller); ok && f.Key == alerter.CallerKey {
+			writeField(&buf, "CODE_FILE", c.File)
+			writeField(&buf, "CODE_LINE", strconv.Itoa(c.Line))
+			writeField(&buf, "CODE_FUNC", c.Function)
+			continue
+		}
+		writeField(&buf, fieldName(f.Key), fmt.Sprint(f.Value))
+	}
+
+	_, _, err := s.conn.WriteMsgUnix(buf.Bytes(), nil, s.addr)
+	if err == nil {
+		return nil
+	}
+	var errno syscall.Errno
+	if !errors.As(err, &errno) || (errno != syscall.EMSGSIZE && errno != syscall.ENOBUFS) {
+		return err
+	}
+	return s.sendFD(buf.Bytes())
+}
+
+// sendFD passes an entry which is too large for a datagram as a file
+// descriptor, as described by the native protocol.
+func (s *sink) sendFD(entry []byte) error {
+	f, err := os.CreateTemp("/dev/shm", "alerter-journal-")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return err
+	}
+	if _, err := f.Write(entry); err != nil {
+		return err
+	}
+	_, _, err = s.conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), s.addr)
+	return err
+}
+
+// writeField appends a field to an entry.  Values containing newlines use
+// the binary form: the name, a newline, the little-endian 64 bit length of
+// the value and the value itself.
+func writeField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(value, '\n') {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// fieldName turns a key into a valid journal field name, which consists of
+// upper case letters, digits and underscores and must not start with an
+// underscore or digit.  The ALERT_ prefix takes care of the latter.
+func fieldName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, key)
+	name = "ALERT_" + name
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mattermost implements an alerter.Sink which posts alerts to
+// Mattermost as message attachments, with a color bar for their severity and
+// their key/value pairs as fields, through an incoming webhook or the REST
+// API:
+//
+//	a, err := mattermost.New(mattermost.Options{
+//		WebhookURL: "https://chat.example.com/hooks/xxx",
+//		Routes: []mattermost.Route{
+//			{Matchers: matchers.MustParse(`{team="db"}`), Channel: "db-alerts"},
+//		},
+//	})
+//
+// With an API token, later occurrences and the resolve of an alert are
+// posted as replies in the thread of its first post.
+package mattermost
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// maxTitle is the number of characters of the title of an attachment
+// beyond which the message is repeated in its text.
+const maxTitle = 256
+
+// Route sends the alerts matching Matchers to Channel instead of the
+// default one.
+type Route struct {
+	Matchers matchers.Matchers
+
+	// Channel is the name of a channel, such as "db-alerts", for
+	// webhooks, and the ID of a channel for the API.
+	Channel string
+}
+
+// Options carries parameters which influence the way alerts are posted.
+// Either WebhookURL or ServerURL, Token and Channel must be set.
+type Options struct {
+	// WebhookURL is the URL of an incoming webhook.
+	WebhookURL string
+
+	// ServerURL is the URL of the Mattermost server, such as
+	// "https://chat.example.com", for posting through the API.
+	ServerURL string
+
+	// Token is a personal access token or the token of a bot account,
+	// for posting through the API.
+	Token string
+
+	// Channel is the channel alerts are posted to if no route matches
+	// them: the ID of a channel for the API and, optionally, the name of
+	// a channel overriding the one of a webhook.
+	Channel string
+
+	// Routes send alerts to other channels, the first matching route
+	// winning.
+	Routes []Route
+
+	// Username and IconURL override the name and icon of webhook posts,
+	// if the server allows it.
+	Username string
+	IconURL  string
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than Mattermost allows.  Limit
+	// defaults to 16000 characters, leaving room for the fields.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Mattermost.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time and tells the age of
+	// threads.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// New returns an Alerter which posts every alert to Mattermost.  It fails
+// if opts configure neither a webhook nor the API.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" && (opts.ServerURL == "" || opts.Token == "" || opts.Channel == "") {
+		return alerter.Alerter{}, errors.New("mattermost: either WebhookURL or ServerURL, Token and Channel must be set")
+	}
+	opts.ServerURL = strings.TrimSuffix(opts.ServerURL, "/")
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 16000
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport), threads: chat.Threads{Clock: opts.Clock}}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts    Options
+	client  *http.Client
+	threads chat.Threads
+}
+
+// webhookPost is the payload of incoming webhooks.
+type webhookPost struct {
+	Channel     string       `json:"channel,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	IconURL     string       `json:"icon_url,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+// apiPost is the payload of POST /api/v4/posts.
+type apiPost struct {
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id,omitempty"`
+	Message   string `json:"message"`
+	Props     struct {
+		Attachments []attachment `json:"attachments,omitempty"`
+	} `json:"props"`
+}
+
+type attachment struct {
+	Fallback string  `json:"fallback"`
+	Color    string  `json:"color"`
+	Title    string  `json:"title"`
+	Text     string  `json:"text,omitempty"`
+	Fields   []field `json:"fields,omitempty"`
+	Footer   string  `json:"footer,omitempty"`
+}
+
+type field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	channel := s.route(a)
+	chunks := chat.Fit(ctx, chat.Body(a, maxTitle), s.opts.Overflow)
+	if s.opts.Token == "" {
+		for i, chunk := range chunks {
+			p := &webhookPost{Channel: channel, Username: s.opts.Username, IconURL: s.opts.IconURL}
+			if i == 0 {
+				p.Attachments = []attachment{s.attachment(a, chunk)}
+			} else {
+				p.Text = chunk
+			}
+			if err := chat.PostJSON(ctx, s.client, s.opts.WebhookURL, nil, p, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	key := channel + "/" + a.Fingerprint()
+	root, threaded := s.threads.Get(key)
+	for i, chunk := range chunks {
+		p := &apiPost{ChannelID: channel, RootID: root}
+		if i == 0 {
+			p.Props.Attachments = []attachment{s.attachment(a, chunk)}
+		} else {
+			p.Message = chunk
+		}
+		var created struct {
+			ID string `json:"id"`
+		}
+		header := http.Header{"Authorization": {"Bearer " + s.opts.Token}}
+		if err := chat.PostJSON(ctx, s.client, s.opts.ServerURL+"/api/v4/posts", header, p, &created); err != nil {
+			return err
+		}
+		if root == "" {
+			root = created.ID
+		}
+	}
+	switch {
+	case a.Resolved:
+		s.threads.Delete(key)
+	case !threaded:
+		s.threads.Set(key, root)
+	}
+	return nil
+}
+
+// route returns the channel of a.
+func (s *sink) route(a *alerter.Alert) string {
+	for _, r := range s.opts.Routes {
+		if r.Matchers.Matches(a) {
+			return r.Channel
+		}
+	}
+	return s.opts.Channel
+}
+
+// attachment returns the attachment of a with the given part of its text.
+func (s *sink) attachment(a *alerter.Alert, text string) attachment {
+	att := attachment{
+		Fallback: chat.Title(a),
+		Color:    chat.HexColor(a),
+		Title:    chat.Truncate(chat.Title(a), maxTitle),
+		Text:     text,
+		Footer:   s.opts.TimeFormat.Format(a.Time),
+	}
+	for _, f := range chat.Fields(a) {
+		value := f.Value.(string)
+		att.Fields = append(att.Fields, field{Title: f.Key, Value: value, Short: len(value) <= 40})
+	}
+	return att
+}
+
+// dryRunReply answers dry-run requests like the API, with a new post ID for
+// every post created.  Webhooks ignore the reply.
+func dryRunReply(_ *http.Request, _ []byte, n int) string {
+	return fmt.Sprintf(`{"id":"dryrun%d"}`, n)
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ntfy implements an alerter.Sink which publishes alerts to ntfy
+// topics, on ntfy.sh or a self-hosted server, for push notifications
+// without a vendor account:
+//
+//	a, err := ntfy.New(ntfy.Options{
+//		Topic: "myapp-alerts-3f9a",
+//		Routes: []ntfy.Route{
+//			{Matchers: matchers.MustParse(`{team="db"}`), Topic: "myapp-db-3f9a"},
+//		},
+//	})
+//
+// Topics on ntfy.sh are public unless reserved, so pick names which are
+// hard to guess or set AccessToken for protected topics.
+package ntfy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// Limits of messages.
+const (
+	maxTitle   = 256
+	maxMessage = 4096
+)
+
+// Priority is the priority of a notification.
+type Priority int
+
+// Priorities of notifications.
+const (
+	// Min notifies without sound or vibration, below the fold.
+	Min Priority = 1
+	// Low notifies without sound or vibration.
+	Low Priority = 2
+	// Default notifies with the default sound and vibration.
+	Default Priority = 3
+	// High notifies with long vibration bursts.
+	High Priority = 4
+	// Urgent notifies with very long vibration bursts and a pop-over.
+	Urgent Priority = 5
+)
+
+// DefaultPriorities are the Priorities of Options which set none.
+var DefaultPriorities = map[alerter.Severity]Priority{
+	alerter.SeverityInfo:     Low,
+	alerter.SeverityWarning:  Default,
+	alerter.SeverityError:    High,
+	alerter.SeverityCritical: Urgent,
+}
+
+// DefaultTags are the Tags of Options which set none.  ntfy shows tags
+// which are emoji short codes as emoji.
+var DefaultTags = map[alerter.Severity][]string{
+	alerter.SeverityInfo:     {"information_source"},
+	alerter.SeverityWarning:  {"warning"},
+	alerter.SeverityError:    {"red_circle"},
+	alerter.SeverityCritical: {"rotating_light"},
+}
+
+// ResolvedTag is the tag of resolves.
+const ResolvedTag = "white_check_mark"
+
+// Route publishes the alerts matching Matchers to Topic.
+type Route struct {
+	Matchers matchers.Matchers
+	Topic    string
+}
+
+// Options carries parameters which influence the way alerts are published.
+type Options struct {
+	// ServerURL is the URL of the ntfy server.  Defaults to
+	// "https://ntfy.sh".
+	ServerURL string
+
+	// Topic is the topic alerts are published to.  Alerts matching no
+	// route are dropped if it is empty.
+	Topic string
+
+	// Routes publish alerts to other topics, the first matching route
+	// winning.
+	Routes []Route
+
+	// AccessToken authenticates with the server, for protected topics.
+	// Username and Password authenticate instead if it is empty and
+	// Username is set.
+	AccessToken string
+	Username    string
+	Password    string
+
+	// Priorities are the priorities of alerts of at least a severity.
+	// Defaults to DefaultPriorities.  Resolves have Low priority.
+	Priorities map[alerter.Severity]Priority
+
+	// Tags are the tags of alerts of at least a severity.  Defaults to
+	// DefaultTags.  Resolves are tagged ResolvedTag.
+	Tags map[alerter.Severity][]string
+
+	// Click, if set, returns the URL opened when the notification of an
+	// alert is tapped, such as its dashboard, or "" for none.
+	Click func(a *alerter.Alert) string
+
+	// Verbosity is the highest V-level of Info alerts which are
+	// published.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Transport configures the HTTP client.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for messages the server did not accept.
+type APIError struct {
+	// Status is the HTTP status code.
+	Status int
+
+	// Code is the ntfy error code, such as 40301 for forbidden topics.
+	Code int
+
+	// Message explains the error.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("ntfy: %d: %s", e.Code, e.Message)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when the server limits the rate of messages or fails, so that APIError
+// implements middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// New returns an Alerter which publishes every alert to ntfy.  It fails if
+// opts leave out the topic.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.Topic == "" && len(opts.Routes) == 0 {
+		return alerter.Alerter{}, errors.New("ntfy: Topic or Routes must be set")
+	}
+	if opts.ServerURL == "" {
+		opts.ServerURL = "https://ntfy.sh"
+	}
+	opts.ServerURL = strings.TrimSuffix(opts.ServerURL, "/")
+	if opts.Priorities == nil {
+		opts.Priorities = DefaultPriorities
+	}
+	if opts.Tags == nil {
+		opts.Tags = DefaultTags
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+}
+
+type message struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title"`
+	Message  string   `json:"message"`
+	Priority Priority `json:"priority"`
+	Tags     []string `json:"tags,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Markdown bool     `json:"markdown"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	topic := s.topic(a)
+	if topic == "" {
+		return nil
+	}
+	m := message{
+		Topic:    topic,
+		Title:    chat.Truncate(chat.Title(a), maxTitle),
+		Message:  chat.Truncate(s.body(a), maxMessage),
+		Priority: Low,
+		Tags:     []string{ResolvedTag},
+		Markdown: true,
+	}
+	if !a.Resolved {
+		m.Priority, m.Tags = bySeverity(s.opts.Priorities, a.Severity, Default), bySeverity(s.opts.Tags, a.Severity, nil)
+	}
+	if s.opts.Click != nil {
+		m.Click = s.opts.Click(a)
+	}
+	return s.post(context.Background(), &m)
+}
+
+// topic returns the topic of a.
+func (s *sink) topic(a *alerter.Alert) string {
+	for _, r := range s.opts.Routes {
+		if r.Matchers.Matches(a) {
+			return r.Topic
+		}
+	}
+	return s.opts.Topic
+}
+
+// bySeverity returns the value for the highest severity of values at most
+// sev, or fallback if there is none.
+func bySeverity[T any](values map[alerter.Severity]T, sev alerter.Severity, fallback T) T {
+	value, best := fallback, alerter.Severity(-1)
+	for s, v := range values {
+		if sev >= s && s > best {
+			value, best = v, s
+		}
+	}
+	return value
+}
+
+// body returns the markdown of a below its title.
+func (s *sink) body(a *alerter.Alert) string {
+	var b strings.Builder
+	if text := chat.Body(a, maxTitle); text != "" {
+		b.WriteString("```\n")
+		b.WriteString(strings.ReplaceAll(text, "```", "'''"))
+		b.WriteString("\n```\n")
+	}
+	for _, f := range chat.Fields(a) {
+		fmt.Fprintf(&b, "- **%s**: %s\n", f.Key, f.Value)
+	}
+	fmt.Fprintf(&b, "_%s_", s.opts.TimeFormat.Format(a.Time))
+	return b.String()
+}
+
+func (s *sink) post(ctx context.Context, m *message) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.ServerURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case s.opts.AccessToken != "":
+		req.Header.Set("Authorization", "Bearer "+s.opts.AccessToken)
+	case s.opts.Username != "":
+		req.SetBasicAuth(s.opts.Username, s.opts.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ntfy: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	var result struct {
+		Code  int    `json:"code"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Error == "" {
+		return fmt.Errorf("ntfy: %w", transport.CheckResponse(resp))
+	}
+	return &APIError{Status: resp.StatusCode, Code: result.Code, Message: result.Error}
+}
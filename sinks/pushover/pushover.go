@@ -0,0 +1,341 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package pushover implements an alerter.Sink which pushes alerts to phones
+// and desktops with Pushover:
+//
+//	a, err := pushover.New(pushover.Options{
+//		Token: os.Getenv("PUSHOVER_TOKEN"),
+//		User:  os.Getenv("PUSHOVER_USER"),
+//	})
+//
+// Critical alerts are pushed with emergency priority, which repeats the
+// notification every Retry until it is acknowledged in the app or Expire
+// passed; resolving the alert cancels the repetition.
+package pushover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// Limits of messages.
+const (
+	maxTitle   = 250
+	maxMessage = 1024
+)
+
+// Priority is the priority of a notification.
+type Priority int
+
+// Priorities of notifications.
+const (
+	// Lowest does not notify at all.
+	Lowest Priority = -2
+	// Low notifies without sound or vibration.
+	Low Priority = -1
+	// Normal notifies with sound and vibration, except in quiet hours.
+	Normal Priority = 0
+	// High notifies with sound and vibration even in quiet hours.
+	High Priority = 1
+	// Emergency notifies like High, repeatedly until acknowledged.
+	Emergency Priority = 2
+)
+
+// DefaultPriorities are the Priorities of Options which set none.
+var DefaultPriorities = map[alerter.Severity]Priority{
+	alerter.SeverityInfo:     Low,
+	alerter.SeverityWarning:  Normal,
+	alerter.SeverityError:    High,
+	alerter.SeverityCritical: Emergency,
+}
+
+// DefaultSounds are the Sounds of Options which set none.
+var DefaultSounds = map[alerter.Severity]string{
+	alerter.SeverityWarning:  "intermission",
+	alerter.SeverityError:    "falling",
+	alerter.SeverityCritical: "siren",
+}
+
+// Route sends the alerts matching Matchers to User or, if it is empty, to
+// the user of the Options, on Devices.
+type Route struct {
+	Matchers matchers.Matchers
+	User     string
+	Devices  []string
+}
+
+// Options carries parameters which influence the way alerts are pushed.
+type Options struct {
+	// Token is the API token of the application pushing alerts.
+	Token string
+
+	// User is the key of the user or group alerts are pushed to.
+	User string
+
+	// Devices are the names of the devices of User alerts are pushed to.
+	// Defaults to all of them.
+	Devices []string
+
+	// Routes push alerts to other users or devices, the first matching
+	// route winning.
+	Routes []Route
+
+	// Priorities are the priorities of alerts of at least a severity.
+	// Defaults to DefaultPriorities.  Resolves have Normal priority.
+	Priorities map[alerter.Severity]Priority
+
+	// Sounds are the sounds of alerts of at least a severity, such as
+	// "siren" or "none".  Defaults to DefaultSounds; alerts without a
+	// sound play the default sound of the user.
+	Sounds map[alerter.Severity]string
+
+	// ResolvedSound is the sound of resolves.  Defaults to "magic".
+	ResolvedSound string
+
+	// Retry is the interval at which emergency notifications repeat,
+	// at least 30 seconds.  Defaults to one minute.
+	Retry time.Duration
+
+	// Expire is the time after which emergency notifications stop
+	// repeating, at most 3 hours.  Defaults to one hour.
+	Expire time.Duration
+
+	// BaseURL is the URL of the Pushover API.  Defaults to
+	// "https://api.pushover.net".
+	BaseURL string
+
+	// Verbosity is the highest V-level of Info alerts which are pushed.
+	Verbosity int
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Pushover, and DryRunRedact to masking
+	// the token and user the requests carry.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time and tells the age of
+	// receipts.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for requests Pushover did not accept.
+type APIError struct {
+	// Status is the HTTP status code.
+	Status int
+
+	// Errors explain the error.
+	Errors []string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("pushover: %d: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when the monthly limit is reached or Pushover fails, so that APIError
+// implements middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500
+}
+
+// New returns an Alerter which pushes every alert with Pushover.  It fails
+// if opts leave out the token or the user.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.Token == "" || opts.User == "" {
+		return alerter.Alerter{}, errors.New("pushover: Token and User must be set")
+	}
+	if opts.Priorities == nil {
+		opts.Priorities = DefaultPriorities
+	}
+	if opts.Sounds == nil {
+		opts.Sounds = DefaultSounds
+	}
+	if opts.ResolvedSound == "" {
+		opts.ResolvedSound = "magic"
+	}
+	if opts.Retry <= 0 {
+		opts.Retry = time.Minute
+	}
+	opts.Retry = max(opts.Retry, 30*time.Second)
+	if opts.Expire <= 0 {
+		opts.Expire = time.Hour
+	}
+	opts.Expire = min(opts.Expire, 3*time.Hour)
+	if opts.BaseURL == "" {
+		opts.BaseURL = "https://api.pushover.net"
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	if opts.Transport.DryRunRedact == nil {
+		opts.Transport.DryRunRedact = transport.RedactFields("token", "user")
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport), receipts: chat.Threads{Clock: opts.Clock}}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+
+	// receipts are the receipts of emergency notifications, to cancel
+	// them once their alert is resolved.
+	receipts chat.Threads
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	fp := a.Fingerprint()
+	if a.Resolved {
+		if receipt, ok := s.receipts.Get(fp); ok {
+			s.receipts.Delete(fp)
+			if err := s.post(ctx, "/1/receipts/"+url.PathEscape(receipt)+"/cancel.json", url.Values{"token": {s.opts.Token}}, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	user, devices := s.opts.User, s.opts.Devices
+	for _, r := range s.opts.Routes {
+		if r.Matchers.Matches(a) {
+			if r.User != "" {
+				user = r.User
+			}
+			devices = r.Devices
+			break
+		}
+	}
+	form := url.Values{
+		"token":     {s.opts.Token},
+		"user":      {user},
+		"title":     {chat.Truncate(chat.Title(a), maxTitle)},
+		"message":   {chat.Truncate(message(a), maxMessage)},
+		"timestamp": {strconv.FormatInt(a.Time.Unix(), 10)},
+	}
+	if len(devices) > 0 {
+		form.Set("device", strings.Join(devices, ","))
+	}
+	priority, sound := Normal, s.opts.ResolvedSound
+	if !a.Resolved {
+		priority, sound = bySeverity(s.opts.Priorities, a.Severity, Normal), bySeverity(s.opts.Sounds, a.Severity, "")
+	}
+	form.Set("priority", strconv.Itoa(int(priority)))
+	if sound != "" {
+		form.Set("sound", sound)
+	}
+	if priority == Emergency {
+		form.Set("retry", strconv.Itoa(int(s.opts.Retry/time.Second)))
+		form.Set("expire", strconv.Itoa(int(s.opts.Expire/time.Second)))
+	}
+	var result struct {
+		Receipt string `json:"receipt"`
+	}
+	if err := s.post(ctx, "/1/messages.json", form, &result); err != nil {
+		return err
+	}
+	if result.Receipt != "" {
+		s.receipts.Set(fp, result.Receipt)
+	}
+	return nil
+}
+
+// bySeverity returns the value for the highest severity of values at most
+// sev, or fallback if there is none.
+func bySeverity[T any](values map[alerter.Severity]T, sev alerter.Severity, fallback T) T {
+	value, best := fallback, alerter.Severity(-1)
+	for s, v := range values {
+		if sev >= s && s > best {
+			value, best = v, s
+		}
+	}
+	return value
+}
+
+// message returns the text of a below its title, which must not be empty.
+func message(a *alerter.Alert) string {
+	var b strings.Builder
+	b.WriteString(chat.Body(a, maxTitle))
+	for _, f := range chat.Fields(a) {
+		if b.Len() > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%s: %s", f.Key, f.Value)
+	}
+	if b.Len() == 0 {
+		return chat.Text(a)
+	}
+	return b.String()
+}
+
+func (s *sink) post(ctx context.Context, path string, form url.Values, response interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pushover: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Status int      `json:"status"`
+		Errors []string `json:"errors"`
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		if cerr := transport.CheckResponse(resp); cerr != nil {
+			return fmt.Errorf("pushover: %w", cerr)
+		}
+		return fmt.Errorf("pushover: decode response: %w", err)
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return fmt.Errorf("pushover: decode response: %w", err)
+	}
+	if result.Status != 1 {
+		return &APIError{Status: resp.StatusCode, Errors: result.Errors}
+	}
+	if response != nil {
+		return json.Unmarshal(body, response)
+	}
+	return nil
+}
+
+// dryRunReply answers dry-run requests like the API, with a receipt for
+// every emergency notification.
+func dryRunReply(_ *http.Request, body []byte, n int) string {
+	form, _ := url.ParseQuery(string(body))
+	if form.Get("priority") == strconv.Itoa(int(Emergency)) {
+		return fmt.Sprintf(`{"status":1,"request":"dryrun%d","receipt":"dryrun%d"}`, n, n)
+	}
+	return fmt.Sprintf(`{"status":1,"request":"dryrun%d"}`, n)
+}
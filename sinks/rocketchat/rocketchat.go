@@ -0,0 +1,175 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rocketchat implements an alerter.Sink which posts alerts to the
+// incoming webhooks of Rocket.Chat, as attachments with a color bar and an
+// emoji for their severity, which shows their time in the time zone of each
+// reader:
+//
+//	a, err := rocketchat.New(rocketchat.Options{
+//		WebhookURL: "https://chat.example.com/hooks/xxx/yyy",
+//		Alias:      "alerter",
+//	})
+package rocketchat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// maxTitle is the number of characters of the title of an attachment
+// beyond which the message is repeated in its text.
+const maxTitle = 256
+
+// DefaultEmoji are the emoji of Options which set none.
+var DefaultEmoji = map[alerter.Severity]string{
+	alerter.SeverityInfo:     ":information_source:",
+	alerter.SeverityWarning:  ":warning:",
+	alerter.SeverityError:    ":red_circle:",
+	alerter.SeverityCritical: ":rotating_light:",
+}
+
+// DefaultResolvedEmoji is the emoji of resolves of Options which set none.
+const DefaultResolvedEmoji = ":white_check_mark:"
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// WebhookURL is the URL of an incoming webhook integration.
+	WebhookURL string
+
+	// Alias and Avatar override the name and the URL of the avatar
+	// messages are posted with.
+	Alias  string
+	Avatar string
+
+	// Emoji are the emoji starting the messages of alerts by severity.
+	// Defaults to DefaultEmoji.
+	Emoji map[alerter.Severity]string
+
+	// ResolvedEmoji starts the messages of resolves.  Defaults to
+	// DefaultResolvedEmoji.
+	ResolvedEmoji string
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// Overflow delivers messages longer than Rocket.Chat allows.  Limit
+	// defaults to 5000 characters, the default of its
+	// Message_MaxAllowedSize setting.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// New returns an Alerter which posts every alert to Rocket.Chat.  It fails
+// if opts configure no webhook.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" {
+		return alerter.Alerter{}, errors.New("rocketchat: WebhookURL must be set")
+	}
+	if opts.Emoji == nil {
+		opts.Emoji = DefaultEmoji
+	}
+	if opts.ResolvedEmoji == "" {
+		opts.ResolvedEmoji = DefaultResolvedEmoji
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 5000
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+}
+
+// message is the payload of incoming webhooks.
+type message struct {
+	Alias       string       `json:"alias,omitempty"`
+	Avatar      string       `json:"avatar,omitempty"`
+	Text        string       `json:"text"`
+	Attachments []attachment `json:"attachments,omitempty"`
+}
+
+type attachment struct {
+	Text   string  `json:"text,omitempty"`
+	Color  string  `json:"color"`
+	Fields []field `json:"fields,omitempty"`
+	TS     string  `json:"ts,omitempty"`
+}
+
+type field struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	title := chat.Truncate(chat.Title(a), maxTitle)
+	for i, chunk := range chat.Fit(ctx, chat.Body(a, maxTitle), s.opts.Overflow) {
+		m := &message{Alias: s.opts.Alias, Avatar: s.opts.Avatar, Text: chunk}
+		if i == 0 {
+			att := attachment{
+				Text:  chunk,
+				Color: chat.HexColor(a),
+				TS:    a.Time.UTC().Format(time.RFC3339),
+			}
+			for _, f := range chat.Fields(a) {
+				value := f.Value.(string)
+				att.Fields = append(att.Fields, field{Title: f.Key, Value: value, Short: len(value) <= 40})
+			}
+			m.Text = strings.TrimSpace(s.emoji(a) + " " + title)
+			m.Attachments = []attachment{att}
+		}
+		if err := chat.PostJSON(ctx, s.client, s.opts.WebhookURL, nil, m, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emoji returns the emoji of a: the one of the highest severity a reaches,
+// or ResolvedEmoji for resolves.
+func (s *sink) emoji(a *alerter.Alert) string {
+	if a.Resolved {
+		return s.opts.ResolvedEmoji
+	}
+	emoji, best := "", alerter.Severity(-1)
+	for sev, e := range s.opts.Emoji {
+		if a.Severity >= sev && sev > best {
+			emoji, best = e, sev
+		}
+	}
+	return emoji
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package slack implements an alerter.Sink which posts alerts to Slack as
+// Block Kit messages, with a color bar for their severity and a table of
+// their key/value pairs:
+//
+//	a, err := slack.New(slack.Options{
+//		Token:   os.Getenv("SLACK_BOT_TOKEN"),
+//		Channel: "#alerts",
+//	})
+//
+// With a bot token, later occurrences and the resolve of an alert are
+// posted as replies in the thread of its first message, which is updated
+// to show the alert resolved.  Incoming webhooks cannot reply, so with
+// WebhookURL every alert is a message of its own.
+package slack
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// DefaultAPIURL is the base URL of the Slack Web API.
+const DefaultAPIURL = "https://slack.com/api"
+
+// Limits of Block Kit which messages are fitted into.
+const (
+	maxHeader      = 150
+	maxSectionText = 3000
+	maxFieldText   = 2000
+	maxFields      = 10
+	maxFieldBlocks = 4
+)
+
+// Options carries parameters which influence the way alerts are posted.
+// Either WebhookURL or Token and Channel must be set.
+type Options struct {
+	// WebhookURL is the URL of an incoming webhook.
+	WebhookURL string
+
+	// Token is a bot token, "xoxb-...", with the chat:write scope, which
+	// posts through chat.postMessage and threads updates.
+	Token string
+
+	// Channel is the channel bot messages are posted to, e.g. "#alerts"
+	// or a channel ID.
+	Channel string
+
+	// APIURL is the base URL of the Web API.  Defaults to DefaultAPIURL.
+	APIURL string
+
+	// Username and IconEmoji override the name and icon of the poster,
+	// if the app is allowed to.
+	Username  string
+	IconEmoji string
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than a section allows.  Limit
+	// defaults to 3000 characters; split chunks are posted as replies to
+	// the first one with a bot token.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  Schema defaults to
+	// transport.SlackSchema, and DryRunReply to replies of the Web API.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time and tells the age of
+	// threads.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for requests the Web API answered with "ok": false.
+type APIError struct {
+	// Method is the API method, such as "chat.postMessage".
+	Method string
+
+	// Code is the error code, such as "channel_not_found".
+	Code string
+}
+
+func (e *APIError) Error() string {
+	return "slack: " + e.Method + ": " + e.Code
+}
+
+// Retryable reports whether sending again could succeed, so that APIError
+// implements middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	switch e.Code {
+	case "ratelimited", "internal_error", "fatal_error", "service_unavailable", "request_timeout":
+		return true
+	}
+	return false
+}
+
+// New returns an Alerter which posts every alert to Slack.  It fails if
+// opts configure neither a webhook nor a bot.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" && (opts.Token == "" || opts.Channel == "") {
+		return alerter.Alerter{}, errors.New("slack: either WebhookURL or Token and Channel must be set")
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+	opts.APIURL = strings.TrimSuffix(opts.APIURL, "/")
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = maxSectionText
+	}
+	if opts.Transport.Schema == nil {
+		opts.Transport.Schema = transport.SlackSchema
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport), threads: chat.Threads{Clock: opts.Clock}}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts    Options
+	client  *http.Client
+	threads chat.Threads
+}
+
+// message is a chat.postMessage, chat.update or webhook payload.
+type message struct {
+	Channel     string       `json:"channel,omitempty"`
+	TS          string       `json:"ts,omitempty"`
+	ThreadTS    string       `json:"thread_ts,omitempty"`
+	Text        string       `json:"text"`
+	Username    string       `json:"username,omitempty"`
+	IconEmoji   string       `json:"icon_emoji,omitempty"`
+	Attachments []attachment `json:"attachments"`
+}
+
+type attachment struct {
+	Color  string  `json:"color"`
+	Blocks []block `json:"blocks"`
+}
+
+type block struct {
+	Type     string `json:"type"`
+	Text     *text  `json:"text,omitempty"`
+	Fields   []text `json:"fields,omitempty"`
+	Elements []text `json:"elements,omitempty"`
+}
+
+type text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	chunks := chat.Fit(ctx, chat.Body(a, maxHeader), s.opts.Overflow)
+	if s.opts.Token == "" {
+		for i, chunk := range chunks {
+			if err := s.webhook(ctx, s.render(a, chunk, i == 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Threads are remembered as "channel/ts", as chat.update takes the
+	// ID of the channel rather than its name.
+	fp := a.Fingerprint()
+	thread, threaded := s.threads.Get(fp)
+	channel, ts, _ := strings.Cut(thread, "/")
+	first := s.render(a, chunks[0], true)
+	first.ThreadTS = ts
+	posted, err := s.post(ctx, "chat.postMessage", first)
+	if err != nil {
+		return err
+	}
+	if !threaded {
+		channel, ts = posted.Channel, posted.TS
+	}
+	for _, chunk := range chunks[1:] {
+		reply := s.render(a, chunk, false)
+		reply.ThreadTS = ts
+		if _, err := s.post(ctx, "chat.postMessage", reply); err != nil {
+			return err
+		}
+	}
+	switch {
+	case !a.Resolved && !threaded:
+		s.threads.Set(fp, channel+"/"+ts)
+	case a.Resolved && threaded:
+		s.threads.Delete(fp)
+		// Show the original message resolved, so that the channel tells
+		// what is still firing.
+		update := s.render(a, chunks[0], true)
+		update.Channel, update.TS = channel, ts
+		if _, err := s.post(ctx, "chat.update", update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render returns the message about a with the given part of its text.
+// Only the first part of a message carries the header and fields.
+func (s *sink) render(a *alerter.Alert, body string, first bool) *message {
+	var blocks []block
+	if first {
+		blocks = append(blocks, block{Type: "header", Text: &text{Type: "plain_text", Text: chat.Truncate(chat.Title(a), maxHeader)}})
+	}
+	if body != "" {
+		blocks = append(blocks, block{Type: "section", Text: &text{Type: "mrkdwn", Text: escape(body)}})
+	}
+	if first {
+		blocks = append(blocks, fieldBlocks(chat.Fields(a))...)
+		context := s.opts.TimeFormat.Format(a.Time)
+		if a.Name != "" {
+			context = escape(a.Name) + " • " + context
+		}
+		blocks = append(blocks, block{Type: "context", Elements: []text{{Type: "mrkdwn", Text: context}}})
+	}
+	return &message{
+		Channel:     s.opts.Channel,
+		Text:        chat.Truncate(chat.Title(a), maxHeader),
+		Username:    s.opts.Username,
+		IconEmoji:   s.opts.IconEmoji,
+		Attachments: []attachment{{Color: chat.HexColor(a), Blocks: blocks}},
+	}
+}
+
+// fieldBlocks lays fields out as a table of sections of up to ten fields,
+// summing up those which do not fit.
+func fieldBlocks(fields []alerter.Field) []block {
+	var blocks []block
+	for len(fields) > 0 && len(blocks) < maxFieldBlocks {
+		n := min(len(fields), maxFields)
+		b := block{Type: "section"}
+		for _, f := range fields[:n] {
+			b.Fields = append(b.Fields, text{Type: "mrkdwn", Text: chat.Truncate("*"+escape(f.Key)+"*\n"+escape(f.Value.(string)), maxFieldText)})
+		}
+		blocks = append(blocks, b)
+		fields = fields[n:]
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, block{Type: "context", Elements: []text{{Type: "mrkdwn", Text: fmt.Sprintf("%d more fields", len(fields))}}})
+	}
+	return blocks
+}
+
+// escape escapes the control characters of mrkdwn.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+func (s *sink) webhook(ctx context.Context, m *message) error {
+	m.Channel = ""
+	return chat.PostJSON(ctx, s.client, s.opts.WebhookURL, nil, m, nil)
+}
+
+// response is the response of the Web API.
+type response struct {
+	OK      bool   `json:"ok"`
+	Error   string `json:"error"`
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// dryRunReply answers dry-run requests like the Web API, with a new
+// timestamp for every message posted.
+func dryRunReply(_ *http.Request, body []byte, n int) string {
+	var m message
+	_ = json.Unmarshal(body, &m)
+	ts := m.TS
+	if ts == "" {
+		ts = strconv.Itoa(n) + ".000000"
+	}
+	reply, _ := json.Marshal(response{OK: true, Channel: m.Channel, TS: ts})
+	return string(reply)
+}
+
+// post calls a Web API method with m and returns the channel and timestamp
+// of the posted message.
+func (s *sink) post(ctx context.Context, method string, m *message) (*response, error) {
+	var resp response
+	header := http.Header{"Authorization": {"Bearer " + s.opts.Token}}
+	if err := chat.PostJSON(ctx, s.client, s.opts.APIURL+"/"+method, header, m, &resp); err != nil {
+		return nil, fmt.Errorf("slack: %s: %w", method, err)
+	}
+	if !resp.OK {
+		return nil, &APIError{Method: method, Code: resp.Error}
+	}
+	return &resp, nil
+}
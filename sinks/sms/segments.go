@@ -0,0 +1,61 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sms
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// gsmBasic and gsmExtended are the characters of the GSM 03.38 alphabet,
+// those of the extension table taking two septets.
+const (
+	gsmBasic = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+		"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsmExtended = "\f^{}\\[~]|€"
+)
+
+// measure returns the length of text in GSM septets if it can be encoded
+// in the GSM alphabet, or else in UTF-16 units.
+func measure(text string) (n int, gsm bool) {
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsmBasic, r):
+			n++
+		case strings.ContainsRune(gsmExtended, r):
+			n += 2
+		default:
+			return len(utf16.Encode([]rune(text))), false
+		}
+	}
+	return n, true
+}
+
+// capacity returns the length of a message of the given number of
+// segments, in the units of measure.  Concatenated messages lose room to
+// their header in every segment.
+func capacity(gsm bool, segments int) int {
+	switch {
+	case gsm && segments == 1:
+		return 160
+	case gsm:
+		return 153 * segments
+	case segments == 1:
+		return 70
+	}
+	return 67 * segments
+}
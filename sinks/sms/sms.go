@@ -0,0 +1,319 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sms implements an alerter.Sink which texts a summary of alerts
+// to phone numbers, by severity, through a Provider such as Twilio:
+//
+//	tw, err := sms.NewTwilio(sms.TwilioOptions{
+//		AccountSID:     "AC...",
+//		AuthToken:      os.Getenv("TWILIO_AUTH_TOKEN"),
+//		From:           "+15005550006",
+//		StatusCallback: "https://alerts.example.com/sms/status",
+//	})
+//	...
+//	m, err := sms.New(sms.Options{
+//		Provider: tw,
+//		Recipients: map[alerter.Severity][]string{
+//			alerter.SeverityCritical: {"+15551230001", "+15551230002"},
+//		},
+//		OnError: func(a *alerter.Alert, err error) { ... },
+//	})
+//	...
+//	http.Handle("/sms/status", m)
+//	a := alerter.New(m)
+//
+// Messages are summarized to fit MaxSegments, counting characters the way
+// carriers do.  Messages which are accepted by the provider but later fail
+// to be delivered are reported to the handler of the status callback,
+// which passes them on to OnError as a *DeliveryError.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Provider sends text messages.
+type Provider interface {
+	// Send sends text to the phone number to and returns the ID of the
+	// message, by which its delivery is reported.
+	Send(ctx context.Context, to, text string) (id string, err error)
+
+	// ParseStatus parses a delivery report posted to the status callback
+	// of the provider.  Providers without status callbacks return an
+	// error.
+	ParseStatus(r *http.Request) (Status, error)
+}
+
+// Status is the delivery report of a message.
+type Status struct {
+	// ID is the ID of the message returned by Provider.Send.
+	ID string
+
+	// To is the phone number the message was sent to, if reported.
+	To string
+
+	// State is the state of the message in terms of the provider, such
+	// as "sent" or "delivered".
+	State string
+
+	// Final tells whether the state is final, so that no further
+	// reports follow.
+	Final bool
+
+	// Err is set if the message could not be delivered.
+	Err error
+}
+
+// DeliveryError is passed to Options.OnError for messages which the provider
+// accepted but could not deliver.
+type DeliveryError struct {
+	// ID is the ID of the message.
+	ID string
+
+	// To is the phone number the message was sent to.
+	To string
+
+	// State is the final state of the message, such as "undelivered".
+	State string
+
+	// Err is the reason reported by the provider.
+	Err error
+}
+
+func (e *DeliveryError) Error() string {
+	return fmt.Sprintf("sms: message %s to %s %s: %v", e.ID, e.To, e.State, e.Err)
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// Options carries parameters which influence the way alerts are texted.
+type Options struct {
+	// Provider sends the messages.
+	Provider Provider
+
+	// To are the phone numbers, in E.164 format, texted all alerts.
+	To []string
+
+	// Recipients are texted in addition to To the alerts of at least a
+	// severity.  Alerts without any recipient are dropped, so that
+	// leaving out To texts only severe alerts.
+	Recipients map[alerter.Severity][]string
+
+	// Summarizer shortens alerts to fit the messages.  Defaults to
+	// alerter.DefaultSummarizer.
+	Summarizer alerter.Summarizer
+
+	// MaxSegments is the number of segments a message may span, each of
+	// 160 GSM characters or 70 other characters, less the header of
+	// concatenated messages.  Carriers bill every segment.  Defaults to
+	// 1.
+	MaxSegments int
+
+	// Retention is the time messages are remembered for their delivery
+	// reports.  Defaults to 24 hours.
+	Retention time.Duration
+
+	// OnError is called with the alerts whose message could not be
+	// delivered, and a *DeliveryError.  The alert is nil for messages
+	// which are no longer remembered, e.g. after a restart.
+	OnError func(a *alerter.Alert, err error)
+
+	// Verbosity is the highest V-level of Info alerts which are texted.
+	Verbosity int
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// sink is the set of interfaces implemented by the Sinks of
+// alerter.NewSink, embedded so that resolves are
+// texted and alerts reach the Messenger whole.
+type sink interface {
+	alerter.Sink
+	alerter.Resolver
+	alerter.AlertSink
+	alerter.BatchSink
+}
+
+// Messenger is a Sink which texts alerts.  It is also the http.Handler of
+// the status callback of its provider.
+type Messenger struct {
+	sink
+
+	opts Options
+
+	mu        sync.Mutex
+	sent      map[string]*message
+	nextSweep int
+}
+
+// message is a message awaiting its delivery report.
+type message struct {
+	alert *alerter.Alert
+	to    string
+	at    time.Time
+}
+
+// New returns a Messenger texting alerts through opts.Provider.  It fails if
+// opts leave out the provider.
+func New(opts Options) (*Messenger, error) {
+	if opts.Provider == nil {
+		return nil, errors.New("sms: Provider must be set")
+	}
+	if opts.Summarizer == nil {
+		opts.Summarizer = alerter.DefaultSummarizer
+	}
+	if opts.MaxSegments <= 0 {
+		opts.MaxSegments = 1
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	m := &Messenger{opts: opts, sent: map[string]*message{}, nextSweep: 1024}
+	m.sink = alerter.NewSink(m.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	}).(sink)
+	return m, nil
+}
+
+func (m *Messenger) send(a *alerter.Alert) error {
+	to := m.recipients(a)
+	if len(to) == 0 {
+		return nil
+	}
+	text := m.text(a)
+	ctx := context.Background()
+	var errs []error
+	for _, number := range to {
+		id, err := m.opts.Provider.Send(ctx, number, text)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sms: %s: %w", number, err))
+			continue
+		}
+		if id != "" {
+			m.remember(id, &message{alert: a, to: number, at: m.opts.Clock.Now()})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// recipients returns the phone numbers texted a.
+func (m *Messenger) recipients(a *alerter.Alert) []string {
+	seen := map[string]bool{}
+	var to []string
+	add := func(numbers []string) {
+		for _, n := range numbers {
+			if !seen[n] {
+				seen[n] = true
+				to = append(to, n)
+			}
+		}
+	}
+	add(m.opts.To)
+	severities := make([]alerter.Severity, 0, len(m.opts.Recipients))
+	for sev := range m.opts.Recipients {
+		severities = append(severities, sev)
+	}
+	sort.Slice(severities, func(i, j int) bool { return severities[i] < severities[j] })
+	for _, sev := range severities {
+		if a.Severity >= sev {
+			add(m.opts.Recipients[sev])
+		}
+	}
+	return to
+}
+
+// text returns the summary of a which fits MaxSegments.  The summarizer
+// counts characters while carriers count GSM septets or UTF-16 units, so
+// the limit is lowered until the summary fits.  Ellipses are spelled out,
+// as a single one outside the GSM alphabet would more than halve the
+// capacity of the message.
+func (m *Messenger) text(a *alerter.Alert) string {
+	limit := capacity(true, m.opts.MaxSegments)
+	for {
+		text := strings.ReplaceAll(m.opts.Summarizer.Summarize(a, limit), "…", "...")
+		n, gsm := measure(text)
+		c := capacity(gsm, m.opts.MaxSegments)
+		if n <= c || limit <= 1 {
+			return text
+		}
+		limit -= max(1, n-c)
+	}
+}
+
+func (m *Messenger) remember(id string, msg *message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) >= m.nextSweep {
+		for id, msg := range m.sent {
+			if msg.at.Add(m.opts.Retention).Before(m.opts.Clock.Now()) {
+				delete(m.sent, id)
+			}
+		}
+		m.nextSweep = max(1024, 2*len(m.sent))
+	}
+	m.sent[id] = msg
+}
+
+// ServeHTTP handles the delivery reports posted to the status callback of
+// the provider.
+func (m *Messenger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := m.opts.Provider.ParseStatus(r)
+	if errors.Is(err, ErrSignature) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.mu.Lock()
+	msg := m.sent[st.ID]
+	if st.Final || st.Err != nil {
+		delete(m.sent, st.ID)
+	}
+	m.mu.Unlock()
+
+	if st.Err != nil && m.opts.OnError != nil {
+		var a *alerter.Alert
+		to := st.To
+		if msg != nil {
+			a, to = msg.alert, msg.to
+		}
+		m.opts.OnError(a, &DeliveryError{ID: st.ID, To: to, State: st.State, Err: st.Err})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// ErrSignature is returned by Provider.ParseStatus for reports which are not
+// signed by the provider.
+var ErrSignature = errors.New("sms: invalid signature")
+
+// TwilioOptions carries parameters which influence the way messages are sent
+// through Twilio.
+type TwilioOptions struct {
+	// AccountSID and AuthToken identify the Twilio account.
+	AccountSID string
+	AuthToken  string
+
+	// From is the phone number or alphanumeric sender ID messages are
+	// sent from.
+	From string
+
+	// MessagingServiceSID, if set, sends messages through a messaging
+	// service instead of From.
+	MessagingServiceSID string
+
+	// StatusCallback is the public URL of the Messenger, to which Twilio
+	// posts delivery reports.  Reports are verified against it, so it
+	// must match the URL Twilio posts to exactly.
+	StatusCallback string
+
+	// BaseURL is the URL of the Twilio API.  Defaults to
+	// "https://api.twilio.com".
+	BaseURL string
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Twilio.
+	Transport transport.Options
+}
+
+// Twilio is a Provider sending messages with the Programmable Messaging API
+// of Twilio.
+type Twilio struct {
+	opts   TwilioOptions
+	client *http.Client
+}
+
+// TwilioError is returned for requests Twilio did not accept, and for
+// messages it could not deliver.
+type TwilioError struct {
+	// Status is the HTTP status code, or 0 for delivery reports.
+	Status int
+
+	// Code is the Twilio error code, such as 21211 for invalid phone
+	// numbers or 30003 for unreachable handsets.
+	Code int
+
+	// Message explains the error.
+	Message string
+}
+
+func (e *TwilioError) Error() string {
+	if e.Code == 0 {
+		return "twilio: " + e.Message
+	}
+	return fmt.Sprintf("twilio: %d: %s", e.Code, e.Message)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when Twilio limits the rate of requests or fails, so that TwilioError
+// implements middleware.RetryableError.
+func (e *TwilioError) Retryable() bool {
+	return e.Status == http.StatusTooManyRequests || e.Status >= 500 || e.Code == 20429
+}
+
+// twilioDeliveryErrors explain the common error codes of delivery reports,
+// which carry the code only.
+var twilioDeliveryErrors = map[int]string{
+	30001: "queue overflow",
+	30002: "account suspended",
+	30003: "unreachable destination handset",
+	30004: "message blocked",
+	30005: "unknown destination handset",
+	30006: "landline or unreachable carrier",
+	30007: "message filtered by carrier",
+	30008: "unknown error",
+}
+
+// NewTwilio returns a Twilio provider.  It fails if opts leave out the
+// account or the sender.
+func NewTwilio(opts TwilioOptions) (*Twilio, error) {
+	if opts.AccountSID == "" || opts.AuthToken == "" {
+		return nil, errors.New("sms: AccountSID and AuthToken must be set")
+	}
+	if opts.From == "" && opts.MessagingServiceSID == "" {
+		return nil, errors.New("sms: From or MessagingServiceSID must be set")
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "https://api.twilio.com"
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = twilioDryRunReply
+	}
+	return &Twilio{opts: opts, client: transport.NewClient(opts.Transport)}, nil
+}
+
+// Send implements Provider.
+func (t *Twilio) Send(ctx context.Context, to, text string) (string, error) {
+	form := url.Values{"To": {to}, "Body": {text}}
+	if t.opts.MessagingServiceSID != "" {
+		form.Set("MessagingServiceSid", t.opts.MessagingServiceSID)
+	} else {
+		form.Set("From", t.opts.From)
+	}
+	if t.opts.StatusCallback != "" {
+		form.Set("StatusCallback", t.opts.StatusCallback)
+	}
+	endpoint := t.opts.BaseURL + "/2010-04-01/Accounts/" + url.PathEscape(t.opts.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.opts.AccountSID, t.opts.AuthToken)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("twilio: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if cerr := transport.CheckResponse(resp); cerr != nil {
+			return "", fmt.Errorf("twilio: %w", cerr)
+		}
+		return "", fmt.Errorf("twilio: decode response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", &TwilioError{Status: resp.StatusCode, Code: result.Code, Message: result.Message}
+	}
+	return result.SID, nil
+}
+
+// ParseStatus implements Provider.  Reports are verified with the
+// X-Twilio-Signature header if StatusCallback is set.
+func (t *Twilio) ParseStatus(r *http.Request) (Status, error) {
+	if err := r.ParseForm(); err != nil {
+		return Status{}, err
+	}
+	if t.opts.StatusCallback != "" && !TwilioSignatureValid(t.opts.AuthToken, t.opts.StatusCallback, r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		return Status{}, ErrSignature
+	}
+	st := Status{
+		ID:    r.PostForm.Get("MessageSid"),
+		To:    r.PostForm.Get("To"),
+		State: r.PostForm.Get("MessageStatus"),
+	}
+	if st.ID == "" || st.State == "" {
+		return Status{}, errors.New("sms: MessageSid and MessageStatus must be set")
+	}
+	switch st.State {
+	case "delivered", "read", "canceled":
+		st.Final = true
+	case "failed", "undelivered":
+		st.Final = true
+		code, _ := strconv.Atoi(r.PostForm.Get("ErrorCode"))
+		msg := twilioDeliveryErrors[code]
+		if msg == "" {
+			msg = "message " + st.State
+		}
+		st.Err = &TwilioError{Code: code, Message: msg}
+	}
+	return st, nil
+}
+
+// TwilioSignatureValid reports whether signature is the X-Twilio-Signature of
+// a request Twilio posted to rawURL with the given form, signed with
+// authToken.
+func TwilioSignatureValid(authToken, rawURL string, form url.Values, signature string) bool {
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(rawURL))
+	for _, k := range keys {
+		for _, v := range form[k] {
+			mac.Write([]byte(k))
+			mac.Write([]byte(v))
+		}
+	}
+	want := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(signature))
+}
+
+// twilioDryRunReply answers dry-run requests like the Messages API, with a
+// new SID for every message.
+func twilioDryRunReply(_ *http.Request, _ []byte, n int) string {
+	return fmt.Sprintf(`{"sid":"SMdryrun%d","status":"queued"}`, n)
+}
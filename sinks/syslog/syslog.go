@@ -0,0 +1,337 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package syslog implements an alerter.Sink which emits RFC 5424 syslog
+// messages, either to the local syslog daemon or to a remote collector over
+// UDP, TCP or TLS.
+//
+// The alerter name becomes the MSGID and all key/value pairs are sent as
+// structured data, so a collector can index them without parsing the
+// message text:
+//
+//	<11>1 2023-05-04T10:20:30.123Z web-1 myapp 4242 db/pool [alert@32473 severity="error" pool="primary"] connection lost: dial tcp: timeout
+package syslog
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Syslog severities as defined by RFC 5424.
+const (
+	Emergency = iota
+	Alert
+	Critical
+	Error
+	Warning
+	Notice
+	Informational
+	Debug
+)
+
+// Common syslog facilities as defined by RFC 5424.
+const (
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityLocal0 = 16
+)
+
+// Options carries parameters which influence the way alerts are sent.
+type Options struct {
+	// Network is one of "unix", "unixgram", "udp", "tcp" or "tls".  When
+	// empty, the local syslog daemon is used.
+	Network string
+
+	// Address of the collector, e.g. "logs.example.com:6514".  Ignored
+	// when Network is empty.
+	Address string
+
+	// TLSConfig is used when Network is "tls".
+	TLSConfig *tls.Config
+
+	// DialTimeout bounds connecting to the collector.  Defaults to 10
+	// seconds.
+	DialTimeout time.Duration
+
+	// KeepAlive is the interval of TCP keep-alive probes on stream
+	// connections.  Zero uses the Go default and a negative value
+	// disables them.
+	KeepAlive time.Duration
+
+	// Facility is the syslog facility of all messages.  Defaults to
+	// FacilityUser.
+	Facility int
+
+	// Hostname is sent as HOSTNAME.  Defaults to os.Hostname.
+	Hostname string
+
+	// AppName is sent as APP-NAME.  Defaults to the name of the running
+	// executable.
+	AppName string
+
+	// SDID is the ID of the structured data element carrying the
+	// key/value pairs.  Defaults to "alert@32473", which uses the example
+	// enterprise number reserved by RFC 5612; organizations with their own
+	// number should use it instead.
+	SDID string
+
+	// Verbosity is the highest V-level of Info alerts which are sent.
+	Verbosity int
+
+	// Priority maps an alert to one of the syslog severities.  When nil,
+	// DefaultPriority is used.
+	Priority func(a *alerter.Alert) int
+
+	// Clock stamps messages with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// DefaultPriority maps critical alerts to Critical, errors to Error,
+// warnings to Warning, resolved alerts to Notice, and Info alerts to
+// Informational at V-level 0 and to Debug above.
+func DefaultPriority(a *alerter.Alert) int {
+	switch {
+	case a.Severity >= alerter.SeverityCritical:
+		return Critical
+	case a.Severity == alerter.SeverityError:
+		return Error
+	case a.Severity == alerter.SeverityWarning:
+		return Warning
+	case a.Resolved:
+		return Notice
+	case a.Level > 0:
+		return Debug
+	default:
+		return Informational
+	}
+}
+
+// Writer is a connection to a syslog daemon or collector.  It reconnects
+// once whenever sending fails and is safe for concurrent use.
+type Writer struct {
+	opts Options
+	pid  string
+
+	mu   sync.Mutex
+	conn net.Conn
+	// framed is true for TCP and TLS connections, which delimit messages
+	// by octet counting (RFC 6587).  Messages on local stream sockets are
+	// terminated by a newline instead.
+	framed bool
+	stream bool
+}
+
+// Dial connects to the syslog daemon or collector described by opts.
+func Dial(opts Options) (*Writer, error) {
+	if opts.Facility == 0 {
+		opts.Facility = FacilityUser
+	}
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.AppName == "" {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+	if opts.SDID == "" {
+		opts.SDID = "alert@32473"
+	}
+	if opts.Priority == nil {
+		opts.Priority = DefaultPriority
+	}
+
+	w := &Writer{opts: opts, pid: strconv.Itoa(os.Getpid())}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// New returns an Alerter which sends every alert through w.
+func New(w *Writer) alerter.Alerter {
+	return alerter.New(alerter.NewSink(w.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= w.opts.Verbosity },
+		Clock:   w.opts.Clock,
+	}))
+}
+
+// Close closes the connection.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+func (w *Writer) connect() error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: w.opts.DialTimeout, KeepAlive: w.opts.KeepAlive}
+	if dialer.Timeout <= 0 {
+		dialer.Timeout = 10 * time.Second
+	}
+	switch w.opts.Network {
+	case "":
+		conn, err = dialLocal()
+	case "tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.opts.Address, w.opts.TLSConfig)
+	default:
+		conn, err = dialer.Dial(w.opts.Network, w.opts.Address)
+	}
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	w.framed = w.opts.Network == "tcp" || w.opts.Network == "tls"
+	w.stream = conn.RemoteAddr() != nil && conn.RemoteAddr().Network() == "unix"
+	return nil
+}
+
+// dialLocal connects to the first local syslog socket which accepts
+// connections.
+func dialLocal() (net.Conn, error) {
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("syslog: no local syslog socket found")
+}
+
+func (w *Writer) send(a *alerter.Alert) error {
+	msg := w.format(a)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn != nil {
+		if err := w.write(msg); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+	if err := w.connect(); err != nil {
+		return err
+	}
+	return w.write(msg)
+}
+
+func (w *Writer) write(msg []byte) error {
+	switch {
+	case w.framed:
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	case w.stream:
+		msg = append(msg, '\n')
+	}
+	_, err := w.conn.Write(msg)
+	return err
+}
+
+func (w *Writer) format(a *alerter.Alert) []byte {
+	var b strings.Builder
+	pri := w.opts.Facility*8 + w.opts.Priority(a)
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ",
+		pri,
+		a.Time.UTC().Format(time.RFC3339Nano),
+		header(w.opts.Hostname, 255),
+		header(w.opts.AppName, 48),
+		header(w.pid, 128),
+		header(a.Name, 32))
+
+	b.WriteByte('[')
+	b.WriteString(w.opts.SDID)
+	writeParam(&b, "severity", a.Severity.String())
+	if a.Resolved {
+		writeParam(&b, "resolved", "true")
+	}
+	for _, f := range a.Fields() {
+		if f.Key == alerter.SeverityKey {
+			continue
+		}
+		writeParam(&b, f.Key, fmt.Sprint(f.Value))
+	}
+	b.WriteString("] ")
+
+	b.WriteString(a.Message)
+	if a.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(a.Err.Error())
+	}
+	return []byte(b.String())
+}
+
+// header returns s as a header field: printable US-ASCII without spaces, at
+// most max characters long, or "-" when empty.
+func header(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > max {
+		s = s[:max]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// writeParam appends an SD-PARAM.  Names are restricted to printable
+// US-ASCII except '=', ' ', ']' and '"'; values escape '"', '\' and ']'.
+func writeParam(b *strings.Builder, name, value string) {
+	name = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	if name == "" {
+		return
+	}
+	b.WriteByte(' ')
+	b.WriteString(name)
+	b.WriteString(`="`)
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,424 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package telegram implements an alerter.Sink which sends alerts through a
+// Telegram bot to chats, groups and channels:
+//
+//	a, err := telegram.New(telegram.Options{
+//		Token:  os.Getenv("TELEGRAM_BOT_TOKEN"),
+//		ChatID: "-1001234567890",
+//	})
+//
+// Later occurrences and the resolve of an alert are sent as replies to its
+// first message.  Messages are paced per chat to stay within the flood
+// limits of Telegram, and when Telegram asks to slow down anyway the sink
+// waits as long as it is told to.
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/matchers"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// DefaultAPIURL is the base URL of the Bot API.
+const DefaultAPIURL = "https://api.telegram.org"
+
+// ParseMode is the formatting of messages.
+type ParseMode string
+
+// Parse modes of the Bot API.
+const (
+	HTML       ParseMode = "HTML"
+	MarkdownV2 ParseMode = "MarkdownV2"
+)
+
+// maxTitle is the number of characters of the title of a message.
+const maxTitle = 256
+
+// Route sends the alerts matching Matchers to another chat, or to a topic
+// of a forum if ThreadID is set.
+type Route struct {
+	Matchers matchers.Matchers
+	ChatID   string
+	ThreadID int
+}
+
+// Options carries parameters which influence the way alerts are sent.
+type Options struct {
+	// Token is the token of the bot, as issued by @BotFather.
+	Token string
+
+	// ChatID is the chat alerts are sent to if no route matches them,
+	// e.g. "-1001234567890" or "@channelname".  It may be empty if the
+	// routes cover all alerts, and alerts no route matches are dropped.
+	ChatID string
+
+	// ThreadID is the topic of the forum ChatID alerts are sent to.
+	ThreadID int
+
+	// Routes send alerts to other chats, the first matching route
+	// winning.
+	Routes []Route
+
+	// ParseMode is the formatting of messages.  Defaults to HTML.
+	ParseMode ParseMode
+
+	// Silent sends alerts below SeverityError without a notification
+	// sound.
+	Silent bool
+
+	// APIURL is the base URL of the Bot API, e.g. of a local Bot API
+	// server.  Defaults to DefaultAPIURL.
+	APIURL string
+
+	// Interval is the minimum time between messages to a chat.  Defaults
+	// to 3 seconds, the pace Telegram allows in groups.
+	Interval time.Duration
+
+	// MaxWait is the longest time a message waits for its chat, be it
+	// for Interval or because Telegram asked to retry later.  Messages
+	// which would wait longer fail with an APIError.  Defaults to one
+	// minute.
+	MaxWait time.Duration
+
+	// Verbosity is the highest V-level of Info alerts which are sent.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than Telegram allows.  Limit
+	// defaults to 3500 characters, leaving room for the title and
+	// formatting; split chunks are sent as replies to the first one.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Telegram.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time, tells the age of threads and
+	// times the waits between messages to a chat.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for requests the Bot API did not accept.
+type APIError struct {
+	// Method is the API method, such as "sendMessage".
+	Method string
+
+	// Code is the error code, which equals the HTTP status code.
+	Code int
+
+	// Description explains the error.
+	Description string
+
+	// RetryAfter is the time Telegram asked to wait before sending again
+	// when rate limiting.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram: %s: %d %s", e.Method, e.Code, e.Description)
+}
+
+// Retryable reports whether sending again could succeed, so that APIError
+// implements middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= 500
+}
+
+// New returns an Alerter which sends every alert through the bot.  It fails
+// if opts configure no token or chat.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.Token == "" || (opts.ChatID == "" && len(opts.Routes) == 0) {
+		return alerter.Alerter{}, errors.New("telegram: Token and ChatID or Routes must be set")
+	}
+	if opts.ParseMode == "" {
+		opts.ParseMode = HTML
+	}
+	if opts.APIURL == "" {
+		opts.APIURL = DefaultAPIURL
+	}
+	opts.APIURL = strings.TrimSuffix(opts.APIURL, "/")
+	if opts.Interval <= 0 {
+		opts.Interval = 3 * time.Second
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = time.Minute
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 3500
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport), next: map[string]time.Time{}, threads: chat.Threads{Clock: opts.Clock}}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts    Options
+	client  *http.Client
+	threads chat.Threads
+
+	// next holds the time each chat may be sent the next message.
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// message is a sendMessage request.
+type message struct {
+	ChatID              string           `json:"chat_id"`
+	ThreadID            int              `json:"message_thread_id,omitempty"`
+	Text                string           `json:"text"`
+	ParseMode           ParseMode        `json:"parse_mode"`
+	DisableNotification bool             `json:"disable_notification,omitempty"`
+	ReplyParameters     *replyParameters `json:"reply_parameters,omitempty"`
+	LinkPreviewOptions  struct {
+		IsDisabled bool `json:"is_disabled"`
+	} `json:"link_preview_options"`
+}
+
+type replyParameters struct {
+	MessageID                int  `json:"message_id"`
+	AllowSendingWithoutReply bool `json:"allow_sending_without_reply"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	chatID, threadID := s.route(a)
+	if chatID == "" {
+		return nil
+	}
+	ctx := context.Background()
+	key := chatID + "/" + a.Fingerprint()
+	var replyTo int
+	if id, ok := s.threads.Get(key); ok {
+		fmt.Sscan(id, &replyTo)
+	}
+	first := replyTo == 0
+	for i, chunk := range chat.Fit(ctx, s.body(a), s.opts.Overflow) {
+		m := &message{
+			ChatID:              chatID,
+			ThreadID:            threadID,
+			Text:                s.escape(chunk),
+			ParseMode:           s.opts.ParseMode,
+			DisableNotification: s.opts.Silent && a.Severity < alerter.SeverityError && !a.Resolved,
+		}
+		m.LinkPreviewOptions.IsDisabled = true
+		if i == 0 {
+			m.Text = s.bold(chat.Truncate(chat.Title(a), maxTitle)) + "\n" + m.Text
+		}
+		if replyTo != 0 {
+			m.ReplyParameters = &replyParameters{MessageID: replyTo, AllowSendingWithoutReply: true}
+		}
+		id, err := s.post(ctx, chatID, m)
+		if err != nil {
+			return err
+		}
+		if replyTo == 0 {
+			replyTo = id
+		}
+	}
+	switch {
+	case a.Resolved:
+		s.threads.Delete(key)
+	case first:
+		s.threads.Set(key, fmt.Sprint(replyTo))
+	}
+	return nil
+}
+
+// route returns the chat and topic of a.
+func (s *sink) route(a *alerter.Alert) (string, int) {
+	for _, r := range s.opts.Routes {
+		if r.Matchers.Matches(a) {
+			return r.ChatID, r.ThreadID
+		}
+	}
+	return s.opts.ChatID, s.opts.ThreadID
+}
+
+// body returns the text of a message about a below its title: its error,
+// its key/value pairs and its time, unformatted.
+func (s *sink) body(a *alerter.Alert) string {
+	var b strings.Builder
+	if text := chat.Body(a, maxTitle); text != "" {
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+	for _, f := range chat.Fields(a) {
+		fmt.Fprintf(&b, "%s: %s\n", f.Key, f.Value)
+	}
+	b.WriteString(s.opts.TimeFormat.Format(a.Time))
+	return b.String()
+}
+
+func (s *sink) bold(text string) string {
+	if s.opts.ParseMode == MarkdownV2 {
+		return "*" + s.escape(text) + "*"
+	}
+	return "<b>" + s.escape(text) + "</b>"
+}
+
+// markdownV2Special are the characters MarkdownV2 requires to be escaped.
+const markdownV2Special = "_*[]()~`>#+-=|{}.!\\"
+
+func (s *sink) escape(text string) string {
+	if s.opts.ParseMode != MarkdownV2 {
+		return html.EscapeString(text)
+	}
+	var b strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(markdownV2Special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// post sends m once its chat may be sent a message, waiting and trying
+// again once if Telegram asks to, and returns the ID of the message.
+func (s *sink) post(ctx context.Context, chatID string, m *message) (int, error) {
+	var delay time.Duration
+	var last error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := s.wait(chatID, delay); err != nil {
+			if last != nil {
+				return 0, last
+			}
+			return 0, err
+		}
+		id, err := s.call(ctx, "sendMessage", m)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter <= 0 {
+			return id, err
+		}
+		delay, last = apiErr.RetryAfter, err
+	}
+	return 0, last
+}
+
+// wait sleeps until chatID may be sent a message, after at least delay,
+// and reserves the slot.  It fails without waiting if that takes longer
+// than MaxWait.
+func (s *sink) wait(chatID string, delay time.Duration) error {
+	now := s.opts.Clock.Now()
+	s.mu.Lock()
+	at := s.next[chatID]
+	if later := now.Add(delay); later.After(at) {
+		at = later
+	}
+	if d := at.Sub(now); d > s.opts.MaxWait {
+		s.mu.Unlock()
+		return &APIError{Method: "sendMessage", Code: http.StatusTooManyRequests, Description: "flood control: chat busy", RetryAfter: d}
+	}
+	s.next[chatID] = maxTime(at, now).Add(s.opts.Interval)
+	if len(s.next) >= 1024 {
+		for id, t := range s.next {
+			if t.Before(now) {
+				delete(s.next, id)
+			}
+		}
+	}
+	s.mu.Unlock()
+	alerter.Sleep(s.opts.Clock, at.Sub(now))
+	return nil
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// call calls a Bot API method with the given parameters and returns the
+// ID of the message it sent.
+func (s *sink) call(ctx context.Context, method string, params interface{}) (int, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.APIURL+"/bot"+s.opts.Token+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL contains the token.
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return 0, fmt.Errorf("telegram: %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		OK          bool   `json:"ok"`
+		ErrorCode   int    `json:"error_code"`
+		Description string `json:"description"`
+		Parameters  struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+		Result struct {
+			MessageID int `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if cerr := transport.CheckResponse(resp); cerr != nil {
+			return 0, fmt.Errorf("telegram: %s: %w", method, cerr)
+		}
+		return 0, fmt.Errorf("telegram: %s: decode response: %w", method, err)
+	}
+	if !result.OK {
+		return 0, &APIError{
+			Method:      method,
+			Code:        result.ErrorCode,
+			Description: result.Description,
+			RetryAfter:  time.Duration(result.Parameters.RetryAfter) * time.Second,
+		}
+	}
+	return result.Result.MessageID, nil
+}
+
+// dryRunReply answers dry-run requests like the Bot API, with a new message
+// ID for every message sent.
+func dryRunReply(_ *http.Request, _ []byte, n int) string {
+	return fmt.Sprintf(`{"ok":true,"result":{"message_id":%d}}`, n)
+}
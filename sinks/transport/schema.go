@@ -0,0 +1,461 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Schema is a compiled JSON Schema which payloads are validated against
+// before they are sent, so that a malformed payload fails with a clear error
+// instead of a 400 from the provider.  The keywords type, enum, const,
+// properties, required, additionalProperties, items, minItems, maxItems,
+// minLength, maxLength, pattern, minimum, maximum, allOf, anyOf and oneOf
+// are supported; others, including $ref, are ignored.
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	constant             interface{}
+	hasConst             bool
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	noAdditional         bool
+	items                *Schema
+	minItems, maxItems   int
+	minLength, maxLength int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+	allOf, anyOf, oneOf  []*Schema
+}
+
+// ParseSchema compiles a JSON Schema document.
+func ParseSchema(data []byte) (*Schema, error) {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	s, err := compile(raw, "")
+	if err != nil {
+		return nil, fmt.Errorf("schema: %w", err)
+	}
+	return s, nil
+}
+
+// MustParseSchema is like ParseSchema but panics if data is not a valid
+// schema.
+func MustParseSchema(data string) *Schema {
+	s, err := ParseSchema([]byte(data))
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func compile(raw interface{}, at string) (*Schema, error) {
+	s := &Schema{minItems: -1, maxItems: -1, minLength: -1, maxLength: -1}
+	switch raw := raw.(type) {
+	case bool:
+		if !raw {
+			// Nothing matches an empty anyOf.
+			s.anyOf = []*Schema{}
+		}
+		return s, nil
+	case map[string]interface{}:
+		return s, s.compile(raw, at)
+	}
+	return nil, fmt.Errorf("%s: schema must be an object or a boolean", pointer(at))
+}
+
+func (s *Schema) compile(m map[string]interface{}, at string) error {
+	var err error
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, t := range t {
+			name, _ := t.(string)
+			s.types = append(s.types, name)
+		}
+	}
+	if e, ok := m["enum"].([]interface{}); ok {
+		s.enum = e
+	}
+	s.constant, s.hasConst = m["const"]
+	if p, ok := m["properties"].(map[string]interface{}); ok {
+		s.properties = make(map[string]*Schema, len(p))
+		for name, raw := range p {
+			if s.properties[name], err = compile(raw, at+"/properties/"+name); err != nil {
+				return err
+			}
+		}
+	}
+	if r, ok := m["required"].([]interface{}); ok {
+		for _, name := range r {
+			if name, ok := name.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	switch a := m["additionalProperties"].(type) {
+	case bool:
+		s.noAdditional = !a
+	case map[string]interface{}:
+		if s.additionalProperties, err = compile(a, at+"/additionalProperties"); err != nil {
+			return err
+		}
+	}
+	if items, ok := m["items"]; ok {
+		if s.items, err = compile(items, at+"/items"); err != nil {
+			return err
+		}
+	}
+	s.minItems = intKeyword(m, "minItems")
+	s.maxItems = intKeyword(m, "maxItems")
+	s.minLength = intKeyword(m, "minLength")
+	s.maxLength = intKeyword(m, "maxLength")
+	if p, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return fmt.Errorf("%s/pattern: %w", pointer(at), err)
+		}
+	}
+	if n, ok := m["minimum"].(float64); ok {
+		s.minimum = &n
+	}
+	if n, ok := m["maximum"].(float64); ok {
+		s.maximum = &n
+	}
+	for keyword, list := range map[string]*[]*Schema{"allOf": &s.allOf, "anyOf": &s.anyOf, "oneOf": &s.oneOf} {
+		raw, ok := m[keyword].([]interface{})
+		if !ok {
+			continue
+		}
+		*list = make([]*Schema, len(raw))
+		for i, r := range raw {
+			if (*list)[i], err = compile(r, fmt.Sprintf("%s/%s/%d", at, keyword, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func intKeyword(m map[string]interface{}, keyword string) int {
+	if n, ok := m[keyword].(float64); ok {
+		return int(n)
+	}
+	return -1
+}
+
+// SchemaError is returned for payloads which do not match a Schema.  It is
+// not retryable, as sending the same payload again cannot succeed.
+type SchemaError struct {
+	// Violations describe where and how the payload differs from the
+	// schema, e.g. `/blocks/0: missing required property "type"`.
+	Violations []string
+}
+
+func (e *SchemaError) Error() string {
+	return "payload does not match schema: " + strings.Join(e.Violations, "; ")
+}
+
+// Retryable returns false, so that SchemaError implements
+// middleware.RetryableError.
+func (e *SchemaError) Retryable() bool {
+	return false
+}
+
+// Validate checks the JSON document data against the schema, returning a
+// *SchemaError if it does not match.
+func (s *Schema) Validate(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return &SchemaError{Violations: []string{"invalid JSON: " + err.Error()}}
+	}
+	if violations := s.validate(v, "", nil); len(violations) > 0 {
+		return &SchemaError{Violations: violations}
+	}
+	return nil
+}
+
+func (s *Schema) validate(v interface{}, at string, violations []string) []string {
+	fail := func(format string, args ...interface{}) {
+		violations = append(violations, pointer(at)+": "+fmt.Sprintf(format, args...))
+	}
+	if len(s.types) > 0 && !s.hasType(v) {
+		fail("must be of type %s, not %s", strings.Join(s.types, " or "), typeOf(v))
+		return violations
+	}
+	if s.enum != nil && !contains(s.enum, v) {
+		fail("must be one of %s", list(s.enum))
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, v) {
+		fail("must be %s", list([]interface{}{s.constant}))
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			switch p := s.properties[name]; {
+			case p != nil:
+				violations = p.validate(v[name], at+"/"+name, violations)
+			case s.additionalProperties != nil:
+				violations = s.additionalProperties.validate(v[name], at+"/"+name, violations)
+			case s.noAdditional:
+				fail("unknown property %q", name)
+			}
+		}
+	case []interface{}:
+		if s.minItems >= 0 && len(v) < s.minItems {
+			fail("must have at least %d items", s.minItems)
+		}
+		if s.maxItems >= 0 && len(v) > s.maxItems {
+			fail("must have at most %d items", s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range v {
+				violations = s.items.validate(item, fmt.Sprintf("%s/%d", at, i), violations)
+			}
+		}
+	case string:
+		n := len([]rune(v))
+		if s.minLength >= 0 && n < s.minLength {
+			fail("must be at least %d characters long", s.minLength)
+		}
+		if s.maxLength >= 0 && n > s.maxLength {
+			fail("must be at most %d characters long, not %d", s.maxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match %q", s.pattern)
+		}
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be at least %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be at most %v", *s.maximum)
+		}
+	}
+	for _, sub := range s.allOf {
+		violations = sub.validate(v, at, violations)
+	}
+	if s.anyOf != nil {
+		matched := 0
+		for _, sub := range s.anyOf {
+			if len(sub.validate(v, at, nil)) == 0 {
+				matched++
+				break
+			}
+		}
+		if matched == 0 {
+			fail("does not match any of the allowed schemas")
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if len(sub.validate(v, at, nil)) == 0 {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("must match exactly one of the allowed schemas, matches %d", matched)
+		}
+	}
+	return violations
+}
+
+func (s *Schema) hasType(v interface{}) bool {
+	t := typeOf(v)
+	for _, want := range s.types {
+		if want == t || want == "number" && t == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded JSON value.
+func typeOf(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+func contains(values []interface{}, v interface{}) bool {
+	for _, e := range values {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func list(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		b, _ := json.Marshal(v)
+		parts[i] = string(b)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// pointer formats a JSON pointer for messages, "/" being the document.
+func pointer(at string) string {
+	if at == "" {
+		return "/"
+	}
+	return at
+}
+
+// validatingTransport validates the JSON bodies of requests before sending
+// them.  Other bodies, such as the forms of Twilio, pass unchecked.
+type validatingTransport struct {
+	next   http.RoundTripper
+	schema *Schema
+}
+
+func (t *validatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody || !isJSON(req.Header.Get("Content-Type")) {
+		return t.next.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := t.schema.Validate(body); err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return t.next.RoundTrip(r)
+}
+
+// isJSON reports whether contentType is the media type of JSON.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// Built-in schemas of the payloads of well-known providers, covering the
+// mistakes which make them reject alerts.
+var (
+	// SlackSchema is the schema of Slack incoming webhook and
+	// chat.postMessage payloads.
+	SlackSchema = MustParseSchema(`{
+		"type": "object",
+		"anyOf": [{"required": ["text"]}, {"required": ["blocks"]}, {"required": ["attachments"]}],
+		"properties": {
+			"text": {"type": "string", "maxLength": 40000},
+			"blocks": {"type": "array", "maxItems": 50, "items": {"type": "object", "required": ["type"]}},
+			"attachments": {"type": "array", "items": {"type": "object"}},
+			"thread_ts": {"type": "string"}
+		}
+	}`)
+
+	// DiscordSchema is the schema of Discord webhook payloads.
+	DiscordSchema = MustParseSchema(`{
+		"type": "object",
+		"anyOf": [{"required": ["content"]}, {"required": ["embeds"]}],
+		"properties": {
+			"content": {"type": "string", "maxLength": 2000},
+			"username": {"type": "string", "maxLength": 80},
+			"embeds": {"type": "array", "maxItems": 10, "items": {
+				"type": "object",
+				"properties": {
+					"title": {"type": "string", "maxLength": 256},
+					"description": {"type": "string", "maxLength": 4096},
+					"color": {"type": "integer", "minimum": 0, "maximum": 16777215}
+				}
+			}}
+		}
+	}`)
+
+	// PagerDutySchema is the schema of PagerDuty Events API v2 payloads.
+	PagerDutySchema = MustParseSchema(`{
+		"type": "object",
+		"required": ["routing_key", "event_action"],
+		"properties": {
+			"routing_key": {"type": "string", "minLength": 32, "maxLength": 32},
+			"event_action": {"enum": ["trigger", "acknowledge", "resolve"]},
+			"dedup_key": {"type": "string", "maxLength": 255},
+			"payload": {
+				"type": "object",
+				"required": ["summary", "source", "severity"],
+				"properties": {
+					"summary": {"type": "string", "minLength": 1, "maxLength": 1024},
+					"source": {"type": "string", "minLength": 1},
+					"severity": {"enum": ["critical", "error", "warning", "info"]}
+				}
+			}
+		},
+		"oneOf": [
+			{"properties": {"event_action": {"const": "trigger"}}, "required": ["payload"]},
+			{"properties": {"event_action": {"enum": ["acknowledge", "resolve"]}}, "required": ["dedup_key"]}
+		]
+	}`)
+
+	// AlertmanagerSchema is the schema of Alertmanager API v2 alert
+	// payloads.
+	AlertmanagerSchema = MustParseSchema(`{
+		"type": "array",
+		"items": {
+			"type": "object",
+			"required": ["labels"],
+			"properties": {
+				"labels": {"type": "object", "additionalProperties": {"type": "string"}},
+				"annotations": {"type": "object", "additionalProperties": {"type": "string"}},
+				"startsAt": {"type": "string"},
+				"endsAt": {"type": "string"},
+				"generatorURL": {"type": "string"}
+			}
+		}
+	}`)
+)
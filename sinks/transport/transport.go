@@ -0,0 +1,268 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package transport builds the HTTP clients of sinks which deliver alerts
+// to web APIs, with connection pooling tuned for alerting: few, long-lived
+// connections per provider and bounded request times, so that one slow
+// provider neither exhausts connections nor stalls deliveries forever.
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options carries parameters for NewClient.  Zero values select the
+// defaults.
+type Options struct {
+	// Timeout bounds a whole request, including reading the response.
+	// Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// MaxConnsPerHost limits the connections to a provider, and thereby
+	// the requests in flight.  Defaults to 4.
+	MaxConnsPerHost int
+
+	// MaxIdleConnsPerHost is the number of idle connections kept open
+	// per provider.  Defaults to MaxConnsPerHost.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout closes idle connections after this time.  Defaults
+	// to 90 seconds.
+	IdleConnTimeout time.Duration
+
+	// KeepAlive is the interval of TCP keep-alive probes.  Defaults to 30
+	// seconds; a negative value disables them.
+	KeepAlive time.Duration
+
+	// TLSConfig configures TLS, e.g. for client certificates or private
+	// certificate authorities.
+	TLSConfig *tls.Config
+
+	// Proxy selects a proxy for a request.  Defaults to
+	// http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// Schema, if set, validates the bodies of requests sent as
+	// application/json before they are sent, failing them with a
+	// *SchemaError if they do not match; other bodies pass.  Sinks
+	// default to the schema of their provider, if there is a built-in
+	// one, and custom schemas suit generic webhooks.
+	Schema *Schema
+
+	// DryRun, if set, receives the requests of the client instead of the
+	// provider, e.g. to validate new routes in production: each one is
+	// written with its method, scheme, host and body, and answered with
+	// 200 OK and the body from DryRunReply.  Paths and headers are left
+	// out, as they often carry credentials, and bodies are written as
+	// DryRunRedact returns them.  Schema still applies.
+	DryRun io.Writer
+
+	// DryRunRedact, if set, returns the body of a dry-run request as it
+	// is written, e.g. RedactFields masking the credentials of providers
+	// which take them in the body.  Sinks of such providers default it;
+	// bodies are written unchanged otherwise.
+	DryRunRedact func(req *http.Request, body []byte) []byte
+
+	// DryRunReply returns the bodies of the responses to dry-run
+	// requests.  Sinks default it to replies shaped like those of their
+	// provider, so that they handle them as real ones; it defaults to
+	// {"ok": true} otherwise.
+	DryRunReply DryRunReply
+}
+
+// DryRunReply returns the body of the response to the nth dry-run request of
+// a client, counting from 1, whose body is body.  Replies carrying IDs, such
+// as those of messages, should derive them from n so that they are unique.
+type DryRunReply func(req *http.Request, body []byte, n int) string
+
+func defaultDryRunReply(*http.Request, []byte, int) string {
+	return `{"ok": true}`
+}
+
+// NewClient returns an HTTP client configured with opts.
+func NewClient(opts Options) *http.Client {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.MaxConnsPerHost <= 0 {
+		opts.MaxConnsPerHost = 4
+	}
+	if opts.MaxIdleConnsPerHost <= 0 {
+		opts.MaxIdleConnsPerHost = opts.MaxConnsPerHost
+	}
+	if opts.IdleConnTimeout <= 0 {
+		opts.IdleConnTimeout = 90 * time.Second
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = 30 * time.Second
+	}
+	if opts.Proxy == nil {
+		opts.Proxy = http.ProxyFromEnvironment
+	}
+	dialer := &net.Dialer{Timeout: opts.Timeout, KeepAlive: opts.KeepAlive}
+	var rt http.RoundTripper = &http.Transport{
+		Proxy:               opts.Proxy,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     opts.TLSConfig,
+		TLSHandshakeTimeout: opts.Timeout,
+		ForceAttemptHTTP2:   true,
+		MaxConnsPerHost:     opts.MaxConnsPerHost,
+		MaxIdleConnsPerHost: opts.MaxIdleConnsPerHost,
+		IdleConnTimeout:     opts.IdleConnTimeout,
+	}
+	if opts.DryRun != nil {
+		if opts.DryRunReply == nil {
+			opts.DryRunReply = defaultDryRunReply
+		}
+		rt = &dryRunTransport{w: opts.DryRun, reply: opts.DryRunReply, redact: opts.DryRunRedact}
+	}
+	if opts.Schema != nil {
+		rt = &validatingTransport{next: rt, schema: opts.Schema}
+	}
+	return &http.Client{Timeout: opts.Timeout, Transport: rt}
+}
+
+// dryRunTransport writes requests instead of sending them.
+type dryRunTransport struct {
+	reply  DryRunReply
+	redact func(req *http.Request, body []byte) []byte
+
+	mu sync.Mutex
+	w  io.Writer
+	n  int
+}
+
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	written := body
+	if t.redact != nil {
+		written = t.redact(req, body)
+	}
+	t.mu.Lock()
+	t.n++
+	n := t.n
+	_, err := fmt.Fprintf(t.w, "dry run: %s %s://%s\n%s\n", req.Method, req.URL.Scheme, req.URL.Host, bytes.TrimSpace(written))
+	t.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	reply := t.reply(req, body, n)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(reply)),
+		ContentLength: int64(len(reply)),
+		Request:       req,
+	}, nil
+}
+
+// redacted replaces the values RedactFields masks.
+const redacted = "REDACTED"
+
+// RedactFields returns an Options.DryRunRedact which masks the values of
+// fields in form bodies, and of the top-level fields of JSON objects.
+// Other bodies are written unchanged, while form bodies which do not parse
+// and JSON bodies which are not objects are replaced entirely.
+func RedactFields(fields ...string) func(req *http.Request, body []byte) []byte {
+	return func(req *http.Request, body []byte) []byte {
+		contentType := req.Header.Get("Content-Type")
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch {
+		case mediaType == "application/x-www-form-urlencoded":
+			form, err := url.ParseQuery(string(body))
+			if err != nil {
+				return []byte(redacted)
+			}
+			for _, f := range fields {
+				if form.Has(f) {
+					form[f] = []string{redacted}
+				}
+			}
+			return []byte(form.Encode())
+		case isJSON(contentType):
+			var object map[string]json.RawMessage
+			if err := json.Unmarshal(body, &object); err != nil {
+				return []byte(redacted)
+			}
+			for _, f := range fields {
+				if _, ok := object[f]; ok {
+					object[f] = json.RawMessage(`"` + redacted + `"`)
+				}
+			}
+			masked, _ := json.Marshal(object)
+			return masked
+		}
+		return body
+	}
+}
+
+// StatusError is returned by sinks for HTTP responses which indicate that an
+// alert was not accepted.
+type StatusError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Body is the beginning of the response body, which usually explains
+	// the error.
+	Body string
+}
+
+// CheckResponse returns a StatusError for responses with a status code
+// outside 2xx, and nil otherwise.  It does not close the body.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("HTTP %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("HTTP %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// for server errors and rate limiting, so that StatusError implements
+// middleware.RetryableError.
+func (e *StatusError) Retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout
+}
@@ -0,0 +1,481 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package voice implements an alerter.Sink which phones alerts through the
+// Programmable Voice API of Twilio and reads out a summary, as the last
+// step of an escalation chain when nobody reacted to other notifications:
+//
+//	c, err := voice.New(voice.Options{
+//		AccountSID:     "AC...",
+//		AuthToken:      os.Getenv("TWILIO_AUTH_TOKEN"),
+//		From:           "+15005550006",
+//		To:             []string{"+15551230001"},
+//		StatusCallback: "https://alerts.example.com/voice/status",
+//		OnAcknowledge:  func(a *alerter.Alert) { esc.Acknowledge(a.Fingerprint()) },
+//	})
+//	...
+//	http.Handle("/voice/status", c)
+//	esc := middleware.EscalationSink(middleware.EscalationOptions{
+//		Steps: []middleware.EscalationStep{
+//			{Sink: chatOps},
+//			{Sink: sms},
+//			{Sink: c, After: 15 * time.Minute},
+//		},
+//	})
+//
+// Twilio reports the progress of calls to StatusCallback, so that the
+// Caller records whether they were answered, see Calls and
+// Options.OnResult.  With OnAcknowledge set, callees acknowledge the alert
+// by pressing 1.
+package voice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/sms"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// DefaultTemplate is the Template of Options which set none.
+var DefaultTemplate = template.Must(template.New("voice").Parse(
+	`This is an alert notification. {{.Summary}}.`))
+
+// Data is the data of Options.Template.
+type Data struct {
+	*alerter.Alert
+
+	// Summary is the summary of the alert by Options.Summarizer.
+	Summary string
+}
+
+// Options carries parameters which influence the way alerts are phoned.
+type Options struct {
+	// AccountSID and AuthToken identify the Twilio account.
+	AccountSID string
+	AuthToken  string
+
+	// From is the phone number calls are made from.
+	From string
+
+	// To are the phone numbers, in E.164 format, called for every alert.
+	To []string
+
+	// StatusCallback is the public URL of the Caller, to which Twilio
+	// reports the progress of calls and the keys pressed.  Requests are
+	// verified against it, so it must match the URL Twilio posts to
+	// exactly.  Without it, calls are not recorded.
+	StatusCallback string
+
+	// Template renders the text read out.  Defaults to DefaultTemplate.
+	Template *template.Template
+
+	// Summarizer shortens alerts for Data.Summary.  Defaults to
+	// alerter.DefaultSummarizer.
+	Summarizer alerter.Summarizer
+
+	// MaxLength bounds Data.Summary, in characters.  Defaults to 300.
+	MaxLength int
+
+	// Voice and Language select the text-to-speech voice, such as
+	// "Polly.Joanna" and "en-US".  Default to those of Twilio.
+	Voice    string
+	Language string
+
+	// Loop is the number of times the text is read out.  Defaults to 2.
+	Loop int
+
+	// Timeout is the time a phone rings before the call is given up.
+	// Defaults to 30 seconds.
+	Timeout time.Duration
+
+	// MachineDetection counts calls answered by voicemail as unanswered.
+	MachineDetection bool
+
+	// OnAcknowledge, if set, lets callees press 1 to acknowledge the
+	// alert, for which it is called, e.g. with Escalator.Acknowledge.
+	OnAcknowledge func(a *alerter.Alert)
+
+	// OnResult is called with every call which ended.
+	OnResult func(c Call)
+
+	// Retention is the time calls are recorded.  Defaults to 24 hours.
+	Retention time.Duration
+
+	// BaseURL is the URL of the Twilio API.  Defaults to
+	// "https://api.twilio.com".
+	BaseURL string
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Twilio.
+	Transport transport.Options
+
+	// Verbosity is the highest V-level of Info alerts which are phoned.
+	Verbosity int
+
+	// Clock stamps alerts and calls with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Call is the record of a call.
+type Call struct {
+	// ID is the SID of the call.
+	ID string
+
+	// To is the phone number called.
+	To string
+
+	// Alert is the alert phoned.
+	Alert *alerter.Alert
+
+	// Started is the time the call was placed.
+	Started time.Time
+
+	// Status is the status of the call in terms of Twilio, such as
+	// "ringing", "in-progress", "completed", "busy" or "no-answer".
+	Status string
+
+	// Ended tells whether the call ended.
+	Ended bool
+
+	// Answered tells whether the call was answered, by a human if
+	// Options.MachineDetection is set.
+	Answered bool
+
+	// Acknowledged tells whether the callee acknowledged the alert.
+	Acknowledged bool
+
+	// Duration is the length of the call once it ended.
+	Duration time.Duration
+}
+
+// sink is the set of interfaces implemented by the Sinks of
+// alerter.NewSink, embedded so that alerts reach the Caller whole, with
+// their time and ID, and that failed calls are reported to alerter.Send.
+type sink interface {
+	alerter.Sink
+	alerter.Resolver
+	alerter.AlertSink
+	alerter.BatchSink
+}
+
+// Caller is a Sink which phones alerts.  It is also the http.Handler of the
+// status callback of its calls.
+//
+// Resolves are not phoned.
+type Caller struct {
+	sink
+
+	opts   Options
+	client *http.Client
+
+	mu        sync.Mutex
+	calls     map[string]*Call
+	nextSweep int
+}
+
+// New returns a Caller phoning alerts.  It fails if opts leave out the
+// account, the caller or the callees.
+func New(opts Options) (*Caller, error) {
+	if opts.AccountSID == "" || opts.AuthToken == "" || opts.From == "" || len(opts.To) == 0 {
+		return nil, errors.New("voice: AccountSID, AuthToken, From and To must be set")
+	}
+	if opts.Template == nil {
+		opts.Template = DefaultTemplate
+	}
+	if opts.Summarizer == nil {
+		opts.Summarizer = alerter.DefaultSummarizer
+	}
+	if opts.MaxLength <= 0 {
+		opts.MaxLength = 300
+	}
+	if opts.Loop <= 0 {
+		opts.Loop = 2
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = "https://api.twilio.com"
+	}
+	opts.BaseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	c := &Caller{opts: opts, client: transport.NewClient(opts.Transport), calls: map[string]*Call{}, nextSweep: 1024}
+	c.sink = alerter.NewSink(c.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	}).(sink)
+	return c, nil
+}
+
+func (c *Caller) send(a *alerter.Alert) error {
+	if a.Resolved {
+		return nil
+	}
+	twiml, err := c.twiml(a)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var errs []error
+	for _, to := range c.opts.To {
+		id, err := c.call(ctx, to, twiml)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("voice: %s: %w", to, err))
+			continue
+		}
+		if c.opts.StatusCallback != "" {
+			c.record(&Call{ID: id, To: to, Alert: a, Started: c.opts.Clock.Now(), Status: "queued"})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// twiml returns the instructions of the call for a.
+func (c *Caller) twiml(a *alerter.Alert) (string, error) {
+	var text bytes.Buffer
+	data := &Data{Alert: a, Summary: c.opts.Summarizer.Summarize(a, c.opts.MaxLength)}
+	if err := c.opts.Template.Execute(&text, data); err != nil {
+		return "", fmt.Errorf("voice: render: %w", err)
+	}
+	gather := c.opts.OnAcknowledge != nil && c.opts.StatusCallback != ""
+	if gather {
+		text.WriteString(" Press 1 to acknowledge.")
+	}
+	var b strings.Builder
+	b.WriteString("<Response>")
+	if gather {
+		b.WriteString(`<Gather numDigits="1" method="POST" action="`)
+		xml.EscapeText(&b, []byte(c.opts.StatusCallback))
+		b.WriteString(`">`)
+	}
+	c.say(&b, text.String(), c.opts.Loop)
+	if gather {
+		b.WriteString("</Gather>")
+	}
+	b.WriteString("</Response>")
+	return b.String(), nil
+}
+
+// say appends a Say verb reading out text loop times.
+func (c *Caller) say(b *strings.Builder, text string, loop int) {
+	b.WriteString("<Say")
+	if c.opts.Voice != "" {
+		b.WriteString(` voice="`)
+		xml.EscapeText(b, []byte(c.opts.Voice))
+		b.WriteByte('"')
+	}
+	if c.opts.Language != "" {
+		b.WriteString(` language="`)
+		xml.EscapeText(b, []byte(c.opts.Language))
+		b.WriteByte('"')
+	}
+	if loop > 1 {
+		fmt.Fprintf(b, ` loop="%d"`, loop)
+	}
+	b.WriteByte('>')
+	xml.EscapeText(b, []byte(text))
+	b.WriteString("</Say>")
+}
+
+// call places a call to the phone number to and returns its SID.
+func (c *Caller) call(ctx context.Context, to, twiml string) (string, error) {
+	form := url.Values{
+		"To":      {to},
+		"From":    {c.opts.From},
+		"Twiml":   {twiml},
+		"Timeout": {strconv.Itoa(int(c.opts.Timeout / time.Second))},
+	}
+	if c.opts.StatusCallback != "" {
+		form.Set("StatusCallback", c.opts.StatusCallback)
+		form.Set("StatusCallbackMethod", http.MethodPost)
+		for _, event := range []string{"ringing", "answered", "completed"} {
+			form.Add("StatusCallbackEvent", event)
+		}
+	}
+	if c.opts.MachineDetection {
+		form.Set("MachineDetection", "Enable")
+	}
+	endpoint := c.opts.BaseURL + "/2010-04-01/Accounts/" + url.PathEscape(c.opts.AccountSID) + "/Calls.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(c.opts.AccountSID, c.opts.AuthToken)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("twilio: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		SID     string `json:"sid"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if cerr := transport.CheckResponse(resp); cerr != nil {
+			return "", fmt.Errorf("twilio: %w", cerr)
+		}
+		return "", fmt.Errorf("twilio: decode response: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		return "", &sms.TwilioError{Status: resp.StatusCode, Code: result.Code, Message: result.Message}
+	}
+	return result.SID, nil
+}
+
+func (c *Caller) record(call *Call) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.calls) >= c.nextSweep {
+		c.sweep()
+	}
+	c.calls[call.ID] = call
+}
+
+// sweep forgets the calls older than Retention.  It must be called with
+// c.mu held.
+func (c *Caller) sweep() {
+	now := c.opts.Clock.Now()
+	for id, call := range c.calls {
+		if now.Sub(call.Started) > c.opts.Retention {
+			delete(c.calls, id)
+		}
+	}
+	c.nextSweep = max(1024, 2*len(c.calls))
+}
+
+// Calls returns the calls of the retention period, latest first.
+func (c *Caller) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sweep()
+	calls := make([]Call, 0, len(c.calls))
+	for _, call := range c.calls {
+		calls = append(calls, *call)
+	}
+	sort.Slice(calls, func(i, j int) bool { return calls[i].Started.After(calls[j].Started) })
+	return calls
+}
+
+// ServeHTTP handles the status reports of calls and the keys pressed by
+// callees, which Twilio posts to StatusCallback.
+func (c *Caller) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !sms.TwilioSignatureValid(c.opts.AuthToken, c.opts.StatusCallback, r.PostForm, r.Header.Get("X-Twilio-Signature")) {
+		http.Error(w, sms.ErrSignature.Error(), http.StatusForbidden)
+		return
+	}
+	form := r.PostForm
+	if _, ok := form["Digits"]; ok {
+		c.gathered(w, form.Get("CallSid"), form.Get("Digits"))
+		return
+	}
+
+	c.mu.Lock()
+	call := c.calls[form.Get("CallSid")]
+	if call == nil {
+		c.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	call.Status = form.Get("CallStatus")
+	switch call.Status {
+	case "in-progress", "completed":
+		// AnsweredBy is only reported with machine detection.
+		answeredBy := form.Get("AnsweredBy")
+		if !strings.HasPrefix(answeredBy, "machine") && answeredBy != "fax" {
+			call.Answered = true
+		}
+	}
+	var ended *Call
+	switch call.Status {
+	case "completed", "busy", "no-answer", "failed", "canceled":
+		call.Ended = true
+		if seconds, err := strconv.Atoi(form.Get("CallDuration")); err == nil {
+			call.Duration = time.Duration(seconds) * time.Second
+		}
+		done := *call
+		ended = &done
+	}
+	c.mu.Unlock()
+
+	if ended != nil && c.opts.OnResult != nil {
+		c.opts.OnResult(*ended)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// gathered handles the key pressed in a call, and responds with the rest of
+// the call.
+func (c *Caller) gathered(w http.ResponseWriter, id, digits string) {
+	var b strings.Builder
+	b.WriteString("<Response>")
+	c.mu.Lock()
+	call := c.calls[id]
+	ack := call != nil && digits == "1" && !call.Acknowledged
+	if ack {
+		call.Acknowledged = true
+	}
+	c.mu.Unlock()
+	if ack {
+		c.opts.OnAcknowledge(call.Alert)
+	}
+	if call != nil && digits == "1" {
+		c.say(&b, "The alert is acknowledged. Goodbye.", 1)
+	} else {
+		c.say(&b, "Goodbye.", 1)
+	}
+	b.WriteString("</Response>")
+	w.Header().Set("Content-Type", "text/xml")
+	w.Write([]byte(b.String()))
+}
+
+// dryRunReply answers dry-run requests like the Calls API, with a new SID
+// for every call.
+func dryRunReply(_ *http.Request, _ []byte, n int) string {
+	return fmt.Sprintf(`{"sid":"CAdryrun%d","status":"queued"}`, n)
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wecom implements an alerter.Sink which posts alerts to the group
+// robots of WeCom (WeChat Work), as text, markdown or template card
+// messages:
+//
+//	a, err := wecom.New(wecom.Options{
+//		WebhookURL: "https://qyapi.weixin.qq.com/cgi-bin/webhook/send?key=xxx",
+//	})
+//
+// A robot accepts 20 messages a minute.  The sink paces its messages to
+// stay within that limit, waiting for at most MaxWait.
+package wecom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// MessageType is the type of the messages of alerts.
+type MessageType string
+
+// Message types of group robots.
+const (
+	// Text messages are plain text, which mention members with a
+	// notification.
+	Text MessageType = "text"
+
+	// Markdown messages use the markdown subset of WeCom, colored by
+	// severity.
+	Markdown MessageType = "markdown"
+
+	// TemplateCard messages are text notice cards with the key/value
+	// pairs of alerts as a table, opening Options.CardURL.
+	TemplateCard MessageType = "template_card"
+)
+
+// Limits of robots.
+const (
+	messagesPerMinute = 20
+	maxCardFields     = 6
+	maxCardTitle      = 26
+	maxTitle          = 256
+)
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// WebhookURL is the URL of the robot, including its key.
+	WebhookURL string
+
+	// MessageType is the type of messages.  Defaults to Markdown.
+	MessageType MessageType
+
+	// CardURL is the page template cards open, e.g. a dashboard.  It is
+	// required for TemplateCard.
+	CardURL string
+
+	// Mentioned are the user IDs of the members, or "@all", mentioned in
+	// the messages of alerts of at least MentionSeverity.
+	Mentioned []string
+
+	// MentionedMobiles are the phone numbers of members mentioned in text
+	// messages of alerts of at least MentionSeverity.
+	MentionedMobiles []string
+
+	// MentionSeverity is the lowest severity which mentions members.
+	// Defaults to alerter.SeverityCritical.
+	MentionSeverity alerter.Severity
+
+	// MaxWait is the longest time a message waits for the rate limit of
+	// the robot.  Messages which would wait longer fail with an APIError.
+	// Defaults to one minute.
+	MaxWait time.Duration
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than WeCom allows.  As robots
+	// limit messages in bytes rather than characters, Limit defaults to
+	// 1300 characters for markdown and 600 for text, which fit in any
+	// script.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of WeCom.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time and times the waits for the
+	// rate limit.  Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for messages the robot did not accept.
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("wecom: %d %s", e.Code, e.Message)
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when the robot limits the rate of messages or is busy, so that APIError
+// implements middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Code == 45009 || e.Code == -1
+}
+
+// New returns an Alerter which posts every alert through the robot.  It
+// fails if opts configure no robot, or template cards without CardURL.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.WebhookURL == "" {
+		return alerter.Alerter{}, errors.New("wecom: WebhookURL must be set")
+	}
+	if opts.MessageType == "" {
+		opts.MessageType = Markdown
+	}
+	switch opts.MessageType {
+	case Text, Markdown:
+	case TemplateCard:
+		if opts.CardURL == "" {
+			return alerter.Alerter{}, errors.New("wecom: template cards require CardURL")
+		}
+	default:
+		return alerter.Alerter{}, fmt.Errorf("wecom: unknown message type %q", opts.MessageType)
+	}
+	if opts.MentionSeverity == alerter.SeverityInfo {
+		opts.MentionSeverity = alerter.SeverityCritical
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = time.Minute
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 1300
+		if opts.MessageType == Text {
+			opts.Overflow.Limit = 600
+		}
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+
+	// sent holds the times of the messages of the last minute, oldest
+	// first.
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+// message is a message of a group robot.
+type message struct {
+	MsgType      MessageType   `json:"msgtype"`
+	Text         *text         `json:"text,omitempty"`
+	Markdown     *markdown     `json:"markdown,omitempty"`
+	TemplateCard *templateCard `json:"template_card,omitempty"`
+}
+
+type text struct {
+	Content             string   `json:"content"`
+	MentionedList       []string `json:"mentioned_list,omitempty"`
+	MentionedMobileList []string `json:"mentioned_mobile_list,omitempty"`
+}
+
+type markdown struct {
+	Content string `json:"content"`
+}
+
+type templateCard struct {
+	CardType              string        `json:"card_type"`
+	Source                *cardSource   `json:"source,omitempty"`
+	MainTitle             cardTitle     `json:"main_title"`
+	SubTitleText          string        `json:"sub_title_text,omitempty"`
+	HorizontalContentList []cardContent `json:"horizontal_content_list,omitempty"`
+	CardAction            cardAction    `json:"card_action"`
+}
+
+type cardSource struct {
+	Desc string `json:"desc"`
+}
+
+type cardTitle struct {
+	Title string `json:"title"`
+	Desc  string `json:"desc,omitempty"`
+}
+
+type cardContent struct {
+	KeyName string `json:"keyname"`
+	Value   string `json:"value"`
+}
+
+type cardAction struct {
+	Type int    `json:"type"`
+	URL  string `json:"url"`
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	mention := !a.Resolved && a.Severity >= s.opts.MentionSeverity
+	if s.opts.MessageType == TemplateCard {
+		return s.post(ctx, s.card(a))
+	}
+	for i, chunk := range chat.Fit(ctx, s.body(a), s.opts.Overflow) {
+		m := &message{MsgType: s.opts.MessageType}
+		if s.opts.MessageType == Text {
+			m.Text = &text{Content: chunk}
+			if i == 0 {
+				m.Text.Content = chat.Truncate(chat.Title(a), maxTitle) + "\n" + chunk
+				if mention {
+					m.Text.MentionedList, m.Text.MentionedMobileList = s.opts.Mentioned, s.opts.MentionedMobiles
+				}
+			}
+		} else {
+			m.Markdown = &markdown{Content: chunk}
+			if i == 0 {
+				m.Markdown.Content = fmt.Sprintf("<font color=\"%s\">**%s**</font>\n%s", color(a), chat.Truncate(chat.Title(a), maxTitle), chunk)
+				if mention && len(s.opts.Mentioned) > 0 {
+					m.Markdown.Content += "\n"
+					for _, user := range s.opts.Mentioned {
+						m.Markdown.Content += "<@" + user + ">"
+					}
+				}
+			}
+		}
+		if err := s.post(ctx, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// color returns the markdown font color of a: "warning", which is orange,
+// for errors and critical alerts, "info", which is green, for resolves and
+// "comment", which is gray, otherwise.
+func color(a *alerter.Alert) string {
+	switch {
+	case a.Resolved:
+		return "info"
+	case a.Severity >= alerter.SeverityError:
+		return "warning"
+	}
+	return "comment"
+}
+
+// body returns the text of a below its title.
+func (s *sink) body(a *alerter.Alert) string {
+	var b strings.Builder
+	if text := chat.Body(a, maxTitle); text != "" {
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+	for _, f := range chat.Fields(a) {
+		if s.opts.MessageType == Markdown {
+			fmt.Fprintf(&b, "> %s: <font color=\"comment\">%s</font>\n", f.Key, f.Value)
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", f.Key, f.Value)
+		}
+	}
+	b.WriteString(s.opts.TimeFormat.Format(a.Time))
+	return b.String()
+}
+
+// card returns the template card of a, which shows its first key/value
+// pairs.
+func (s *sink) card(a *alerter.Alert) *message {
+	c := &templateCard{
+		CardType:     "text_notice",
+		MainTitle:    cardTitle{Title: chat.Truncate(chat.Title(a), maxCardTitle), Desc: s.opts.TimeFormat.Format(a.Time)},
+		SubTitleText: chat.Truncate(chat.Text(a), s.opts.Overflow.Limit),
+		CardAction:   cardAction{Type: 1, URL: s.opts.CardURL},
+	}
+	if a.Name != "" {
+		c.Source = &cardSource{Desc: a.Name}
+	}
+	for _, f := range chat.Fields(a) {
+		if len(c.HorizontalContentList) == maxCardFields {
+			break
+		}
+		c.HorizontalContentList = append(c.HorizontalContentList, cardContent{KeyName: f.Key, Value: f.Value.(string)})
+	}
+	return &message{MsgType: TemplateCard, TemplateCard: c}
+}
+
+// post sends m to the robot once the rate limit allows.
+func (s *sink) post(ctx context.Context, m *message) error {
+	if err := s.wait(); err != nil {
+		return err
+	}
+	var resp struct {
+		ErrCode int    `json:"errcode"`
+		ErrMsg  string `json:"errmsg"`
+	}
+	if err := chat.PostJSON(ctx, s.client, s.opts.WebhookURL, nil, m, &resp); err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			// The URL contains the key of the robot.
+			err = uerr.Err
+		}
+		return fmt.Errorf("wecom: %w", err)
+	}
+	if resp.ErrCode != 0 {
+		return &APIError{Code: resp.ErrCode, Message: resp.ErrMsg}
+	}
+	return nil
+}
+
+// wait sleeps until fewer than 20 messages were sent within the last
+// minute and records the message about to be sent.  It fails without
+// waiting if that takes longer than MaxWait.
+func (s *sink) wait() error {
+	s.mu.Lock()
+	now := s.opts.Clock.Now()
+	for len(s.sent) > 0 && now.Sub(s.sent[0]) >= time.Minute {
+		s.sent = s.sent[1:]
+	}
+	at := now
+	if len(s.sent) >= messagesPerMinute {
+		at = s.sent[len(s.sent)-messagesPerMinute].Add(time.Minute)
+	}
+	if d := at.Sub(now); d > s.opts.MaxWait {
+		s.mu.Unlock()
+		return &APIError{Code: 45009, Message: fmt.Sprintf("rate limit of %d messages per minute reached", messagesPerMinute)}
+	}
+	s.sent = append(s.sent, at)
+	s.mu.Unlock()
+	alerter.Sleep(s.opts.Clock, at.Sub(now))
+	return nil
+}
+
+// dryRunReply answers dry-run requests like the robot API.
+func dryRunReply(*http.Request, []byte, int) string {
+	return `{"errcode":0,"errmsg":"ok"}`
+}
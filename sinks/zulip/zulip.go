@@ -0,0 +1,250 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package zulip implements an alerter.Sink which posts alerts to Zulip
+// streams, in a topic per Alerter name, so that the alerts of every
+// subsystem are kept apart without per-alert configuration:
+//
+//	a, err := zulip.New(zulip.Options{
+//		SiteURL: "https://example.zulipchat.com",
+//		Email:   "alert-bot@example.zulipchat.com",
+//		APIKey:  os.Getenv("ZULIP_API_KEY"),
+//		Stream:  "alerts",
+//		Streams: map[string]string{"billing": "billing-alerts"},
+//	})
+//
+// An alert of the Alerter named "billing/api" is posted to the stream
+// billing-alerts, in the topic "billing/api".
+package zulip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/sinks/chat"
+	"github.com/sumengzs/alerter/sinks/transport"
+)
+
+// Limits of messages.
+const (
+	maxTopic = 60
+	maxTitle = 256
+)
+
+// DefaultTopic is the topic of alerts of Alerters without a name.
+const DefaultTopic = "alerts"
+
+// Options carries parameters which influence the way alerts are posted.
+type Options struct {
+	// SiteURL is the URL of the Zulip organization.
+	SiteURL string
+
+	// Email and APIKey identify the bot posting alerts.
+	Email  string
+	APIKey string
+
+	// Stream is the stream alerts are posted to.
+	Stream string
+
+	// Streams route alerts to other streams by the first segment of the
+	// Alerter name, e.g. "billing" for "billing/api".
+	Streams map[string]string
+
+	// Topic returns the topic of an alert.  Defaults to its name, or
+	// DefaultTopic if it has none.
+	Topic func(a *alerter.Alert) string
+
+	// Verbosity is the highest V-level of Info alerts which are posted.
+	Verbosity int
+
+	// TimeFormat renders the time of alerts.
+	TimeFormat alerter.TimeFormat
+
+	// Overflow delivers messages longer than Zulip allows.  Limit
+	// defaults to 10000 characters.
+	Overflow chat.OverflowOptions
+
+	// Transport configures the HTTP client.  DryRunReply defaults to
+	// replies shaped like those of Zulip.
+	Transport transport.Options
+
+	// Clock stamps alerts with their time.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// APIError is returned for messages Zulip did not accept.
+type APIError struct {
+	// Code is the error code, such as "STREAM_DOES_NOT_EXIST".
+	Code string
+
+	// Message explains the error.
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return "zulip: " + e.Code + ": " + e.Message
+}
+
+// Retryable reports whether sending again could succeed, which is the case
+// when Zulip limits the rate of messages, so that APIError implements
+// middleware.RetryableError.
+func (e *APIError) Retryable() bool {
+	return e.Code == "RATE_LIMIT_HIT"
+}
+
+// New returns an Alerter which posts every alert to Zulip.  It fails if
+// opts leave out the site, the bot or the stream.
+func New(opts Options) (alerter.Alerter, error) {
+	if opts.SiteURL == "" || opts.Email == "" || opts.APIKey == "" || opts.Stream == "" {
+		return alerter.Alerter{}, errors.New("zulip: SiteURL, Email, APIKey and Stream must be set")
+	}
+	opts.SiteURL = strings.TrimSuffix(opts.SiteURL, "/")
+	if opts.Topic == nil {
+		opts.Topic = defaultTopic
+	}
+	if opts.Overflow.Limit == 0 {
+		opts.Overflow.Limit = 10000
+	}
+	if opts.Transport.DryRunReply == nil {
+		opts.Transport.DryRunReply = dryRunReply
+	}
+	s := &sink{opts: opts, client: transport.NewClient(opts.Transport)}
+	return alerter.New(alerter.NewSink(s.send, alerter.SinkOptions{
+		Enabled: func(level int) bool { return level <= opts.Verbosity },
+		Clock:   opts.Clock,
+	})), nil
+}
+
+func defaultTopic(a *alerter.Alert) string {
+	if a.Name == "" {
+		return DefaultTopic
+	}
+	return a.Name
+}
+
+type sink struct {
+	opts   Options
+	client *http.Client
+}
+
+func (s *sink) send(a *alerter.Alert) error {
+	ctx := context.Background()
+	stream := s.opts.Stream
+	if segment, _, _ := strings.Cut(a.Name, "/"); s.opts.Streams[segment] != "" {
+		stream = s.opts.Streams[segment]
+	}
+	topic := chat.Truncate(s.opts.Topic(a), maxTopic)
+	for i, chunk := range s.body(ctx, a) {
+		content := chunk
+		if i == 0 {
+			content = emoji(a) + " **" + chat.Truncate(chat.Title(a), maxTitle) + "**\n" + chunk
+		}
+		if err := s.post(ctx, stream, topic, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emoji returns the emoji of the severity of a.
+func emoji(a *alerter.Alert) string {
+	switch {
+	case a.Resolved:
+		return ":check:"
+	case a.Severity >= alerter.SeverityCritical:
+		return ":rotating_light:"
+	case a.Severity == alerter.SeverityError:
+		return ":red_circle:"
+	case a.Severity == alerter.SeverityWarning:
+		return ":warning:"
+	}
+	return ":information_source:"
+}
+
+// textBlock fences the text of alerts.
+var textBlock = chat.Block{Open: "```text\n", Close: "\n```"}
+
+// body returns the messages delivering the markdown of a below its title:
+// its text in a code block, which is split first and fenced in every
+// message, followed by its fields and time.  These end the last message,
+// unless they take more than half of a message and are posted on their own.
+func (s *sink) body(ctx context.Context, a *alerter.Alert) []string {
+	var b strings.Builder
+	for _, f := range chat.Fields(a) {
+		fmt.Fprintf(&b, "* **%s**: %s\n", f.Key, f.Value)
+	}
+	fmt.Fprintf(&b, "*%s*", s.opts.TimeFormat.Format(a.Time))
+	tail := b.String()
+	text := chat.Body(a, maxTitle)
+	if text == "" {
+		return chat.Fit(ctx, tail, s.opts.Overflow)
+	}
+	opts := s.opts.Overflow
+	room := utf8.RuneCountInString(tail) + 1
+	own := opts.Limit > 0 && 2*room > opts.Limit
+	if opts.Limit > 0 && !own {
+		opts.Limit -= room
+	}
+	chunks := chat.FitBlock(ctx, strings.ReplaceAll(text, "```", "'''"), textBlock, opts)
+	if own {
+		return append(chunks, chat.Fit(ctx, tail, s.opts.Overflow)...)
+	}
+	chunks[len(chunks)-1] += "\n" + tail
+	return chunks
+}
+
+func (s *sink) post(ctx context.Context, stream, topic, content string) error {
+	form := url.Values{"type": {"stream"}, "to": {stream}, "topic": {topic}, "content": {content}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.SiteURL+"/api/v1/messages", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(s.opts.Email, s.opts.APIKey)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("zulip: %w", err)
+	}
+	defer resp.Body.Close()
+	var result struct {
+		Result string `json:"result"`
+		Msg    string `json:"msg"`
+		Code   string `json:"code"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		if cerr := transport.CheckResponse(resp); cerr != nil {
+			return fmt.Errorf("zulip: %w", cerr)
+		}
+		return fmt.Errorf("zulip: decode response: %w", err)
+	}
+	if result.Result != "success" {
+		return &APIError{Code: result.Code, Message: result.Msg}
+	}
+	return nil
+}
+
+// dryRunReply answers dry-run requests like the API.
+func dryRunReply(_ *http.Request, _ []byte, n int) string {
+	return fmt.Sprintf(`{"result":"success","msg":"","id":%d}`, n)
+}
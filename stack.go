@@ -0,0 +1,157 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"encoding/json"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// StackKey is the key under which stack traces are attached to alerts.
+const StackKey = "stack"
+
+// Stack is a goroutine stack trace, innermost frame first.
+type Stack []Caller
+
+// String returns the stack in the format of Go panics, one function and
+// its position per frame.
+func (s Stack) String() string {
+	var b strings.Builder
+	for i, c := range s {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(c.Function)
+		b.WriteString("\n\t")
+		b.WriteString(c.File)
+		b.WriteByte(':')
+		b.WriteString(strconv.Itoa(c.Line))
+	}
+	return b.String()
+}
+
+// MarshalJSON implements json.Marshaler, so structured sinks write the stack
+// as an array of frames rather than its text form.
+func (s Stack) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]Caller(s))
+}
+
+// StackOptions controls which frames a stack trace contains.
+type StackOptions struct {
+	// MaxDepth is the maximum number of frames, counted after filtering.
+	// Defaults to 32.
+	MaxDepth int
+
+	// Filter reports whether a frame should be kept.  Nil keeps all of
+	// them.  See SkipPackages.
+	Filter func(frame Caller) bool
+}
+
+// SkipPackages returns a StackOptions.Filter which drops the frames of
+// functions in the given packages, or any package below them, e.g.
+// SkipPackages("runtime", "net/http").
+func SkipPackages(packages ...string) func(frame Caller) bool {
+	return func(frame Caller) bool {
+		pkg := packageOf(frame.Function)
+		for _, p := range packages {
+			if pkg == p || strings.HasPrefix(pkg, p+"/") {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// packageOf returns the import path of a fully qualified function name such
+// as "github.com/a/b.(*T).M".
+func packageOf(function string) string {
+	dir, name := "", function
+	if i := strings.LastIndexByte(function, '/'); i >= 0 {
+		dir, name = function[:i+1], function[i+1:]
+	}
+	if i := strings.IndexByte(name, '.'); i >= 0 {
+		name = name[:i]
+	}
+	return dir + name
+}
+
+// CaptureStack returns the stack trace of the calling goroutine.  It can be
+// passed to a single Error call to attach a stack trace to just that alert:
+//
+//	a.Error(err, "cannot sync", alerter.StackKey, alerter.CaptureStack(0, alerter.StackOptions{}))
+//
+// The skip argument is the number of frames to skip, with 0 identifying the
+// caller of CaptureStack.
+func CaptureStack(skip int, opts StackOptions) Stack {
+	return captureStack(skip, opts)
+}
+
+// captureStack returns the stack trace starting skip frames above the
+// caller of its caller.
+func captureStack(skip int, opts StackOptions) Stack {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = 32
+	}
+	// Filtered frames do not count towards MaxDepth, so leave some room.
+	pcs := make([]uintptr, 2*opts.MaxDepth+16)
+	// Skip runtime.Callers, captureStack and its caller.
+	n := runtime.Callers(3+skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var stack Stack
+	for len(stack) < opts.MaxDepth {
+		frame, more := frames.Next()
+		c := Caller{File: frame.File, Line: frame.Line, Function: frame.Function}
+		if opts.Filter == nil || opts.Filter(c) {
+			stack = append(stack, c)
+		}
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// WithStackTrace returns a new Alerter instance which attaches the stack
+// trace of the calling goroutine to every Error, as a Stack value with the
+// key StackKey.  Calls which already pass a StackKey keep theirs.  A nil opts
+// disables stack traces again.
+func (a Alerter) WithStackTrace(opts *StackOptions) Alerter {
+	if opts != nil {
+		o := *opts
+		opts = &o
+	}
+	a.stack = opts
+	return a
+}
+
+// withStack returns keysAndValues with the stack trace of the caller of the
+// Alerter method calling it appended.  The original slice is never modified.
+func (a Alerter) withStack(keysAndValues []interface{}) []interface{} {
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] == StackKey {
+			return keysAndValues
+		}
+	}
+	// Skip withStack and the Alerter method.
+	stack := captureStack(1+a.callDepth, *a.stack)
+	return append(keysAndValues[:len(keysAndValues):len(keysAndValues)], StackKey, stack)
+}
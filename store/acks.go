@@ -0,0 +1,70 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Acks records acknowledgements of alerts in a Store, by fingerprint, so
+// that an alert acknowledged through one replica of a service counts as
+// acknowledged by all replicas sharing the Store.  The zero value, without a
+// Store, records nothing, leaving acknowledgements to each process.
+type Acks struct {
+	Store Store
+
+	// TTL is the time an acknowledgement is kept if its alert is not
+	// resolved before.  Defaults to 24 hours.
+	TTL time.Duration
+}
+
+func ackKey(fingerprint string) string {
+	return "alerter/ack/" + fingerprint
+}
+
+// Acknowledge records that the alert with the given fingerprint was
+// acknowledged by actor, which may be empty.
+func (k Acks) Acknowledge(ctx context.Context, fingerprint, actor string) error {
+	if k.Store == nil {
+		return nil
+	}
+	ttl := k.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return k.Store.Set(ctx, ackKey(fingerprint), []byte(actor), ttl)
+}
+
+// Acknowledged reports whether the alert with the given fingerprint was
+// acknowledged, and by whom.
+func (k Acks) Acknowledged(ctx context.Context, fingerprint string) (string, bool, error) {
+	if k.Store == nil {
+		return "", false, nil
+	}
+	actor, ok, err := k.Store.Get(ctx, ackKey(fingerprint))
+	return string(actor), ok, err
+}
+
+// Clear forgets the acknowledgement of the alert with the given fingerprint,
+// once it is resolved.
+func (k Acks) Clear(ctx context.Context, fingerprint string) error {
+	if k.Store == nil {
+		return nil
+	}
+	return k.Store.Delete(ctx, ackKey(fingerprint))
+}
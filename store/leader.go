@@ -0,0 +1,192 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// LeaderOptions carries parameters which influence the way a Leader
+// campaigns.
+type LeaderOptions struct {
+	// Key is the key of the lease, shared by the replicas which elect a
+	// leader among themselves.  Defaults to "alerter/leader".
+	Key string
+
+	// ID identifies the replica.  Defaults to the host name and process
+	// ID with a random suffix.
+	ID string
+
+	// TTL is the time the lease lasts without renewal, and thereby the
+	// time without a leader after the leader died.  Defaults to 15
+	// seconds.  The lease is renewed every third of it.
+	TTL time.Duration
+
+	// OnChange is called when the replica becomes the leader or stops
+	// being it.
+	OnChange func(leader bool)
+
+	// OnError is called with the errors of the Store.
+	OnError func(err error)
+
+	// Clock tells when the lease expires and times its renewals.
+	// Defaults to alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Leader elects one of the replicas of a service sharing a Store as the
+// leader, with a lease which the leader renews and the others take over
+// once it expires, so that only one replica delivers alerts all replicas
+// raise, see middleware.LeaderSink.
+//
+// A replica considers itself the leader only until its lease would expire
+// by its own clock, so that two replicas do not lead at once as long as
+// their clocks run at the same rate; Leader is no fit for coordination
+// needing stronger guarantees.
+type Leader struct {
+	store Store
+	opts  LeaderOptions
+
+	mu     sync.Mutex
+	until  time.Time
+	leader bool
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLeader returns a Leader campaigning in s until Close is called.
+func NewLeader(s Store, opts LeaderOptions) *Leader {
+	if opts.Key == "" {
+		opts.Key = "alerter/leader"
+	}
+	if opts.ID == "" {
+		opts.ID = defaultID()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 15 * time.Second
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	l := &Leader{store: s, opts: opts, stop: make(chan struct{}), done: make(chan struct{})}
+	go l.run()
+	return l
+}
+
+func defaultID() string {
+	host, _ := os.Hostname()
+	var b [4]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b[:]))
+}
+
+// ID returns the ID of the replica.
+func (l *Leader) ID() string {
+	return l.opts.ID
+}
+
+// IsLeader reports whether the replica holds the lease.
+func (l *Leader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader && l.opts.Clock.Now().Before(l.until)
+}
+
+func (l *Leader) run() {
+	defer close(l.done)
+	for {
+		l.campaign()
+		renew := make(chan struct{})
+		timer := alerter.AfterFunc(l.opts.Clock, l.opts.TTL/3, func() { close(renew) })
+		select {
+		case <-renew:
+		case <-l.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// campaign renews the lease, or takes it over if it is free.
+func (l *Leader) campaign() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.opts.TTL/3)
+	defer cancel()
+	start := l.opts.Clock.Now()
+	id := []byte(l.opts.ID)
+	held, err := l.store.CompareAndSwap(ctx, l.opts.Key, id, id, l.opts.TTL)
+	if err == nil && !held {
+		held, err = l.store.CompareAndSwap(ctx, l.opts.Key, nil, id, l.opts.TTL)
+	}
+	if err != nil && l.opts.OnError != nil {
+		l.opts.OnError(fmt.Errorf("leader election: %w", err))
+	}
+
+	l.mu.Lock()
+	was := l.leader && l.opts.Clock.Now().Before(l.until)
+	if held {
+		// The lease started at the latest when the request was sent.
+		l.leader, l.until = true, start.Add(l.opts.TTL)
+	} else if err == nil {
+		l.leader = false
+	}
+	// After an error, the replica leads until its lease expires.
+	is := l.leader && l.opts.Clock.Now().Before(l.until)
+	l.mu.Unlock()
+	if was != is && l.opts.OnChange != nil {
+		l.opts.OnChange(is)
+	}
+}
+
+// Close stops campaigning and gives up the lease, so that another replica
+// takes over right away.
+func (l *Leader) Close() error {
+	l.mu.Lock()
+	closed := l.closed
+	l.closed = true
+	l.mu.Unlock()
+	if closed {
+		return nil
+	}
+	close(l.stop)
+	<-l.done
+	l.mu.Lock()
+	leader := l.leader
+	l.leader = false
+	l.mu.Unlock()
+	if !leader {
+		return nil
+	}
+	if l.opts.OnChange != nil {
+		l.opts.OnChange(false)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.opts.TTL/3)
+	defer cancel()
+	// There is no conditional delete, so the lease is set to expire
+	// right away instead.
+	_, err := l.store.CompareAndSwap(ctx, l.opts.Key, []byte(l.opts.ID), []byte{}, time.Millisecond)
+	return err
+}
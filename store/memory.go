@@ -0,0 +1,129 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// Memory is a Store keeping values in memory, for a single process and for
+// tests.
+type Memory struct {
+	clock alerter.Clock
+
+	mu        sync.Mutex
+	values    map[string]entry
+	nextSweep int
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+var _ Store = &Memory{}
+
+// NewMemory returns an empty Memory store whose values expire by clock,
+// which defaults to alerter.SystemClock if nil.
+func NewMemory(clock alerter.Clock) *Memory {
+	if clock == nil {
+		clock = alerter.SystemClock
+	}
+	return &Memory{clock: clock, values: map[string]entry{}, nextSweep: 1024}
+}
+
+// get returns the live entry of key.  It must be called with m.mu held.
+func (m *Memory) get(key string, now time.Time) (entry, bool) {
+	e, ok := m.values[key]
+	if ok && !e.expires.IsZero() && !now.Before(e.expires) {
+		delete(m.values, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+// set sets key.  It must be called with m.mu held.
+func (m *Memory) set(key string, value []byte, ttl time.Duration, now time.Time) {
+	e := entry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	if _, ok := m.values[key]; !ok {
+		m.sweep(now)
+	}
+	m.values[key] = e
+}
+
+// Get implements Store.
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.get(key, m.clock.Now())
+	if !ok {
+		return nil, false, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+// Set implements Store.
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.set(key, value, ttl, m.clock.Now())
+	return nil
+}
+
+// CompareAndSwap implements Store.
+func (m *Memory) CompareAndSwap(_ context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	e, ok := m.get(key, now)
+	if ok != (old != nil) || ok && !bytes.Equal(e.value, old) {
+		return false, nil
+	}
+	m.set(key, new, ttl, now)
+	return true, nil
+}
+
+// Delete implements Store.
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.values, key)
+	return nil
+}
+
+// sweep removes expired entries once there are many of them.  It must be
+// called with m.mu held.
+func (m *Memory) sweep(now time.Time) {
+	if len(m.values) < m.nextSweep {
+		return
+	}
+	for key := range m.values {
+		m.get(key, now)
+	}
+	m.nextSweep = 2 * len(m.values)
+	if m.nextSweep < 1024 {
+		m.nextSweep = 1024
+	}
+}
@@ -0,0 +1,330 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RedisOptions carries parameters for NewRedis.
+type RedisOptions struct {
+	// Addr is the host and port of the server.  Defaults to
+	// "localhost:6379".
+	Addr string
+
+	// Username and Password authenticate with AUTH, if Password is set.
+	Username, Password string
+
+	// DB is the number of the database.
+	DB int
+
+	// Prefix is prepended to all keys, e.g. "checkout:", so that several
+	// services share a server.
+	Prefix string
+
+	// TLSConfig, if set, connects with TLS.
+	TLSConfig *tls.Config
+
+	// Timeout bounds connecting and every command, unless the context of
+	// the call ends earlier.  Defaults to 5 seconds.
+	Timeout time.Duration
+
+	// MaxIdleConns is the number of idle connections kept open.  Defaults
+	// to 4.
+	MaxIdleConns int
+}
+
+// Redis is a Store keeping values in Redis, or servers speaking its
+// protocol such as Valkey and KeyDB.  It needs no client library: it speaks
+// the few commands it uses itself.
+type Redis struct {
+	opts RedisOptions
+
+	mu   sync.Mutex
+	idle []*redisConn
+}
+
+var _ Store = &Redis{}
+
+// NewRedis returns a Redis store.  Connections are opened as needed.
+func NewRedis(opts RedisOptions) *Redis {
+	if opts.Addr == "" {
+		opts.Addr = "localhost:6379"
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = 4
+	}
+	return &Redis{opts: opts}
+}
+
+// RedisError is an error reply of the server.
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// redisCAS sets KEYS[1] to ARGV[3] if its value is ARGV[2], or if it does
+// not exist and ARGV[1] is "0", expiring after ARGV[4] milliseconds unless
+// that is "0".
+const redisCAS = `
+local v = redis.call('GET', KEYS[1])
+if ARGV[1] == '0' then
+	if v then return 0 end
+elseif v ~= ARGV[2] then
+	return 0
+end
+if ARGV[4] == '0' then
+	redis.call('SET', KEYS[1], ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+end
+return 1`
+
+// Get implements Store.
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.do(ctx, "GET", r.opts.Prefix+key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("redis: unexpected reply %v to GET", reply)
+	}
+	return value, true, nil
+}
+
+// Set implements Store.
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	args := []interface{}{"SET", r.opts.Prefix + key, value}
+	if ttl > 0 {
+		args = append(args, "PX", max(ttl.Milliseconds(), 1))
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+// CompareAndSwap implements Store.
+func (r *Redis) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	exists := "1"
+	if old == nil {
+		exists = "0"
+	}
+	var ms int64
+	if ttl > 0 {
+		ms = max(ttl.Milliseconds(), 1)
+	}
+	reply, err := r.do(ctx, "EVAL", redisCAS, 1, r.opts.Prefix+key, exists, old, new, ms)
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+// Delete implements Store.
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	_, err := r.do(ctx, "DEL", r.opts.Prefix+key)
+	return err
+}
+
+// Close closes the idle connections.
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	idle := r.idle
+	r.idle = nil
+	r.mu.Unlock()
+	var errs []error
+	for _, c := range idle {
+		errs = append(errs, c.conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// do sends a command and returns its reply: nil, an int64, a string for
+// status replies, []byte for bulk strings or []interface{} for arrays.
+func (r *Redis) do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	c, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := c.do(ctx, r.opts.Timeout, args...)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is in an unknown state.
+		c.conn.Close()
+		return nil, err
+	}
+	r.release(c)
+	return reply, err
+}
+
+func (r *Redis) conn(ctx context.Context) (*redisConn, error) {
+	r.mu.Lock()
+	if n := len(r.idle); n > 0 {
+		c := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return c, nil
+	}
+	r.mu.Unlock()
+
+	dialer := &net.Dialer{Timeout: r.opts.Timeout}
+	var conn net.Conn
+	var err error
+	if r.opts.TLSConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: r.opts.TLSConfig}).DialContext(ctx, "tcp", r.opts.Addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", r.opts.Addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if r.opts.Password != "" {
+		args := []interface{}{"AUTH", r.opts.Password}
+		if r.opts.Username != "" {
+			args = []interface{}{"AUTH", r.opts.Username, r.opts.Password}
+		}
+		if _, err := c.do(ctx, r.opts.Timeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if r.opts.DB != 0 {
+		if _, err := c.do(ctx, r.opts.Timeout, "SELECT", r.opts.DB); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (r *Redis) release(c *redisConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.idle) >= r.opts.MaxIdleConns {
+		c.conn.Close()
+		return
+	}
+	r.idle = append(r.idle, c)
+}
+
+// redisConn is a connection speaking RESP2.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args ...interface{}) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.conn.SetDeadline(deadline)
+	fmt.Fprintf(c.w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		var b []byte
+		switch v := arg.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		case int:
+			b = strconv.AppendInt(nil, int64(v), 10)
+		case int64:
+			b = strconv.AppendInt(nil, v, 10)
+		default:
+			return nil, fmt.Errorf("redis: unsupported argument %T", arg)
+		}
+		fmt.Fprintf(c.w, "$%d\r\n", len(b))
+		c.w.Write(b)
+		c.w.WriteString("\r\n")
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	reply, err := c.read()
+	if err != nil {
+		var redisErr RedisError
+		if !errors.As(err, &redisErr) {
+			err = fmt.Errorf("redis: %w", err)
+		}
+	}
+	return reply, err
+}
+
+// read reads a reply.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed reply")
+	}
+	kind, rest := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return rest, nil
+	case '-':
+		return nil, RedisError(rest)
+	case ':':
+		return strconv.ParseInt(rest, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			// Errors within arrays are returned as items.
+			item, err := c.read()
+			var redisErr RedisError
+			if errors.As(err, &redisErr) {
+				item = redisErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unknown reply type %q", kind)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+// SQLOptions carries parameters for NewSQL.
+type SQLOptions struct {
+	// Table is the name of the table.  Defaults to "alerter_store".
+	Table string
+
+	// Dollar selects numbered placeholders, $1, $2 and so on, as used by
+	// PostgreSQL, instead of question marks.
+	Dollar bool
+
+	// Clock tells the time values expire at.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// SQL is a Store keeping values in a table of a SQL database, for services
+// which have one but no Redis.  The table is created by the application,
+// e.g. for PostgreSQL
+//
+//	CREATE TABLE alerter_store (
+//		k          VARCHAR(512) PRIMARY KEY,
+//		v          BYTEA NOT NULL,
+//		expires_at BIGINT NOT NULL
+//	);
+//
+// and with BLOB instead of BYTEA for MySQL and SQLite.  expires_at holds
+// Unix milliseconds, 0 for values which do not expire.  Expired rows are
+// ignored, and replaced when their keys are written again.
+type SQL struct {
+	db   *sql.DB
+	opts SQLOptions
+
+	// The statements, with placeholders for the database.
+	get, lookup, update, insert, cas, deleteExpired, delete string
+}
+
+var _ Store = &SQL{}
+
+// sqlIdentifier matches table names which need no quoting.
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewSQL returns a SQL store using db, which is opened with the driver of
+// the database.
+func NewSQL(db *sql.DB, opts SQLOptions) (*SQL, error) {
+	if opts.Table == "" {
+		opts.Table = "alerter_store"
+	}
+	if !sqlIdentifier.MatchString(opts.Table) {
+		return nil, fmt.Errorf("store: invalid table name %q", opts.Table)
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	s := &SQL{db: db, opts: opts}
+	t := opts.Table
+	s.get = s.query("SELECT v FROM " + t + " WHERE k = ? AND (expires_at = 0 OR expires_at > ?)")
+	s.lookup = s.query("SELECT v, expires_at FROM " + t + " WHERE k = ?")
+	s.update = s.query("UPDATE " + t + " SET v = ?, expires_at = ? WHERE k = ?")
+	s.insert = s.query("INSERT INTO " + t + " (k, v, expires_at) VALUES (?, ?, ?)")
+	s.deleteExpired = s.query("DELETE FROM " + t + " WHERE k = ? AND expires_at <> 0 AND expires_at <= ?")
+	s.delete = s.query("DELETE FROM " + t + " WHERE k = ?")
+	s.cas = s.query("UPDATE " + t + " SET v = ?, expires_at = ? WHERE k = ? AND v = ? AND (expires_at = 0 OR expires_at > ?)")
+	return s, nil
+}
+
+// query numbers the placeholders of q if needed.
+func (s *SQL) query(q string) string {
+	if !s.opts.Dollar {
+		return q
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range q {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// now returns the current time and the expiry after ttl, in Unix
+// milliseconds.
+func (s *SQL) now(ttl time.Duration) (now, expires int64) {
+	t := s.opts.Clock.Now()
+	if ttl > 0 {
+		expires = t.Add(ttl).UnixMilli()
+	}
+	return t.UnixMilli(), expires
+}
+
+// Get implements Store.
+func (s *SQL) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	now, _ := s.now(0)
+	var value []byte
+	err := s.db.QueryRowContext(ctx, s.get, key, now).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Set implements Store.
+func (s *SQL) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, expires := s.now(ttl)
+	if value == nil {
+		value = []byte{}
+	}
+	res, err := s.db.ExecContext(ctx, s.update, value, expires, key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	if _, err := s.db.ExecContext(ctx, s.insert, key, value, expires); err != nil {
+		// MySQL counts the rows an update changed rather than those it
+		// matched, so the row may exist with this value already.
+		// Otherwise another replica may have inserted the key meanwhile.
+		if s.holds(ctx, key, value, expires) {
+			return nil
+		}
+		if res, uerr := s.db.ExecContext(ctx, s.update, value, expires, key); uerr == nil {
+			if n, _ := res.RowsAffected(); n > 0 {
+				return nil
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// holds reports whether the row of key has value and expires.
+func (s *SQL) holds(ctx context.Context, key string, value []byte, expires int64) bool {
+	var v []byte
+	var e int64
+	if err := s.db.QueryRowContext(ctx, s.lookup, key).Scan(&v, &e); err != nil {
+		return false
+	}
+	return bytes.Equal(v, value) && e == expires
+}
+
+// CompareAndSwap implements Store.
+func (s *SQL) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	now, expires := s.now(ttl)
+	if new == nil {
+		new = []byte{}
+	}
+	if old != nil {
+		res, err := s.db.ExecContext(ctx, s.cas, new, expires, key, old, now)
+		if err != nil {
+			return false, err
+		}
+		n, err := res.RowsAffected()
+		if err == nil && n == 0 && bytes.Equal(old, new) {
+			// MySQL reports no rows for updates which change nothing.
+			return s.holds(ctx, key, new, expires), nil
+		}
+		return n > 0, err
+	}
+	if _, err := s.db.ExecContext(ctx, s.deleteExpired, key, now); err != nil {
+		return false, err
+	}
+	if _, err := s.db.ExecContext(ctx, s.insert, key, new, expires); err != nil {
+		// The insert fails if the key exists, which is the common
+		// reason; other errors are told apart by looking.
+		if _, exists, gerr := s.Get(ctx, key); gerr == nil && exists {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete implements Store.
+func (s *SQL) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.delete, key)
+	return err
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package store defines the storage of state shared by the replicas of a
+// service, such as which alerts were delivered recently or acknowledged,
+// so that suppression works across processes rather than per process:
+//
+//	s := store.NewRedis(store.RedisOptions{Addr: "redis:6379"})
+//	sink := middleware.StoreDedupSink(inner, s, 10*time.Minute)
+//
+// Acks records acknowledgements in a Store for the escalations and
+// reminders of package middleware and for lifecycle Trackers.
+// Implementations keep values in memory, in Redis or in a SQL database.
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a key/value store with expiry and compare-and-swap, the minimum
+// for replicas to agree on state.  Implementations are safe for concurrent
+// use.
+type Store interface {
+	// Get returns the value of key, and false if it does not exist or
+	// expired.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+
+	// Set sets the value of key, which expires after ttl, or never if
+	// ttl is not positive.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// CompareAndSwap sets the value of key to new, expiring after ttl, if
+	// its value is old, or if it does not exist and old is nil.  It
+	// reports whether it set the value.
+	CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error)
+
+	// Delete removes key.  Deleting a key which does not exist is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Summarizer produces a short, single-line summary of an alert for
+// length-limited channels such as SMS, push notifications and voice calls,
+// which cannot carry the full alert.  Sinks for such channels take a
+// Summarizer option and default to DefaultSummarizer.
+type Summarizer interface {
+	// Summarize returns a summary of a of at most max characters, or of
+	// any length if max is not positive.
+	Summarize(a *Alert, max int) string
+}
+
+// SummarizerFunc adapts a function to the Summarizer interface.
+type SummarizerFunc func(a *Alert, max int) string
+
+// Summarize implements Summarizer.
+func (f SummarizerFunc) Summarize(a *Alert, max int) string {
+	return f(a, max)
+}
+
+// DefaultSummarizer is the Summarizer used by sinks for which none is set.
+var DefaultSummarizer Summarizer = TruncatingSummarizer{}
+
+// TruncatingSummarizer summarizes alerts as
+//
+//	CRITICAL billing/api: payment failed: connection refused (region=eu)
+//
+// that is the severity, name, message and error, followed by the values of
+// Keys.  Whitespace is collapsed so that the summary is a single line.  If
+// the summary is too long, the values are left out and then the rest is
+// cut off with an ellipsis.
+type TruncatingSummarizer struct {
+	// Keys are the keys whose values are included, in order, if the
+	// alert has them.
+	Keys []string
+}
+
+// Summarize implements Summarizer.
+func (s TruncatingSummarizer) Summarize(a *Alert, max int) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(a.Severity.String()))
+	if a.Resolved {
+		b.WriteString(" RESOLVED")
+	}
+	b.WriteByte(' ')
+	if a.Name != "" {
+		b.WriteString(a.Name)
+		b.WriteString(": ")
+	}
+	b.WriteString(a.Message)
+	if a.Err != nil {
+		b.WriteString(": ")
+		b.WriteString(a.Err.Error())
+	}
+	summary := oneLine(b.String())
+
+	var values []string
+	fields := a.Fields()
+	for _, key := range s.Keys {
+		for _, f := range fields {
+			if f.Key == key {
+				values = append(values, fmt.Sprintf("%s=%v", f.Key, f.Value))
+				break
+			}
+		}
+	}
+	if len(values) > 0 {
+		full := summary + " (" + oneLine(strings.Join(values, ", ")) + ")"
+		if max <= 0 || len([]rune(full)) <= max {
+			return full
+		}
+	}
+	return truncate(summary, max)
+}
+
+// oneLine collapses runs of whitespace, including newlines, into single
+// spaces.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncate cuts s to at most max characters, ending in an ellipsis if it
+// was cut.
+func truncate(s string, max int) string {
+	r := []rune(s)
+	if max <= 0 || len(r) <= max {
+		return s
+	}
+	return strings.TrimRight(string(r[:max-1]), " ") + "…"
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alerter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Names of layouts accepted by TimeFormat in addition to those of package
+// time.
+const (
+	// UnixLayout renders seconds since the Unix epoch.
+	UnixLayout = "unix"
+
+	// UnixMilliLayout renders milliseconds since the Unix epoch.
+	UnixMilliLayout = "unixmilli"
+
+	// RelativeLayout renders the time relative to now, such as "5 minutes
+	// ago", which no receiver can misread for lack of a time zone.
+	RelativeLayout = "relative"
+)
+
+// layouts maps the names ParseTimeFormat accepts to layouts.
+var layouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"datetime":    time.DateTime,
+	"kitchen":     time.Kitchen,
+	"stamp":       time.Stamp,
+}
+
+// TimeFormat describes how a sink renders timestamps, so that each sink or
+// route can show times the way its receivers read them.  The zero value
+// renders RFC 3339 in the location of the time.
+type TimeFormat struct {
+	// Layout is a layout of package time, such as time.RFC1123, or one of
+	// UnixLayout, UnixMilliLayout and RelativeLayout.  Defaults to
+	// time.RFC3339.
+	Layout string
+
+	// Location is the time zone times are converted to, e.g. the one of
+	// an on-call team.  Defaults to the location of the time.
+	Location *time.Location
+
+	// Clock tells the time RelativeLayout is relative to.  Defaults to
+	// SystemClock.
+	Clock Clock
+}
+
+// ParseTimeFormat returns the TimeFormat for a layout and an IANA time zone
+// name, as found in configuration.  layout may also be the name of a
+// standard layout, such as "rfc3339", "rfc1123", "datetime" or "kitchen",
+// ignoring case.  An empty zone keeps the location of times, and "Local"
+// is the local time zone.
+func ParseTimeFormat(layout, zone string) (TimeFormat, error) {
+	f := TimeFormat{Layout: layout}
+	if l, ok := layouts[strings.ToLower(layout)]; ok {
+		f.Layout = l
+	}
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return TimeFormat{}, fmt.Errorf("time zone: %w", err)
+		}
+		f.Location = loc
+	}
+	return f, nil
+}
+
+// Format renders t.
+func (f TimeFormat) Format(t time.Time) string {
+	if f.Location != nil {
+		t = t.In(f.Location)
+	}
+	switch f.Layout {
+	case "":
+		return t.Format(time.RFC3339)
+	case UnixLayout:
+		return strconv.FormatInt(t.Unix(), 10)
+	case UnixMilliLayout:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	case RelativeLayout:
+		return relative(t, clockOr(f.Clock).Now())
+	}
+	return t.Format(f.Layout)
+}
+
+// relative renders t relative to now in the largest whole unit.
+func relative(t, now time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	var n time.Duration
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		n, unit = d/time.Minute, "minute"
+	case d < 24*time.Hour:
+		n, unit = d/time.Hour, "hour"
+	default:
+		n, unit = d/(24*time.Hour), "day"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %s ago", n, unit)
+}
@@ -0,0 +1,192 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vault
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LeaseOptions carries parameters which influence the way a Lease keeps its
+// credentials valid.
+type LeaseOptions struct {
+	// OnRotate is called with the new credentials whenever the old ones
+	// could not be renewed and have been replaced.  Sinks holding a
+	// connection authenticated with the old credentials should re-dial
+	// here; the old lease is revoked once OnRotate returns.
+	OnRotate func(s *Secret)
+
+	// OnError is called when renewing or replacing the credentials fails.
+	// The Lease keeps retrying until it is closed.
+	OnError func(err error)
+
+	// RetryInterval is the time between two attempts to replace
+	// credentials after a failure.  Defaults to 10 seconds.
+	RetryInterval time.Duration
+}
+
+// Lease holds short-lived credentials read from a dynamic secrets engine,
+// such as "database/creds/alerting" or "aws/creds/alerting", and renews them
+// in the background before they expire.  When a lease reaches its maximum
+// TTL or cannot be renewed, fresh credentials are read and passed to
+// LeaseOptions.OnRotate.
+type Lease struct {
+	client *Client
+	path   string
+	opts   LeaseOptions
+
+	mu     sync.Mutex
+	secret *Secret
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Lease reads the credentials at path and starts renewing them.  Close must
+// be called to stop renewing and revoke the credentials.
+func (c *Client) Lease(ctx context.Context, path string, opts LeaseOptions) (*Lease, error) {
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = 10 * time.Second
+	}
+	s, err := c.Read(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Lease{client: c, path: path, opts: opts, secret: s, cancel: cancel, done: make(chan struct{})}
+	go l.run(ctx)
+	return l, nil
+}
+
+// Secret returns the current credentials.
+func (l *Lease) Secret() *Secret {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.secret
+}
+
+// Close stops renewing and revokes the current credentials.
+func (l *Lease) Close() error {
+	l.cancel()
+	<-l.done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return l.client.revoke(ctx, l.Secret().LeaseID)
+}
+
+func (l *Lease) run(ctx context.Context) {
+	defer close(l.done)
+	s := l.Secret()
+	if s.LeaseDuration <= 0 {
+		// Static credentials never expire.
+		return
+	}
+	ttl := time.Duration(s.LeaseDuration) * time.Second
+	for {
+		// Renew after two thirds of the lease, leaving time for retries.
+		if !sleep(ctx, ttl*2/3) {
+			return
+		}
+		if s.Renewable {
+			renewed, err := l.client.renew(ctx, s.LeaseID, s.LeaseDuration)
+			if err == nil && 2*renewed >= s.LeaseDuration {
+				ttl = time.Duration(renewed) * time.Second
+				continue
+			}
+			if err != nil && ctx.Err() == nil {
+				l.error(err)
+			}
+			// The lease is close to its maximum TTL or lost: replace
+			// the credentials before they expire.
+		}
+		next, ok := l.rotate(ctx)
+		if !ok {
+			return
+		}
+		s = next
+		ttl = time.Duration(s.LeaseDuration) * time.Second
+		if ttl <= 0 {
+			return
+		}
+	}
+}
+
+// rotate reads new credentials, retrying until it succeeds or ctx is
+// cancelled, and then revokes the old ones.
+func (l *Lease) rotate(ctx context.Context) (*Secret, bool) {
+	for {
+		s, err := l.client.Read(ctx, l.path)
+		if err == nil {
+			old := l.Secret()
+			l.mu.Lock()
+			l.secret = s
+			l.mu.Unlock()
+			if l.opts.OnRotate != nil {
+				l.opts.OnRotate(s)
+			}
+			if err := l.client.revoke(ctx, old.LeaseID); err != nil && ctx.Err() == nil {
+				l.error(err)
+			}
+			return s, true
+		}
+		if ctx.Err() != nil {
+			return nil, false
+		}
+		l.error(err)
+		if !sleep(ctx, l.opts.RetryInterval) {
+			return nil, false
+		}
+	}
+}
+
+func (l *Lease) error(err error) {
+	if l.opts.OnError != nil {
+		l.opts.OnError(err)
+	}
+}
+
+// renew extends a lease by increment seconds and returns the duration
+// Vault granted, which is shorter when the lease nears its maximum TTL.
+func (c *Client) renew(ctx context.Context, leaseID string, increment int) (int, error) {
+	var s Secret
+	in := map[string]interface{}{"lease_id": leaseID, "increment": increment}
+	if err := c.Do(ctx, http.MethodPut, "sys/leases/renew", in, &s); err != nil {
+		return 0, err
+	}
+	return s.LeaseDuration, nil
+}
+
+func (c *Client) revoke(ctx context.Context, leaseID string) error {
+	if leaseID == "" {
+		return nil
+	}
+	return c.Do(ctx, http.MethodPut, "sys/leases/revoke", map[string]string{"lease_id": leaseID}, nil)
+}
+
+// sleep waits for d and reports whether ctx is still active.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vault is a minimal client for the HashiCorp Vault HTTP API, covering
+// what alerting pipelines need to keep credentials out of their config.
+//
+// Besides reading static secrets, a Client can lease short-lived credentials
+// for sinks, e.g. SMTP or database passwords, and keep them renewed:
+//
+//	lease, err := vault.NewClient().Lease(ctx, "database/creds/alerting", vault.LeaseOptions{
+//		OnRotate: func(s *vault.Secret) { redial(s.Data["username"], s.Data["password"]) },
+//	})
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ErrNotFound is returned when a path does not exist in Vault.
+var ErrNotFound = errors.New("vault: not found")
+
+// Client talks to a Vault server.
+type Client struct {
+	// Address is the base URL of the server, e.g.
+	// "https://vault.example.com:8200".
+	Address string
+
+	// Token authenticates requests.
+	Token string
+
+	// Namespace selects a Vault Enterprise namespace.  Optional.
+	Namespace string
+
+	// HTTPClient sends the requests.  Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// NewClient returns a Client configured from the standard VAULT_ADDR,
+// VAULT_TOKEN and VAULT_NAMESPACE environment variables.
+func NewClient() *Client {
+	return &Client{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+}
+
+// Secret is the response to reading a path.
+type Secret struct {
+	// LeaseID identifies the lease of dynamic secrets.  It is empty for
+	// static secrets.
+	LeaseID string `json:"lease_id"`
+
+	// LeaseDuration is the number of seconds the secret is valid for.
+	LeaseDuration int `json:"lease_duration"`
+
+	// Renewable reports whether the lease can be extended.
+	Renewable bool `json:"renewable"`
+
+	// Data holds the secret itself.
+	Data map[string]interface{} `json:"data"`
+}
+
+// Read reads the secret at path, e.g. "secret/data/alerting" or
+// "database/creds/readonly".
+func (c *Client) Read(ctx context.Context, path string) (*Secret, error) {
+	var s Secret
+	if err := c.Do(ctx, http.MethodGet, path, nil, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Do sends a request with the JSON encoding of in as body to the API path
+// (without the "/v1/" prefix) and decodes the response into out.  Either
+// may be nil.
+func (c *Client) Do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	url := strings.TrimSuffix(c.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if c.Token != "" {
+		req.Header.Set("X-Vault-Token", c.Token)
+	}
+	if c.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.Namespace)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrNotFound, path)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&e)
+		return fmt.Errorf("vault: %s %s: %s: %s", method, path, resp.Status, strings.Join(e.Errors, "; "))
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
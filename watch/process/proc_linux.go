@@ -0,0 +1,90 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package process
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// clockTicks is USER_HZ, the unit of the times in /proc/<pid>/stat.  It is
+// 100 on all architectures supported by Go.
+const clockTicks = 100
+
+func listProcs() ([]procInfo, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pageSize := uint64(os.Getpagesize())
+
+	var procs []procInfo
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		// Processes may exit while we are looking at them, so errors
+		// for individual processes are skipped.
+		info, err := readProc(pid, pageSize)
+		if err != nil {
+			continue
+		}
+		procs = append(procs, info)
+	}
+	return procs, nil
+}
+
+func readProc(pid int, pageSize uint64) (procInfo, error) {
+	dir := filepath.Join("/proc", strconv.Itoa(pid))
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return procInfo{}, err
+	}
+
+	// The command name is enclosed in parentheses and may itself contain
+	// spaces and parentheses, so fields are counted from the last ")".
+	open, end := bytes.IndexByte(stat, '('), bytes.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return procInfo{}, errors.New("malformed stat")
+	}
+	fields := strings.Fields(string(stat[end+1:]))
+	if len(fields) < 22 {
+		return procInfo{}, errors.New("malformed stat")
+	}
+	num := func(i int) uint64 {
+		v, _ := strconv.ParseUint(fields[i], 10, 64)
+		return v
+	}
+
+	info := procInfo{
+		pid:   pid,
+		comm:  string(stat[open+1 : end]),
+		cpu:   num(11) + num(12), // utime, stime
+		start: num(19),           // starttime
+		rss:   num(21) * pageSize,
+	}
+	if cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline")); err == nil {
+		argv0, _, _ := bytes.Cut(cmdline, []byte{0})
+		info.exe = string(argv0)
+	}
+	return info, nil
+}
@@ -0,0 +1,259 @@
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package process implements a supervisor adapter which watches named
+// processes or PIDs and reports missing processes, excessive CPU or memory
+// usage and restart loops.
+//
+// The Watchdog is a checks.Check, so it is run by a checks.Runner which
+// alerts on violations and resolves them once they clear:
+//
+//	var cfg process.Config
+//	if err := config.Load("/etc/myagent/processes.json", &cfg); err != nil {
+//		...
+//	}
+//	runner := checks.NewRunner(alerter, 30*time.Second, process.New(cfg))
+//	go runner.Run(ctx)
+//
+// Process information is read from /proc and is only available on Linux.
+package process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+	"github.com/sumengzs/alerter/checks"
+	"github.com/sumengzs/alerter/config"
+)
+
+// Config lists the processes to watch.  It is meant to be embedded in the
+// config file.
+type Config struct {
+	Processes []Process `json:"processes"`
+
+	// Clock is used to measure CPU usage and RestartWindow.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock `json:"-"`
+}
+
+// Process describes a single watched process and the limits it must stay
+// within.  Zero limits are not enforced.
+type Process struct {
+	// Name identifies the process in alerts.  Unless PID or PIDFile is
+	// set, it is also matched against the executable name of running
+	// processes.
+	Name string `json:"name"`
+
+	// PID watches the process with this ID instead of matching by name.
+	PID int `json:"pid,omitempty"`
+
+	// PIDFile watches the process whose ID is stored in this file instead
+	// of matching by name.  It is read on every run.
+	PIDFile string `json:"pidFile,omitempty"`
+
+	// MinInstances is the number of matching processes expected to be
+	// running.  Defaults to 1.
+	MinInstances int `json:"minInstances,omitempty"`
+
+	// MaxCPUPercent is the CPU usage, in percent of one core and summed
+	// over all instances, above which the process is reported.  It is
+	// measured between two consecutive runs.
+	MaxCPUPercent float64 `json:"maxCPUPercent,omitempty"`
+
+	// MaxMemoryBytes is the resident memory, summed over all instances,
+	// above which the process is reported.
+	MaxMemoryBytes uint64 `json:"maxMemoryBytes,omitempty"`
+
+	// MaxRestarts is the number of restarts within RestartWindow above
+	// which the process is reported as restarting repeatedly.  Every
+	// matching process which shows up after the first run counts as a
+	// restart.
+	MaxRestarts int `json:"maxRestarts,omitempty"`
+
+	// RestartWindow is the period over which restarts are counted.
+	// Defaults to 10 minutes.
+	RestartWindow config.Duration `json:"restartWindow,omitempty"`
+}
+
+// procInfo is the state of one running process.
+type procInfo struct {
+	pid   int
+	comm  string
+	exe   string
+	start uint64 // start time in clock ticks since boot
+	cpu   uint64 // user and system time in clock ticks
+	rss   uint64 // resident memory in bytes
+}
+
+// procID tells apart processes which were assigned the same PID.
+type procID struct {
+	pid   int
+	start uint64
+}
+
+// Watchdog is a checks.Check which enforces a Config.
+type Watchdog struct {
+	cfg   Config
+	list  func() ([]procInfo, error)
+	state map[string]*procState
+}
+
+type procState struct {
+	initialized bool
+	measured    time.Time
+	cpu         map[procID]uint64
+	restarts    []time.Time
+}
+
+var _ checks.Check = &Watchdog{}
+
+// New returns a Watchdog for the processes in cfg.
+func New(cfg Config) *Watchdog {
+	if cfg.Clock == nil {
+		cfg.Clock = alerter.SystemClock
+	}
+	return &Watchdog{
+		cfg:   cfg,
+		list:  listProcs,
+		state: make(map[string]*procState, len(cfg.Processes)),
+	}
+}
+
+// Name implements checks.Check.
+func (w *Watchdog) Name() string { return "process" }
+
+// Run implements checks.Check.
+func (w *Watchdog) Run(ctx context.Context) ([]checks.Problem, error) {
+	procs, err := w.list()
+	if err != nil {
+		return nil, err
+	}
+	now := w.cfg.Clock.Now()
+
+	var problems []checks.Problem
+	for _, p := range w.cfg.Processes {
+		st := w.state[p.Name]
+		if st == nil {
+			st = &procState{}
+			w.state[p.Name] = st
+		}
+		problems = append(problems, w.check(now, p, st, match(p, procs))...)
+	}
+	return problems, nil
+}
+
+func (w *Watchdog) check(now time.Time, p Process, st *procState, matches []procInfo) []checks.Problem {
+	var problems []checks.Problem
+	problem := func(msg string, kvs ...interface{}) {
+		problems = append(problems, checks.Problem{
+			Object:        p.Name,
+			Message:       msg,
+			Err:           fmt.Errorf("%s: %s", p.Name, msg),
+			KeysAndValues: kvs,
+		})
+	}
+
+	minInstances := p.MinInstances
+	if minInstances <= 0 {
+		minInstances = 1
+	}
+	if len(matches) < minInstances {
+		problem("process not running", "instances", len(matches), "minInstances", minInstances)
+	}
+
+	var cpuTicks, rss uint64
+	cpu := make(map[procID]uint64, len(matches))
+	for _, m := range matches {
+		id := procID{pid: m.pid, start: m.start}
+		cpu[id] = m.cpu
+		rss += m.rss
+		if prev, ok := st.cpu[id]; ok {
+			if m.cpu >= prev {
+				cpuTicks += m.cpu - prev
+			}
+		} else if st.initialized {
+			st.restarts = append(st.restarts, now)
+		}
+	}
+
+	if p.MaxCPUPercent > 0 && st.initialized {
+		if elapsed := now.Sub(st.measured).Seconds(); elapsed > 0 {
+			percent := float64(cpuTicks) / clockTicks / elapsed * 100
+			if percent > p.MaxCPUPercent {
+				problem("process CPU usage above limit",
+					"cpuPercent", strconv.FormatFloat(percent, 'f', 1, 64), "maxCPUPercent", p.MaxCPUPercent)
+			}
+		}
+	}
+
+	if p.MaxMemoryBytes > 0 && rss > p.MaxMemoryBytes {
+		problem("process memory usage above limit", "memoryBytes", rss, "maxMemoryBytes", p.MaxMemoryBytes)
+	}
+
+	window := time.Duration(p.RestartWindow)
+	if window <= 0 {
+		window = 10 * time.Minute
+	}
+	cutoff := now.Add(-window)
+	keep := st.restarts[:0]
+	for _, t := range st.restarts {
+		if t.After(cutoff) {
+			keep = append(keep, t)
+		}
+	}
+	st.restarts = keep
+	if p.MaxRestarts > 0 && len(st.restarts) > p.MaxRestarts {
+		problem("process restarting repeatedly", "restarts", len(st.restarts), "window", window.String())
+	}
+
+	st.initialized = true
+	st.measured = now
+	st.cpu = cpu
+	return problems
+}
+
+// match returns the running processes described by p.
+func match(p Process, procs []procInfo) []procInfo {
+	pid := p.PID
+	if p.PIDFile != "" {
+		data, err := os.ReadFile(p.PIDFile)
+		if err != nil {
+			return nil
+		}
+		if pid, err = strconv.Atoi(strings.TrimSpace(string(data))); err != nil {
+			return nil
+		}
+	}
+
+	var matches []procInfo
+	for _, proc := range procs {
+		switch {
+		case pid > 0:
+			if proc.pid == pid {
+				return []procInfo{proc}
+			}
+		case proc.comm == p.Name || filepath.Base(proc.exe) == p.Name:
+			matches = append(matches, proc)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,298 @@
+//go:build linux
+
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package systemd implements an adapter which watches systemd units for
+// failures and restart loops and reports them through an alerter.Alerter.
+//
+// Every unit is alerted through an Alerter carrying a "unit" value, so a unit
+// which recovers resolves exactly the alert that was raised for it.  Unit
+// state is read from the service manager with systemctl, which talks to
+// systemd over D-Bus on our behalf.
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sumengzs/alerter"
+)
+
+const (
+	msgQuery       = "cannot query systemd"
+	msgFailed      = "unit failed"
+	msgRestartLoop = "unit restart loop"
+)
+
+// Options carries parameters which influence the way a Watcher polls and
+// alerts.
+type Options struct {
+	// Units lists the units to watch, e.g. "nginx.service".  When empty,
+	// all loaded service units are watched.
+	Units []string
+
+	// Interval is the time between two polls.  Defaults to 30 seconds.
+	Interval time.Duration
+
+	// RestartThreshold is the number of automatic restarts within
+	// RestartWindow which is reported as a restart loop.  Defaults to 5.
+	RestartThreshold int
+
+	// RestartWindow is the period over which restarts are counted.  A
+	// restart loop is resolved once a whole window passes without a
+	// restart.  Defaults to 10 minutes.
+	RestartWindow time.Duration
+
+	// Clock is used to measure RestartWindow.  Defaults to
+	// alerter.SystemClock.
+	Clock alerter.Clock
+}
+
+// Unit is the state of a single unit as reported by systemd.
+type Unit struct {
+	Name        string
+	ActiveState string
+	SubState    string
+	Result      string
+	Restarts    int
+}
+
+// Watcher polls systemd and alerts on unit failures and restart loops.
+type Watcher struct {
+	alerter alerter.Alerter
+	opts    Options
+	query   func(ctx context.Context, units []string) ([]Unit, error)
+	units   map[string]*unitState
+
+	queryFailing bool
+}
+
+type unitState struct {
+	failed   bool
+	looping  bool
+	restarts int
+	seen     []time.Time
+}
+
+// New returns a Watcher which reports through a.
+func New(a alerter.Alerter, opts Options) *Watcher {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	if opts.RestartThreshold <= 0 {
+		opts.RestartThreshold = 5
+	}
+	if opts.RestartWindow <= 0 {
+		opts.RestartWindow = 10 * time.Minute
+	}
+	if opts.Clock == nil {
+		opts.Clock = alerter.SystemClock
+	}
+	return &Watcher{
+		alerter: a,
+		opts:    opts,
+		query:   systemctl,
+		units:   map[string]*unitState{},
+	}
+}
+
+// Run polls systemd every Options.Interval until ctx is cancelled.  Failures
+// to query systemd are alerted once, resolved by the next successful poll,
+// and do not stop the Watcher.
+func (w *Watcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		err := w.Poll(ctx)
+		switch {
+		case ctx.Err() != nil:
+		case err != nil && !w.queryFailing:
+			w.queryFailing = true
+			w.alerter.Error(err, msgQuery)
+		case err == nil && w.queryFailing:
+			w.queryFailing = false
+			w.alerter.Resolve(msgQuery)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll queries systemd once and raises or resolves alerts for every unit
+// whose state changed since the previous poll.
+func (w *Watcher) Poll(ctx context.Context) error {
+	units, err := w.query(ctx, w.opts.Units)
+	if err != nil {
+		return err
+	}
+	now := w.opts.Clock.Now()
+	seen := make(map[string]bool, len(units))
+	for _, u := range units {
+		seen[u.Name] = true
+		w.update(now, u)
+	}
+	for name, st := range w.units {
+		if seen[name] {
+			continue
+		}
+		// The unit was unloaded, which also clears its failed state.
+		ua := w.alerter.WithValues("unit", name)
+		if st.failed {
+			ua.Resolve(msgFailed, "activeState", "unloaded")
+		}
+		if st.looping {
+			ua.Resolve(msgRestartLoop)
+		}
+		delete(w.units, name)
+	}
+	return nil
+}
+
+func (w *Watcher) update(now time.Time, u Unit) {
+	st, known := w.units[u.Name]
+	if !known {
+		st = &unitState{restarts: u.Restarts}
+		w.units[u.Name] = st
+	}
+	ua := w.alerter.WithValues("unit", u.Name)
+
+	failed := u.ActiveState == "failed"
+	switch {
+	case failed && !st.failed:
+		ua.Error(fmt.Errorf("unit %s failed with result %q", u.Name, u.Result), msgFailed,
+			"result", u.Result, "subState", u.SubState)
+	case !failed && st.failed:
+		ua.Resolve(msgFailed, "activeState", u.ActiveState, "subState", u.SubState)
+	}
+	st.failed = failed
+
+	switch {
+	case u.Restarts < st.restarts:
+		// The counter was reset, e.g. by "systemctl reset-failed".
+		st.seen = st.seen[:0]
+	case u.Restarts > st.restarts:
+		for i := st.restarts; i < u.Restarts && len(st.seen) < w.opts.RestartThreshold; i++ {
+			st.seen = append(st.seen, now)
+		}
+	}
+	st.restarts = u.Restarts
+
+	cutoff := now.Add(-w.opts.RestartWindow)
+	keep := st.seen[:0]
+	for _, t := range st.seen {
+		if t.After(cutoff) {
+			keep = append(keep, t)
+		}
+	}
+	st.seen = keep
+
+	switch {
+	case len(st.seen) >= w.opts.RestartThreshold && !st.looping:
+		st.looping = true
+		ua.Error(fmt.Errorf("unit %s restarted %d times within %s", u.Name, len(st.seen), w.opts.RestartWindow),
+			msgRestartLoop, "restarts", u.Restarts, "window", w.opts.RestartWindow.String())
+	case len(st.seen) == 0 && st.looping:
+		st.looping = false
+		ua.Resolve(msgRestartLoop, "restarts", u.Restarts)
+	}
+}
+
+// systemctl reads the state of units from the service manager.
+func systemctl(ctx context.Context, units []string) ([]Unit, error) {
+	if len(units) == 0 {
+		out, err := run(ctx, "list-units", "--type=service", "--all", "--plain", "--no-legend", "--no-pager")
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				units = append(units, fields[0])
+			}
+		}
+		if len(units) == 0 {
+			return nil, nil
+		}
+	}
+
+	args := append([]string{"show", "--no-pager", "--property=Id,ActiveState,SubState,Result,NRestarts"}, units...)
+	out, err := run(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return parseShow(out), nil
+}
+
+func run(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "systemctl", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("systemctl %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// parseShow parses the output of "systemctl show", which prints one block of
+// key=value lines per unit, separated by empty lines.
+func parseShow(out []byte) []Unit {
+	var (
+		units []Unit
+		cur   Unit
+	)
+	flush := func() {
+		if cur.Name != "" {
+			units = append(units, cur)
+		}
+		cur = Unit{}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Id":
+			cur.Name = value
+		case "ActiveState":
+			cur.ActiveState = value
+		case "SubState":
+			cur.SubState = value
+		case "Result":
+			cur.Result = value
+		case "NRestarts":
+			cur.Restarts, _ = strconv.Atoi(value)
+		}
+	}
+	flush()
+	return units
+}
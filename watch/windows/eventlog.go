@@ -0,0 +1,247 @@
+//go:build windows
+
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package windows
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sumengzs/alerter"
+)
+
+var (
+	wevtapi          = syscall.NewLazyDLL("wevtapi.dll")
+	procEvtSubscribe = wevtapi.NewProc("EvtSubscribe")
+	procEvtNext      = wevtapi.NewProc("EvtNext")
+	procEvtRender    = wevtapi.NewProc("EvtRender")
+	procEvtClose     = wevtapi.NewProc("EvtClose")
+
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procCreateEventW = kernel32.NewProc("CreateEventW")
+	procResetEvent   = kernel32.NewProc("ResetEvent")
+)
+
+const (
+	evtSubscribeToFutureEvents = 1
+	evtRenderEventXML          = 1
+
+	errorInsufficientBuffer syscall.Errno = 122
+	errorNoMoreItems        syscall.Errno = 259
+)
+
+// EventOptions carries parameters which influence the way an EventWatcher
+// subscribes.
+type EventOptions struct {
+	// Channel is the event log channel to subscribe to, e.g. "System" or
+	// "Microsoft-Windows-TaskScheduler/Operational".
+	Channel string
+
+	// Query is an XPath filter selecting the events to alert on.  Defaults
+	// to critical, error and warning events:
+	// "*[System[(Level=1 or Level=2 or Level=3)]]".
+	Query string
+}
+
+// EventWatcher raises an alert for every new event matching its query.  The
+// severity of the alert follows the level of the event.  Events are
+// point-in-time occurrences and are never resolved.
+type EventWatcher struct {
+	alerter alerter.Alerter
+	opts    EventOptions
+}
+
+// NewEventWatcher returns an EventWatcher which reports through a.
+func NewEventWatcher(a alerter.Alerter, opts EventOptions) *EventWatcher {
+	if opts.Query == "" {
+		opts.Query = "*[System[(Level=1 or Level=2 or Level=3)]]"
+	}
+	return &EventWatcher{alerter: a, opts: opts}
+}
+
+// Run subscribes to the channel and alerts on matching events until ctx is
+// cancelled.  Events which occurred before Run was called are not alerted.
+func (w *EventWatcher) Run(ctx context.Context) error {
+	// A manual-reset event, initially not signaled.
+	h, _, err := procCreateEventW.Call(0, 1, 0, 0)
+	if h == 0 {
+		return fmt.Errorf("CreateEvent: %w", err)
+	}
+	signal := syscall.Handle(h)
+	defer syscall.CloseHandle(signal)
+
+	channel, err := syscall.UTF16PtrFromString(w.opts.Channel)
+	if err != nil {
+		return err
+	}
+	query, err := syscall.UTF16PtrFromString(w.opts.Query)
+	if err != nil {
+		return err
+	}
+	sub, _, err := procEvtSubscribe.Call(
+		0, // local session
+		uintptr(signal),
+		uintptr(unsafe.Pointer(channel)),
+		uintptr(unsafe.Pointer(query)),
+		0, // bookmark
+		0, // context
+		0, // callback; events are pulled with EvtNext
+		evtSubscribeToFutureEvents,
+	)
+	if sub == 0 {
+		return fmt.Errorf("EvtSubscribe %s: %w", w.opts.Channel, err)
+	}
+	defer procEvtClose.Call(sub)
+
+	for {
+		// Waiting in short slices keeps cancellation responsive without
+		// a second wait handle.
+		ev, _ := syscall.WaitForSingleObject(signal, 1000)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if ev != syscall.WAIT_OBJECT_0 {
+			continue
+		}
+		if err := w.drain(sub); err != nil {
+			return err
+		}
+		procResetEvent.Call(uintptr(signal))
+	}
+}
+
+// drain alerts on all events currently available from the subscription.
+func (w *EventWatcher) drain(sub uintptr) error {
+	var events [16]uintptr
+	for {
+		var returned uint32
+		r, _, err := procEvtNext.Call(
+			sub,
+			uintptr(len(events)),
+			uintptr(unsafe.Pointer(&events[0])),
+			0, // timeout
+			0, // flags
+			uintptr(unsafe.Pointer(&returned)),
+		)
+		if r == 0 {
+			if errors.Is(err, errorNoMoreItems) {
+				return nil
+			}
+			return fmt.Errorf("EvtNext: %w", err)
+		}
+		for _, h := range events[:returned] {
+			ev, err := renderEvent(h)
+			procEvtClose.Call(h)
+			if err != nil {
+				w.alerter.Error(err, "cannot render event", "channel", w.opts.Channel)
+				continue
+			}
+			w.alert(ev)
+		}
+	}
+}
+
+func (w *EventWatcher) alert(ev *event) {
+	sev := alerter.SeverityInfo
+	switch ev.System.Level {
+	case 1:
+		sev = alerter.SeverityCritical
+	case 2:
+		sev = alerter.SeverityError
+	case 3:
+		sev = alerter.SeverityWarning
+	}
+
+	kvs := []interface{}{
+		alerter.SeverityKey, sev,
+		"channel", ev.System.Channel,
+		"provider", ev.System.Provider.Name,
+		"eventID", ev.System.EventID,
+		"recordID", ev.System.EventRecordID,
+		"computer", ev.System.Computer,
+		"timeCreated", ev.System.TimeCreated.SystemTime,
+	}
+	for _, d := range ev.EventData.Data {
+		if d.Name != "" {
+			kvs = append(kvs, d.Name, d.Value)
+		}
+	}
+	msg := fmt.Sprintf("%s event %d", ev.System.Provider.Name, ev.System.EventID)
+	if sev >= alerter.SeverityError {
+		w.alerter.Error(errors.New(msg), msg, kvs...)
+	} else {
+		w.alerter.Info(msg, kvs...)
+	}
+}
+
+// event is the part of the XML rendering of an event we are interested in.
+type event struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		}
+		EventID     uint32
+		Level       uint8
+		TimeCreated struct {
+			SystemTime time.Time `xml:"SystemTime,attr"`
+		}
+		EventRecordID uint64
+		Channel       string
+		Computer      string
+	}
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		}
+	}
+}
+
+func renderEvent(h uintptr) (*event, error) {
+	var used, props uint32
+	buf := make([]uint16, 4096)
+	for {
+		r, _, err := procEvtRender.Call(
+			0, // context
+			h,
+			evtRenderEventXML,
+			uintptr(len(buf)*2),
+			uintptr(unsafe.Pointer(&buf[0])),
+			uintptr(unsafe.Pointer(&used)),
+			uintptr(unsafe.Pointer(&props)),
+		)
+		if r != 0 {
+			break
+		}
+		if !errors.Is(err, errorInsufficientBuffer) {
+			return nil, fmt.Errorf("EvtRender: %w", err)
+		}
+		buf = make([]uint16, used/2+1)
+	}
+
+	var ev event
+	if err := xml.Unmarshal([]byte(syscall.UTF16ToString(buf)), &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
@@ -0,0 +1,193 @@
+//go:build windows
+
+/*
+Copyright 2023 The alerter Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package windows implements adapters which watch Windows hosts, giving them
+// the same watch-and-alert capabilities as the Linux adapters:
+//
+//   - ServiceWatcher alerts when a service stops running and resolves the
+//     alert once it runs again, like the systemd adapter does for units.
+//   - EventWatcher subscribes to an event log channel with an XPath filter
+//     and raises an alert for every matching event.
+package windows
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sumengzs/alerter"
+)
+
+var (
+	advapi32               = syscall.NewLazyDLL("advapi32.dll")
+	procOpenSCManagerW     = advapi32.NewProc("OpenSCManagerW")
+	procOpenServiceW       = advapi32.NewProc("OpenServiceW")
+	procQueryServiceStatus = advapi32.NewProc("QueryServiceStatus")
+	procCloseServiceHandle = advapi32.NewProc("CloseServiceHandle")
+)
+
+// Access rights and states of the service control manager API.
+const (
+	scManagerConnect   = 0x0001
+	serviceQueryStatus = 0x0004
+
+	serviceStopped         = 1
+	serviceStartPending    = 2
+	serviceStopPending     = 3
+	serviceRunning         = 4
+	serviceContinuePending = 5
+	servicePausePending    = 6
+	servicePaused          = 7
+)
+
+// serviceStatus mirrors SERVICE_STATUS.
+type serviceStatus struct {
+	ServiceType             uint32
+	CurrentState            uint32
+	ControlsAccepted        uint32
+	Win32ExitCode           uint32
+	ServiceSpecificExitCode uint32
+	CheckPoint              uint32
+	WaitHint                uint32
+}
+
+const (
+	msgQuery      = "cannot query service control manager"
+	msgNotRunning = "service not running"
+)
+
+// ServiceOptions carries parameters which influence the way a
+// ServiceWatcher polls and alerts.
+type ServiceOptions struct {
+	// Services lists the names of services which are expected to be
+	// running, e.g. "W3SVC".
+	Services []string
+
+	// Interval is the time between two polls.  Defaults to 30 seconds.
+	Interval time.Duration
+}
+
+// ServiceWatcher polls the service control manager and alerts on services
+// which are not running.
+type ServiceWatcher struct {
+	alerter alerter.Alerter
+	opts    ServiceOptions
+	down    map[string]bool
+
+	queryFailing bool
+}
+
+// NewServiceWatcher returns a ServiceWatcher which reports through a.
+func NewServiceWatcher(a alerter.Alerter, opts ServiceOptions) *ServiceWatcher {
+	if opts.Interval <= 0 {
+		opts.Interval = 30 * time.Second
+	}
+	return &ServiceWatcher{alerter: a, opts: opts, down: map[string]bool{}}
+}
+
+// Run polls every ServiceOptions.Interval until ctx is cancelled.  Failures
+// to connect to the service control manager are alerted once and resolved
+// by the next successful poll.
+func (w *ServiceWatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+	for {
+		err := w.Poll()
+		switch {
+		case err != nil && !w.queryFailing:
+			w.queryFailing = true
+			w.alerter.Error(err, msgQuery)
+		case err == nil && w.queryFailing:
+			w.queryFailing = false
+			w.alerter.Resolve(msgQuery)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll queries every service once and raises or resolves alerts for those
+// whose state changed since the previous poll.
+func (w *ServiceWatcher) Poll() error {
+	scm, _, err := procOpenSCManagerW.Call(0, 0, scManagerConnect)
+	if scm == 0 {
+		return fmt.Errorf("OpenSCManager: %w", err)
+	}
+	defer procCloseServiceHandle.Call(scm)
+
+	for _, name := range w.opts.Services {
+		state, err := serviceState(scm, name)
+		sa := w.alerter.WithValues("service", name)
+		running := err == nil && state == serviceRunning
+		switch {
+		case !running && !w.down[name]:
+			if err == nil {
+				err = fmt.Errorf("service %s is %s", name, stateName(state))
+			}
+			sa.Error(err, msgNotRunning, "state", stateName(state))
+		case running && w.down[name]:
+			sa.Resolve(msgNotRunning, "state", stateName(state))
+		}
+		w.down[name] = !running
+	}
+	return nil
+}
+
+func serviceState(scm uintptr, name string) (uint32, error) {
+	n, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	svc, _, err := procOpenServiceW.Call(scm, uintptr(unsafe.Pointer(n)), serviceQueryStatus)
+	if svc == 0 {
+		return 0, fmt.Errorf("OpenService %s: %w", name, err)
+	}
+	defer procCloseServiceHandle.Call(svc)
+
+	var status serviceStatus
+	if r, _, err := procQueryServiceStatus.Call(svc, uintptr(unsafe.Pointer(&status))); r == 0 {
+		return 0, fmt.Errorf("QueryServiceStatus %s: %w", name, err)
+	}
+	return status.CurrentState, nil
+}
+
+func stateName(state uint32) string {
+	switch state {
+	case serviceStopped:
+		return "stopped"
+	case serviceStartPending:
+		return "start pending"
+	case serviceStopPending:
+		return "stop pending"
+	case serviceRunning:
+		return "running"
+	case serviceContinuePending:
+		return "continue pending"
+	case servicePausePending:
+		return "pause pending"
+	case servicePaused:
+		return "paused"
+	default:
+		return "unknown"
+	}
+}